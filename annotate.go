@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/containerd/containerd/namespaces"
+	"github.com/mchirico/img/client"
+	"github.com/moby/buildkit/identity"
+	"github.com/moby/buildkit/session"
+)
+
+const annotateShortHelp = `Add or modify the annotations of an image without rebuilding it.`
+
+var annotateLongHelp = annotateShortHelp + `
+
+By default the root of the image is annotated, which is the index for
+multi-platform images. Use --target manifest:os/arch to annotate the manifest
+of a single platform of an index instead. The image is updated to point at
+the new digest.`
+
+func (cmd *annotateCommand) Name() string      { return "annotate" }
+func (cmd *annotateCommand) Args() string      { return "[OPTIONS] IMAGE KEY=VALUE..." }
+func (cmd *annotateCommand) ShortHelp() string { return annotateShortHelp }
+func (cmd *annotateCommand) LongHelp() string  { return annotateLongHelp }
+func (cmd *annotateCommand) Hidden() bool      { return false }
+
+func (cmd *annotateCommand) Register(fs *flag.FlagSet) {
+	fs.StringVar(&cmd.target, "target", "", "Object to annotate: index, manifest or manifest:os/arch (defaults to the root of the image)")
+}
+
+type annotateCommand struct {
+	target string
+}
+
+func (cmd *annotateCommand) Run(ctx context.Context, args []string) (err error) {
+	if len(args) < 2 {
+		return usageErrorf("must pass an image and at least one KEY=VALUE annotation")
+	}
+
+	if cmd.target != "" {
+		if _, _, err := client.ParseAnnotateTarget(cmd.target); err != nil {
+			return err
+		}
+	}
+	annotations, err := parseAnnotations(args[1:])
+	if err != nil {
+		return err
+	}
+
+	reexec()
+
+	// Create the context.
+	id := identity.NewID()
+	ctx = session.NewContext(ctx, id)
+	ctx = namespaces.WithNamespace(ctx, "buildkit")
+
+	// Create the client.
+	c, err := client.New(stateDir, backend, nil)
+	if err != nil {
+		return systemError{err}
+	}
+	defer c.Close()
+
+	desc, err := c.Annotate(ctx, args[0], cmd.target, annotations)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Successfully annotated %s, it now points at %s\n", args[0], desc.Digest)
+
+	return nil
+}
+
+// parseAnnotations parses the KEY=VALUE annotation arguments.
+func parseAnnotations(values []string) (map[string]string, error) {
+	annotations := map[string]string{}
+	for _, value := range values {
+		kv := strings.SplitN(value, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("invalid annotation %s, expected KEY=VALUE", value)
+		}
+		annotations[kv[0]] = kv[1]
+	}
+	return annotations, nil
+}
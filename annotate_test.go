@@ -0,0 +1,45 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAnnotate(t *testing.T) {
+	runBuild(t, "testannotate", withDockerfile(`
+    FROM busybox
+    RUN echo annotate
+    `))
+
+	out := run(t, "annotate", "testannotate", "org.opencontainers.image.created=2019-01-01T00:00:00Z")
+	if !strings.Contains(out, "Successfully annotated testannotate") {
+		t.Fatalf("expected annotate to succeed, got: %s", out)
+	}
+
+	// The image points at the annotated manifest now.
+	fields := strings.Fields(out)
+	dgst := fields[len(fields)-1]
+	if out := run(t, "ls"); !strings.Contains(out, dgst) {
+		t.Fatalf("expected testannotate to point at %s, got: %s", dgst, out)
+	}
+	out = run(t, "content", "get", dgst)
+	if !strings.Contains(out, `"org.opencontainers.image.created": "2019-01-01T00:00:00Z"`) {
+		t.Fatalf("expected the annotation in the manifest, got: %s", out)
+	}
+}
+
+func TestParseAnnotations(t *testing.T) {
+	annotations, err := parseAnnotations([]string{"a=b", "c=d=e", "empty="})
+	if err != nil {
+		t.Fatalf("parsing annotations failed: %v", err)
+	}
+	if annotations["a"] != "b" || annotations["c"] != "d=e" || annotations["empty"] != "" {
+		t.Fatalf("unexpected annotations: %v", annotations)
+	}
+
+	for _, value := range []string{"novalue", "=value"} {
+		if _, err := parseAnnotations([]string{value}); err == nil {
+			t.Fatalf("expected parsing annotation %q to fail but it did not", value)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// argSource resolves the value of a build arg at build time from where the
+// rest of a --build-arg value after its scheme points to, so the value itself
+// is not on the command line.
+type argSource interface {
+	resolve(location string) (string, error)
+}
+
+// argSources are the sources of build arg values by scheme.
+var argSources = map[string]argSource{
+	"file": fileArgSource{},
+	"env":  envArgSource{},
+}
+
+// fileArgSource reads the value from a file, without its trailing newline,
+// e.g. file:///run/secrets/token.
+type fileArgSource struct{}
+
+func (fileArgSource) resolve(location string) (string, error) {
+	b, err := ioutil.ReadFile(location)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
+
+// envArgSource reads the value from an environment variable of img, e.g.
+// env://NPM_TOKEN.
+type envArgSource struct{}
+
+func (envArgSource) resolve(location string) (string, error) {
+	v, ok := os.LookupEnv(location)
+	if !ok {
+		return "", fmt.Errorf("environment variable %s is not set", location)
+	}
+	return v, nil
+}
+
+// resolveBuildArg returns the value of the build arg from its source if the
+// value starts with the scheme of one, e.g. file:// or env://, and if it did.
+// Other values, including other URLs, are returned as is.
+func resolveBuildArg(name, value string) (string, bool, error) {
+	i := strings.Index(value, "://")
+	if i < 0 {
+		return value, false, nil
+	}
+	source, ok := argSources[value[:i]]
+	if !ok {
+		return value, false, nil
+	}
+	location := value[i+len("://"):]
+	if location == "" {
+		return "", false, fmt.Errorf("invalid build-arg value %s=%s, the %s source needs a location", name, value, value[:i])
+	}
+	v, err := source.resolve(location)
+	if err != nil {
+		return "", false, fmt.Errorf("resolving build-arg %s from %s failed: %v", name, value, err)
+	}
+	return v, true, nil
+}
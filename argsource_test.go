@@ -0,0 +1,51 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolveBuildArg(t *testing.T) {
+	dir, err := ioutil.TempDir("", "img-argsource")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	token := filepath.Join(dir, "token")
+	if err := ioutil.WriteFile(token, []byte("s3cr3t\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("IMG_TEST_ARG_SOURCE", "fr0m-env")
+	defer os.Unsetenv("IMG_TEST_ARG_SOURCE")
+	os.Unsetenv("IMG_TEST_ARG_SOURCE_UNSET")
+
+	for value, expected := range map[string]string{
+		"file://" + token:           "s3cr3t",
+		"env://IMG_TEST_ARG_SOURCE": "fr0m-env",
+		"https://example.com":       "https://example.com",
+		"plain":                     "plain",
+	} {
+		v, resolved, err := resolveBuildArg("TOKEN", value)
+		if err != nil {
+			t.Fatalf("resolving %s failed: %v", value, err)
+		}
+		if v != expected {
+			t.Fatalf("expected %s to resolve to %q, got: %q", value, expected, v)
+		}
+		if resolved != (v != value) {
+			t.Fatalf("expected %s to be resolved %t, got: %t", value, v != value, resolved)
+		}
+	}
+
+	for _, invalid := range []string{
+		"file://",
+		"file://" + filepath.Join(dir, "missing"),
+		"env://IMG_TEST_ARG_SOURCE_UNSET",
+	} {
+		if _, _, err := resolveBuildArg("TOKEN", invalid); err == nil {
+			t.Fatalf("expected resolving %s to fail but it did not", invalid)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strings"
+
+	"github.com/docker/distribution/reference"
+	"github.com/moby/buildkit/frontend/dockerfile/parser"
+	digest "github.com/opencontainers/go-digest"
+)
+
+// baseDigests are the base images of the stages of a dockerfile resolved to
+// the digests of their manifests for --resolve-digests and --pin-digests.
+type baseDigests struct {
+	// bases is the expanded base image of each stage, empty for the stages
+	// built from scratch or from a previous stage.
+	bases []string
+	// images are the distinct base images in dockerfile order.
+	images  []string
+	digests map[string]digest.Digest
+}
+
+// resolveBaseDigests resolves the base image of every stage of the
+// dockerfile, after expanding the ARGs of its FROM, with resolve. Images
+// already referenced by digest are not resolved again.
+func resolveBaseDigests(ctx context.Context, df *dockerfile, buildArgs map[string]string, resolve func(context.Context, string) (digest.Digest, error)) (*baseDigests, error) {
+	bases, err := df.stageBases(buildArgs)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &baseDigests{bases: bases, digests: map[string]digest.Digest{}}
+	for _, base := range bases {
+		if _, ok := b.digests[base]; ok || base == "" {
+			continue
+		}
+		named, err := reference.ParseNormalizedNamed(base)
+		if err != nil {
+			return nil, fmt.Errorf("parsing base image %q failed: %v", base, err)
+		}
+		var dgst digest.Digest
+		if canonical, ok := named.(reference.Canonical); ok {
+			dgst = canonical.Digest()
+		} else if dgst, err = resolve(ctx, base); err != nil {
+			return nil, err
+		}
+		b.images = append(b.images, base)
+		b.digests[base] = dgst
+	}
+	return b, nil
+}
+
+// print writes the digest of each base image.
+func (b *baseDigests) print(w io.Writer, redact func(string) string) {
+	if len(b.images) < 1 {
+		fmt.Fprintln(w, "The dockerfile has no base images to resolve")
+		return
+	}
+	fmt.Fprintln(w, "Resolved the digests of the base images:")
+	for _, image := range b.images {
+		fmt.Fprintf(w, "  %s -> %s\n", redact(image), b.digests[image])
+	}
+}
+
+// pinnedRef returns the reference of the base image by its digest, e.g.
+// busybox@sha256:... for busybox:latest.
+func (b *baseDigests) pinnedRef(base string) (string, error) {
+	named, err := reference.ParseNormalizedNamed(base)
+	if err != nil {
+		return "", fmt.Errorf("parsing base image %q failed: %v", base, err)
+	}
+	canonical, err := reference.WithDigest(reference.TrimNamed(named), b.digests[base])
+	if err != nil {
+		return "", err
+	}
+	return reference.FamiliarString(canonical), nil
+}
+
+// pin rewrites the FROM instructions of the dockerfile at path to reference
+// their base image by digest. The expanded image replaces a FROM with ARGs.
+func (b *baseDigests) pin(path string) error {
+	dt, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	result, err := parser.Parse(bytes.NewReader(dt))
+	if err != nil {
+		return fmt.Errorf("parsing dockerfile %s failed: %v", path, err)
+	}
+
+	lines := strings.Split(string(dt), "\n")
+	stage := 0
+	for _, node := range result.AST.Children {
+		if node.Value != "from" {
+			continue
+		}
+		i := stage
+		stage++
+		if i >= len(b.bases) || b.bases[i] == "" || node.Next == nil || node.StartLine < 1 || node.StartLine > len(lines) {
+			continue
+		}
+		ref, err := b.pinnedRef(b.bases[i])
+		if err != nil {
+			return err
+		}
+		re := regexp.MustCompile(`(?i)^(\s*FROM\s+(?:--\S+\s+)*)` + regexp.QuoteMeta(node.Next.Value) + `(\s|$)`)
+		lines[node.StartLine-1] = replaceSubmatch(re, lines[node.StartLine-1], ref)
+	}
+	return ioutil.WriteFile(path, []byte(strings.Join(lines, "\n")), 0644)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	digest "github.com/opencontainers/go-digest"
+)
+
+func TestResolveBaseDigests(t *testing.T) {
+	tmpd, err := ioutil.TempDir("", "img-base-digests")
+	if err != nil {
+		t.Fatalf("creating temporary directory failed: %v", err)
+	}
+	defer os.RemoveAll(tmpd)
+
+	pinned := digest.FromString("pinned")
+	dockerfilePath := filepath.Join(tmpd, "Dockerfile")
+	if err := ioutil.WriteFile(dockerfilePath, []byte(`ARG GO_VERSION=1.12
+FROM golang:${GO_VERSION}-alpine AS builder
+RUN go build
+FROM builder AS test
+RUN go test
+FROM --platform=$BUILDPLATFORM busybox AS tools
+FROM alpine@`+pinned.String()+`
+COPY --from=builder /app /app
+FROM scratch
+FROM busybox:latest
+`), 0644); err != nil {
+		t.Fatalf("writing dockerfile failed: %v", err)
+	}
+	df, err := parseDockerfile(dockerfilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var resolved []string
+	resolve := func(ctx context.Context, image string) (digest.Digest, error) {
+		resolved = append(resolved, image)
+		return digest.FromString(image), nil
+	}
+	digests, err := resolveBaseDigests(context.Background(), df, map[string]string{"GO_VERSION": "1.13"}, resolve)
+	if err != nil {
+		t.Fatalf("resolving the base digests failed: %v", err)
+	}
+
+	// Each base is resolved once, with the ARGs of its FROM expanded, and
+	// the pinned one is not resolved.
+	if strings.Join(resolved, ",") != "golang:1.13-alpine,busybox,busybox:latest" {
+		t.Fatalf("expected the bases to be resolved in dockerfile order, got: %v", resolved)
+	}
+	var buf bytes.Buffer
+	digests.print(&buf, func(s string) string { return s })
+	expected := "Resolved the digests of the base images:\n" +
+		"  golang:1.13-alpine -> " + digest.FromString("golang:1.13-alpine").String() + "\n" +
+		"  busybox -> " + digest.FromString("busybox").String() + "\n" +
+		"  alpine@" + pinned.String() + " -> " + pinned.String() + "\n" +
+		"  busybox:latest -> " + digest.FromString("busybox:latest").String() + "\n"
+	if buf.String() != expected {
+		t.Fatalf("expected the report:\n%s\ngot:\n%s", expected, buf.String())
+	}
+
+	// Pinning only rewrites the FROMs of images.
+	if err := digests.pin(dockerfilePath); err != nil {
+		t.Fatalf("pinning the base digests failed: %v", err)
+	}
+	dt, err := ioutil.ReadFile(dockerfilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = `ARG GO_VERSION=1.12
+FROM golang@` + digest.FromString("golang:1.13-alpine").String() + ` AS builder
+RUN go build
+FROM builder AS test
+RUN go test
+FROM --platform=$BUILDPLATFORM busybox@` + digest.FromString("busybox").String() + ` AS tools
+FROM alpine@` + pinned.String() + `
+COPY --from=builder /app /app
+FROM scratch
+FROM busybox@` + digest.FromString("busybox:latest").String() + `
+`
+	if string(dt) != expected {
+		t.Fatalf("expected the pinned dockerfile:\n%s\ngot:\n%s", expected, dt)
+	}
+	if _, err := parseDockerfile(dockerfilePath); err != nil {
+		t.Fatalf("parsing the pinned dockerfile failed: %v", err)
+	}
+}
@@ -4,23 +4,32 @@ import (
 	"archive/tar"
 	"bufio"
 	"bytes"
-	"compress/gzip"
 	"context"
+	"encoding/csv"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
 	"github.com/containerd/containerd/platforms"
 	"io"
 	"io/ioutil"
+	"net/http"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"text/tabwriter"
+	"time"
 
 	"github.com/containerd/console"
 	"github.com/containerd/containerd/namespaces"
 	securejoin "github.com/cyphar/filepath-securejoin"
 	"github.com/docker/distribution/reference"
+	"github.com/docker/docker/builder/dockerignore"
 	"github.com/docker/docker/pkg/archive"
+	units "github.com/docker/go-units"
 	"github.com/mchirico/img/client"
 	controlapi "github.com/moby/buildkit/api/services/control"
 	bkclient "github.com/moby/buildkit/client"
@@ -28,6 +37,9 @@ import (
 	"github.com/moby/buildkit/session"
 	"github.com/moby/buildkit/util/appcontext"
 	"github.com/moby/buildkit/util/progress/progressui"
+	digest "github.com/opencontainers/go-digest"
+	specs "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/sirupsen/logrus"
 	"golang.org/x/sync/errgroup"
 )
 
@@ -40,173 +52,1677 @@ func (cmd *buildCommand) LongHelp() string  { return buildHelp }
 func (cmd *buildCommand) Hidden() bool      { return false }
 
 func (cmd *buildCommand) Register(fs *flag.FlagSet) {
-	fs.StringVar(&cmd.dockerfilePath, "file", "", "Name of the Dockerfile (Default is 'PATH/Dockerfile')")
-	fs.StringVar(&cmd.dockerfilePath, "f", "", "Name of the Dockerfile (Default is 'PATH/Dockerfile')")
+	fs.StringVar(&cmd.dockerfilePath, "file", "", "Name of the Dockerfile, or an http(s) URL to fetch it from (Default is 'PATH/Dockerfile')")
+	fs.StringVar(&cmd.dockerfilePath, "f", "", "Name of the Dockerfile, or an http(s) URL to fetch it from (Default is 'PATH/Dockerfile')")
+	fs.DurationVar(&cmd.contextTimeout, "context-timeout", 0, "Set a timeout for fetching a Dockerfile from a URL, e.g. 30s (defaults to no limit)")
 	fs.Var(&cmd.tags, "tag", "Name and optionally a tag in the 'name:tag' format")
 	fs.Var(&cmd.tags, "t", "Name and optionally a tag in the 'name:tag' format")
-	fs.StringVar(&cmd.target, "target", "", "Set the target build stage to build")
-	fs.Var(&cmd.platforms, "platform", "Set platforms for which the image should be built")
-	fs.Var(&cmd.buildArgs, "build-arg", "Set build-time variables")
+	fs.Var(&cmd.targets, "target", "Set the target build stage to build, repeat or comma separate them to build several stages in one build")
+	fs.BoolVar(&cmd.targetSuffix, "target-suffix", false, "Tag the image of each of several targets with each -t tag suffixed with the target")
+	fs.Var(&cmd.platforms, "platform", "Set platforms for which the image should be built, 'host' is the current machine and 'all' builds every platform of the base image")
+	fs.BoolVar(&cmd.allPlatforms, "all-platforms", false, "Build for every platform the base image supports")
+	fs.BoolVar(&cmd.showHostPlatform, "host-platform", false, "Print the detected platform of the host and whether img runs emulated on it, for debugging the default platform")
+	fs.BoolVar(&cmd.platformReport, "platform-report", false, "Print which platforms succeeded or failed, with the failing step, when a multi-platform build fails")
+	fs.Var(&cmd.buildArgs, "build-arg", "Set build-time variables, a file://PATH or env://NAME value is read from the file or environment variable")
+	fs.BoolVar(&cmd.proxy, "proxy", false, "Pass the HTTP_PROXY, HTTPS_PROXY, FTP_PROXY, NO_PROXY and ALL_PROXY variables of the host, in upper or lower case, as build-args")
+	fs.Var(&cmd.envFiles, "env-file", "Read build-time variables from a file of KEY=VALUE lines")
+	fs.BoolVar(&cmd.noEnvAuto, "no-env-auto", false, "Do not load build-time variables and labels from the .img/build.env file of the context")
+	fs.Var(&cmd.redactBuildArgs, "redact-build-args", "Print *** instead of the values of the comma separated build-args in the output of img, they are still stored in the image history")
+	fs.BoolVar(&cmd.strictBuildArgs, "strict-build-args", false, "Error if a build-arg is not declared with ARG in the Dockerfile")
+	fs.BoolVar(&cmd.noDefaultLatest, "no-default-latest", false, "Error if a tag is missing instead of defaulting to latest")
+	fs.Var(&cmd.contextExcludes, "context-exclude", "Exclude the files matching the pattern from the context on top of the .dockerignore file, in the same syntax, repeat for several patterns")
+	fs.StringVar(&cmd.chmodContext, "chmod-context", "", "Normalize the modes of the context files in the 'MODE[,dir=MODE][,preserve-exec]' format, e.g. 0644 for files and 0755 for directories")
+	fs.BoolVar(&cmd.keepGitDir, "keep-git-dir", false, "Keep the .git directory in the checkout of a git context")
+	fs.Var(&cmd.buildContexts, "build-context", "Set a named build context in the 'name=docker-image://ref', 'name=oci-layout://path@digest' or 'name=path#subdir' format")
 	fs.Var(&cmd.labels, "label", "Set metadata for an image")
+	fs.BoolVar(&cmd.ociLabels, "oci-labels", false, "Set the standard OCI labels for the created time and, for a git context, the revision and source, unless overridden with --label")
+	fs.BoolVar(&cmd.labelInherit, "label-inherit", false, "Copy the labels of the base image of the first stage, unless overridden with --label")
+	fs.StringVar(&cmd.entrypoint, "entrypoint", "", "Override the ENTRYPOINT of the image, a JSON array for the exec form or a command for the shell form")
+	fs.StringVar(&cmd.command, "cmd", "", "Override the CMD of the image, a JSON array for the exec form or a command for the shell form")
+	fs.StringVar(&cmd.workdir, "workdir", "", "Override the WORKDIR of the image")
+	fs.Var(&cmd.env, "env", "Set an environment variable of the image in the 'KEY=VALUE' format, overriding ENV")
+	fs.StringVar(&cmd.user, "user", "", "Override the USER of the image")
+	fs.Var(&cmd.attests, "attest", "Set attestation parameters in the 'type=sbom,...' format, not supported by the embedded builder")
+	fs.Var(&cmd.outputs, "output", "Set the output of the build in the 'type=<image|registry|oci|local|cacheonly>,key=value' format, repeat to emit several outputs from one build")
+	fs.Var(&cmd.outputs, "o", "Set the output of the build in the 'type=<image|registry|oci|local|cacheonly>,key=value' format, repeat to emit several outputs from one build")
+	fs.BoolVar(&cmd.inlineCache, "inline-cache", false, "Embed the build cache metadata in the image config so builds using the pushed image can reuse its cache")
+	fs.StringVar(&cmd.cache, "cache", "", "Import the build cache from the registry image REF and export the cache of every step back to it")
+	fs.BoolVar(&cmd.outputChecksums, "output-checksums", false, "Write a SHA256SUMS file of the files of the local output to its directory")
+	fs.BoolVar(&cmd.noOutput, "no-output", false, "Only run the build to populate the cache, without exporting an image")
+	fs.StringVar(&cmd.stopAt, "stop-at", "", "Build and cache the stages up to the given stage without exporting an image, for a later build to finish from the cache")
+	fs.IntVar(&cmd.keepDays, "keep-days", 0, "Keep the build cache of the image from garbage collection and pruning for N days, over the gc policy")
+	fs.StringVar(&cmd.contextCompression, "context-compression", contextCompressionNone, "Compress the context sent to the builder with gzip, zstd or none")
+	fs.StringVar(&cmd.digestFile, "digestfile", "", "Write a JSON map of each built platform to the digest of its manifest to the file")
+	fs.StringVar(&cmd.sourceDateEpoch, "source-date-epoch", "", "Set the created time of the image config to the given unix timestamp")
+	fs.StringVar(&cmd.timestampSource, "timestamp-source", "", "Set the source date epoch from the source, git uses the commit time of the HEAD of a git context")
+	fs.BoolVar(&cmd.quietSuccess, "quiet-success", false, "Show the progress on STDERR and only print the digest of the image to STDOUT on success")
+	fs.BoolVar(&cmd.quiet, "quiet", false, "Only print the digest of the image to STDOUT on success or a one-line error to STDERR on failure, without the progress")
+	fs.BoolVar(&cmd.quiet, "q", false, "Only print the digest of the image to STDOUT on success or a one-line error to STDERR on failure, without the progress")
 	fs.BoolVar(&cmd.noConsole, "no-console", false, "Use non-console progress UI")
+	fs.StringVar(&cmd.progress, "progress", "auto", "Set the type of progress output (auto, plain, json, rawjson), json prints the translated status and rawjson the status of the controller as JSON lines")
+	fs.DurationVar(&cmd.pullTimeout, "pull-timeout", 0, "Set a timeout for pulling each base image, e.g. 5m, without limiting the other steps (defaults to no limit)")
+	fs.DurationVar(&cmd.progressInterval, "progress-interval", 0, "Set how often the progress output is refreshed, e.g. 500ms (defaults to the progress UI rate)")
+	fs.StringVar(&cmd.logPrefix, "log-prefix", "", "Prepend the string to every line of the plain progress and the messages of the build to tell apart builds running in parallel")
+	fs.StringVar(&cmd.progressFile, "progress-file", "", "Also write the full progress of the build to a file as a JSON object per status update")
+	fs.StringVar(&cmd.metricsAddr, "metrics-addr", "", "Serve Prometheus metrics of the build on /metrics of the address, e.g. :9090, while it runs")
+	fs.BoolVar(&cmd.quietPull, "quiet-pull", false, "Do not show the progress of pulling base images")
+	fs.BoolVar(&cmd.explainCache, "explain-cache", false, "Print whether each step hit the cache and why it missed compared with the previous build of the context")
+	fs.BoolVar(&cmd.disableNetwork, "disable-network", false, "Run every RUN step without network access so the steps that need it fail")
 	fs.BoolVar(&cmd.noCache, "no-cache", false, "Do not use cache when building the image")
+	fs.StringVar(&cmd.maxLogSize, "max-log-size", "", "Cap the log output kept for each step, e.g. 1m, keeping the last part (defaults to unlimited)")
+	fs.Var(&cmd.mounts, "mount", "Bind mount a host path into every RUN step in the 'type=bind,src=HOST,dst=TARGET[,ro|rw]' format, read-only unless rw is set")
+	fs.Var(&cmd.allow, "allow", "Allow extra privileges, mount.rw allows writable --mount bind mounts")
+	fs.Var(&cmd.securityOpts, "security-opt", "Set the SELinux context of the mounts of the RUN steps in the 'label=user:role:type:level' format, relabeling the --mount sources")
+	fs.StringVar(&cmd.shmSize, "shm-size", "", "Set the size of /dev/shm for the RUN containers, e.g. 2g (defaults to 64m)")
+	fs.StringVar(&cmd.cgroupParent, "cgroup-parent", "", "Set the parent cgroup of the RUN containers")
+	fs.StringVar(&cmd.cacheNamespace, "cache-ns", "", "Scope the build cache to a namespace so it is not shared with other builds")
+	fs.StringVar(&cmd.cacheMountNamespace, "cache-mount-ns", "", "Scope the RUN --mount=type=cache mounts to a namespace so they are not shared with other projects")
+	fs.BoolVar(&cmd.singlePlatformBase, "single-platform-base", false, "Error unless a multi-platform base image has exactly one manifest for each target platform, instead of picking the closest one")
+	fs.BoolVar(&cmd.noEmulationCheck, "no-emulation-check", false, "Do not check for emulators when building for platforms the host can not run")
+	fs.BoolVar(&cmd.platformFallback, "platform-fallback", false, "Skip the platforms there is no emulator for with a warning instead of failing the build")
+	fs.BoolVar(&cmd.noInstallRunc, "no-install-runc", false, "Do not install the embedded runc binary when runc is not in PATH, error instead")
+	fs.StringVar(&cmd.runcPath, "runc-path", "", "Run the RUN steps with the runc binary at the path instead of the one in PATH or the embedded one")
+	fs.StringVar(&cmd.runtime, "runtime", "", "Run the RUN steps with another OCI runtime with the command line of runc, a path or a name in PATH like crun (defaults to runc)")
+	fs.BoolVar(&cmd.resolveDigests, "resolve-digests", false, "Resolve the base image of every stage to the digest of its manifest and print them before building")
+	fs.BoolVar(&cmd.pinDigests, "pin-digests", false, "Resolve the base images like --resolve-digests and build from them by digest, without changing the Dockerfile on disk")
+	fs.BoolVar(&cmd.dryRun, "dry-run", false, "Resolve the build and print what would be built without building it")
+	fs.Var(&cmd.registryAuth, "registry-auth", "Set registry credentials in the 'host=base64(user:pass)' format")
+	fs.Var(&cmd.registryTokens, "registry-token", "Set a registry bearer token in the 'host=token' format, 'host=-' reads it from STDIN")
 }
 
 type buildCommand struct {
-	buildArgs      stringSlice
-	dockerfilePath string
-	labels         stringSlice
-	target         string
-	tags           stringSlice
-	platforms      stringSlice
+	attests             stringSlice
+	buildArgs           stringSlice
+	envFiles            stringSlice
+	noEnvAuto           bool
+	singlePlatformBase  bool
+	buildContexts       stringSlice
+	cacheNamespace      string
+	cacheMountNamespace string
+	cgroupParent        string
+	progressInterval    time.Duration
+	progressFile        string
+	metricsAddr         string
+	progress            string
+	logPrefix           string
+	pullTimeout         time.Duration
+	contextTimeout      time.Duration
+	dockerfilePath      string
+	contextCompression  string
+	digestFile          string
+	entrypoint          string
+	command             string
+	workdir             string
+	env                 stringSlice
+	user                string
+	labels              stringSlice
+	outputs             stringSlice
+	targets             stringSlice
+	tags                stringSlice
+	platforms           stringSlice
+	registryAuth        stringSlice
+	registryTokens      stringSlice
+	redactBuildArgs     stringSlice
+	contextExcludes     stringSlice
+	chmodContext        string
+	mounts              stringSlice
+	allow               stringSlice
+	securityOpts        stringSlice
+	sourceDateEpoch     string
+	timestampSource     string
+	stopAt              string
+	shmSize             string
+	maxLogSize          string
+	runcPath            string
+	runtime             string
 
-	contextDir string
-	noConsole  bool
-	noCache    bool
+	contextDir     string
+	noConsole      bool
+	noCache        bool
+	disableNetwork bool
+	dryRun         bool
+	noInstallRunc  bool
+	resolveDigests bool
+	pinDigests     bool
+
+	allPlatforms     bool
+	showHostPlatform bool
+	platformReport   bool
+	noEmulationCheck bool
+	platformFallback bool
+	quietPull        bool
+	labelInherit     bool
+	ociLabels        bool
+	strictBuildArgs  bool
+	proxy            bool
+	noDefaultLatest  bool
+	keepGitDir       bool
+	explainCache     bool
+	quietSuccess     bool
+	quiet            bool
+	noOutput         bool
+	targetSuffix     bool
+	keepDays         int
+	outputChecksums  bool
+	inlineCache      bool
+	cache            string
+
+	ociDest   string
+	localDest string
+	redactor  *strings.Replacer
+
+	prefixStdout *prefixWriter
+	prefixStderr *prefixWriter
 }
 
 func (cmd *buildCommand) Run(ctx context.Context, args []string) (err error) {
+	if cmd.quiet {
+		// Like --quiet-success without the progress, and errors on a line
+		// with their exit code.
+		cmd.quietSuccess = true
+		cmd.noConsole = true
+		defer func() {
+			if err != nil {
+				err = quietError{err}
+			}
+		}()
+	}
 	if len(args) < 1 {
-		return fmt.Errorf("must pass a path to build")
+		return usageErrorf("must pass a path to build")
+	}
+	cmd.targets = splitTargets(cmd.targets)
+	switch cmd.progress {
+	case "auto", progressJSON, progressRawJSON:
+	case "plain":
+		cmd.noConsole = true
+	default:
+		return usageErrorf("invalid progress type %s, expected auto, plain, json or rawjson", cmd.progress)
+	}
+	if cmd.logPrefix != "" {
+		if cmd.progress == progressJSON || cmd.progress == progressRawJSON {
+			return usageErrorf("--log-prefix prefixes the plain progress, it can not be used with --progress %s", cmd.progress)
+		}
+		cmd.noConsole = true
+		cmd.prefixStdout = newPrefixWriter(os.Stdout, cmd.logPrefix)
+		cmd.prefixStderr = newPrefixWriter(os.Stderr, cmd.logPrefix)
+		logrus.SetOutput(cmd.prefixStderr)
+	}
+	if cmd.stopAt != "" {
+		// Warm the cache up to the stage, the build that finishes from it
+		// has the outputs.
+		if len(cmd.targets) > 0 {
+			return usageErrorf("--stop-at builds up to the given stage, remove --target")
+		}
+		if len(cmd.outputs) > 0 || len(cmd.tags) > 0 {
+			return usageErrorf("--stop-at does not export an image, remove the outputs and `-t` tags")
+		}
+		cmd.targets = []string{cmd.stopAt}
+		cmd.noOutput = true
+	}
+	// Identify the build for --explain-cache before the paths are resolved.
+	explainKey := strings.Join([]string{args[0], cmd.dockerfilePath, strings.Join(cmd.targets, ","), strings.Join(cmd.platforms, ","), strings.Join(cmd.tags, ",")}, "\x00")
+
+	// Parse the outputs and make sure we know what to name the image.
+	outputs, err := parseOutputs(cmd.outputs)
+	if err != nil {
+		return err
+	}
+	noOutput := "--no-output"
+	for _, output := range outputs {
+		if output.exporter != outputCacheOnly {
+			continue
+		}
+		if len(outputs) > 1 || cmd.noOutput {
+			return usageErrorf("the %s output does not export anything, it can not be combined with other outputs or --no-output", outputCacheOnly)
+		}
+		// The same as --no-output.
+		noOutput = "the " + outputCacheOnly + " output"
+		cmd.outputs = nil
+		cmd.noOutput = true
+	}
+	if cmd.noOutput {
+		// Nothing is exported so there is nothing to name or report.
+		if len(cmd.outputs) > 0 || len(cmd.tags) > 0 {
+			return usageErrorf("%s does not export an image, remove the outputs and `-t` tags", noOutput)
+		}
+		if cmd.digestFile != "" || cmd.quietSuccess || cmd.inlineCache {
+			return usageErrorf("%s does not export an image, remove --digestfile, --quiet-success and --inline-cache", noOutput)
+		}
+		outputs = nil
+	}
+	if cmd.cache != "" && cmd.inlineCache {
+		// The controller only supports a single cache export per build.
+		return usageErrorf("--cache and --inline-cache both export the build cache, use only one of them")
+	}
+	if len(cmd.targets) > 1 {
+		// Each target is exported to its own image.
+		if len(cmd.outputs) > 0 {
+			return usageErrorf("building several targets stores the image of each target in the image store, remove the outputs")
+		}
+		if cmd.digestFile != "" || cmd.quietSuccess {
+			return usageErrorf("building several targets builds several images, remove --digestfile and --quiet-success")
+		}
+	} else if cmd.targetSuffix {
+		return usageErrorf("--target-suffix tags the images of several targets, pass more than one --target")
+	}
+	if cmd.keepDays < 0 {
+		return usageErrorf("--keep-days must be a positive number of days, got: %d", cmd.keepDays)
+	}
+	if cmd.keepDays > 0 && cmd.noOutput {
+		return usageErrorf("%s does not export an image to keep, remove --keep-days", noOutput)
+	}
+	namesImage := false
+	for _, output := range outputs {
+		if output.exporter == bkclient.ExporterOCI {
+			cmd.ociDest = output.attrs["dest"]
+			delete(output.attrs, "dest")
+			continue
+		}
+		if output.exporter == bkclient.ExporterLocal {
+			cmd.localDest = output.attrs["dest"]
+			delete(output.attrs, "dest")
+			continue
+		}
+		namesImage = true
+		if len(cmd.tags) < 1 && output.attrs["name"] == "" {
+			return usageErrorf("please specify an image tag with `-t`")
+		}
+	}
+	if !namesImage && !cmd.noOutput {
+		// The oci and local exporters only write files, they can not name
+		// the image.
+		kind := "oci"
+		if cmd.localDest != "" {
+			kind = "local"
+		}
+		if len(cmd.tags) > 0 {
+			return usageErrorf("the %s output can not name the image, remove the `-t` tags", kind)
+		}
+		if cmd.digestFile != "" {
+			return usageErrorf("the %s output does not report the digests of the image, remove --digestfile", kind)
+		}
+		if cmd.quietSuccess {
+			return usageErrorf("the %s output does not report the digest of the image, remove --quiet-success", kind)
+		}
+	}
+	if cmd.outputChecksums && cmd.localDest == "" {
+		return usageErrorf("--output-checksums needs a local output to write the checksums of, e.g. `-o type=local,dest=out`")
+	}
+	if cmd.quietSuccess && cmd.ociDest == "-" {
+		return usageErrorf("--quiet-success prints the digest to STDOUT, it can not be combined with an oci output to STDOUT")
 	}
 
-	if len(cmd.tags) < 1 {
-		return errors.New("please specify an image tag with `-t`")
+	if err := checkTimestampSource(cmd.timestampSource, args[0], cmd.sourceDateEpoch); err != nil {
+		return err
+	}
+	if cmd.runtime != "" && cmd.runcPath != "" {
+		return usageErrorf("--runtime and --runc-path both set the runtime, pass only one")
 	}
 
-	reexec()
-	if err := installRuncIfDNE(); err != nil {
+	if err := checkContextCompression(cmd.contextCompression); err != nil {
+		return err
+	}
+	maxLogSize, err := parseMaxLogSize(cmd.maxLogSize)
+	if err != nil {
 		return err
 	}
+	// The dockerfile frontend of the embedded builder predates attestations
+	// and ignores the attest attrs, do not build without them silently.
+	if len(cmd.attests) > 0 {
+		return usageErrorf("the embedded builder does not generate attestations, remove --attest")
+	}
+	allow, err := parseAllow(cmd.allow)
+	if err != nil {
+		return err
+	}
+	hostMounts, err := parseHostMounts(cmd.mounts, allow)
+	if err != nil {
+		return err
+	}
+	mountLabel, err := parseSecurityOpts(cmd.securityOpts)
+	if err != nil {
+		return err
+	}
+	var contextModes *client.FileModes
+	if cmd.chmodContext != "" {
+		modes, err := parseChmodContext(cmd.chmodContext)
+		if err != nil {
+			return err
+		}
+		contextModes = &modes
+	}
+
+	reexec()
+	if cmd.runtime != "" {
+		if cmd.runcPath, err = resolveRuntime(cmd.runtime); err != nil {
+			return systemError{err}
+		}
+	} else if cmd.runcPath != "" {
+		if cmd.runcPath, err = filepath.Abs(cmd.runcPath); err != nil {
+			return err
+		}
+	}
+	if err := installRuncIfDNE(cmd.runcPath, cmd.noInstallRunc); err != nil {
+		return systemError{err}
+	}
+	if cmd.showHostPlatform {
+		fmt.Fprintln(cmd.stderr(), describeHostPlatform(client.HostPlatform(), platforms.DefaultSpec()))
+	}
 
 	// Get the specified context.
 	cmd.contextDir = args[0]
 
 	// Parse what is set to come from stdin.
+	stdinToken := false
+	for _, token := range cmd.registryTokens {
+		stdinToken = stdinToken || strings.HasSuffix(token, "=-")
+	}
+	if stdinToken && (cmd.dockerfilePath == "-" || cmd.contextDir == "-") {
+		return usageErrorf("only one of the registry token, the dockerfile or the context can be read from stdin")
+	}
+	registryTokens, err := readRegistryTokens(cmd.registryTokens, os.Stdin)
+	if err != nil {
+		return err
+	}
 	if cmd.dockerfilePath == "-" {
 		cmd.dockerfilePath, err = dockerfileFromStdin()
 		if err != nil {
 			return fmt.Errorf("reading dockerfile from stdin failed: %v", err)
 		}
-		// On exit cleanup the temporary file we used hold the dockerfile from stdin.
-		defer os.RemoveAll(cmd.dockerfilePath)
+		// On exit cleanup the temporary file we used hold the dockerfile from stdin.
+		defer os.RemoveAll(cmd.dockerfilePath)
+	} else if isURL(cmd.dockerfilePath) {
+		url := cmd.dockerfilePath
+		cmd.dockerfilePath, err = dockerfileFromURL(url, cmd.contextTimeout)
+		if err != nil {
+			return fmt.Errorf("fetching dockerfile from %s failed: %v", url, err)
+		}
+		// On exit cleanup the temporary file we used hold the dockerfile from the URL.
+		defer os.RemoveAll(cmd.dockerfilePath)
+	}
+
+	if cmd.contextDir == "" {
+		return usageErrorf("please specify build context (e.g. \".\" for the current directory)")
+	}
+
+	// The remote and commit of a git context for --oci-labels and
+	// --timestamp-source.
+	var (
+		gitRemote string
+		commit    gitCommit
+	)
+	if cmd.contextDir == "-" {
+		cmd.contextDir, err = contextFromStdin(cmd.dockerfilePath)
+		if err != nil {
+			return fmt.Errorf("reading context from stdin failed: %v", err)
+		}
+		// On exit cleanup the temporary directory we used hold the files from stdin.
+		defer os.RemoveAll(cmd.contextDir)
+	} else if isGitContext(cmd.contextDir) {
+		tmpDir, dir, checkout, err := contextFromGit(cmd.contextDir, cmd.keepGitDir)
+		if tmpDir != "" {
+			// On exit cleanup the temporary directory we used hold the checkout.
+			defer os.RemoveAll(tmpDir)
+		}
+		if err != nil {
+			return fmt.Errorf("cloning git context %s failed: %v", cmd.contextDir, err)
+		}
+		gitRemote, _, _ = parseGitContext(cmd.contextDir)
+		commit = checkout
+		cmd.contextDir = dir
+		if cmd.timestampSource == timestampSourceGit {
+			cmd.sourceDateEpoch = strconv.FormatInt(commit.time.Unix(), 10)
+		}
+	} else {
+		// Unpack the context if it is a tar archive on disk.
+		dir, err := contextFromArchive(cmd.contextDir)
+		if dir != "" {
+			// On exit cleanup the temporary directory we used hold the files from the archive.
+			defer os.RemoveAll(dir)
+		}
+		if err != nil {
+			return fmt.Errorf("reading context from archive %s failed: %v", cmd.contextDir, err)
+		}
+		if dir != "" {
+			cmd.contextDir = dir
+		}
+	}
+
+	// Export the image of each target with its own tags.
+	if len(cmd.targets) > 1 && !cmd.noOutput {
+		names, err := targetTags(cmd.targets, cmd.tags, cmd.targetSuffix, !cmd.noDefaultLatest)
+		if err != nil {
+			return err
+		}
+		image := outputs[0]
+		outputs = nil
+		for i, target := range cmd.targets {
+			attrs := map[string]string{}
+			for k, v := range image.attrs {
+				attrs[k] = v
+			}
+			attrs["name"] = strings.Join(names[i], ",")
+			attrs[client.ExporterTarget] = target
+			outputs = append(outputs, buildOutput{exporter: image.exporter, attrs: attrs})
+		}
+	}
+
+	for position, tag := range cmd.tags {
+		cmd.tags[position], err = normalizeTag(tag, !cmd.noDefaultLatest)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, output := range outputs {
+		if output.attrs["name"] == "" && output.exporter != bkclient.ExporterOCI && output.exporter != bkclient.ExporterLocal {
+			output.attrs["name"] = strings.Join(cmd.tags, ",")
+		}
+	}
+	if err := checkOutputDestinations(outputs); err != nil {
+		return err
+	}
+	initialTag := "to warm the cache"
+	if len(outputs) > 0 {
+		initialTag = strings.Split(outputs[0].attrs["name"], ",")[0]
+	}
+	if len(cmd.targets) > 1 && len(outputs) > 0 {
+		var names []string
+		for _, output := range outputs {
+			names = append(names, strings.Split(output.attrs["name"], ",")[0])
+		}
+		initialTag = strings.Join(names, ", ")
+	}
+	if len(outputs) > 0 && outputs[0].exporter == bkclient.ExporterOCI {
+		initialTag = "an OCI archive to " + cmd.ociDest
+		if cmd.ociDest == "-" {
+			initialTag = "an OCI archive to STDOUT"
+		}
+	}
+	if len(outputs) > 0 && outputs[0].exporter == bkclient.ExporterLocal {
+		initialTag = "files to " + cmd.localDest
+	}
+
+	// Set the dockerfile path as the default if one was not given.
+	if cmd.dockerfilePath == "" {
+		cmd.dockerfilePath, err = securejoin.SecureJoin(cmd.contextDir, defaultDockerfileName)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Resolve the named build contexts into a copy of the dockerfile.
+	var ociLayouts map[string]string
+	if len(cmd.buildContexts) > 0 {
+		var contexts map[string]string
+		contexts, ociLayouts, err = parseBuildContexts(cmd.buildContexts)
+		if err != nil {
+			return err
+		}
+		tmpDir, err := localContextLayouts(contexts, ociLayouts)
+		if tmpDir != "" {
+			// On exit cleanup the temporary directory we used hold the images of the local contexts.
+			defer os.RemoveAll(tmpDir)
+		}
+		if err != nil {
+			return err
+		}
+		cmd.dockerfilePath, err = applyBuildContexts(cmd.dockerfilePath, contexts)
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(filepath.Dir(cmd.dockerfilePath))
+	}
+	if cmd.pinDigests && len(cmd.buildContexts) < 1 {
+		// Pin the base images in a copy of the dockerfile, its directory is
+		// the dockerfile local dir of the client.
+		cmd.dockerfilePath, err = applyBuildContexts(cmd.dockerfilePath, nil)
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(filepath.Dir(cmd.dockerfilePath))
+	}
+
+	if cmd.platforms, err = resolvePlatforms(cmd.platforms); err != nil {
+		return err
+	}
+
+	// Check if we need to resolve the platforms from the base image.
+	if cmd.allPlatforms && len(cmd.platforms) > 0 {
+		return usageErrorf("--all-platforms builds every platform of the base image, remove --platform")
+	}
+	allPlatforms := cmd.allPlatforms
+	for _, p := range cmd.platforms {
+		allPlatforms = allPlatforms || p == allPlatformsValue
+	}
+	if allPlatforms && len(cmd.platforms) > 1 {
+		return usageErrorf("cannot combine --platform all with other platforms")
+	}
+
+	if len(cmd.platforms) < 1 {
+		cmd.platforms = []string{platforms.Format(defaultPlatform())}
+	}
+	platforms := strings.Join(cmd.platforms, ",")
+
+	// Create the client.
+	c, err := client.New(stateDir, backend, cmd.getLocalDirs())
+	if err != nil {
+		return systemError{err}
+	}
+	defer c.Close()
+
+	if err := c.AddRegistryAuth(cmd.registryAuth...); err != nil {
+		return err
+	}
+	if err := c.AddRegistryToken(registryTokens...); err != nil {
+		return err
+	}
+	if err := c.SetCacheNamespace(cmd.cacheNamespace); err != nil {
+		return err
+	}
+	if err := c.SetCacheMountNamespace(cmd.cacheMountNamespace); err != nil {
+		return err
+	}
+	if err := c.SetCgroupParent(cmd.cgroupParent); err != nil {
+		return err
+	}
+	if err := c.SetPullTimeout(cmd.pullTimeout); err != nil {
+		return err
+	}
+	c.SetOCILayouts(ociLayouts)
+	if cmd.runcPath != "" {
+		c.SetRuntime(cmd.runcPath)
+	}
+	if err := c.SetHostMounts(hostMounts); err != nil {
+		return err
+	}
+	if err := c.SetMountLabel(mountLabel); err != nil {
+		return err
+	}
+	if cmd.shmSize != "" {
+		size, err := parseShmSize(cmd.shmSize)
+		if err != nil {
+			return err
+		}
+		if err := c.SetShmSize(size); err != nil {
+			return err
+		}
+	}
+
+	// Create the frontend attrs.
+	frontendAttrs := map[string]string{
+		// We use the base for filename here because we already set up the local dirs which sets the path in createController.
+		"filename": filepath.Base(cmd.dockerfilePath),
+		"target":   "",
+		"platform": platforms,
+	}
+	if len(cmd.targets) == 1 {
+		frontendAttrs["target"] = cmd.targets[0]
+	}
+	if len(cmd.targets) > 1 {
+		// The frontend builds each of the targets in the same build.
+		frontendAttrs[client.FrontendTargets] = strings.Join(cmd.targets, ",")
+	}
+	if cmd.noCache {
+		frontendAttrs["no-cache"] = ""
+	}
+	if cmd.disableNetwork {
+		frontendAttrs["force-network-mode"] = "none"
+	}
+
+	// Get the build args and add them to frontend attrs, the proxy variables
+	// and env files go first so the build-arg flags take precedence.
+	var autoArgs, autoLabels map[string]string
+	if !cmd.noEnvAuto {
+		autoArgs, autoLabels, err = readAutoEnvFile(cmd.contextDir)
+		if err != nil {
+			return err
+		}
+	}
+	buildArgs := map[string]string{}
+	for k, v := range autoArgs {
+		frontendAttrs["build-arg:"+k] = v
+		buildArgs[k] = v
+	}
+	if cmd.proxy {
+		for k, v := range proxyBuildArgs() {
+			frontendAttrs["build-arg:"+k] = v
+			buildArgs[k] = v
+		}
+	}
+	for _, envFile := range cmd.envFiles {
+		env, err := readEnvFile(envFile)
+		if err != nil {
+			return err
+		}
+		for k, v := range env {
+			frontendAttrs["build-arg:"+k] = v
+			buildArgs[k] = v
+		}
+	}
+	// The values resolved from a source are masked like --redact-build-args.
+	redacted := append([]string{}, cmd.redactBuildArgs...)
+	// The build args scoped to a platform by platform.
+	platformArgs := map[string]map[string]string{}
+	for _, buildArg := range cmd.buildArgs {
+		kv := strings.SplitN(buildArg, "=", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("invalid build-arg value %s", buildArg)
+		}
+		name, platform, err := client.ParsePlatformBuildArg(kv[0])
+		if err != nil {
+			return err
+		}
+		v, resolved, err := resolveBuildArg(name, kv[1])
+		if err != nil {
+			return err
+		}
+		if resolved {
+			redacted = append(redacted, name)
+		}
+		if platform != "" {
+			if platformArgs[platform] == nil {
+				platformArgs[platform] = map[string]string{}
+			}
+			frontendAttrs[client.PlatformBuildArgPrefix+platform+":"+name] = v
+			platformArgs[platform][name] = v
+			continue
+		}
+		frontendAttrs["build-arg:"+name] = v
+		buildArgs[name] = v
+	}
+
+	redactedArgs := []map[string]string{buildArgs}
+	// The checks of the build arg names see the scoped ones too.
+	checkedArgs := map[string]string{}
+	for _, args := range platformArgs {
+		redactedArgs = append(redactedArgs, args)
+		for k, v := range args {
+			checkedArgs[k] = v
+		}
+	}
+	for k, v := range buildArgs {
+		checkedArgs[k] = v
+	}
+
+	cmd.redactor = buildArgRedactor(redacted, redactedArgs...)
+
+	// Make sure all the build args are declared in the dockerfile.
+	if cmd.strictBuildArgs {
+		if err := cmd.checkBuildArgs(checkedArgs); err != nil {
+			return err
+		}
+	}
+
+	// Expand into every platform the base image supports.
+	if allPlatforms {
+		ps, err := cmd.baseImagePlatforms(ctx, c, buildArgs)
+		if err != nil {
+			return err
+		}
+		frontendAttrs["platform"] = strings.Join(ps, ",")
+	}
+
+	// Report and pin the digests of the base images.
+	if cmd.resolveDigests || cmd.pinDigests {
+		if err := cmd.resolveBaseDigests(ctx, c, buildArgs); err != nil {
+			return err
+		}
+	}
+
+	// Skip the target platforms RUN instructions can not be executed for.
+	if cmd.platformFallback {
+		ps, err := cmd.fallbackPlatforms(strings.Split(frontendAttrs["platform"], ","))
+		if err != nil {
+			return err
+		}
+		frontendAttrs["platform"] = strings.Join(ps, ",")
+	}
+
+	// Make sure the base image does not need a choice of manifest.
+	if cmd.singlePlatformBase {
+		if err := cmd.checkSinglePlatformBase(ctx, c, buildArgs, strings.Split(frontendAttrs["platform"], ",")); err != nil {
+			return err
+		}
+	}
+
+	// Warn if RUN instructions can not be executed for the target platforms.
+	if !cmd.noEmulationCheck {
+		cmd.checkEmulation(strings.Split(frontendAttrs["platform"], ","))
+	}
+	if mountLabel == "" {
+		cmd.checkMountLabel()
+	}
+	warnUnbuiltPlatformArgs(cmd.stderr(), platformArgs, strings.Split(frontendAttrs["platform"], ","))
+
+	labels := map[string]string{}
+	for k, v := range autoLabels {
+		labels[k] = v
+	}
+	if cmd.ociLabels {
+		created := time.Now()
+		if epoch, err := strconv.ParseInt(cmd.sourceDateEpoch, 10, 64); err == nil {
+			created = time.Unix(epoch, 0)
+		}
+		for k, v := range ociLabels(created, gitRemote, commit.revision) {
+			labels[k] = v
+		}
+	}
+	for _, label := range cmd.labels {
+		kv := strings.SplitN(label, "=", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("invalid label value %s", label)
+		}
+		labels[kv[0]] = kv[1]
+	}
+	// Merge in the labels of the base image.
+	if cmd.labelInherit {
+		labels, err = cmd.inheritLabels(ctx, c, buildArgs, labels)
+		if err != nil {
+			return err
+		}
+	}
+	for k, v := range labels {
+		frontendAttrs["label:"+k] = v
+	}
+
+	// Get the created time of the image from the source date epoch.
+	created, err := cmd.createdTime(frontendAttrs)
+	if err != nil {
+		return err
+	}
+
+	if cmd.dryRun {
+		// Make sure the dockerfile is there since we will not get to the frontend.
+		if _, err := os.Stat(cmd.dockerfilePath); err != nil {
+			return fmt.Errorf("resolving dockerfile failed: %v", err)
+		}
+	} else if !cmd.quietSuccess {
+		fmt.Fprintf(cmd.stdout(), "Building %s\n", initialTag)
+		fmt.Fprintln(cmd.stdout(), "Setting up the rootfs... this may take a bit.")
+	}
+
+	// Send the oci archive to its destination.
+	if cmd.ociDest != "" && !cmd.dryRun {
+		w, err := ociOutput(cmd.ociDest)
+		if err != nil {
+			return err
+		}
+		// The session closes the output once the archive is written, this
+		// takes care of failed builds.
+		defer w.Close()
+		c.SetExportOutput(w)
+	}
+	// Write the files of the local output to their directory.
+	if cmd.localDest != "" && !cmd.dryRun {
+		c.SetExportDir(cmd.localDest)
+	}
+	if len(cmd.contextExcludes) > 0 {
+		excludes, err := contextExcludes(cmd.contextDir, cmd.contextExcludes)
+		if err != nil {
+			return err
+		}
+		c.SetLocalExcludes("context", excludes)
+	}
+	if contextModes != nil {
+		c.SetLocalModes("context", *contextModes)
+	}
+
+	// Create the context.
+	ctx = appcontext.Context()
+	sess, sessDialer, err := c.Session(ctx)
+	if err != nil {
+		return err
+	}
+	id := identity.NewID()
+	ctx = session.NewContext(ctx, sess.ID())
+	ctx = namespaces.WithNamespace(ctx, "buildkit")
+
+	cache := cmd.cacheOptions()
+	if len(cache.Imports) > 0 {
+		// The dockerfile frontend only imports the cache it is told to
+		// through its attrs.
+		b, err := json.Marshal(cache.Imports)
+		if err != nil {
+			return err
+		}
+		frontendAttrs["cache-imports"] = string(b)
+	}
+
+	exporter, exporterAttrs := solveExporter(outputs)
+	overrides, err := cmd.configOverrides()
+	if err != nil {
+		return err
+	}
+	overrides.Created = created
+	if !overrides.IsEmpty() && exporter != "" {
+		b, err := json.Marshal(overrides)
+		if err != nil {
+			return err
+		}
+		exporterAttrs[client.ExporterConfigOverrides] = string(b)
+	}
+	if cmd.keepDays > 0 && exporter != "" {
+		exporterAttrs[client.ExporterKeepDuration] = (time.Duration(cmd.keepDays) * 24 * time.Hour).String()
+	}
+	req := &controlapi.SolveRequest{
+		Ref:           id,
+		Session:       sess.ID(),
+		Exporter:      exporter,
+		ExporterAttrs: exporterAttrs,
+		Frontend:      "dockerfile.v0",
+		FrontendAttrs: frontendAttrs,
+		Cache:         cache,
+	}
+
+	// Print what would be built and stop before solving.
+	if cmd.dryRun {
+		sess.Close()
+		printDryRun(cmd.stdout(), req, cmd.getLocalDirs(), cmd.redact)
+		return nil
+	}
+
+	// Collect the results per platform to report which platforms failed.
+	var report *platformReport
+	if ps := strings.Split(frontendAttrs["platform"], ","); cmd.platformReport && len(ps) > 1 {
+		report = newPlatformReport(ps)
+	}
+
+	// Compare the build with the previous one to explain the cache misses.
+	var explainer *cacheExplainer
+	explainPath := cacheRecordPath(stateDir, explainKey)
+	if cmd.explainCache {
+		previous, err := readCacheRecord(explainPath)
+		if err != nil {
+			return err
+		}
+		files, err := contextFiles(cmd.contextDir)
+		if err != nil {
+			return err
+		}
+		explainer = newCacheExplainer(previous, buildArgs, files)
+	}
+
+	var progress *progressFile
+	if cmd.progressFile != "" {
+		progress, err = createProgressFile(cmd.progressFile)
+		if err != nil {
+			return err
+		}
+		defer progress.close()
+	}
+
+	metrics, err := startBuildMetrics(cmd.metricsAddr, c.RegistryStats)
+	if err != nil {
+		return err
+	}
+	defer metrics.close()
+
+	eg, solveCtx := errgroup.WithContext(ctx)
+
+	ch := make(chan *controlapi.StatusResponse)
+	eg.Go(func() error {
+		return sess.Run(solveCtx, sessDialer)
+	})
+	// Solve the dockerfile.
+	var exporterResponse map[string]string
+	eg.Go(func() error {
+		defer sess.Close()
+		var err error
+		exporterResponse, err = c.Solve(solveCtx, req, ch)
+		return err
+	})
+	eg.Go(func() error {
+		if cmd.progress == progressJSON || cmd.progress == progressRawJSON {
+			return showJSONProgress(ch, cmd.stdout(), cmd.progress == progressRawJSON, report.update, explainer.update, progress.write, metrics.update)
+		}
+		return showProgress(ch, cmd.stdout(), cmd.noConsole, cmd.quietPull, cmd.progressInterval, maxLogSize, c.TransferStats, report.update, explainer.update, progress.write, metrics.update)
+	})
+	err = eg.Wait()
+	metrics.finish(err)
+	if err != nil {
+		if report != nil {
+			report.print(cmd.stderr())
+		}
+		if explainer != nil {
+			explainer.print(cmd.stderr())
+		}
+		if cmd.disableNetwork && strings.Contains(err.Error(), "executor failed running") {
+			return fmt.Errorf("%v (the network of RUN steps is disabled by --disable-network)", err)
+		}
+		return err
+	}
+	if err := progress.close(); err != nil {
+		return err
+	}
+	// Apply the gc policy now, the controller does not get to it before
+	// img exits.
+	if size, err := c.GC(ctx); err != nil {
+		fmt.Fprintf(cmd.stderr(), "WARNING: %v\n", err)
+	} else if size > 0 {
+		logrus.Debugf("gc cleaned up %d bytes", size)
+	}
+	if explainer != nil {
+		explainer.print(cmd.stdout())
+		if err := writeCacheRecord(explainPath, explainer.record()); err != nil {
+			return err
+		}
+	}
+	if !cmd.quietSuccess {
+		cmd.warnUnused(checkedArgs)
+		cmd.reportOnBuildTriggers(c, buildArgs)
+	}
+	if cmd.digestFile != "" {
+		if err := writeDigestFile(cmd.digestFile, exporterResponse); err != nil {
+			return err
+		}
+	}
+	if cmd.quietSuccess {
+		fmt.Fprintln(os.Stdout, exporterResponse[client.ExporterImageDigest])
+		return nil
+	}
+	if cmd.noOutput {
+		fmt.Fprintln(cmd.stdout(), "Cache warmed")
+		return nil
+	}
+	if cmd.outputChecksums {
+		n, err := writeChecksums(cmd.localDest)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(cmd.stdout(), "Wrote the checksums of %d files to %s\n", n, filepath.Join(cmd.localDest, checksumsFile))
+	}
+	fmt.Fprintf(cmd.stdout(), "Successfully built %s\n", initialTag)
+	for _, output := range outputs {
+		if output.exporter == client.ExporterRegistry {
+			fmt.Fprintf(cmd.stdout(), "Pushed %s, it was not stored in the local image store\n", output.attrs["name"])
+		}
+	}
+
+	return nil
+}
+
+// writeDigestFile writes the JSON map of the platforms of the built image to
+// the digests of their manifests from the exporter response for --digestfile.
+// Single platform builds have one entry.
+func writeDigestFile(path string, exporterResponse map[string]string) error {
+	digests := map[string]digest.Digest{}
+	if err := json.Unmarshal([]byte(exporterResponse[client.ExporterPlatformDigests]), &digests); err != nil {
+		return fmt.Errorf("the exporter did not report the digests of the image: %v", err)
+	}
+
+	b, err := json.MarshalIndent(digests, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path, append(b, '\n'), 0644); err != nil {
+		return fmt.Errorf("writing digest file failed: %v", err)
+	}
+	return nil
+}
+
+// stdout returns where to print the build output, STDERR if the oci archive
+// is streamed to STDOUT or only the digest is printed to it.
+func (cmd *buildCommand) stdout() io.Writer {
+	if cmd.quiet {
+		return ioutil.Discard
+	}
+	if cmd.ociDest == "-" || cmd.quietSuccess {
+		return cmd.stderr()
+	}
+	if cmd.prefixStdout != nil {
+		return cmd.prefixStdout
+	}
+	return os.Stdout
+}
+
+// stderr returns where the warnings and reports of the build are printed,
+// nowhere for -q.
+func (cmd *buildCommand) stderr() io.Writer {
+	if cmd.quiet {
+		return ioutil.Discard
+	}
+	if cmd.prefixStderr != nil {
+		return cmd.prefixStderr
+	}
+	return os.Stderr
+}
+
+// ociOutput opens the destination of the oci archive, - is STDOUT.
+func ociOutput(dest string) (io.WriteCloser, error) {
+	if dest == "-" {
+		return &flushCloser{Writer: bufio.NewWriter(os.Stdout)}, nil
+	}
+	f, err := os.Create(dest)
+	if err != nil {
+		return nil, fmt.Errorf("creating oci output failed: %v", err)
+	}
+	return f, nil
+}
+
+// flushCloser flushes the buffered writer on Close, leaving the underlying
+// writer open.
+type flushCloser struct {
+	*bufio.Writer
+}
+
+func (f *flushCloser) Close() error {
+	return f.Flush()
+}
+
+// baseImage parses the dockerfile and returns the base image of the target
+// stage, the first of several targets, empty for scratch.
+func (cmd *buildCommand) baseImage(buildArgs map[string]string) (string, error) {
+	df, err := parseDockerfile(cmd.dockerfilePath)
+	if err != nil {
+		return "", err
+	}
+	target := ""
+	if len(cmd.targets) > 0 {
+		target = cmd.targets[0]
+	}
+	return df.baseImage(target, buildArgs)
+}
+
+// checkBuildArgs errors if any of the build args is not declared with ARG
+// in the dockerfile.
+func (cmd *buildCommand) checkBuildArgs(buildArgs map[string]string) error {
+	df, err := parseDockerfile(cmd.dockerfilePath)
+	if err != nil {
+		return err
+	}
+	if undeclared := df.undeclaredArgs(buildArgs); len(undeclared) > 0 {
+		return fmt.Errorf("build-args %s are not declared with ARG in dockerfile %s", strings.Join(undeclared, ", "), cmd.dockerfilePath)
+	}
+	return nil
+}
+
+// warnUnused warns about the build args and stages the build did not use.
+// This is purely informative so any failure is only logged.
+func (cmd *buildCommand) warnUnused(buildArgs map[string]string) {
+	df, err := parseDockerfile(cmd.dockerfilePath)
+	if err == nil {
+		err = df.printUnused(cmd.stderr(), cmd.targets, buildArgs)
+	}
+	if err != nil {
+		logrus.Debugf("checking for unused build-args and stages failed: %s", cmd.redact(err.Error()))
+	}
+}
+
+// resolveBaseDigests prints the digests of the base images of the dockerfile
+// and pins them in the copy of the dockerfile for --pin-digests.
+func (cmd *buildCommand) resolveBaseDigests(ctx context.Context, c *client.Client, buildArgs map[string]string) error {
+	df, err := parseDockerfile(cmd.dockerfilePath)
+	if err != nil {
+		return err
+	}
+	digests, err := resolveBaseDigests(ctx, df, buildArgs, c.ResolveDigest)
+	if err != nil {
+		return err
+	}
+	digests.print(cmd.stdout(), cmd.redact)
+	if cmd.pinDigests {
+		return digests.pin(cmd.dockerfilePath)
+	}
+	return nil
+}
+
+// reportOnBuildTriggers lets the user know about the ONBUILD triggers of the
+// base image, from its config the frontend resolved during the build so it
+// is not resolved again. This is purely informative so any failure is only
+// logged.
+func (cmd *buildCommand) reportOnBuildTriggers(c *client.Client, buildArgs map[string]string) {
+	base, err := cmd.baseImage(buildArgs)
+	if err != nil || base == "" {
+		return
+	}
+	named, err := reference.ParseNormalizedNamed(base)
+	if err != nil {
+		return
+	}
+	config, ok := c.ResolvedImageConfig(reference.TagNameOnly(named).String())
+	if !ok {
+		return
+	}
+
+	if err := printOnBuildTriggers(cmd.stdout(), base, config); err != nil {
+		logrus.Debugf("checking ONBUILD triggers of base image %s failed: %s", cmd.redact(base), cmd.redact(err.Error()))
+	}
+}
+
+// checkEmulation warns if the dockerfile has RUN instructions and there is
+// no emulator registered for target platforms the host can not run.
+func (cmd *buildCommand) checkEmulation(targets []string) {
+	df, err := parseDockerfile(cmd.dockerfilePath)
+	if err != nil || !df.hasRunCommands() {
+		return
+	}
+	if err := checkEmulation(binfmtMiscDir, client.HostPlatform(), targets); err != nil {
+		fmt.Fprintf(cmd.stderr(), "WARNING: %v\n", err)
+	}
+}
+
+// checkMountLabel warns if the dockerfile has RUN instructions and SELinux
+// is enforcing, where the mounts of the RUN containers need a label.
+func (cmd *buildCommand) checkMountLabel() {
+	if !client.SELinuxEnforcing() {
+		return
+	}
+	df, err := parseDockerfile(cmd.dockerfilePath)
+	if err != nil || !df.hasRunCommands() {
+		return
+	}
+	fmt.Fprintln(cmd.stderr(), "WARNING: SELinux is enforcing and the mounts of the RUN steps have no label, they may fail with permission denied, set one with --security-opt label=CONTEXT")
+}
+
+// fallbackPlatforms returns the target platforms without the ones there is
+// no emulator for, warning about each one skipped. All of them are kept if
+// the dockerfile has no RUN instructions.
+func (cmd *buildCommand) fallbackPlatforms(targets []string) ([]string, error) {
+	df, err := parseDockerfile(cmd.dockerfilePath)
+	if err != nil {
+		return nil, err
+	}
+	if !df.hasRunCommands() {
+		return targets, nil
+	}
+	supported, skipped, err := fallbackPlatforms(binfmtMiscDir, client.HostPlatform(), targets)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range skipped {
+		fmt.Fprintf(cmd.stderr(), "WARNING: skipping platform %s, no emulator is registered in %s to run its RUN instructions\n", p, binfmtMiscDir)
+	}
+	return supported, nil
+}
+
+// inheritLabels resolves the config of the base image of the first stage in
+// the registry and returns its labels merged with the given labels, which
+// take precedence.
+func (cmd *buildCommand) inheritLabels(ctx context.Context, c *client.Client, buildArgs, labels map[string]string) (map[string]string, error) {
+	df, err := parseDockerfile(cmd.dockerfilePath)
+	if err != nil {
+		return nil, err
+	}
+	base, err := df.stageBaseImage(0, buildArgs)
+	if err != nil {
+		return nil, err
+	}
+	if base == "" {
+		// Images built from scratch have no labels to inherit.
+		return labels, nil
+	}
+
+	var platform *specs.Platform
+	if p, err := parsePlatform(cmd.platforms[0]); err == nil {
+		platform = &p
+	}
+	_, config, err := c.ImageConfig(ctx, base, platform)
+	if err != nil {
+		return nil, fmt.Errorf("resolving labels of base image %s failed: %v", base, err)
+	}
+	return inheritLabels(config, labels)
+}
+
+// checkSinglePlatformBase checks the manifest list of the base image has
+// exactly one manifest for each of the target platforms. Single platform base
+// images and scratch leave nothing to choose.
+func (cmd *buildCommand) checkSinglePlatformBase(ctx context.Context, c *client.Client, buildArgs map[string]string, targets []string) error {
+	base, err := cmd.baseImage(buildArgs)
+	if err != nil || base == "" {
+		return err
+	}
+	index, err := c.IndexPlatforms(ctx, base)
+	if err != nil {
+		return fmt.Errorf("resolving platforms of base image %s failed: %v", base, err)
+	}
+	if index == nil {
+		return nil
+	}
+	return checkSinglePlatformBase(base, index, targets)
+}
+
+// baseImagePlatforms resolves the base image of the target stage in the
+// registry and returns all the platforms of its manifest list.
+func (cmd *buildCommand) baseImagePlatforms(ctx context.Context, c *client.Client, buildArgs map[string]string) ([]string, error) {
+	base, err := cmd.baseImage(buildArgs)
+	if err != nil {
+		return nil, err
+	}
+	if base == "" {
+		return nil, errors.New("cannot build all platforms of an image based on scratch")
+	}
+
+	ps, err := c.ImagePlatforms(ctx, base)
+	if err != nil {
+		return nil, fmt.Errorf("resolving platforms of base image %s failed: %v", base, err)
+	}
+	fmt.Fprintf(cmd.stdout(), "Building for all platforms of %s: %s\n", base, strings.Join(ps, ", "))
+
+	return ps, nil
+}
+
+// outputCacheOnly is the type of the output which exports nothing, like
+// --no-output, to only run the build and populate the cache.
+const outputCacheOnly = "cacheonly"
+
+// outputPushName is the attr of the image output naming the image pushed to
+// the registry when it differs from the name in the local image store.
+const outputPushName = "push-name"
+
+// parseOutput parses the --output value in the 'type=<type>,key=value' format
+// into the exporter and its attributes. Keys other than type are passed
+// through to the exporter.
+func parseOutput(value string) (string, map[string]string, error) {
+	exporter := bkclient.ExporterImage
+	attrs := map[string]string{}
+	if value == "" {
+		return exporter, attrs, nil
+	}
+
+	fields, err := csv.NewReader(strings.NewReader(value)).Read()
+	if err != nil {
+		return "", nil, fmt.Errorf("parsing output value %q failed: %v", value, err)
+	}
+	for _, field := range fields {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 {
+			return "", nil, fmt.Errorf("invalid output value %s, expected key=value", value)
+		}
+		switch kv[0] {
+		case "type":
+			exporter = kv[1]
+		case "ref":
+			attrs["name"] = kv[1]
+		default:
+			attrs[kv[0]] = kv[1]
+		}
+	}
+
+	if _, ok := attrs[outputPushName]; ok && exporter != bkclient.ExporterImage {
+		return "", nil, fmt.Errorf("%s is only supported by the image output", outputPushName)
+	}
+
+	switch exporter {
+	case bkclient.ExporterImage:
+		if pushName, ok := attrs[outputPushName]; ok {
+			if push, _ := strconv.ParseBool(attrs["push"]); push {
+				return "", nil, fmt.Errorf("%s pushes the image under another name, remove push", outputPushName)
+			}
+			if err := checkImageNames(outputPushName, pushName); err != nil {
+				return "", nil, err
+			}
+			if attrs["name"] != "" {
+				if err := checkImageNames("name", attrs["name"]); err != nil {
+					return "", nil, err
+				}
+			}
+		}
+	case client.ExporterRegistry:
+		// The registry exporter always pushes.
+		attrs["push"] = "true"
+	case bkclient.ExporterOCI:
+		if attrs["dest"] == "" {
+			return "", nil, errors.New("the oci output requires a dest, use dest=- to write to STDOUT")
+		}
+	case bkclient.ExporterLocal:
+		if attrs["dest"] == "" {
+			return "", nil, errors.New("the local output requires a dest directory")
+		}
+	case outputCacheOnly:
+		if len(attrs) > 0 {
+			return "", nil, fmt.Errorf("the %s output does not export anything, it takes no other keys", outputCacheOnly)
+		}
+	default:
+		return "", nil, fmt.Errorf("%q is not a valid output type", exporter)
+	}
+
+	return exporter, attrs, nil
+}
+
+// buildOutput is the exporter and its attrs of an --output.
+type buildOutput struct {
+	exporter string
+	attrs    map[string]string
+}
+
+// parseOutputs parses the --output values, the image exporter is the default
+// when none is given.
+func parseOutputs(values []string) ([]buildOutput, error) {
+	if len(values) < 1 {
+		values = []string{""}
+	}
+	outputs := make([]buildOutput, 0, len(values))
+	for _, value := range values {
+		exporter, attrs, err := parseOutput(value)
+		if err != nil {
+			return nil, err
+		}
+		outputs = append(outputs, splitPushName(buildOutput{exporter: exporter, attrs: attrs})...)
+	}
+	return outputs, nil
+}
+
+// checkImageNames validates the comma separated image names of the attr.
+func checkImageNames(key, names string) error {
+	for _, name := range strings.Split(names, ",") {
+		if _, err := reference.ParseNormalizedNamed(name); err != nil {
+			return fmt.Errorf("invalid %s %q of the image output: %v", key, name, err)
+		}
+	}
+	return nil
+}
+
+// splitPushName splits an image output with a push-name into an image output
+// storing the image under its name and a registry output pushing it under the
+// push-name. Both are exported by the multi exporter from the same build.
+func splitPushName(output buildOutput) []buildOutput {
+	pushName, ok := output.attrs[outputPushName]
+	if !ok {
+		return []buildOutput{output}
+	}
+	delete(output.attrs, outputPushName)
+	attrs := map[string]string{}
+	for k, v := range output.attrs {
+		attrs[k] = v
+	}
+	attrs["name"] = pushName
+	attrs["push"] = "true"
+	return []buildOutput{output, {exporter: client.ExporterRegistry, attrs: attrs}}
+}
+
+// checkOutputDestinations errors if two outputs write to the same
+// destination: the same image name in the local image store, the same image
+// name pushed to its registry, or the oci archive, since the session only
+// has one export output.
+func checkOutputDestinations(outputs []buildOutput) error {
+	seen := map[string]bool{}
+	check := func(dest string) error {
+		if seen[dest] {
+			return usageErrorf("more than one output %s, the outputs must have distinct destinations", dest)
+		}
+		seen[dest] = true
+		return nil
+	}
+	for _, output := range outputs {
+		var dests []string
+		switch output.exporter {
+		case bkclient.ExporterOCI:
+			dests = append(dests, "writes the oci archive")
+		case bkclient.ExporterLocal:
+			dests = append(dests, "writes the local files")
+		case client.ExporterRegistry:
+			for _, name := range strings.Split(output.attrs["name"], ",") {
+				dests = append(dests, "pushes "+name)
+			}
+		default:
+			for _, name := range strings.Split(output.attrs["name"], ",") {
+				dests = append(dests, "stores "+name+" in the image store")
+				if push, _ := strconv.ParseBool(output.attrs["push"]); push {
+					dests = append(dests, "pushes "+name)
+				}
+			}
+		}
+		for _, dest := range dests {
+			if err := check(dest); err != nil {
+				return err
+			}
+		}
+	}
+	if seen["writes the oci archive"] && seen["writes the local files"] {
+		return usageErrorf("the oci and local outputs can not be combined, the session only has one export target")
+	}
+	return nil
+}
+
+// solveExporter returns the exporter and its attrs of the solve request for
+// the outputs. Several outputs are exported by the multi exporter so the
+// image is only built once, without outputs nothing is exported.
+func solveExporter(outputs []buildOutput) (string, map[string]string) {
+	if len(outputs) == 0 {
+		return "", map[string]string{}
+	}
+	if len(outputs) == 1 {
+		return outputs[0].exporter, outputs[0].attrs
+	}
+	exporters := make([]string, 0, len(outputs))
+	attrs := make([]map[string]string, 0, len(outputs))
+	for _, output := range outputs {
+		exporters = append(exporters, output.exporter)
+		attrs = append(attrs, output.attrs)
+	}
+	return client.ExporterMulti, client.MultiExporterAttrs(exporters, attrs)
+}
+
+// configOverrides returns the overrides of the image config from the
+// --entrypoint, --cmd, --workdir, --env and --user flags.
+func (cmd *buildCommand) configOverrides() (client.ConfigOverrides, error) {
+	entrypoint, err := parseCommandFlag("entrypoint", cmd.entrypoint)
+	if err != nil {
+		return client.ConfigOverrides{}, err
+	}
+	command, err := parseCommandFlag("cmd", cmd.command)
+	if err != nil {
+		return client.ConfigOverrides{}, err
+	}
+	for _, env := range cmd.env {
+		if kv := strings.SplitN(env, "=", 2); len(kv) != 2 || kv[0] == "" {
+			return client.ConfigOverrides{}, fmt.Errorf("invalid env value %s, expected KEY=VALUE", env)
+		}
 	}
+	return client.ConfigOverrides{
+		Entrypoint: entrypoint,
+		Cmd:        command,
+		WorkingDir: cmd.workdir,
+		Env:        cmd.env,
+		User:       cmd.user,
+	}, nil
+}
 
-	if cmd.contextDir == "" {
-		return errors.New("please specify build context (e.g. \".\" for the current directory)")
+// parseCommandFlag parses the value of --entrypoint or --cmd like the
+// Dockerfile instruction: a JSON array is the exec form and anything else is
+// run with /bin/sh -c. An empty value is nil so the Dockerfile value is kept.
+func parseCommandFlag(flag, value string) ([]string, error) {
+	if value == "" {
+		return nil, nil
 	}
-
-	if cmd.contextDir == "-" {
-		cmd.contextDir, err = contextFromStdin(cmd.dockerfilePath)
-		if err != nil {
-			return fmt.Errorf("reading context from stdin failed: %v", err)
+	if strings.HasPrefix(strings.TrimSpace(value), "[") {
+		command := []string{}
+		if err := json.Unmarshal([]byte(value), &command); err != nil {
+			return nil, fmt.Errorf("parsing %s %s as a JSON array failed: %v", flag, value, err)
 		}
-		// On exit cleanup the temporary directory we used hold the files from stdin.
-		defer os.RemoveAll(cmd.contextDir)
+		return command, nil
 	}
+	return []string{"/bin/sh", "-c", value}, nil
+}
 
-	for position, tag := range cmd.tags {
-		// Parse the image name and tag.
-		named, err := reference.ParseNormalizedNamed(tag)
-		if err != nil {
-			return fmt.Errorf("parsing image name %q failed: %v", tag, err)
-		}
-		// Add the latest tag if they did not provide one.
-		named = reference.TagNameOnly(named)
-		cmd.tags[position] = named.String()
+// cacheOptions returns the cache options of the solve request, with an inline
+// cache export for --inline-cache and both an import and a max export of the
+// registry cache for --cache.
+func (cmd *buildCommand) cacheOptions() controlapi.CacheOptions {
+	var opts controlapi.CacheOptions
+	if cmd.inlineCache {
+		opts.Exports = append(opts.Exports, &controlapi.CacheOptionsEntry{Type: client.CacheExporterInline})
 	}
+	if cmd.cache != "" {
+		opts.Imports = append(opts.Imports, &controlapi.CacheOptionsEntry{
+			Type:  client.CacheRegistry,
+			Attrs: map[string]string{"ref": cmd.cache},
+		})
+		opts.Exports = append(opts.Exports, &controlapi.CacheOptionsEntry{
+			Type:  client.CacheRegistry,
+			Attrs: map[string]string{"ref": cmd.cache, "mode": "max"},
+		})
+	}
+	return opts
+}
 
-	initialTag := cmd.tags[0]
+// timestampSourceGit is the --timestamp-source for the commit time of a git
+// context.
+const timestampSourceGit = "git"
 
-	// Set the dockerfile path as the default if one was not given.
-	if cmd.dockerfilePath == "" {
-		cmd.dockerfilePath, err = securejoin.SecureJoin(cmd.contextDir, defaultDockerfileName)
-		if err != nil {
-			return err
-		}
+// checkTimestampSource validates --timestamp-source for the context, it can
+// not be combined with --source-date-epoch.
+func checkTimestampSource(source, context, sourceDateEpoch string) error {
+	switch {
+	case source == "":
+		return nil
+	case source != timestampSourceGit:
+		return usageErrorf("invalid timestamp-source %s, expected %s", source, timestampSourceGit)
+	case !isGitContext(context):
+		return usageErrorf("--timestamp-source git uses the commit time of a git context, %s is not a git context", context)
+	case sourceDateEpoch != "":
+		return usageErrorf("--timestamp-source and --source-date-epoch both set the source date epoch, pass only one")
 	}
+	return nil
+}
 
-	if len(cmd.platforms) < 1 {
-		cmd.platforms = []string{platforms.DefaultString()}
+// createdTime adds the SOURCE_DATE_EPOCH build arg for --source-date-epoch
+// and returns the created time of the image, nil without an epoch. Only the
+// created times of the config are set, the exporter of the embedded builder
+// writes the layers with the timestamps of the files as they are.
+func (cmd *buildCommand) createdTime(frontendAttrs map[string]string) (*time.Time, error) {
+	if cmd.sourceDateEpoch == "" {
+		return nil, nil
 	}
-	platforms := strings.Join(cmd.platforms, ",")
-
-	// Create the client.
-	c, err := client.New(stateDir, backend, cmd.getLocalDirs())
+	epoch, err := strconv.ParseInt(cmd.sourceDateEpoch, 10, 64)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("invalid source-date-epoch value %s, expected a unix timestamp", cmd.sourceDateEpoch)
 	}
-	defer c.Close()
+	frontendAttrs["build-arg:SOURCE_DATE_EPOCH"] = cmd.sourceDateEpoch
+	created := time.Unix(epoch, 0)
+	return &created, nil
+}
 
-	// Create the frontend attrs.
-	frontendAttrs := map[string]string{
-		// We use the base for filename here because we already set up the local dirs which sets the path in createController.
-		"filename": filepath.Base(cmd.dockerfilePath),
-		"target":   cmd.target,
-		"platform": platforms,
-	}
-	if cmd.noCache {
-		frontendAttrs["no-cache"] = ""
+// splitTargets splits the comma separated --target values into the target
+// stages, leaving out the empty ones.
+func splitTargets(values []string) []string {
+	var targets []string
+	for _, value := range values {
+		for _, target := range strings.Split(value, ",") {
+			if target = strings.TrimSpace(target); target != "" {
+				targets = append(targets, target)
+			}
+		}
 	}
+	return targets
+}
 
-	// Get the build args and add them to frontend attrs.
-	for _, buildArg := range cmd.buildArgs {
-		kv := strings.SplitN(buildArg, "=", 2)
-		if len(kv) != 2 {
-			return fmt.Errorf("invalid build-arg value %s", buildArg)
+// targetTags returns the tags of the image of each of several targets. The
+// tags are either one per target in the order of the targets or, with
+// suffix, every tag with the target appended to its tag, a name without a
+// tag is tagged with the target.
+func targetTags(targets, tags []string, suffix, defaultLatest bool) ([][]string, error) {
+	names := make([][]string, len(targets))
+	if !suffix {
+		if len(tags) != len(targets) {
+			return nil, usageErrorf("%d targets need a `-t` tag per target in the order of the targets, or --target-suffix, got %d tags", len(targets), len(tags))
+		}
+		for i, tag := range tags {
+			name, err := normalizeTag(tag, defaultLatest)
+			if err != nil {
+				return nil, err
+			}
+			names[i] = []string{name}
 		}
-		frontendAttrs["build-arg:"+kv[0]] = kv[1]
+		return names, nil
 	}
 
-	for _, label := range cmd.labels {
-		kv := strings.SplitN(label, "=", 2)
-		if len(kv) != 2 {
-			return fmt.Errorf("invalid label value %s", label)
+	for _, tag := range tags {
+		named, err := reference.ParseNormalizedNamed(tag)
+		if err != nil {
+			return nil, fmt.Errorf("parsing image name %q failed: %v", tag, err)
+		}
+		for i, target := range targets {
+			t := target
+			if tagged, ok := named.(reference.Tagged); ok {
+				t = tagged.Tag() + "-" + target
+			}
+			name, err := reference.WithTag(reference.TrimNamed(named), t)
+			if err != nil {
+				return nil, fmt.Errorf("tagging %s for target %s failed: %v", tag, target, err)
+			}
+			names[i] = append(names[i], name.String())
 		}
-		frontendAttrs["label:"+kv[0]] = kv[1]
 	}
+	return names, nil
+}
+
+// normalizeTag parses the image name and tag, adding the latest tag if none
+// was given unless defaultLatest is false, in which case that is an error.
+func normalizeTag(tag string, defaultLatest bool) (string, error) {
+	named, err := reference.ParseNormalizedNamed(tag)
+	if err != nil {
+		return "", fmt.Errorf("parsing image name %q failed: %v", tag, err)
+	}
+	if reference.IsNameOnly(named) && !defaultLatest {
+		return "", fmt.Errorf("image name %q has no tag, pass one explicitly such as %s:latest", tag, tag)
+	}
+	return reference.TagNameOnly(named).String(), nil
+}
 
-	fmt.Printf("Building %s\n", initialTag)
-	fmt.Println("Setting up the rootfs... this may take a bit.")
+const contextCompressionNone = "none"
 
-	// Create the context.
-	ctx = appcontext.Context()
-	sess, sessDialer, err := c.Session(ctx)
+// checkContextCompression validates the --context-compression algorithm.
+// The context is only worth compressing when it is uploaded to a remote
+// builder, img runs the builder in-process and reads the context from disk
+// over the session, so only none is supported for now.
+func checkContextCompression(algorithm string) error {
+	switch algorithm {
+	case contextCompressionNone:
+		return nil
+	case "gzip", "zstd":
+		return fmt.Errorf("context compression %s is only supported with a remote builder, the embedded builder reads the context in-process", algorithm)
+	default:
+		return usageErrorf("invalid context compression %s, must be one of gzip, zstd or none", algorithm)
+	}
+}
+
+// parseShmSize parses a human readable --shm-size value such as 2g.
+func parseShmSize(value string) (int64, error) {
+	size, err := units.RAMInBytes(value)
 	if err != nil {
-		return err
+		return 0, fmt.Errorf("invalid shm-size value %s: %v", value, err)
 	}
-	id := identity.NewID()
-	ctx = session.NewContext(ctx, sess.ID())
-	ctx = namespaces.WithNamespace(ctx, "buildkit")
-	eg, ctx := errgroup.WithContext(ctx)
+	if size <= 0 {
+		return 0, fmt.Errorf("invalid shm-size value %s, it must be positive", value)
+	}
+	return size, nil
+}
 
-	ch := make(chan *controlapi.StatusResponse)
-	eg.Go(func() error {
-		return sess.Run(ctx, sessDialer)
-	})
-	// Solve the dockerfile.
-	eg.Go(func() error {
-		defer sess.Close()
-		return c.Solve(ctx, &controlapi.SolveRequest{
-			Ref:      id,
-			Session:  sess.ID(),
-			Exporter: "image",
-			ExporterAttrs: map[string]string{
-				"name": strings.Join(cmd.tags, ","),
-			},
-			Frontend:      "dockerfile.v0",
-			FrontendAttrs: frontendAttrs,
-		}, ch)
-	})
-	eg.Go(func() error {
-		return showProgress(ch, cmd.noConsole)
-	})
-	if err := eg.Wait(); err != nil {
-		return err
+// parseChmodContext parses the --chmod-context value in the
+// 'MODE[,dir=MODE][,preserve-exec]' format. The directories default to the
+// file mode with the execute bits for who can read, e.g. 0755 for 0644.
+func parseChmodContext(value string) (client.FileModes, error) {
+	fields := strings.Split(value, ",")
+	file, err := parseFileMode(fields[0])
+	if err != nil {
+		return client.FileModes{}, fmt.Errorf("invalid chmod-context value %s: %v", value, err)
+	}
+	modes := client.FileModes{File: file, Dir: file | (file&0444)>>2}
+	for _, field := range fields[1:] {
+		kv := strings.SplitN(field, "=", 2)
+		switch {
+		case field == "preserve-exec":
+			modes.PreserveExec = true
+		case kv[0] == "dir" && len(kv) == 2:
+			if modes.Dir, err = parseFileMode(kv[1]); err != nil {
+				return client.FileModes{}, fmt.Errorf("invalid chmod-context value %s: %v", value, err)
+			}
+		default:
+			return client.FileModes{}, fmt.Errorf("invalid chmod-context value %s, unknown option %s", value, field)
+		}
 	}
-	fmt.Printf("Successfully built %s\n", initialTag)
+	return modes, nil
+}
 
-	return nil
+// parseFileMode parses an octal permission mode such as 0644.
+func parseFileMode(value string) (os.FileMode, error) {
+	mode, err := strconv.ParseUint(value, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("%s is not an octal mode between 0000 and 0777", value)
+	}
+	return os.FileMode(mode), nil
 }
 
 // dockerfileFromStdin copies a dockerfile from stdin to a temporary file.
@@ -230,10 +1746,64 @@ func dockerfileFromStdin() (string, error) {
 	return f.Name(), nil
 }
 
+// isURL returns if the value is an http or https URL.
+func isURL(value string) bool {
+	return strings.HasPrefix(value, "http://") || strings.HasPrefix(value, "https://")
+}
+
+// dockerfileFromURL fetches the Dockerfile at the URL into a temporary file,
+// giving up after the timeout unless it is zero. The response has to be a
+// non-empty text file.
+func dockerfileFromURL(url string, timeout time.Duration) (string, error) {
+	resp, err := (&http.Client{Timeout: timeout}).Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("reading response failed: %v", err)
+	}
+	if len(bytes.TrimSpace(b)) == 0 {
+		return "", errors.New("the dockerfile is empty")
+	}
+	contentType := http.DetectContentType(b)
+	if strings.HasPrefix(contentType, "text/html") {
+		return "", errors.New("expected a text file, got an HTML page, use the URL of the raw file")
+	}
+	if !strings.HasPrefix(contentType, "text/") {
+		return "", fmt.Errorf("expected a text file, got %s", contentType)
+	}
+
+	// Create a temporary file for the Dockerfile
+	f, err := ioutil.TempFile("", "img-build-dockerfile-")
+	if err != nil {
+		return "", fmt.Errorf("unable to create temporary file for dockerfile: %v", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(b); err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("writing to temporary file for dockerfile failed: %v", err)
+	}
+
+	return f.Name(), nil
+}
+
 // contextFromStdin will read the contents of stdin as either a
 // Dockerfile or tar archive. Returns the path to a temporary directory
 // for the build context..
 func contextFromStdin(dockerfileName string) (string, error) {
+	return contextFromReader(os.Stdin, dockerfileName)
+}
+
+// contextFromReader reads the contents of the reader as either a Dockerfile
+// or tar archive into a temporary directory for the build context.
+func contextFromReader(r io.Reader, dockerfileName string) (string, error) {
 	// Set the dockerfile name if it is empty.
 	if dockerfileName == "" {
 		dockerfileName = defaultDockerfileName
@@ -245,13 +1815,12 @@ func contextFromStdin(dockerfileName string) (string, error) {
 		return "", fmt.Errorf("unable to create temporary context directory: %v", err)
 	}
 
-	// Create a new reader from stdin.
-	buf := bufio.NewReader(os.Stdin)
+	// Create a new buffered reader.
+	buf := bufio.NewReader(r)
 
 	// Grab the magic number range from the reader.
-	archiveHeaderSize := 512 // number of bytes in an archive header
-	magic, err := buf.Peek(archiveHeaderSize)
-	if err != nil && err != io.EOF {
+	magic, err := peekHeader(buf, archiveHeaderSize)
+	if err != nil {
 		return tmpDir, fmt.Errorf("failed to peek context header from STDIN: %v", err)
 	}
 
@@ -280,10 +1849,76 @@ func contextFromStdin(dockerfileName string) (string, error) {
 	return tmpDir, err
 }
 
+// contextFromArchive unpacks the tar archive at path into a temporary
+// directory for the build context. It returns an empty directory if path is
+// not a file or not an archive, so it is used as is.
+func contextFromArchive(path string) (string, error) {
+	fi, err := os.Stat(path)
+	if err != nil || !fi.Mode().IsRegular() {
+		return "", nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	buf := bufio.NewReader(f)
+	magic, err := peekHeader(buf, archiveHeaderSize)
+	if err != nil {
+		return "", fmt.Errorf("failed to peek context header from %s: %v", path, err)
+	}
+	if !isArchive(magic) {
+		return "", nil
+	}
+
+	tmpDir, err := ioutil.TempDir("", "img-build-context-")
+	if err != nil {
+		return "", fmt.Errorf("unable to create temporary context directory: %v", err)
+	}
+	return tmpDir, untar(tmpDir, buf)
+}
+
+// peekHeader peeks at up to n bytes of the reader. Unlike a plain Peek it is
+// not an error to get fewer bytes, which happens for small contexts and for
+// FIFOs that stop delivering data, so the caller decides from what is there.
+func peekHeader(r *bufio.Reader, n int) ([]byte, error) {
+	header, err := r.Peek(n)
+	switch err {
+	case nil, io.EOF, io.ErrUnexpectedEOF, io.ErrNoProgress:
+		return header, nil
+	}
+	return header, err
+}
+
+// archiveHeaderSize is the number of bytes in an archive header.
+const archiveHeaderSize = 512
+
+// zstdMagic is the magic number of a zstd frame, archive.DetectCompression
+// does not know about zstd.
+var zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+// archiveCompression returns the compression of an archive from the magic
+// bytes of its header, empty if it is not compressed.
+func archiveCompression(header []byte) string {
+	if bytes.HasPrefix(header, zstdMagic) {
+		return "zstd"
+	}
+	switch archive.DetectCompression(header) {
+	case archive.Gzip:
+		return "gzip"
+	case archive.Bzip2:
+		return "bzip2"
+	case archive.Xz:
+		return "xz"
+	}
+	return ""
+}
+
 // isArchive checks for the magic bytes of a tar or any supported compression algorithm.
 func isArchive(header []byte) bool {
-	compression := archive.DetectCompression(header)
-	if compression != archive.Uncompressed {
+	if archiveCompression(header) != "" {
 		return true
 	}
 	r := tar.NewReader(bytes.NewBuffer(header))
@@ -291,15 +1926,46 @@ func isArchive(header []byte) bool {
 	return err == nil
 }
 
+// decompressStream decompresses the archive, zstd with the zstd binary like
+// the archive package does for xz.
+func decompressStream(r io.Reader) (io.ReadCloser, error) {
+	buf := bufio.NewReader(r)
+	header, err := peekHeader(buf, len(zstdMagic))
+	if err != nil {
+		return nil, err
+	}
+	if archiveCompression(header) != "zstd" {
+		return archive.DecompressStream(buf)
+	}
+
+	cmd := exec.Command("zstd", "-d", "-c", "-q")
+	cmd.Stdin = buf
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	pr, pw := io.Pipe()
+	cmd.Stdout = pw
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("decompressing the zstd archive needs the zstd binary: %v", err)
+	}
+	go func() {
+		if err := cmd.Wait(); err != nil {
+			pw.CloseWithError(fmt.Errorf("decompressing the zstd archive failed: %v: %s", err, strings.TrimSpace(stderr.String())))
+			return
+		}
+		pw.Close()
+	}()
+	return pr, nil
+}
+
 // untar unpacks a tarball to a given directory.
 func untar(dest string, r io.Reader) error {
-	gzr, err := gzip.NewReader(r)
+	dr, err := decompressStream(r)
 	if err != nil {
 		return err
 	}
-	defer gzr.Close()
+	defer dr.Close()
 
-	tr := tar.NewReader(gzr)
+	tr := tar.NewReader(dr)
 	for {
 		header, err := tr.Next()
 		switch {
@@ -348,6 +2014,136 @@ func untar(dest string, r io.Reader) error {
 	}
 }
 
+// proxyBuildArgs returns the proxy variables set in the environment of the
+// host. They can be used in RUN steps without being declared with ARG.
+func proxyBuildArgs() map[string]string {
+	args := map[string]string{}
+	for name := range builtinArgs {
+		if v, ok := os.LookupEnv(name); ok {
+			args[name] = v
+		}
+	}
+	return args
+}
+
+// warnUnbuiltPlatformArgs warns about the build args scoped to a platform
+// that is not one of the targets, they do not apply to anything.
+func warnUnbuiltPlatformArgs(w io.Writer, platformArgs map[string]map[string]string, targets []string) {
+	built := map[string]bool{}
+	for _, target := range targets {
+		if p, err := parsePlatform(target); err == nil {
+			built[platforms.Format(p)] = true
+		}
+	}
+	var unbuilt []string
+	for platform, args := range platformArgs {
+		if built[platform] {
+			continue
+		}
+		for name := range args {
+			unbuilt = append(unbuilt, platform+":"+name)
+		}
+	}
+	sort.Strings(unbuilt)
+	for _, arg := range unbuilt {
+		fmt.Fprintf(w, "WARNING: build-arg %s is scoped to a platform that is not built\n", arg)
+	}
+}
+
+// redactedValue is printed instead of the values of the --redact-build-args.
+const redactedValue = "***"
+
+// buildArgRedactor returns a replacer of the values of the named build args
+// with ***, the names can be comma separated. A name is masked in each of
+// the build args, such as the ones scoped to a platform. Longer values are
+// replaced first so a value containing another is fully masked.
+func buildArgRedactor(names []string, buildArgs ...map[string]string) *strings.Replacer {
+	var values []string
+	for _, name := range names {
+		for _, n := range strings.Split(name, ",") {
+			for _, args := range buildArgs {
+				if v := args[strings.TrimSpace(n)]; v != "" {
+					values = append(values, v)
+				}
+			}
+		}
+	}
+	sort.Slice(values, func(i, j int) bool { return len(values[i]) > len(values[j]) })
+
+	oldnew := make([]string, 0, 2*len(values))
+	for _, v := range values {
+		oldnew = append(oldnew, v, redactedValue)
+	}
+	return strings.NewReplacer(oldnew...)
+}
+
+// redact masks the values of the --redact-build-args in s.
+func (cmd *buildCommand) redact(s string) string {
+	if cmd.redactor == nil {
+		return s
+	}
+	return cmd.redactor.Replace(s)
+}
+
+// printDryRun prints the local dirs, frontend and exporter configuration of
+// the solve request that would have been sent to the controller, with the
+// values passed through redact.
+func printDryRun(w io.Writer, req *controlapi.SolveRequest, localDirs map[string]string, redact func(string) string) {
+	tw := tabwriter.NewWriter(w, 1, 8, 1, '\t', 0)
+
+	fmt.Fprintln(tw, "Dry run, nothing will be built.")
+	fmt.Fprintf(tw, "Frontend:\t%s\n", req.Frontend)
+	fmt.Fprintln(tw, "Local dirs:")
+	printSortedMap(tw, "  ", localDirs, redact)
+	fmt.Fprintln(tw, "Frontend attrs:")
+	printSortedMap(tw, "  ", req.FrontendAttrs, redact)
+	fmt.Fprintf(tw, "Exporter:\t%s\n", req.Exporter)
+	fmt.Fprintln(tw, "Exporter attrs:")
+	printSortedMap(tw, "  ", req.ExporterAttrs, redact)
+	if len(req.Cache.Imports) > 0 {
+		fmt.Fprintln(tw, "Cache imports:")
+		for _, e := range req.Cache.Imports {
+			fmt.Fprintf(tw, "  %s\n", e.Type)
+			printSortedMap(tw, "    ", e.Attrs, redact)
+		}
+	}
+	if len(req.Cache.Exports) > 0 {
+		fmt.Fprintln(tw, "Cache exports:")
+		for _, e := range req.Cache.Exports {
+			fmt.Fprintf(tw, "  %s\n", e.Type)
+			printSortedMap(tw, "    ", e.Attrs, redact)
+		}
+	}
+
+	tw.Flush()
+}
+
+func printSortedMap(tw *tabwriter.Writer, indent string, m map[string]string, redact func(string) string) {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(tw, "%s%s:\t%s\n", indent, k, redact(m[k]))
+	}
+}
+
+// contextExcludes returns the patterns of the .dockerignore file of the
+// context followed by the patterns of --context-exclude, so they can negate
+// the patterns of the file with "!". Both are cleaned up the same way.
+func contextExcludes(contextDir string, patterns []string) ([]string, error) {
+	ignore, err := ioutil.ReadFile(filepath.Join(contextDir, ".dockerignore"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("reading .dockerignore failed: %v", err)
+	}
+	excludes, err := dockerignore.ReadAll(io.MultiReader(bytes.NewReader(ignore), strings.NewReader("\n"+strings.Join(patterns, "\n"))))
+	if err != nil {
+		return nil, fmt.Errorf("parsing the context excludes failed: %v", err)
+	}
+	return excludes, nil
+}
+
 func (cmd *buildCommand) getLocalDirs() map[string]string {
 	return map[string]string{
 		"context":    cmd.contextDir,
@@ -355,51 +2151,219 @@ func (cmd *buildCommand) getLocalDirs() map[string]string {
 	}
 }
 
-func showProgress(ch chan *controlapi.StatusResponse, noConsole bool) error {
-	displayCh := make(chan *bkclient.SolveStatus)
+func showProgress(ch chan *controlapi.StatusResponse, w io.Writer, noConsole, quietPull bool, interval time.Duration, maxLogSize int64, transfers func() map[string]client.TransferStats, observers ...func(*controlapi.StatusResponse)) error {
+	statusCh := make(chan *bkclient.SolveStatus)
 	go func() {
-		for resp := range ch {
-			s := bkclient.SolveStatus{}
-			for _, v := range resp.Vertexes {
-				s.Vertexes = append(s.Vertexes, &bkclient.Vertex{
-					Digest:    v.Digest,
-					Inputs:    v.Inputs,
-					Name:      v.Name,
-					Started:   v.Started,
-					Completed: v.Completed,
-					Error:     v.Error,
-					Cached:    v.Cached,
-				})
-			}
-			for _, v := range resp.Statuses {
-				s.Statuses = append(s.Statuses, &bkclient.VertexStatus{
-					ID:        v.ID,
-					Vertex:    v.Vertex,
-					Name:      v.Name,
-					Total:     v.Total,
-					Current:   v.Current,
-					Timestamp: v.Timestamp,
-					Started:   v.Started,
-					Completed: v.Completed,
-				})
-			}
-			for _, v := range resp.Logs {
-				s.Logs = append(s.Logs, &bkclient.VertexLog{
-					Vertex:    v.Vertex,
-					Stream:    int(v.Stream),
-					Data:      v.Msg,
-					Timestamp: v.Timestamp,
-				})
+		defer close(statusCh)
+
+		pulls := map[digest.Digest]bool{}
+		tp := newTransferProgress(transfers)
+		logs := newLogLimiter(maxLogSize)
+		ticker := time.NewTicker(transferStatsInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case resp, ok := <-ch:
+				if !ok {
+					if l := logs.flush(); len(l) > 0 {
+						statusCh <- solveStatus(&controlapi.StatusResponse{Logs: l}, quietPull, pulls)
+					}
+					if s := tp.status(time.Now()); s != nil {
+						statusCh <- s
+					}
+					return
+				}
+				for _, observe := range observers {
+					observe(resp)
+				}
+				logs.limit(resp)
+				statusCh <- solveStatus(resp, quietPull, pulls)
+			case now := <-ticker.C:
+				if s := tp.status(now); s != nil {
+					statusCh <- s
+				}
 			}
-			displayCh <- &s
 		}
-		close(displayCh)
 	}()
+
+	displayCh := statusCh
+	if interval > 0 {
+		// Make the progress UI repaint at the interval as well.
+		os.Setenv("TTY_DISPLAY_RATE", strconv.FormatInt(int64(interval/time.Millisecond), 10))
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		displayCh = make(chan *bkclient.SolveStatus)
+		go throttleStatus(statusCh, displayCh, ticker.C)
+	}
+
 	var c console.Console
 	if !noConsole {
 		if cf, err := console.ConsoleFromFile(os.Stderr); err == nil {
 			c = cf
 		}
 	}
-	return progressui.DisplaySolveStatus(context.TODO(), "", c, os.Stdout, displayCh)
+	return progressui.DisplaySolveStatus(context.TODO(), "", c, w, displayCh)
+}
+
+// transferStatsInterval is how often the context transfer stats are checked
+// for the progress output.
+const transferStatsInterval = 100 * time.Millisecond
+
+// transferProgress turns the stats of the local dirs sent over the session
+// into vertices for the progress UI, e.g. "Transferring context: 1234 files,
+// 56.0 MiB".
+type transferProgress struct {
+	stats   func() map[string]client.TransferStats
+	last    map[string]client.TransferStats
+	started map[string]time.Time
+}
+
+func newTransferProgress(stats func() map[string]client.TransferStats) *transferProgress {
+	return &transferProgress{
+		stats:   stats,
+		last:    map[string]client.TransferStats{},
+		started: map[string]time.Time{},
+	}
+}
+
+// status returns a vertex for each local dir whose stats changed since the
+// last call, or nil if none did.
+func (p *transferProgress) status(now time.Time) *bkclient.SolveStatus {
+	if p.stats == nil {
+		return nil
+	}
+
+	stats := p.stats()
+	names := make([]string, 0, len(stats))
+	for name := range stats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var s *bkclient.SolveStatus
+	for _, name := range names {
+		st := stats[name]
+		if st.Files == 0 || st == p.last[name] {
+			continue
+		}
+		p.last[name] = st
+
+		started, ok := p.started[name]
+		if !ok {
+			started = now
+			p.started[name] = now
+		}
+		completed := now
+
+		if s == nil {
+			s = &bkclient.SolveStatus{}
+		}
+		s.Vertexes = append(s.Vertexes, &bkclient.Vertex{
+			Digest:    digest.FromString("transfer " + name),
+			Name:      fmt.Sprintf("Transferring %s: %d files, %s", name, st.Files, formatBytes(st.Bytes)),
+			Started:   &started,
+			Completed: &completed,
+		})
+	}
+	return s
+}
+
+// throttleStatus forwards the statuses from in to out at most once per tick,
+// merging the statuses received in between. The pending statuses are flushed
+// once in is closed, then out is closed.
+func throttleStatus(in <-chan *bkclient.SolveStatus, out chan<- *bkclient.SolveStatus, tick <-chan time.Time) {
+	defer close(out)
+
+	var pending *bkclient.SolveStatus
+	for {
+		select {
+		case s, ok := <-in:
+			if !ok {
+				if pending != nil {
+					out <- pending
+				}
+				return
+			}
+			if pending == nil {
+				pending = &bkclient.SolveStatus{}
+			}
+			pending.Vertexes = append(pending.Vertexes, s.Vertexes...)
+			pending.Statuses = append(pending.Statuses, s.Statuses...)
+			pending.Logs = append(pending.Logs, s.Logs...)
+		case <-tick:
+			if pending != nil {
+				out <- pending
+				pending = nil
+			}
+		}
+	}
+}
+
+// solveStatus converts a status response into a solve status for the
+// progress UI. If quietPull is true the vertices pulling base images are
+// filtered out along with their statuses and logs, unless they failed. The
+// pull vertices seen so far are tracked in pulls since statuses and logs can
+// come in later responses than their vertex.
+func solveStatus(resp *controlapi.StatusResponse, quietPull bool, pulls map[digest.Digest]bool) *bkclient.SolveStatus {
+	s := bkclient.SolveStatus{}
+	for _, v := range resp.Vertexes {
+		if quietPull && isPullVertex(v.Name) {
+			pulls[v.Digest] = true
+			if v.Error == "" {
+				continue
+			}
+		}
+		s.Vertexes = append(s.Vertexes, &bkclient.Vertex{
+			Digest:    v.Digest,
+			Inputs:    v.Inputs,
+			Name:      v.Name,
+			Started:   v.Started,
+			Completed: v.Completed,
+			Error:     v.Error,
+			Cached:    v.Cached,
+		})
+	}
+	for _, v := range resp.Statuses {
+		if pulls[v.Vertex] {
+			continue
+		}
+		s.Statuses = append(s.Statuses, &bkclient.VertexStatus{
+			ID:        v.ID,
+			Vertex:    v.Vertex,
+			Name:      v.Name,
+			Total:     v.Total,
+			Current:   v.Current,
+			Timestamp: v.Timestamp,
+			Started:   v.Started,
+			Completed: v.Completed,
+		})
+	}
+	for _, v := range resp.Logs {
+		if pulls[v.Vertex] {
+			continue
+		}
+		s.Logs = append(s.Logs, &bkclient.VertexLog{
+			Vertex:    v.Vertex,
+			Stream:    int(v.Stream),
+			Data:      v.Msg,
+			Timestamp: v.Timestamp,
+		})
+	}
+	return &s
+}
+
+// isPullVertex returns if the vertex name is one the dockerfile frontend uses
+// for resolving or pulling a base image.
+func isPullVertex(name string) bool {
+	// Strip the step prefix, e.g. "[2/3] FROM ...".
+	if strings.HasPrefix(name, "[") {
+		if i := strings.Index(name, "] "); i > 0 {
+			if name[:i+2] == "[internal] " {
+				return strings.HasPrefix(name[i+2:], "load metadata for ")
+			}
+			name = name[i+2:]
+		}
+	}
+	return strings.HasPrefix(name, "FROM ") || strings.HasPrefix(name, "docker-image://")
 }
@@ -1,8 +1,29 @@
 package main
 
 import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
 	"runtime"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/mchirico/img/client"
+	controlapi "github.com/moby/buildkit/api/services/control"
+	bkclient "github.com/moby/buildkit/client"
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
 func TestBuildShCmdJSONEntrypoint(t *testing.T) {
@@ -156,3 +177,1853 @@ func TestBuildMultiplePlatforms(t *testing.T) {
 		t.FailNow()
 	}
 }
+
+func TestBuildDigestFile(t *testing.T) {
+	tmpd, err := ioutil.TempDir("", "img-digestfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpd)
+
+	path := filepath.Join(tmpd, "digests.json")
+	args := []string{"build", "--platform", "linux/amd64,linux/arm64", "--digestfile", path, "-t", "testbuilddigestfile", "-"}
+	if out, err := doRun(args, withDockerfile(`
+  FROM alpine
+  `)); err != nil {
+		t.Fatalf("img %v failed unexpectedly: %v\n%s", args, err, out)
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading digest file failed: %v", err)
+	}
+	digests := map[string]digest.Digest{}
+	if err := json.Unmarshal(b, &digests); err != nil {
+		t.Fatalf("parsing digest file failed: %v", err)
+	}
+	for _, p := range []string{"linux/amd64", "linux/arm64"} {
+		if err := digests[p].Validate(); err != nil {
+			t.Fatalf("expected a digest for %s, got: %v", p, digests)
+		}
+	}
+}
+
+func TestWriteDigestFile(t *testing.T) {
+	tmpd, err := ioutil.TempDir("", "img-digestfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpd)
+
+	amd64, arm64 := digest.FromString("amd64"), digest.FromString("arm64")
+	path := filepath.Join(tmpd, "digests.json")
+	resp := map[string]string{
+		client.ExporterImageDigest:     digest.FromString("index").String(),
+		client.ExporterPlatformDigests: `{"linux/amd64":"` + amd64.String() + `","linux/arm64":"` + arm64.String() + `"}`,
+	}
+	if err := writeDigestFile(path, resp); err != nil {
+		t.Fatalf("writing digest file failed: %v", err)
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digests := map[string]digest.Digest{}
+	if err := json.Unmarshal(b, &digests); err != nil {
+		t.Fatalf("parsing digest file failed: %v", err)
+	}
+	expected := map[string]digest.Digest{"linux/amd64": amd64, "linux/arm64": arm64}
+	if !reflect.DeepEqual(digests, expected) {
+		t.Fatalf("expected digests %v, got: %v", expected, digests)
+	}
+
+	if err := writeDigestFile(path, map[string]string{}); err == nil {
+		t.Fatal("expected writing a digest file without the digests in the response to fail but it did not")
+	}
+}
+
+func TestBuildDryRun(t *testing.T) {
+	name := "testbuilddryrun"
+
+	args := []string{"build", "--dry-run", "-t", name, "-"}
+	out, err := doRun(args, withDockerfile(`
+  FROM busybox
+  RUN echo dryrun
+  `))
+	if err != nil {
+		t.Logf("img %v failed unexpectedly: %v", args, err)
+		t.FailNow()
+	}
+
+	if !strings.Contains(out, "Dry run") || strings.Contains(out, "Successfully built") {
+		t.Fatalf("expected dry run output without a build, got: %s", out)
+	}
+
+	// Make sure nothing was solved and exported.
+	out = run(t, "ls")
+	if strings.Contains(out, name) {
+		t.Fatalf("expected %s to not be in ls output after a dry run, got: %s", name, out)
+	}
+}
+
+func TestBuildProxy(t *testing.T) {
+	defer setEnv("HTTP_PROXY", "http://proxy.example.com:3128")()
+	defer setEnv("https_proxy", "http://proxy.example.com:3129")()
+	defer setEnv("no_proxy", "localhost,.example.com")()
+
+	args := []string{"build", "--dry-run", "--proxy", "--build-arg", "https_proxy=http://override.example.com", "-t", "testbuildproxy", "-"}
+	out, err := doRun(args, withDockerfile(`
+  FROM busybox
+  RUN wget -q -O- https://example.com
+  `))
+	if err != nil {
+		t.Logf("img %v failed unexpectedly: %v", args, err)
+		t.FailNow()
+	}
+
+	// Ignore the alignment of the attrs.
+	fields := strings.Join(strings.Fields(out), " ")
+	for _, attr := range []string{
+		"build-arg:HTTP_PROXY: http://proxy.example.com:3128",
+		"build-arg:https_proxy: http://override.example.com",
+		"build-arg:no_proxy: localhost,.example.com",
+	} {
+		if !strings.Contains(fields, attr) {
+			t.Fatalf("expected the frontend attrs to have %q, got: %s", attr, out)
+		}
+	}
+}
+
+func TestBuildRedactBuildArgs(t *testing.T) {
+	args := []string{"build", "--dry-run", "--build-arg", "TOKEN=s3cr3t", "--build-arg", "PASSWORD=hunter2", "--build-arg", "VERSION=1.2.3", "--redact-build-args", "TOKEN,PASSWORD", "-t", "testbuildredactbuildargs", "-"}
+	out, err := doRun(args, withDockerfile(`
+  FROM busybox
+  ARG TOKEN
+  ARG PASSWORD
+  ARG VERSION
+  RUN echo redact
+  `))
+	if err != nil {
+		t.Logf("img %v failed unexpectedly: %v", args, err)
+		t.FailNow()
+	}
+
+	if strings.Contains(out, "s3cr3t") || strings.Contains(out, "hunter2") {
+		t.Fatalf("expected the redacted build-args to be masked, got: %s", out)
+	}
+	fields := strings.Join(strings.Fields(out), " ")
+	for _, attr := range []string{
+		"build-arg:TOKEN: ***",
+		"build-arg:PASSWORD: ***",
+		"build-arg:VERSION: 1.2.3",
+	} {
+		if !strings.Contains(fields, attr) {
+			t.Fatalf("expected the frontend attrs to have %q, got: %s", attr, out)
+		}
+	}
+}
+
+func TestBuildArgRedactor(t *testing.T) {
+	r := buildArgRedactor([]string{"SHORT", "LONG"}, map[string]string{"SHORT": "abc", "LONG": "abcdef", "EMPTY": ""})
+	if got := r.Replace("token=abcdef key=abc"); got != "token=*** key=***" {
+		t.Fatalf("expected both values to be masked, got: %s", got)
+	}
+}
+
+func TestBuildDockerfileFromURL(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/Dockerfile":
+			fmt.Fprintln(w, "FROM busybox\nRUN echo fromurl")
+		case "/empty":
+		case "/gist":
+			fmt.Fprintln(w, "<!DOCTYPE html><html><body>FROM busybox</body></html>")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	path, err := dockerfileFromURL(srv.URL+"/Dockerfile", time.Minute)
+	if err != nil {
+		t.Fatalf("fetching dockerfile failed: %v", err)
+	}
+	defer os.Remove(path)
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), "RUN echo fromurl") {
+		t.Fatalf("expected the fetched dockerfile, got: %s", b)
+	}
+
+	for name, expected := range map[string]string{
+		"/empty":   "the dockerfile is empty",
+		"/gist":    "got an HTML page",
+		"/missing": "unexpected status 404",
+	} {
+		if _, err := dockerfileFromURL(srv.URL+name, time.Minute); err == nil || !strings.Contains(err.Error(), expected) {
+			t.Fatalf("expected fetching %s to fail with %q, got: %v", name, expected, err)
+		}
+	}
+
+	// The build uses the fetched dockerfile with the local context.
+	tmpd, err := ioutil.TempDir("", "img-dockerfile-url")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpd)
+	out := run(t, "build", "--dry-run", "--context-timeout", "1m", "-f", srv.URL+"/Dockerfile", "-t", "testbuilddockerfilefromurl", tmpd)
+	if !strings.Contains(out, "Dry run") || !strings.Contains(out, tmpd) {
+		t.Fatalf("expected a dry run of the local context %s, got: %s", tmpd, out)
+	}
+}
+
+func TestConfigOverridesFlags(t *testing.T) {
+	cmd := &buildCommand{
+		entrypoint: `["/bin/app", "--serve"]`,
+		command:    "echo hello",
+		workdir:    "/srv",
+		env:        stringSlice{"MODE=prod"},
+		user:       "nobody",
+	}
+	overrides, err := cmd.configOverrides()
+	if err != nil {
+		t.Fatalf("parsing config overrides failed: %v", err)
+	}
+	expected := client.ConfigOverrides{
+		Entrypoint: []string{"/bin/app", "--serve"},
+		Cmd:        []string{"/bin/sh", "-c", "echo hello"},
+		WorkingDir: "/srv",
+		Env:        []string{"MODE=prod"},
+		User:       "nobody",
+	}
+	if !reflect.DeepEqual(overrides, expected) {
+		t.Fatalf("expected config overrides %#v, got: %#v", expected, overrides)
+	}
+
+	if overrides, err := (&buildCommand{}).configOverrides(); err != nil || !overrides.IsEmpty() {
+		t.Fatalf("expected no config overrides without the flags, got: %#v %v", overrides, err)
+	}
+	if _, err := (&buildCommand{env: stringSlice{"MODE"}}).configOverrides(); err == nil {
+		t.Fatal("expected an env without a value to fail but it did not")
+	}
+	if _, err := (&buildCommand{entrypoint: "[/bin/app"}).configOverrides(); err == nil {
+		t.Fatal("expected an invalid JSON entrypoint to fail but it did not")
+	}
+}
+
+func TestBuildEnvAuto(t *testing.T) {
+	tmpd, err := ioutil.TempDir("", "img-build-env-auto")
+	if err != nil {
+		t.Fatalf("creating temporary directory failed: %v", err)
+	}
+	defer os.RemoveAll(tmpd)
+
+	if err := ioutil.WriteFile(filepath.Join(tmpd, "Dockerfile"), []byte("FROM busybox\nARG VERSION\nARG CHANNEL\n"), 0644); err != nil {
+		t.Fatalf("writing dockerfile failed: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Join(tmpd, ".img"), 0755); err != nil {
+		t.Fatalf("creating .img directory failed: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(tmpd, ".img", "build.env"), []byte("VERSION=auto\nCHANNEL=stable\nlabel:maintainer=auto@example.com\n"), 0644); err != nil {
+		t.Fatalf("writing build.env failed: %v", err)
+	}
+
+	// The build-arg flags override the args of the conventional file.
+	out, err := doRun([]string{"build", "--dry-run", "-t", "testbuildenvauto", "--build-arg", "CHANNEL=beta", tmpd}, nil)
+	if err != nil {
+		t.Fatalf("dry run failed: %v: %s", err, out)
+	}
+	out = strings.Join(strings.Fields(out), " ")
+	for _, expected := range []string{"build-arg:VERSION: auto", "build-arg:CHANNEL: beta", "label:maintainer: auto@example.com"} {
+		if !strings.Contains(out, expected) {
+			t.Fatalf("expected %q in the dry run, got: %s", expected, out)
+		}
+	}
+
+	out, err = doRun([]string{"build", "--dry-run", "--no-env-auto", "-t", "testbuildenvauto", tmpd}, nil)
+	if err != nil {
+		t.Fatalf("dry run failed: %v: %s", err, out)
+	}
+	if strings.Contains(out, "VERSION") || strings.Contains(out, "maintainer") {
+		t.Fatalf("expected --no-env-auto to skip the conventional file, got: %s", out)
+	}
+}
+
+func TestBuildLocalOutputChecksums(t *testing.T) {
+	tmpd, err := ioutil.TempDir("", "img-build-checksums")
+	if err != nil {
+		t.Fatalf("creating temporary directory failed: %v", err)
+	}
+	defer os.RemoveAll(tmpd)
+
+	contextDir := filepath.Join(tmpd, "context")
+	if err := os.MkdirAll(filepath.Join(contextDir, "dist", "nested"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"Dockerfile":      "FROM scratch\nCOPY dist /\n",
+		"dist/one":        "one\n",
+		"dist/nested/two": "two\n",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(contextDir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dest := filepath.Join(tmpd, "out")
+	out := run(t, "build", "--output-checksums", "-o", "type=local,dest="+dest, contextDir)
+	if !strings.Contains(out, "Wrote the checksums of 2 files to "+filepath.Join(dest, "SHA256SUMS")) {
+		t.Fatalf("expected the number of files with checksums, got: %s", out)
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(dest, "SHA256SUMS"))
+	if err != nil {
+		t.Fatalf("reading SHA256SUMS failed: %v", err)
+	}
+	expected := `27dd8ed44a83ff94d557f9fd0412ed5a8cbca69ea04922d88c01184a07300a5a  nested/two
+2c8b08da5ce60398e1f19af0e5dccc744df274b826abe585eaba68c525434806  one
+`
+	if string(b) != expected {
+		t.Fatalf("expected checksums %q, got: %q", expected, string(b))
+	}
+}
+
+func TestBuildContextExclude(t *testing.T) {
+	tmpd, err := ioutil.TempDir("", "img-build-context-exclude")
+	if err != nil {
+		t.Fatalf("creating temporary directory failed: %v", err)
+	}
+	defer os.RemoveAll(tmpd)
+
+	contextDir := filepath.Join(tmpd, "context")
+	if err := os.MkdirAll(filepath.Join(contextDir, "big"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"Dockerfile":    "FROM scratch\nCOPY . /\n",
+		".dockerignore": "*.log",
+		"app":           "app\n",
+		"big/artifact":  "big\n",
+		"build.log":     "build\n",
+		"keep.log":      "keep\n",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(contextDir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dest := filepath.Join(tmpd, "out")
+	run(t, "build", "--context-exclude", "big", "--context-exclude", "!keep.log", "-o", "type=local,dest="+dest, contextDir)
+
+	for _, name := range []string{"app", "keep.log"} {
+		if _, err := os.Stat(filepath.Join(dest, name)); err != nil {
+			t.Fatalf("expected %s to be sent with the context: %v", name, err)
+		}
+	}
+	for _, name := range []string{"big", "build.log"} {
+		if _, err := os.Stat(filepath.Join(dest, name)); !os.IsNotExist(err) {
+			t.Fatalf("expected %s to be excluded from the context, got: %v", name, err)
+		}
+	}
+}
+
+func TestContextExcludes(t *testing.T) {
+	tmpd, err := ioutil.TempDir("", "img-context-excludes")
+	if err != nil {
+		t.Fatalf("creating temporary directory failed: %v", err)
+	}
+	defer os.RemoveAll(tmpd)
+
+	excludes, err := contextExcludes(tmpd, []string{"/dist/", "!dist/keep"})
+	if err != nil {
+		t.Fatalf("reading context excludes failed: %v", err)
+	}
+	if expected := []string{"dist", "!dist/keep"}; !reflect.DeepEqual(excludes, expected) {
+		t.Fatalf("expected the excludes without a .dockerignore to be %v, got: %v", expected, excludes)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(tmpd, ".dockerignore"), []byte("# comment\n*.log\nnode_modules"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	excludes, err = contextExcludes(tmpd, []string{"dist"})
+	if err != nil {
+		t.Fatalf("reading context excludes failed: %v", err)
+	}
+	if expected := []string{"*.log", "node_modules", "dist"}; !reflect.DeepEqual(excludes, expected) {
+		t.Fatalf("expected the excludes %v, got: %v", expected, excludes)
+	}
+}
+
+func TestShowJSONProgress(t *testing.T) {
+	now := time.Now()
+	resp := &controlapi.StatusResponse{
+		Vertexes: []*controlapi.Vertex{{Digest: digest.FromString("copy"), Name: "COPY Dockerfile /", Started: &now}},
+		Logs:     []*controlapi.VertexLog{{Vertex: digest.FromString("copy"), Timestamp: now, Stream: 2, Msg: []byte("log")}},
+	}
+
+	show := func(raw bool) string {
+		ch := make(chan *controlapi.StatusResponse, 1)
+		ch <- resp
+		close(ch)
+		var buf bytes.Buffer
+		if err := showJSONProgress(ch, &buf, raw); err != nil {
+			t.Fatalf("printing the progress failed: %v", err)
+		}
+		return buf.String()
+	}
+
+	// The raw status keeps the field names of the controller API, which the
+	// translated status does not have.
+	raw, translated := show(true), show(false)
+	for _, field := range []string{`"vertexes":`, `"digest":`, `"logs":`, `"msg":`, `"stream":2`} {
+		if !strings.Contains(raw, field) {
+			t.Fatalf("expected %s in the raw progress, got: %s", field, raw)
+		}
+		if strings.Contains(translated, field) {
+			t.Fatalf("expected %s not to be in the translated progress, got: %s", field, translated)
+		}
+	}
+	var status bkclient.SolveStatus
+	if err := json.Unmarshal([]byte(translated), &status); err != nil || len(status.Logs) != 1 || string(status.Logs[0].Data) != "log" {
+		t.Fatalf("expected the translated progress to be a solve status, got: %s (%v)", translated, err)
+	}
+}
+
+func TestBuildProgressRawJSON(t *testing.T) {
+	out, err := doRun([]string{"build", "--progress", "rawjson", "-t", "testbuildprogressrawjson", "-"}, withDockerfile("FROM scratch\nCOPY Dockerfile /\n"))
+	if err != nil {
+		t.Fatalf("building with raw JSON progress failed: %v\n%s", err, out)
+	}
+
+	copied := false
+	for _, line := range strings.Split(out, "\n") {
+		if !strings.HasPrefix(line, "{") {
+			continue
+		}
+		var resp controlapi.StatusResponse
+		if err := json.Unmarshal([]byte(line), &resp); err != nil {
+			t.Fatalf("expected a status response, got: %v: %s", err, line)
+		}
+		for _, v := range resp.Vertexes {
+			copied = copied || strings.Contains(v.Name, "COPY Dockerfile /")
+		}
+	}
+	if !copied {
+		t.Fatalf("expected the COPY step in the raw progress, got: %s", out)
+	}
+
+	if _, err := doRun([]string{"build", "--progress", "tty", "-t", "testbuildprogressrawjson", "-"}, withDockerfile("FROM scratch\n")); err == nil {
+		t.Fatal("expected an invalid progress type to fail but it did not")
+	}
+}
+
+func TestBuildProgressFile(t *testing.T) {
+	tmpd, err := ioutil.TempDir("", "img-build-progress-file")
+	if err != nil {
+		t.Fatalf("creating temporary directory failed: %v", err)
+	}
+	defer os.RemoveAll(tmpd)
+
+	contextDir := filepath.Join(tmpd, "context")
+	if err := os.MkdirAll(contextDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(contextDir, "Dockerfile"), []byte("FROM scratch\nCOPY Dockerfile /\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(tmpd, "progress.jsonl")
+	run(t, "build", "--progress-file", path, "-t", "testbuildprogressfile", contextDir)
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading progress file failed: %v", err)
+	}
+	// Every line is a status update and every vertex completes in one.
+	vertices := map[digest.Digest]*bkclient.Vertex{}
+	for i, line := range strings.Split(strings.TrimSuffix(string(b), "\n"), "\n") {
+		var status bkclient.SolveStatus
+		if err := json.Unmarshal([]byte(line), &status); err != nil {
+			t.Fatalf("line %d of the progress file is not a JSON object: %v: %s", i, err, line)
+		}
+		for _, v := range status.Vertexes {
+			if vertices[v.Digest] == nil || v.Completed != nil {
+				vertices[v.Digest] = v
+			}
+		}
+	}
+	copied := false
+	for _, v := range vertices {
+		if v.Completed == nil {
+			t.Fatalf("expected vertex %s to complete in the progress file, got: %+v", v.Name, v)
+		}
+		copied = copied || strings.Contains(v.Name, "COPY Dockerfile /")
+	}
+	if !copied {
+		t.Fatalf("expected the COPY step in the progress file, got: %s", b)
+	}
+}
+
+func TestBuildInlineCache(t *testing.T) {
+	args := []string{"build", "--dry-run", "--inline-cache", "-o", "type=image,name=r.j3ss.co/testbuildinlinecache,push=true", "-"}
+	out, err := doRun(args, withDockerfile(`
+  FROM busybox
+  RUN echo inlinecache
+  `))
+	if err != nil {
+		t.Logf("img %v failed unexpectedly: %v", args, err)
+		t.FailNow()
+	}
+
+	if !strings.HasSuffix(out, "Cache exports:\n  inline\n") {
+		t.Fatalf("expected an inline cache export, got: %s", out)
+	}
+	if !strings.Contains(strings.Join(strings.Fields(out), " "), "push: true") {
+		t.Fatalf("expected the image to be pushed along with the inline cache, got: %s", out)
+	}
+}
+
+func TestBuildCache(t *testing.T) {
+	args := []string{"build", "--dry-run", "--cache", "r.j3ss.co/testbuildcache:cache", "-t", "testbuildcache", "-"}
+	out, err := doRun(args, withDockerfile(`
+  FROM busybox
+  RUN echo cache
+  `))
+	if err != nil {
+		t.Logf("img %v failed unexpectedly: %v", args, err)
+		t.FailNow()
+	}
+
+	// The shorthand both imports and exports the same ref.
+	expected := "Cache imports:\n  registry\n    ref: r.j3ss.co/testbuildcache:cache\n" +
+		"Cache exports:\n  registry\n    mode: max\n    ref: r.j3ss.co/testbuildcache:cache\n"
+	if !strings.HasSuffix(strings.Replace(out, "\t", " ", -1), expected) {
+		t.Fatalf("expected a registry cache import and export, got: %s", out)
+	}
+	if !strings.Contains(out, "cache-imports") {
+		t.Fatalf("expected the cache import to be passed to the frontend, got: %s", out)
+	}
+
+	out, err = doRun([]string{"build", "--cache", "r.j3ss.co/testbuildcache:cache", "--inline-cache", "-t", "testbuildcache", "-"}, withDockerfile(`
+  FROM busybox
+  `))
+	if err == nil || !strings.Contains(out, "use only one of them") {
+		t.Fatalf("expected --cache with --inline-cache to fail, got: %s %v", out, err)
+	}
+}
+
+func TestBuildDisableNetwork(t *testing.T) {
+	args := []string{"build", "--dry-run", "--disable-network", "-t", "testbuilddisablenetwork", "-"}
+	out, err := doRun(args, withDockerfile(`
+  FROM busybox
+  RUN wget -q -O /dev/null http://example.com
+  `))
+	if err != nil {
+		t.Logf("img %v failed unexpectedly: %v", args, err)
+		t.FailNow()
+	}
+
+	if !strings.Contains(strings.Join(strings.Fields(out), " "), "force-network-mode: none") {
+		t.Fatalf("expected the network mode of every RUN step to be forced to none, got: %s", out)
+	}
+}
+
+func TestBuildKeepDays(t *testing.T) {
+	args := []string{"build", "--dry-run", "--keep-days", "3", "-t", "testbuildkeepdays", "-"}
+	out, err := doRun(args, withDockerfile(`
+  FROM scratch
+  `))
+	if err != nil {
+		t.Logf("img %v failed unexpectedly: %v", args, err)
+		t.FailNow()
+	}
+
+	if !strings.Contains(strings.Join(strings.Fields(out), " "), client.ExporterKeepDuration+": 72h0m0s") {
+		t.Fatalf("expected the image to be kept for 3 days, got: %s", out)
+	}
+
+	for _, args := range [][]string{
+		{"build", "--keep-days", "-1", "-t", "testbuildkeepdays", "-"},
+		{"build", "--keep-days", "3", "--no-output", "-"},
+	} {
+		if _, err := doRun(args, withDockerfile("FROM scratch\n")); err == nil {
+			t.Fatalf("expected img %v to fail but it did not", args)
+		}
+	}
+}
+
+func TestBuildTargets(t *testing.T) {
+	tmpd, err := ioutil.TempDir("", "img-build-targets")
+	if err != nil {
+		t.Fatalf("creating temporary directory failed: %v", err)
+	}
+	defer os.RemoveAll(tmpd)
+
+	if err := ioutil.WriteFile(filepath.Join(tmpd, "Dockerfile"), []byte(`
+FROM scratch AS base
+COPY Dockerfile /
+FROM base AS one
+COPY Dockerfile /one
+FROM base AS two
+COPY Dockerfile /two
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	run(t, "build", "--target", "one,two", "-t", "testbuildtargets", "--target-suffix", tmpd)
+	run(t, "build", "--target", "one", "--target", "two", "-t", "testbuildtargetsone", "-t", "testbuildtargetstwo", tmpd)
+
+	out := run(t, "ls")
+	for _, name := range []string{"testbuildtargets:one", "testbuildtargets:two", "testbuildtargetsone:latest", "testbuildtargetstwo:latest"} {
+		if !strings.Contains(out, name) {
+			t.Fatalf("expected %s to be in ls output, got: %s", name, out)
+		}
+	}
+
+	// Each target needs a tag without a naming scheme.
+	if _, err := doRun([]string{"build", "--target", "one,two", "-t", "testbuildtargets", tmpd}, nil); err == nil {
+		t.Fatal("expected building two targets with one tag to fail but it did not")
+	}
+}
+
+func TestTargetTags(t *testing.T) {
+	names, err := targetTags([]string{"one", "two"}, []string{"img", "r.j3ss.co/img:v1"}, true, true)
+	if err != nil {
+		t.Fatalf("tagging targets failed: %v", err)
+	}
+	expected := [][]string{
+		{"docker.io/library/img:one", "r.j3ss.co/img:v1-one"},
+		{"docker.io/library/img:two", "r.j3ss.co/img:v1-two"},
+	}
+	if !reflect.DeepEqual(names, expected) {
+		t.Fatalf("expected target tags %v, got: %v", expected, names)
+	}
+
+	names, err = targetTags([]string{"one", "two"}, []string{"first", "second:v2"}, false, true)
+	if err != nil {
+		t.Fatalf("tagging targets failed: %v", err)
+	}
+	expected = [][]string{{"docker.io/library/first:latest"}, {"docker.io/library/second:v2"}}
+	if !reflect.DeepEqual(names, expected) {
+		t.Fatalf("expected target tags %v, got: %v", expected, names)
+	}
+
+	if _, err := targetTags([]string{"one", "two", "three"}, []string{"first", "second"}, false, true); exitCode(err) != exitUsage {
+		t.Fatalf("expected more targets than tags to be a usage error, got: %v", err)
+	}
+}
+
+func TestBuildPlatformVariants(t *testing.T) {
+	tmpd, err := ioutil.TempDir("", "img-build-platform-variants")
+	if err != nil {
+		t.Fatalf("creating temporary directory failed: %v", err)
+	}
+	defer os.RemoveAll(tmpd)
+
+	contextDir := filepath.Join(tmpd, "context")
+	if err := os.MkdirAll(contextDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(contextDir, "Dockerfile"), []byte("FROM scratch\nCOPY Dockerfile /\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	archive := filepath.Join(tmpd, "image.tar")
+	run(t, "build", "--platform", "linux/arm/6,linux/arm/7,linux/arm64/v8", "-o", "type=oci,dest="+archive, contextDir)
+
+	f, err := os.Open(archive)
+	if err != nil {
+		t.Fatalf("opening the oci archive failed: %v", err)
+	}
+	defer f.Close()
+	files := map[string][]byte{}
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("reading oci archive failed: %v", err)
+		}
+		b, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatalf("reading %s from the oci archive failed: %v", hdr.Name, err)
+		}
+		files[hdr.Name] = b
+	}
+
+	// The index.json of the archive points at the index of the platforms.
+	var layout, index ocispec.Index
+	if err := json.Unmarshal(files["index.json"], &layout); err != nil || len(layout.Manifests) != 1 {
+		t.Fatalf("expected an index.json with the image index, got: %s (%v)", files["index.json"], err)
+	}
+	if err := json.Unmarshal(files["blobs/sha256/"+layout.Manifests[0].Digest.Hex()], &index); err != nil {
+		t.Fatalf("parsing the image index failed: %v", err)
+	}
+	var got []ocispec.Platform
+	for _, m := range index.Manifests {
+		if m.Platform == nil {
+			t.Fatalf("expected manifest %s to have a platform", m.Digest)
+		}
+		got = append(got, *m.Platform)
+	}
+	expected := []ocispec.Platform{
+		{OS: "linux", Architecture: "arm", Variant: "v6"},
+		{OS: "linux", Architecture: "arm", Variant: "v7"},
+		{OS: "linux", Architecture: "arm64"},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected the manifests of the platforms %v, got: %v", expected, got)
+	}
+}
+
+func TestBuildPlatformBuildArgs(t *testing.T) {
+	args := []string{"build", "--dry-run", "--platform", "linux/amd64,linux/arm64",
+		"--build-arg", "GOARCH=amd64", "--build-arg", "linux/arm64:GOARCH=arm64", "-t", "testbuildplatformbuildargs", "-"}
+	out, err := doRun(args, withDockerfile(`
+  FROM busybox
+  ARG GOARCH
+  RUN echo $GOARCH
+  `))
+	if err != nil {
+		t.Logf("img %v failed unexpectedly: %v", args, err)
+		t.FailNow()
+	}
+
+	out = strings.Join(strings.Fields(out), " ")
+	for _, attr := range []string{
+		"build-arg:GOARCH: amd64",
+		client.PlatformBuildArgPrefix + "linux/arm64:GOARCH: arm64",
+	} {
+		if !strings.Contains(out, attr) {
+			t.Fatalf("expected frontend attr %q, got: %s", attr, out)
+		}
+	}
+}
+
+func TestBuildNoOutput(t *testing.T) {
+	// No tag is required since nothing is exported.
+	args := []string{"build", "--dry-run", "--no-output", "-"}
+	out, err := doRun(args, withDockerfile(`
+  FROM busybox
+  RUN echo nooutput
+  `))
+	if err != nil {
+		t.Logf("img %v failed unexpectedly: %v", args, err)
+		t.FailNow()
+	}
+
+	// The exporter of the solve request is empty.
+	if !strings.HasSuffix(out, "Exporter:\t\nExporter attrs:\n") {
+		t.Fatalf("expected an empty exporter without exporter attrs, got: %s", out)
+	}
+
+	out, err = doRun([]string{"build", "--no-output", "-t", "testbuildnooutput", "-"}, withDockerfile(`
+  FROM busybox
+  `))
+	if err == nil || !strings.Contains(out, "--no-output does not export an image") {
+		t.Fatalf("expected --no-output with a tag to fail, got: %s %v", out, err)
+	}
+}
+
+func TestBuildCacheOnlyOutput(t *testing.T) {
+	// The cacheonly output is the same as --no-output, no tag is required.
+	args := []string{"build", "--dry-run", "-o", "type=cacheonly", "-"}
+	out, err := doRun(args, withDockerfile(`
+  FROM busybox
+  RUN echo cacheonly
+  `))
+	if err != nil {
+		t.Logf("img %v failed unexpectedly: %v", args, err)
+		t.FailNow()
+	}
+
+	// The solve request has no exporter.
+	if !strings.HasSuffix(out, "Exporter:\t\nExporter attrs:\n") {
+		t.Fatalf("expected an empty exporter without exporter attrs, got: %s", out)
+	}
+
+	for _, tc := range []struct {
+		args     []string
+		expected string
+	}{
+		{args: []string{"-o", "type=cacheonly", "-o", "type=image,name=testbuildcacheonly"}, expected: "can not be combined with other outputs"},
+		{args: []string{"-o", "type=cacheonly", "--no-output"}, expected: "can not be combined with other outputs or --no-output"},
+		{args: []string{"-o", "type=cacheonly,name=testbuildcacheonly"}, expected: "it takes no other keys"},
+		{args: []string{"-o", "type=cacheonly", "-t", "testbuildcacheonly"}, expected: "the cacheonly output does not export an image"},
+	} {
+		args := append(append([]string{"build", "--dry-run"}, tc.args...), "-")
+		out, err := doRun(args, withDockerfile(`
+  FROM busybox
+  `))
+		if err == nil || !strings.Contains(out, tc.expected) {
+			t.Fatalf("expected img %v to fail with %q, got: %s %v", args, tc.expected, out, err)
+		}
+	}
+}
+
+func TestBuildStopAt(t *testing.T) {
+	tmpd, err := ioutil.TempDir("", "img-build-stop-at")
+	if err != nil {
+		t.Fatalf("creating temporary directory failed: %v", err)
+	}
+	defer os.RemoveAll(tmpd)
+
+	// The file is unique so the first build does not hit the cache of
+	// another test.
+	if err := ioutil.WriteFile(filepath.Join(tmpd, "stage"), []byte(tmpd), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(tmpd, "Dockerfile"), []byte(`
+FROM scratch AS base
+COPY stage /stage
+FROM base
+COPY Dockerfile /final
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	out := run(t, "build", "--no-console", "--stop-at", "base", tmpd)
+	if strings.Contains(out, "CACHED") || !strings.Contains(out, "COPY stage /stage") || strings.Contains(out, "COPY Dockerfile /final") {
+		t.Fatalf("expected --stop-at base to only build the base stage, got: %s", out)
+	}
+
+	// The build finishing the image reuses the stages up to base.
+	out = run(t, "build", "--no-console", "-t", "testbuildstopat", tmpd)
+	if !strings.Contains(out, "CACHED") || !strings.Contains(out, "COPY Dockerfile /final") {
+		t.Fatalf("expected the base stage to be cached, got: %s", out)
+	}
+
+	for _, args := range [][]string{
+		{"build", "--stop-at", "base", "-t", "testbuildstopat", tmpd},
+		{"build", "--stop-at", "base", "--target", "base", tmpd},
+	} {
+		if out, err := doRun(args, nil); err == nil || !strings.Contains(out, "--stop-at") {
+			t.Fatalf("expected img %v to fail, got: %s %v", args, out, err)
+		}
+	}
+}
+
+func TestParseOutputRegistry(t *testing.T) {
+	exporter, attrs, err := parseOutput("type=registry,ref=r.j3ss.co/img:test")
+	if err != nil {
+		t.Fatalf("parsing output failed: %v", err)
+	}
+
+	if exporter != client.ExporterRegistry {
+		t.Fatalf("expected exporter to be %q, got: %q", client.ExporterRegistry, exporter)
+	}
+	expected := map[string]string{
+		"name": "r.j3ss.co/img:test",
+		"push": "true",
+	}
+	if !reflect.DeepEqual(attrs, expected) {
+		t.Fatalf("expected exporter attrs %v, got: %v", expected, attrs)
+	}
+
+	if _, _, err := parseOutput("type=blah"); err == nil {
+		t.Fatal("expected parsing an invalid output type to fail but it did not")
+	}
+}
+
+func TestParseOutputPushName(t *testing.T) {
+	outputs, err := parseOutputs([]string{"type=image,name=local/app:dev,push-name=r.j3ss.co/app:dev"})
+	if err != nil {
+		t.Fatalf("parsing output failed: %v", err)
+	}
+
+	// The image is stored under the name and only pushed under the push-name.
+	expected := []buildOutput{
+		{exporter: bkclient.ExporterImage, attrs: map[string]string{"name": "local/app:dev"}},
+		{exporter: client.ExporterRegistry, attrs: map[string]string{"name": "r.j3ss.co/app:dev", "push": "true"}},
+	}
+	if !reflect.DeepEqual(outputs, expected) {
+		t.Fatalf("expected outputs %v, got: %v", expected, outputs)
+	}
+	if err := checkOutputDestinations(outputs); err != nil {
+		t.Fatalf("expected the store and push names to be distinct destinations, got: %v", err)
+	}
+	exporter, attrs := solveExporter(outputs)
+	expectedAttrs := map[string]string{"0.type": "image", "0.name": "local/app:dev", "1.type": "registry", "1.name": "r.j3ss.co/app:dev", "1.push": "true"}
+	if exporter != client.ExporterMulti || !reflect.DeepEqual(attrs, expectedAttrs) {
+		t.Fatalf("expected the %s exporter with attrs %v, got: %s %v", client.ExporterMulti, expectedAttrs, exporter, attrs)
+	}
+
+	for value, expected := range map[string]string{
+		"type=image,name=local/app:dev,push-name=R.j3ss.co/App":       `invalid push-name "R.j3ss.co/App"`,
+		"type=image,name=Local/app:dev,push-name=r.j3ss.co/app:dev":   `invalid name "Local/app:dev"`,
+		"type=image,name=a,push-name=r.j3ss.co/app:dev,push=true":     "remove push",
+		"type=registry,ref=r.j3ss.co/app,push-name=r.j3ss.co/app:dev": "only supported by the image output",
+	} {
+		if _, err := parseOutputs([]string{value}); err == nil || !strings.Contains(err.Error(), expected) {
+			t.Fatalf("expected parsing %s to fail with %q, got: %v", value, expected, err)
+		}
+	}
+}
+
+func TestParseOutputOCI(t *testing.T) {
+	exporter, attrs, err := parseOutput("type=oci,dest=-")
+	if err != nil {
+		t.Fatalf("parsing output failed: %v", err)
+	}
+	if exporter != bkclient.ExporterOCI {
+		t.Fatalf("expected exporter to be %q, got: %q", bkclient.ExporterOCI, exporter)
+	}
+	if attrs["dest"] != "-" {
+		t.Fatalf("expected dest -, got: %v", attrs)
+	}
+
+	if _, _, err := parseOutput("type=oci"); err == nil {
+		t.Fatal("expected parsing an oci output without a dest to fail but it did not")
+	}
+}
+
+func TestBuildOCIStdout(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("./testimg"+exeSuffix, "build", "--state", testStateDir, "--no-console", "-o", "type=oci,dest=-", "-")
+	cmd.Stdin = withDockerfile(`
+  FROM busybox
+  RUN echo ocistdout
+  `)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("img build to an oci archive on STDOUT failed: %v\n%s", err, stderr.String())
+	}
+
+	// The build output goes to STDERR so STDOUT is only the archive.
+	if !strings.Contains(stderr.String(), "Successfully built an OCI archive to STDOUT") {
+		t.Fatalf("expected the build output on STDERR, got: %s", stderr.String())
+	}
+
+	files := map[string][]byte{}
+	tr := tar.NewReader(&stdout)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("reading oci archive failed: %v", err)
+		}
+		b, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatalf("reading %s from oci archive failed: %v", hdr.Name, err)
+		}
+		files[hdr.Name] = b
+	}
+
+	if _, ok := files["oci-layout"]; !ok {
+		t.Fatal("expected oci archive to have an oci-layout")
+	}
+	var index ocispec.Index
+	if err := json.Unmarshal(files["index.json"], &index); err != nil {
+		t.Fatalf("parsing index.json of oci archive failed: %v", err)
+	}
+	if len(index.Manifests) != 1 {
+		t.Fatalf("expected 1 manifest in index.json, got: %d", len(index.Manifests))
+	}
+	for name, b := range files {
+		if !strings.HasPrefix(name, "blobs/sha256/") {
+			continue
+		}
+		if dgst := digest.FromBytes(b); "blobs/sha256/"+dgst.Hex() != name {
+			t.Fatalf("expected blob %s to match its digest, got: %s", name, dgst)
+		}
+	}
+	if _, ok := files["blobs/sha256/"+index.Manifests[0].Digest.Hex()]; !ok {
+		t.Fatalf("expected oci archive to have the manifest blob %s", index.Manifests[0].Digest)
+	}
+}
+
+func TestBuildMultipleOutputs(t *testing.T) {
+	tmpd, err := ioutil.TempDir("", "img-multiple-outputs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpd)
+	archive := filepath.Join(tmpd, "image.tar")
+
+	name := "testbuildmultipleoutputs"
+	out, err := doRun([]string{"build", "--no-console", "-t", name, "-o", "type=image", "-o", "type=oci,dest=" + archive, "-"}, withDockerfile(`
+  FROM busybox
+  RUN echo multipleoutputs
+  `))
+	if err != nil {
+		t.Fatalf("building with two outputs failed: %v", err)
+	}
+	if !strings.Contains(out, "Successfully built "+name) {
+		t.Fatalf("expected the build to name the image, got: %s", out)
+	}
+
+	// The image is in the image store and the archive was written.
+	out = run(t, "ls")
+	if !strings.Contains(out, name+":latest") {
+		t.Fatalf("expected %s:latest to be in ls output, got: %s", name, out)
+	}
+	f, err := os.Open(archive)
+	if err != nil {
+		t.Fatalf("opening the oci archive failed: %v", err)
+	}
+	defer f.Close()
+	found := false
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("reading oci archive failed: %v", err)
+		}
+		found = found || hdr.Name == "index.json"
+	}
+	if !found {
+		t.Fatal("expected the oci archive to have an index.json")
+	}
+}
+
+func TestCheckOutputDestinations(t *testing.T) {
+	testCases := []struct {
+		outputs []string
+		err     string
+	}{
+		{outputs: []string{"type=image,name=a", "type=oci,dest=a.tar"}},
+		{outputs: []string{"type=image,name=a", "type=registry,ref=a"}},
+		{outputs: []string{"type=image,name=a", "type=image,name=b"}},
+		{outputs: []string{"type=image,name=a", `type=image,"name=b,a"`}, err: "more than one output stores a in the image store"},
+		{outputs: []string{"type=image,name=a,push=true", "type=registry,ref=a"}, err: "more than one output pushes a"},
+		{outputs: []string{"type=oci,dest=a.tar", "type=oci,dest=b.tar"}, err: "more than one output writes the oci archive"},
+	}
+	for _, tc := range testCases {
+		outputs, err := parseOutputs(tc.outputs)
+		if err != nil {
+			t.Fatalf("parsing outputs %v failed: %v", tc.outputs, err)
+		}
+		err = checkOutputDestinations(outputs)
+		if tc.err == "" && err != nil {
+			t.Fatalf("expected outputs %v to be distinct, got: %v", tc.outputs, err)
+		}
+		if tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)) {
+			t.Fatalf("expected outputs %v to fail with %q, got: %v", tc.outputs, tc.err, err)
+		}
+	}
+
+	// A single output is sent as is, several use the multi exporter.
+	outputs, _ := parseOutputs(nil)
+	if exporter, _ := solveExporter(outputs); exporter != bkclient.ExporterImage {
+		t.Fatalf("expected the default output to use the %s exporter, got: %s", bkclient.ExporterImage, exporter)
+	}
+	outputs, _ = parseOutputs([]string{"type=image,name=a", "type=oci,dest=a.tar"})
+	exporter, attrs := solveExporter(outputs)
+	expected := map[string]string{"0.type": "image", "0.name": "a", "1.type": "oci", "1.dest": "a.tar"}
+	if exporter != client.ExporterMulti || !reflect.DeepEqual(attrs, expected) {
+		t.Fatalf("expected the %s exporter with attrs %v, got: %s %v", client.ExporterMulti, expected, exporter, attrs)
+	}
+}
+
+func TestBuildQuietSuccess(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("./testimg"+exeSuffix, "build", "--state", testStateDir, "--no-console", "--quiet-success", "-t", "testbuildquietsuccess", "-")
+	cmd.Stdin = withDockerfile(`
+  FROM scratch
+  COPY Dockerfile /
+  `)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("img build --quiet-success failed: %v\n%s", err, stderr.String())
+	}
+
+	// Only the digest is on STDOUT, the progress went to STDERR.
+	if _, err := digest.Parse(strings.TrimSpace(stdout.String())); err != nil || strings.Count(stdout.String(), "\n") != 1 {
+		t.Fatalf("expected only the digest on STDOUT, got: %q", stdout.String())
+	}
+	if strings.Contains(stderr.String(), "Successfully built") || !strings.Contains(stderr.String(), "COPY Dockerfile /") {
+		t.Fatalf("expected the progress without the success notice on STDERR, got: %s", stderr.String())
+	}
+}
+
+func TestBuildQuiet(t *testing.T) {
+	build := func(dockerfile string, args ...string) (string, string, int) {
+		var stdout, stderr bytes.Buffer
+		cmd := exec.Command("./testimg"+exeSuffix, append([]string{"build", "--state", testStateDir}, append(args, "-")...)...)
+		cmd.Stdin = withDockerfile(dockerfile)
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+		err := cmd.Run()
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return stdout.String(), stderr.String(), exitErr.ExitCode()
+		}
+		if err != nil {
+			t.Fatalf("running img build failed: %v", err)
+		}
+		return stdout.String(), stderr.String(), 0
+	}
+
+	// Only the digest is printed on success, without the progress.
+	stdout, stderr, code := build(`
+  FROM scratch
+  COPY Dockerfile /
+  `, "-q", "-t", "testbuildquiet")
+	if code != exitSuccess {
+		t.Fatalf("expected img build -q to succeed, got exit code %d: %s", code, stderr)
+	}
+	if _, err := digest.Parse(strings.TrimSpace(stdout)); err != nil || strings.Count(stdout, "\n") != 1 {
+		t.Fatalf("expected only the digest on STDOUT, got: %q", stdout)
+	}
+	if stderr != "" {
+		t.Fatalf("expected nothing on STDERR, got: %q", stderr)
+	}
+
+	// A failure prints the error on a line with its exit code.
+	for _, tc := range []struct {
+		args []string
+		code int
+	}{
+		{args: []string{"--quiet", "-t", "testbuildquiet"}, code: exitError},
+		{args: []string{"--quiet"}, code: exitUsage},
+	} {
+		stdout, stderr, code := build(`
+  FROM scratch
+  COPY missing /
+  `, tc.args...)
+		if code != tc.code {
+			t.Fatalf("expected img build %v to exit with %d, got: %d (%s)", tc.args, tc.code, code, stderr)
+		}
+		if stdout != "" {
+			t.Fatalf("expected nothing on STDOUT for a failure, got: %q", stdout)
+		}
+		if strings.Count(stderr, "\n") != 1 || !strings.HasSuffix(stderr, "\n") {
+			t.Fatalf("expected a one-line error on STDERR, got: %q", stderr)
+		}
+	}
+}
+
+func TestBuildCacheNamespace(t *testing.T) {
+	dockerfile := `
+  FROM busybox
+  RUN echo cachenamespace
+  `
+
+	build := func(ns string) string {
+		args := []string{"build", "--no-console", "--cache-ns", ns, "-t", "testbuildcachens" + ns, "-"}
+		out, err := doRun(args, withDockerfile(dockerfile))
+		if err != nil {
+			t.Logf("img %v failed unexpectedly: %v", args, err)
+			t.FailNow()
+		}
+		return out
+	}
+
+	build("one")
+
+	// A build in another namespace should not hit the cache of the first one.
+	if out := build("two"); strings.Contains(out, "CACHED") {
+		t.Fatalf("expected build in cache namespace two to not use the cache of namespace one, got: %s", out)
+	}
+
+	// A build in the same namespace should hit the cache.
+	if out := build("one"); !strings.Contains(out, "CACHED") {
+		t.Fatalf("expected build in cache namespace one to use its cache, got: %s", out)
+	}
+}
+
+func TestBuildCreatedTime(t *testing.T) {
+	frontendAttrs := map[string]string{}
+	created, err := (&buildCommand{}).createdTime(frontendAttrs)
+	if err != nil || created != nil || len(frontendAttrs) != 0 {
+		t.Fatalf("expected no created time nor attrs without an epoch, got: %v, %v, %v", created, frontendAttrs, err)
+	}
+
+	created, err = (&buildCommand{sourceDateEpoch: "1577836800"}).createdTime(frontendAttrs)
+	if err != nil {
+		t.Fatalf("getting the created time failed: %v", err)
+	}
+	if created == nil || created.Unix() != 1577836800 {
+		t.Fatalf("expected the created time of the epoch, got: %v", created)
+	}
+	if !reflect.DeepEqual(frontendAttrs, map[string]string{"build-arg:SOURCE_DATE_EPOCH": "1577836800"}) {
+		t.Fatalf("expected the SOURCE_DATE_EPOCH build arg, got: %v", frontendAttrs)
+	}
+
+	if _, err := (&buildCommand{sourceDateEpoch: "yesterday"}).createdTime(map[string]string{}); err == nil || exitCode(err) != exitError {
+		t.Fatalf("expected an invalid epoch to fail with exit code %d, got: %v", exitError, err)
+	}
+}
+
+func TestBuildSourceDateEpoch(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("./testimg"+exeSuffix, "build", "--state", testStateDir, "--no-console", "--source-date-epoch", "1577836800", "-o", "type=oci,dest=-", "-")
+	cmd.Stdin = withDockerfile(`
+  FROM scratch
+  COPY Dockerfile /
+  `)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("img build --source-date-epoch failed: %v\n%s", err, stderr.String())
+	}
+
+	files := map[string][]byte{}
+	tr := tar.NewReader(&stdout)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("reading oci archive failed: %v", err)
+		}
+		b, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatalf("reading %s from oci archive failed: %v", hdr.Name, err)
+		}
+		files[hdr.Name] = b
+	}
+	var index ocispec.Index
+	if err := json.Unmarshal(files["index.json"], &index); err != nil || len(index.Manifests) != 1 {
+		t.Fatalf("expected an index.json with a manifest, got: %s (%v)", files["index.json"], err)
+	}
+	var manifest ocispec.Manifest
+	if err := json.Unmarshal(files["blobs/sha256/"+index.Manifests[0].Digest.Hex()], &manifest); err != nil {
+		t.Fatalf("parsing the manifest failed: %v", err)
+	}
+	var config ocispec.Image
+	if err := json.Unmarshal(files["blobs/sha256/"+manifest.Config.Digest.Hex()], &config); err != nil {
+		t.Fatalf("parsing the image config failed: %v", err)
+	}
+
+	epoch := time.Unix(1577836800, 0)
+	if config.Created == nil || !config.Created.Equal(epoch) {
+		t.Fatalf("expected the image created at %s, got: %v", epoch, config.Created)
+	}
+	for _, h := range config.History {
+		if h.Created == nil || !h.Created.Equal(epoch) {
+			t.Fatalf("expected the history entry %s created at %s, got: %v", h.CreatedBy, epoch, h.Created)
+		}
+	}
+}
+
+func TestCheckTimestampSource(t *testing.T) {
+	for _, tc := range []struct {
+		source, context, epoch string
+	}{
+		{"", ".", ""},
+		{"", ".", "1577836800"},
+		{"git", "https://github.com/mchirico/img.git", ""},
+		{"git", "github.com/mchirico/img#v1:docs", ""},
+	} {
+		if err := checkTimestampSource(tc.source, tc.context, tc.epoch); err != nil {
+			t.Fatalf("expected --timestamp-source %q for context %s to pass, got: %v", tc.source, tc.context, err)
+		}
+	}
+
+	for _, tc := range []struct {
+		source, context, epoch, expected string
+	}{
+		{"mtime", "github.com/mchirico/img", "", "invalid timestamp-source mtime"},
+		{"git", ".", "", ". is not a git context"},
+		{"git", "github.com/mchirico/img", "1577836800", "pass only one"},
+	} {
+		err := checkTimestampSource(tc.source, tc.context, tc.epoch)
+		if err == nil || !strings.Contains(err.Error(), tc.expected) {
+			t.Fatalf("expected --timestamp-source %q for context %s to fail with %q, got: %v", tc.source, tc.context, tc.expected, err)
+		}
+	}
+}
+
+func TestSolveStatusQuietPull(t *testing.T) {
+	resp := &controlapi.StatusResponse{
+		Vertexes: []*controlapi.Vertex{
+			{Digest: "sha256:metadata", Name: "[internal] load metadata for docker.io/library/busybox:latest"},
+			{Digest: "sha256:from", Name: "[1/2] FROM docker.io/library/busybox@sha256:abc"},
+			{Digest: "sha256:context", Name: "[internal] load build context"},
+			{Digest: "sha256:run", Name: "[2/2] RUN echo hello"},
+		},
+		Statuses: []*controlapi.VertexStatus{
+			{ID: "resolve docker.io/library/busybox", Vertex: "sha256:from"},
+			{ID: "transferring context", Vertex: "sha256:context"},
+		},
+		Logs: []*controlapi.VertexLog{
+			{Vertex: "sha256:run", Msg: []byte("hello\n")},
+		},
+	}
+
+	s := solveStatus(resp, true, map[digest.Digest]bool{})
+	var names []string
+	for _, v := range s.Vertexes {
+		names = append(names, v.Name)
+	}
+	expected := []string{"[internal] load build context", "[2/2] RUN echo hello"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Fatalf("expected vertexes %v, got: %v", expected, names)
+	}
+	if len(s.Statuses) != 1 || s.Statuses[0].Vertex != "sha256:context" {
+		t.Fatalf("expected only the build context status, got: %v", s.Statuses)
+	}
+	if len(s.Logs) != 1 {
+		t.Fatalf("expected the RUN log to be kept, got: %v", s.Logs)
+	}
+
+	// Without quiet pull everything is kept.
+	if s := solveStatus(resp, false, map[digest.Digest]bool{}); len(s.Vertexes) != 4 || len(s.Statuses) != 2 {
+		t.Fatalf("expected all vertexes and statuses without quiet pull, got: %v, %v", s.Vertexes, s.Statuses)
+	}
+}
+
+// shortReader delivers its data in small chunks followed by empty reads before
+// the EOF, like a FIFO whose writer is slow to close.
+type shortReader struct {
+	data  []byte
+	empty int
+}
+
+func (r *shortReader) Read(p []byte) (int, error) {
+	if len(r.data) > 0 {
+		n := copy(p, r.data[:1+len(r.data)/2])
+		r.data = r.data[n:]
+		return n, nil
+	}
+	if r.empty > 0 {
+		r.empty--
+		return 0, nil
+	}
+	return 0, io.EOF
+}
+
+func TestContextFromReaderShortReads(t *testing.T) {
+	dockerfile := "FROM busybox\nRUN echo short\n"
+
+	for _, r := range []io.Reader{
+		strings.NewReader(dockerfile),
+		&shortReader{data: []byte(dockerfile)},
+		&shortReader{data: []byte(dockerfile), empty: 200},
+	} {
+		dir, err := contextFromReader(r, "")
+		if dir != "" {
+			defer os.RemoveAll(dir)
+		}
+		if err != nil {
+			t.Fatalf("reading context from %T failed: %v", r, err)
+		}
+
+		b, err := ioutil.ReadFile(filepath.Join(dir, defaultDockerfileName))
+		if err != nil {
+			t.Fatalf("reading dockerfile from context failed: %v", err)
+		}
+		if string(b) != dockerfile {
+			t.Fatalf("expected dockerfile %q, got: %q", dockerfile, string(b))
+		}
+	}
+}
+
+func TestThrottleStatus(t *testing.T) {
+	in := make(chan *bkclient.SolveStatus)
+	out := make(chan *bkclient.SolveStatus)
+	tick := make(chan time.Time)
+	go throttleStatus(in, out, tick)
+
+	// Statuses are held back until the next tick.
+	in <- &bkclient.SolveStatus{Vertexes: []*bkclient.Vertex{{Name: "one"}}}
+	in <- &bkclient.SolveStatus{Vertexes: []*bkclient.Vertex{{Name: "two"}}}
+	select {
+	case s := <-out:
+		t.Fatalf("expected no status before the tick, got: %v", s)
+	default:
+	}
+
+	tick <- time.Now()
+	s := <-out
+	if len(s.Vertexes) != 2 || s.Vertexes[0].Name != "one" || s.Vertexes[1].Name != "two" {
+		t.Fatalf("expected the merged statuses on tick, got: %v", s.Vertexes)
+	}
+
+	// Pending statuses are flushed on close.
+	in <- &bkclient.SolveStatus{Vertexes: []*bkclient.Vertex{{Name: "three"}}}
+	close(in)
+	s = <-out
+	if len(s.Vertexes) != 1 || s.Vertexes[0].Name != "three" {
+		t.Fatalf("expected the pending status on close, got: %v", s.Vertexes)
+	}
+	if _, ok := <-out; ok {
+		t.Fatal("expected the output channel to be closed")
+	}
+}
+
+func TestTransferProgress(t *testing.T) {
+	stats := map[string]client.TransferStats{}
+	tp := newTransferProgress(func() map[string]client.TransferStats {
+		return stats
+	})
+
+	// Nothing is shown before any file was sent.
+	if s := tp.status(time.Now()); s != nil {
+		t.Fatalf("expected no status before the transfer, got: %v", s.Vertexes)
+	}
+
+	stats["context"] = client.TransferStats{Files: 1234, Bytes: 56 << 20}
+	stats["dockerfile"] = client.TransferStats{Files: 1, Bytes: 100}
+	s := tp.status(time.Now())
+	if s == nil || len(s.Vertexes) != 2 {
+		t.Fatalf("expected a vertex per local dir, got: %v", s)
+	}
+	for i, name := range []string{"Transferring context: 1234 files, 56.0 MiB", "Transferring dockerfile: 1 files, 100 B"} {
+		if s.Vertexes[i].Name != name {
+			t.Fatalf("expected vertex %q, got: %q", name, s.Vertexes[i].Name)
+		}
+	}
+
+	// Only changed stats are sent again, under the same vertex.
+	first := s.Vertexes[0]
+	stats["context"] = client.TransferStats{Files: 1300, Bytes: 60 << 20}
+	s = tp.status(time.Now())
+	if s == nil || len(s.Vertexes) != 1 {
+		t.Fatalf("expected only the changed vertex, got: %v", s)
+	}
+	if v := s.Vertexes[0]; v.Digest != first.Digest || !v.Started.Equal(*first.Started) {
+		t.Fatalf("expected the update to reuse the vertex %s, got: %s", first.Digest, v.Digest)
+	}
+	if s := tp.status(time.Now()); s != nil {
+		t.Fatalf("expected no status without changes, got: %v", s.Vertexes)
+	}
+}
+
+func TestBuildStrictBuildArgs(t *testing.T) {
+	tmpd, err := ioutil.TempDir("", "img-strict-build-args")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpd)
+
+	dockerfilePath := filepath.Join(tmpd, "Dockerfile")
+	if err := ioutil.WriteFile(dockerfilePath, []byte(`
+ARG BASE=busybox
+FROM ${BASE}
+ARG VERSION
+RUN echo ${VERSION}
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := &buildCommand{dockerfilePath: dockerfilePath, strictBuildArgs: true}
+	if err := cmd.checkBuildArgs(map[string]string{"BASE": "alpine", "VERSION": "1.0", "HTTP_PROXY": "http://proxy"}); err != nil {
+		t.Fatalf("expected declared build-args to pass, got: %v", err)
+	}
+
+	err = cmd.checkBuildArgs(map[string]string{"VERSION": "1.0", "VERSOIN": "1.0"})
+	if err == nil || !strings.Contains(err.Error(), "VERSOIN") {
+		t.Fatalf("expected undeclared build-arg VERSOIN to fail, got: %v", err)
+	}
+}
+
+func TestParseShmSize(t *testing.T) {
+	size, err := parseShmSize("2g")
+	if err != nil {
+		t.Fatalf("parsing shm size failed: %v", err)
+	}
+	if size != 2*1024*1024*1024 {
+		t.Fatalf("expected 2g to be %d bytes, got: %d", 2*1024*1024*1024, size)
+	}
+
+	for _, value := range []string{"0", "-1m", "big"} {
+		if _, err := parseShmSize(value); err == nil {
+			t.Fatalf("expected parsing shm size %q to fail but it did not", value)
+		}
+	}
+}
+
+func TestCheckContextCompression(t *testing.T) {
+	if err := checkContextCompression("none"); err != nil {
+		t.Fatalf("expected none to be valid, got: %v", err)
+	}
+	for _, algorithm := range []string{"gzip", "zstd"} {
+		err := checkContextCompression(algorithm)
+		if err == nil || !strings.Contains(err.Error(), "remote builder") {
+			t.Fatalf("expected %s to require a remote builder, got: %v", algorithm, err)
+		}
+	}
+	if err := checkContextCompression("lz4"); exitCode(err) != exitUsage {
+		t.Fatalf("expected an invalid algorithm to be a usage error, got: %v", err)
+	}
+}
+
+func TestNormalizeTag(t *testing.T) {
+	testCases := []struct {
+		tag           string
+		defaultLatest bool
+		expected      string
+		shouldFail    bool
+	}{
+		{tag: "thing", defaultLatest: true, expected: "docker.io/library/thing:latest"},
+		{tag: "thing", shouldFail: true},
+		{tag: "jess/thing:v1", expected: "docker.io/jess/thing:v1"},
+		{tag: "r.j3ss.co/thing:v1", defaultLatest: true, expected: "r.j3ss.co/thing:v1"},
+	}
+	for _, tc := range testCases {
+		tag, err := normalizeTag(tc.tag, tc.defaultLatest)
+		if tc.shouldFail {
+			if err == nil {
+				t.Fatalf("expected normalizing %q without defaulting to latest to fail, got: %s", tc.tag, tag)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("normalizing %q failed: %v", tc.tag, err)
+		}
+		if tag != tc.expected {
+			t.Fatalf("expected %q to be normalized to %q, got: %q", tc.tag, tc.expected, tag)
+		}
+	}
+}
+
+func writeContextArchive(t *testing.T, path string, compress bool) {
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("creating archive failed: %v", err)
+	}
+	defer f.Close()
+
+	var w io.Writer = f
+	if compress {
+		gzw := gzip.NewWriter(f)
+		defer gzw.Close()
+		w = gzw
+	}
+	tw := tar.NewWriter(w)
+	defer tw.Close()
+
+	dockerfile := []byte("FROM busybox\nRUN echo archive\n")
+	if err := tw.WriteHeader(&tar.Header{Name: defaultDockerfileName, Mode: 0644, Size: int64(len(dockerfile)), Typeflag: tar.TypeReg}); err != nil {
+		t.Fatalf("writing archive header failed: %v", err)
+	}
+	if _, err := tw.Write(dockerfile); err != nil {
+		t.Fatalf("writing archive failed: %v", err)
+	}
+}
+
+func TestContextFromArchive(t *testing.T) {
+	tmpd, err := ioutil.TempDir("", "img-context-archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpd)
+
+	for _, compress := range []bool{false, true} {
+		path := filepath.Join(tmpd, "context.tar")
+		writeContextArchive(t, path, compress)
+
+		dir, err := contextFromArchive(path)
+		if dir != "" {
+			defer os.RemoveAll(dir)
+		}
+		if err != nil {
+			t.Fatalf("reading context from archive (compressed: %t) failed: %v", compress, err)
+		}
+		if _, err := os.Stat(filepath.Join(dir, defaultDockerfileName)); err != nil {
+			t.Fatalf("expected the dockerfile to be unpacked (compressed: %t): %v", compress, err)
+		}
+	}
+
+	// Directories and files that are not archives are used as is.
+	notArchive := filepath.Join(tmpd, "notes.txt")
+	if err := ioutil.WriteFile(notArchive, []byte("not an archive"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{tmpd, notArchive} {
+		dir, err := contextFromArchive(path)
+		if err != nil || dir != "" {
+			t.Fatalf("expected %s to not be unpacked, got: %q, %v", path, dir, err)
+		}
+	}
+}
+
+func TestIsArchive(t *testing.T) {
+	var tarball bytes.Buffer
+	tw := tar.NewWriter(&tarball)
+	if err := tw.WriteHeader(&tar.Header{Name: defaultDockerfileName, Mode: 0644, Typeflag: tar.TypeReg}); err != nil {
+		t.Fatalf("writing archive header failed: %v", err)
+	}
+	tw.Close()
+
+	for _, tc := range []struct {
+		name        string
+		header      []byte
+		compression string
+		archive     bool
+	}{
+		{name: "tar", header: tarball.Bytes(), archive: true},
+		{name: "gzip", header: []byte{0x1f, 0x8b, 0x08, 0x00}, compression: "gzip", archive: true},
+		{name: "bzip2", header: []byte("BZh91AY"), compression: "bzip2", archive: true},
+		{name: "xz", header: []byte{0xfd, 0x37, 0x7a, 0x58, 0x5a, 0x00, 0x00}, compression: "xz", archive: true},
+		{name: "zstd", header: []byte{0x28, 0xb5, 0x2f, 0xfd, 0x04, 0x58}, compression: "zstd", archive: true},
+		{name: "truncated zstd", header: []byte{0x28, 0xb5, 0x2f}},
+		{name: "malformed zstd", header: []byte{0x28, 0xb5, 0x2f, 0xfe, 0x04, 0x58}},
+		{name: "malformed xz", header: []byte{0xfd, 0x37, 0x7a, 0x58, 0x5a, 0x01, 0x00}},
+		{name: "text", header: []byte("FROM busybox\n")},
+	} {
+		if compression := archiveCompression(tc.header); compression != tc.compression {
+			t.Fatalf("expected the compression of the %s header to be %q, got: %q", tc.name, tc.compression, compression)
+		}
+		if isArchive(tc.header) != tc.archive {
+			t.Fatalf("expected the %s header to be an archive: %t", tc.name, tc.archive)
+		}
+	}
+}
+
+func TestUntarCompressed(t *testing.T) {
+	var tarball bytes.Buffer
+	tw := tar.NewWriter(&tarball)
+	dockerfile := []byte("FROM busybox\n")
+	if err := tw.WriteHeader(&tar.Header{Name: defaultDockerfileName, Mode: 0644, Size: int64(len(dockerfile)), Typeflag: tar.TypeReg}); err != nil {
+		t.Fatalf("writing archive header failed: %v", err)
+	}
+	if _, err := tw.Write(dockerfile); err != nil {
+		t.Fatalf("writing archive failed: %v", err)
+	}
+	tw.Close()
+
+	for _, compressor := range []string{"xz", "zstd"} {
+		if _, err := exec.LookPath(compressor); err != nil {
+			t.Logf("skipping %s, the binary is not installed", compressor)
+			continue
+		}
+		cmd := exec.Command(compressor, "-c", "-q")
+		cmd.Stdin = bytes.NewReader(tarball.Bytes())
+		compressed, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("compressing the archive with %s failed: %v", compressor, err)
+		}
+		if compression := archiveCompression(compressed); compression != compressor {
+			t.Fatalf("expected the archive compressed with %s to be detected, got: %q", compressor, compression)
+		}
+
+		dir, err := ioutil.TempDir("", "img-untar")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		if err := untar(dir, bytes.NewReader(compressed)); err != nil {
+			t.Fatalf("unpacking the %s archive failed: %v", compressor, err)
+		}
+		b, err := ioutil.ReadFile(filepath.Join(dir, defaultDockerfileName))
+		if err != nil || !bytes.Equal(b, dockerfile) {
+			t.Fatalf("expected the dockerfile to be unpacked from the %s archive, got: %q (%v)", compressor, b, err)
+		}
+	}
+}
+
+func TestContextArchiveRoundTrip(t *testing.T) {
+	// A file larger than a deflate block of the gzip stream and one that
+	// does not compress, a zero-byte file and a nested directory.
+	large := bytes.Repeat([]byte("img context round trip\n"), 64<<10)
+	random := make([]byte, 256<<10)
+	for i := range random {
+		random[i] = byte(i*7919 + i>>3)
+	}
+	files := map[string][]byte{
+		defaultDockerfileName: []byte("FROM busybox\nCOPY . /src\n"),
+		"large.txt":           large,
+		"data/random.bin":     random,
+		"data/empty":          {},
+	}
+	var tarball bytes.Buffer
+	tw := tar.NewWriter(&tarball)
+	if err := tw.WriteHeader(&tar.Header{Name: "data/", Mode: 0755, Typeflag: tar.TypeDir}); err != nil {
+		t.Fatalf("writing archive header failed: %v", err)
+	}
+	for _, name := range []string{defaultDockerfileName, "large.txt", "data/random.bin", "data/empty"} {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(files[name])), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatalf("writing archive header failed: %v", err)
+		}
+		if _, err := tw.Write(files[name]); err != nil {
+			t.Fatalf("writing archive failed: %v", err)
+		}
+	}
+	tw.Close()
+
+	archives := map[string][]byte{"none": tarball.Bytes()}
+	var gz bytes.Buffer
+	gzw := gzip.NewWriter(&gz)
+	gzw.Write(tarball.Bytes())
+	gzw.Close()
+	archives["gzip"] = gz.Bytes()
+	for _, compressor := range []string{"xz", "zstd"} {
+		if _, err := exec.LookPath(compressor); err != nil {
+			t.Logf("skipping %s, the binary is not installed", compressor)
+			continue
+		}
+		cmd := exec.Command(compressor, "-c", "-q")
+		cmd.Stdin = bytes.NewReader(tarball.Bytes())
+		compressed, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("compressing the archive with %s failed: %v", compressor, err)
+		}
+		archives[compressor] = compressed
+	}
+
+	// Every codec unpacks the same files as the uncompressed archive.
+	for compression, archive := range archives {
+		dir, err := contextFromReader(bytes.NewReader(archive), defaultDockerfileName)
+		if dir != "" {
+			defer os.RemoveAll(dir)
+		}
+		if err != nil {
+			t.Fatalf("reading the %s context failed: %v", compression, err)
+		}
+		got := map[string][]byte{}
+		if err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+			if err != nil || fi.IsDir() {
+				return err
+			}
+			rel, err := filepath.Rel(dir, path)
+			if err != nil {
+				return err
+			}
+			got[filepath.ToSlash(rel)], err = ioutil.ReadFile(path)
+			return err
+		}); err != nil {
+			t.Fatalf("walking the %s context failed: %v", compression, err)
+		}
+		if len(got) != len(files) {
+			t.Fatalf("expected the %s context to have %d files, got: %d", compression, len(files), len(got))
+		}
+		for name, b := range files {
+			if !bytes.Equal(got[name], b) {
+				t.Fatalf("expected %s of the %s context to be identical, got %d bytes instead of %d", name, compression, len(got[name]), len(b))
+			}
+		}
+	}
+}
+
+func TestBuildContextArchive(t *testing.T) {
+	tmpd, err := ioutil.TempDir("", "img-build-context-archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpd)
+
+	path := filepath.Join(tmpd, "context.tar")
+	writeContextArchive(t, path, false)
+
+	out := run(t, "build", "--dry-run", "-t", "testbuildcontextarchive", path)
+	if !strings.Contains(out, "img-build-context-") || strings.Contains(out, path) {
+		t.Fatalf("expected the archive to be unpacked into a temporary context, got: %s", out)
+	}
+}
+
+func TestParseChmodContext(t *testing.T) {
+	testCases := []struct {
+		value    string
+		expected client.FileModes
+		err      string
+	}{
+		{value: "0644", expected: client.FileModes{File: 0644, Dir: 0755}},
+		{value: "600", expected: client.FileModes{File: 0600, Dir: 0700}},
+		{value: "0640,dir=0750,preserve-exec", expected: client.FileModes{File: 0640, Dir: 0750, PreserveExec: true}},
+		{value: "0644,preserve-exec", expected: client.FileModes{File: 0644, Dir: 0755, PreserveExec: true}},
+		{value: "0999", err: "not an octal mode"},
+		{value: "01777", err: "not an octal mode"},
+		{value: "0644,dir=rwx", err: "not an octal mode"},
+		{value: "0644,exec", err: "unknown option exec"},
+	}
+	for _, tc := range testCases {
+		modes, err := parseChmodContext(tc.value)
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Fatalf("expected parsing %s to fail with %q, got: %v", tc.value, tc.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("parsing %s failed: %v", tc.value, err)
+		}
+		if modes != tc.expected {
+			t.Fatalf("expected %s to parse to %+v, got: %+v", tc.value, tc.expected, modes)
+		}
+	}
+}
+
+func TestBuildLogPrefix(t *testing.T) {
+	out, err := doRun([]string{"build", "--progress", "plain", "--log-prefix", "[logprefix] ", "-t", "testbuildlogprefix", "-"}, withDockerfile(`
+  FROM scratch
+  COPY Dockerfile /
+  `))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "COPY Dockerfile /") {
+		t.Fatalf("expected the plain progress of the build, got: %s", out)
+	}
+	for _, line := range strings.Split(strings.TrimSuffix(out, "\n"), "\n") {
+		if !strings.HasPrefix(line, "[logprefix] ") {
+			t.Fatalf("expected every line prefixed, got %q in: %s", line, out)
+		}
+	}
+
+	out, err = doRun([]string{"build", "--dry-run", "--log-prefix", "[logprefix] ", "-t", "testbuildlogprefix", "-"}, withDockerfile("FROM scratch"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "dockerfile.v0") {
+		t.Fatalf("expected the dry run of the build, got: %s", out)
+	}
+	for _, line := range strings.Split(strings.TrimSuffix(out, "\n"), "\n") {
+		if !strings.HasPrefix(line, "[logprefix] ") {
+			t.Fatalf("expected every line of the dry run prefixed, got %q in: %s", line, out)
+		}
+	}
+
+	out, err = doRun([]string{"build", "--dry-run", "-q", "-t", "testbuildlogprefix", "-"}, withDockerfile("FROM scratch"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "" {
+		t.Fatalf("expected no dry run output with -q, got: %s", out)
+	}
+
+	if _, err := doRun([]string{"build", "--progress", "json", "--log-prefix", "[logprefix] ", "-"}, withDockerfile("FROM scratch")); err == nil || !strings.Contains(err.Error(), "--log-prefix prefixes the plain progress") {
+		t.Fatalf("expected --log-prefix with json progress to fail, got: %v", err)
+	}
+}
+
+func TestBuildGCPolicy(t *testing.T) {
+	// Use a state directory of its own so the policy does not collect the
+	// cache of the other tests.
+	stateDir, err := ioutil.TempDir("", "img-build-gc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(stateDir)
+	img := func(stdin io.Reader, args ...string) string {
+		cmd := exec.Command("./testimg"+exeSuffix, append([]string{args[0], "--state", stateDir}, args[1:]...)...)
+		cmd.Stdin = stdin
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("img %v failed: %v\n%s", args, err, out)
+		}
+		return string(out)
+	}
+
+	dockerfile := `
+  FROM scratch
+  COPY Dockerfile /
+  `
+	img(withDockerfile(dockerfile), "build", "--no-console", "-t", "testbuildgcpolicy", "-")
+	if out := img(nil, "du"); strings.Contains(out, "Total:\t\t0 B") {
+		t.Fatalf("expected the build to leave a build cache, got: %s", out)
+	}
+
+	// The policy is applied once the next build succeeds.
+	img(nil, "gc", "--set", "keep-bytes=1")
+	img(withDockerfile(dockerfile+"COPY Dockerfile /again\n"), "build", "--no-console", "-t", "testbuildgcpolicy", "-")
+	if out := img(nil, "du"); !strings.Contains(out, "Total:\t\t0 B") {
+		t.Fatalf("expected the gc policy to collect the build cache, got: %s", out)
+	}
+}
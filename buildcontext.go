@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	securejoin "github.com/cyphar/filepath-securejoin"
+	"github.com/docker/distribution/reference"
+	"github.com/mchirico/img/client"
+	"github.com/moby/buildkit/frontend/dockerfile/parser"
+	digest "github.com/opencontainers/go-digest"
+)
+
+const (
+	dockerImageScheme = "docker-image://"
+	ociLayoutScheme   = "oci-layout://"
+
+	// localScheme prefixes the directories of local build contexts in the
+	// parsed contexts until localContextLayouts turns them into images.
+	localScheme = "local://"
+)
+
+// parseBuildContexts parses the --build-context values in the
+// 'name=docker-image://ref', 'name=oci-layout://path@digest' and
+// 'name=path[#subdir]' formats into a map of lowercase context names to
+// normalized image references. The images of OCI layouts are referenced by
+// digest in the client.OCILayoutDomain, the returned layouts map those
+// references to the layout directories. Local directories are kept as
+// local://path for localContextLayouts.
+func parseBuildContexts(values []string) (contexts map[string]string, layouts map[string]string, err error) {
+	contexts = map[string]string{}
+	layouts = map[string]string{}
+	for _, value := range values {
+		kv := strings.SplitN(value, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, nil, fmt.Errorf("invalid build-context value %s, expected name=docker-image://ref, name=oci-layout://path@digest or name=path#subdir", value)
+		}
+		name := strings.ToLower(kv[0])
+
+		switch {
+		case strings.HasPrefix(kv[1], dockerImageScheme):
+			named, err := reference.ParseNormalizedNamed(strings.TrimPrefix(kv[1], dockerImageScheme))
+			if err != nil {
+				return nil, nil, fmt.Errorf("parsing image name of build-context %s failed: %v", kv[0], err)
+			}
+			contexts[name] = reference.TagNameOnly(named).String()
+		case strings.HasPrefix(kv[1], ociLayoutScheme):
+			ref, dir, err := parseOCILayoutContext(name, strings.TrimPrefix(kv[1], ociLayoutScheme))
+			if err != nil {
+				return nil, nil, fmt.Errorf("build-context %s: %v", kv[0], err)
+			}
+			contexts[name] = ref
+			layouts[ref] = dir
+		case !strings.Contains(kv[1], "://"):
+			dir, err := parseLocalContext(kv[1])
+			if err != nil {
+				return nil, nil, fmt.Errorf("build-context %s: %v", kv[0], err)
+			}
+			contexts[name] = localScheme + dir
+		default:
+			return nil, nil, fmt.Errorf("build-context %s has an unsupported source %s, only %s, %s and local directories are supported", kv[0], kv[1], dockerImageScheme, ociLayoutScheme)
+		}
+	}
+	return contexts, layouts, nil
+}
+
+// parseOCILayoutContext parses the path@digest of an oci-layout build context
+// and checks the layout holds the digest. It returns the reference the image
+// is built from and the absolute path of the layout.
+func parseOCILayoutContext(name, value string) (string, string, error) {
+	i := strings.LastIndex(value, "@")
+	if i < 0 {
+		return "", "", fmt.Errorf("oci-layout %s must be pinned to a digest in the path@sha256:... format", value)
+	}
+	dir, dgst := value[:i], digest.Digest(value[i+1:])
+
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", "", err
+	}
+	if err := client.ValidateOCILayout(dir, dgst); err != nil {
+		return "", "", err
+	}
+
+	named, err := reference.ParseNormalizedNamed(client.OCILayoutDomain + "/" + name + "@" + dgst.String())
+	if err != nil {
+		return "", "", fmt.Errorf("invalid build-context name: %v", err)
+	}
+	return named.String(), dir, nil
+}
+
+// parseLocalContext parses the path#subdir of a local build context like the
+// #ref:subdir fragment of a git context. It returns the absolute path of the
+// subdir of the path, which has to be a directory within it.
+func parseLocalContext(value string) (string, error) {
+	parts := strings.SplitN(value, "#", 2)
+	dir, err := filepath.Abs(parts[0])
+	if err != nil {
+		return "", err
+	}
+	if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+		return "", fmt.Errorf("local context %s is not a directory", parts[0])
+	}
+	if len(parts) < 2 || parts[1] == "" {
+		return dir, nil
+	}
+
+	subdir := filepath.Clean(parts[1])
+	if filepath.IsAbs(subdir) || subdir == ".." || strings.HasPrefix(subdir, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("subdir %s escapes the local context %s", parts[1], parts[0])
+	}
+	// Symlinks in the subdir are resolved within the directory too.
+	p, err := securejoin.SecureJoin(dir, subdir)
+	if err != nil {
+		return "", err
+	}
+	fi, err := os.Stat(p)
+	if err != nil {
+		return "", fmt.Errorf("subdir %s not found in the local context %s", parts[1], parts[0])
+	}
+	if !fi.IsDir() {
+		return "", fmt.Errorf("subdir %s in the local context %s is not a directory", parts[1], parts[0])
+	}
+	return p, nil
+}
+
+// localContextLayouts turns the local directories of the build contexts into
+// images in OCI layouts written to a temporary directory, adding the layouts
+// to layouts. It returns the temporary directory for the caller to remove,
+// which is empty if there are no local contexts.
+func localContextLayouts(contexts, layouts map[string]string) (string, error) {
+	var tmpDir string
+	for name, ref := range contexts {
+		if !strings.HasPrefix(ref, localScheme) {
+			continue
+		}
+		if tmpDir == "" {
+			var err error
+			tmpDir, err = ioutil.TempDir("", "img-build-context-local-")
+			if err != nil {
+				return "", fmt.Errorf("unable to create temporary build context directory: %v", err)
+			}
+		}
+
+		dir := strings.TrimPrefix(ref, localScheme)
+		layout := filepath.Join(tmpDir, name)
+		dgst, err := client.WriteDirLayout(dir, layout)
+		if err != nil {
+			return tmpDir, fmt.Errorf("build-context %s: %v", name, err)
+		}
+		ref, _, err = parseOCILayoutContext(name, layout+"@"+dgst.String())
+		if err != nil {
+			return tmpDir, fmt.Errorf("build-context %s: %v", name, err)
+		}
+		contexts[name] = ref
+		layouts[ref] = layout
+	}
+	return tmpDir, nil
+}
+
+// applyBuildContexts writes a copy of the dockerfile with the named build
+// contexts resolved into a temporary directory and returns its path. The
+// caller is responsible for removing the directory.
+func applyBuildContexts(dockerfilePath string, contexts map[string]string) (string, error) {
+	dt, err := ioutil.ReadFile(dockerfilePath)
+	if err != nil {
+		return "", err
+	}
+	dt, err = rewriteBuildContexts(dt, contexts)
+	if err != nil {
+		return "", fmt.Errorf("applying build contexts to dockerfile %s failed: %v", dockerfilePath, err)
+	}
+
+	tmpDir, err := ioutil.TempDir("", "img-build-dockerfile-")
+	if err != nil {
+		return "", fmt.Errorf("unable to create temporary dockerfile directory: %v", err)
+	}
+	p := filepath.Join(tmpDir, filepath.Base(dockerfilePath))
+	if err := ioutil.WriteFile(p, dt, 0644); err != nil {
+		os.RemoveAll(tmpDir)
+		return "", err
+	}
+	return p, nil
+}
+
+// rewriteBuildContexts replaces the references to named build contexts in
+// FROM instructions and COPY --from flags with their image. This way the
+// contexts take precedence over stages of the same name, and COPY --from a
+// context copies from the image rootfs.
+func rewriteBuildContexts(dt []byte, contexts map[string]string) ([]byte, error) {
+	result, err := parser.Parse(bytes.NewReader(dt))
+	if err != nil {
+		return nil, err
+	}
+
+	lines := strings.Split(string(dt), "\n")
+	for _, node := range result.AST.Children {
+		if node.StartLine < 1 || node.StartLine > len(lines) {
+			continue
+		}
+		i := node.StartLine - 1
+
+		switch node.Value {
+		case "from":
+			if node.Next == nil {
+				continue
+			}
+			ref, ok := contexts[strings.ToLower(node.Next.Value)]
+			if !ok {
+				continue
+			}
+			re := regexp.MustCompile(`(?i)^(\s*FROM\s+(?:--\S+\s+)*)` + regexp.QuoteMeta(node.Next.Value) + `(\s|$)`)
+			lines[i] = replaceSubmatch(re, lines[i], ref)
+		case "copy":
+			for _, flag := range node.Flags {
+				if !strings.HasPrefix(flag, "--from=") {
+					continue
+				}
+				name := strings.TrimPrefix(flag, "--from=")
+				ref, ok := contexts[strings.ToLower(name)]
+				if !ok {
+					continue
+				}
+				re := regexp.MustCompile(`(?i)(--from=)` + regexp.QuoteMeta(name) + `(\s|$)`)
+				lines[i] = replaceSubmatch(re, lines[i], ref)
+			}
+		}
+	}
+	return []byte(strings.Join(lines, "\n")), nil
+}
+
+// replaceSubmatch replaces the text between the first and second submatch of
+// the first match of re in s with value.
+func replaceSubmatch(re *regexp.Regexp, s, value string) string {
+	m := re.FindStringSubmatchIndex(s)
+	if m == nil {
+		return s
+	}
+	return s[:m[3]] + value + s[m[4]:]
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mchirico/img/client"
+	digest "github.com/opencontainers/go-digest"
+)
+
+func TestRewriteBuildContexts(t *testing.T) {
+	contexts, _, err := parseBuildContexts([]string{"base=docker-image://alpine:3.19", "Tools=docker-image://busybox"})
+	if err != nil {
+		t.Fatalf("parsing build contexts failed: %v", err)
+	}
+
+	dockerfile := `FROM base AS builder
+RUN echo builder
+FROM scratch
+COPY --from=tools /bin/busybox /bin/busybox
+COPY --from=builder /etc/os-release /etc/os-release
+COPY --from=base /etc/alpine-release /
+`
+	dt, err := rewriteBuildContexts([]byte(dockerfile), contexts)
+	if err != nil {
+		t.Fatalf("rewriting build contexts failed: %v", err)
+	}
+
+	expected := `FROM docker.io/library/alpine:3.19 AS builder
+RUN echo builder
+FROM scratch
+COPY --from=docker.io/library/busybox:latest /bin/busybox /bin/busybox
+COPY --from=builder /etc/os-release /etc/os-release
+COPY --from=docker.io/library/alpine:3.19 /etc/alpine-release /
+`
+	if string(dt) != expected {
+		t.Fatalf("expected dockerfile:\n%s\ngot:\n%s", expected, string(dt))
+	}
+}
+
+func TestParseBuildContextsInvalid(t *testing.T) {
+	for _, value := range []string{"base", "=docker-image://alpine", "base=./dir", "base=docker-image://UPPER"} {
+		if _, _, err := parseBuildContexts([]string{value}); err == nil {
+			t.Fatalf("expected parsing build-context %q to fail but it did not", value)
+		}
+	}
+}
+
+func TestParseBuildContextsOCILayout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "img-build-context-oci-layout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	manifest := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json"}`)
+	dgst := digest.FromBytes(manifest)
+	if err := os.MkdirAll(filepath.Join(dir, "blobs", "sha256"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "blobs", "sha256", dgst.Hex()), manifest, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "oci-layout"), []byte(`{"imageLayoutVersion":"1.0.0"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	contexts, layouts, err := parseBuildContexts([]string{"Base=oci-layout://" + dir + "@" + dgst.String()})
+	if err != nil {
+		t.Fatalf("parsing oci-layout build context failed: %v", err)
+	}
+	ref := client.OCILayoutDomain + "/base@" + dgst.String()
+	if contexts["base"] != ref {
+		t.Fatalf("expected the context to resolve to %s, got: %s", ref, contexts["base"])
+	}
+	if layouts[ref] != dir {
+		t.Fatalf("expected %s to be loaded from %s, got: %v", ref, dir, layouts)
+	}
+
+	dt, err := rewriteBuildContexts([]byte("FROM base\n"), contexts)
+	if err != nil {
+		t.Fatalf("rewriting build contexts failed: %v", err)
+	}
+	if string(dt) != "FROM "+ref+"\n" {
+		t.Fatalf("expected the FROM to use the oci-layout image, got: %s", dt)
+	}
+
+	missing := digest.FromString("missing")
+	for _, value := range []string{
+		"base=oci-layout://" + dir,
+		"base=oci-layout://" + dir + "@" + missing.String(),
+		"base=oci-layout://" + filepath.Join(dir, "blobs") + "@" + dgst.String(),
+	} {
+		if _, _, err := parseBuildContexts([]string{value}); err == nil {
+			t.Fatalf("expected parsing build-context %q to fail but it did not", value)
+		}
+	}
+}
+
+func TestBuildContextDockerImage(t *testing.T) {
+	out, err := doRun([]string{"build", "--no-console", "--build-context", "base=docker-image://busybox", "-t", "testbuildcontextimage", "-"},
+		withDockerfile(`
+    FROM scratch
+    COPY --from=base /bin/busybox /bin/busybox
+    `))
+	if err != nil {
+		t.Fatalf("building with a docker-image build context failed: %v\n%s", err, out)
+	}
+	if !strings.Contains(out, "Successfully built") {
+		t.Fatalf("expected a successful build, got: %s", out)
+	}
+}
+
+func TestParseLocalContext(t *testing.T) {
+	dir, err := ioutil.TempDir("", "img-build-context-local")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := os.MkdirAll(filepath.Join(dir, "repo", "docs"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "repo", "README"), []byte("readme\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	repo := filepath.Join(dir, "repo")
+
+	contexts, _, err := parseBuildContexts([]string{"Docs=" + repo + "#docs", "repo=" + repo})
+	if err != nil {
+		t.Fatalf("parsing local build contexts failed: %v", err)
+	}
+	expected := map[string]string{"docs": localScheme + filepath.Join(repo, "docs"), "repo": localScheme + repo}
+	if !reflect.DeepEqual(contexts, expected) {
+		t.Fatalf("expected the local contexts %v, got: %v", expected, contexts)
+	}
+
+	for _, value := range []string{
+		repo + "#missing",
+		repo + "#README",
+		repo + "#../",
+		repo + "#docs/../../repo",
+		repo + "#/etc",
+		filepath.Join(dir, "missing"),
+	} {
+		if _, err := parseLocalContext(value); err == nil {
+			t.Fatalf("expected parsing local context %q to fail but it did not", value)
+		}
+	}
+}
+
+func TestBuildContextLocal(t *testing.T) {
+	tmpd, err := ioutil.TempDir("", "img-build-context-local")
+	if err != nil {
+		t.Fatalf("creating temporary directory failed: %v", err)
+	}
+	defer os.RemoveAll(tmpd)
+
+	files := map[string]string{
+		"repo/README":          "readme\n",
+		"repo/docs/index.md":   "index\n",
+		"repo/docs/guide/a.md": "a\n",
+		"context/Dockerfile":   "FROM scratch\nCOPY --from=docs / /docs/\n",
+	}
+	for name, content := range files {
+		p := filepath.Join(tmpd, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	repo := filepath.Join(tmpd, "repo")
+
+	dest := filepath.Join(tmpd, "out")
+	run(t, "build", "--build-context", "docs="+repo+"#docs", "-o", "type=local,dest="+dest, filepath.Join(tmpd, "context"))
+
+	var got []string
+	if err := filepath.Walk(dest, func(p string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dest, p)
+		got = append(got, rel)
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	// Only the files of the subdir are in the named context.
+	expected := []string{"docs/guide/a.md", "docs/index.md"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected the files %v from the subdir, got: %v", expected, got)
+	}
+}
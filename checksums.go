@@ -0,0 +1,66 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// checksumsFile is the file --output-checksums writes to the directory of
+// the local output, in the format of sha256sum.
+const checksumsFile = "SHA256SUMS"
+
+// writeChecksums writes the SHA256 checksums of the regular files in dir and
+// its subdirectories, by their slash separated path relative to dir, to the
+// checksums file in dir. It returns the number of files.
+func writeChecksums(dir string) (int, error) {
+	sums := map[string]string{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if rel == checksumsFile {
+			// Skip the checksums of a previous build.
+			return nil
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		h := sha256.New()
+		if _, err := io.Copy(h, f); err != nil {
+			return err
+		}
+		sums[rel] = fmt.Sprintf("%x", h.Sum(nil))
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("computing the checksums of %s failed: %v", dir, err)
+	}
+
+	paths := make([]string, 0, len(sums))
+	for path := range sums {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	var b strings.Builder
+	for _, path := range paths {
+		fmt.Fprintf(&b, "%s  %s\n", sums[path], path)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, checksumsFile), []byte(b.String()), 0644); err != nil {
+		return 0, fmt.Errorf("writing %s failed: %v", checksumsFile, err)
+	}
+	return len(paths), nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteChecksums(t *testing.T) {
+	dir, err := ioutil.TempDir("", "img-checksums")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := os.MkdirAll(filepath.Join(dir, "bin", "linux"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"README":        "hello\n",
+		"bin/linux/img": "img\n",
+		checksumsFile:   "stale\n",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	n, err := writeChecksums(dir)
+	if err != nil {
+		t.Fatalf("writing checksums failed: %v", err)
+	}
+	if n != 2 {
+		t.Fatalf("expected the checksums of 2 files, got: %d", n)
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, checksumsFile))
+	if err != nil {
+		t.Fatalf("reading %s failed: %v", checksumsFile, err)
+	}
+	expected := `5891b5b522d5df086d0ff0b110fbd9d21bb4fc7163af34d08286a2e846f6be03  README
+6fb6d3835a3563bf834b561a53b234839f9c9449ab9b3cf01c531ef30198dcbe  bin/linux/img
+`
+	if string(b) != expected {
+		t.Fatalf("expected checksums %q, got: %q", expected, string(b))
+	}
+}
@@ -0,0 +1,240 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/platforms"
+	"github.com/docker/distribution/reference"
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+const (
+	// AnnotateTargetIndex annotates the index or manifest list of an image.
+	AnnotateTargetIndex = "index"
+	// AnnotateTargetManifest annotates the manifest of a single platform
+	// image, or with a ":os/arch" suffix the manifest for that platform of
+	// an index.
+	AnnotateTargetManifest = "manifest"
+)
+
+const gcRefContentLabel = "containerd.io/gc.ref.content"
+
+// Annotate adds or modifies the annotations of the index or a manifest of an
+// image in the image store without rebuilding it, see ParseAnnotateTarget
+// for the format of target. The modified blobs are
+// written to the content store and the image is updated to point at the new
+// root digest, which is returned.
+func (c *Client) Annotate(ctx context.Context, image, target string, annotations map[string]string) (ocispec.Descriptor, error) {
+	// Parse the image name and tag.
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return ocispec.Descriptor{}, fmt.Errorf("parsing image name %q failed: %v", image, err)
+	}
+	// Add the latest lag if they did not provide one.
+	named = reference.TagNameOnly(named)
+	image = named.String()
+
+	// Create the worker opts.
+	opt, err := c.createWorkerOpt(false)
+	if err != nil {
+		return ocispec.Descriptor{}, fmt.Errorf("creating worker opt failed: %v", err)
+	}
+
+	if opt.ImageStore == nil {
+		return ocispec.Descriptor{}, errors.New("image store is nil")
+	}
+
+	img, err := opt.ImageStore.Get(ctx, image)
+	if err != nil {
+		return ocispec.Descriptor{}, fmt.Errorf("getting image %s from image store failed: %v", image, err)
+	}
+
+	desc, err := annotateRoot(ctx, opt.ContentStore, img.Target, target, annotations)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+
+	img.Target = desc
+	img.UpdatedAt = time.Now()
+	if _, err := opt.ImageStore.Update(ctx, img, "target"); err != nil {
+		return ocispec.Descriptor{}, fmt.Errorf("updating image store for %s failed: %v", image, err)
+	}
+
+	return desc, nil
+}
+
+// ParseAnnotateTarget parses an annotate target in the 'index', 'manifest'
+// or 'manifest:os/arch[/variant]' format. The platform is nil unless given.
+func ParseAnnotateTarget(value string) (string, *ocispec.Platform, error) {
+	switch {
+	case value == AnnotateTargetIndex, value == AnnotateTargetManifest:
+		return value, nil, nil
+	case strings.HasPrefix(value, AnnotateTargetManifest+":"):
+		p, err := platforms.Parse(strings.TrimPrefix(value, AnnotateTargetManifest+":"))
+		if err != nil {
+			return "", nil, fmt.Errorf("parsing platform of annotate target %s failed: %v", value, err)
+		}
+		p = platforms.Normalize(p)
+		return AnnotateTargetManifest, &p, nil
+	}
+	return "", nil, fmt.Errorf("invalid annotate target %s, expected index, manifest or manifest:os/arch", value)
+}
+
+// annotateRoot annotates the target of the image with the root descriptor
+// and returns the descriptor of the new root. An empty target annotates the
+// root itself.
+func annotateRoot(ctx context.Context, cs content.Store, root ocispec.Descriptor, target string, annotations map[string]string) (ocispec.Descriptor, error) {
+	isIndex := root.MediaType == images.MediaTypeDockerSchema2ManifestList || root.MediaType == ocispec.MediaTypeImageIndex
+
+	// Default to the root of the image.
+	if target == "" {
+		target = AnnotateTargetManifest
+		if isIndex {
+			target = AnnotateTargetIndex
+		}
+	}
+	kind, platform, err := ParseAnnotateTarget(target)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	switch {
+	case kind == AnnotateTargetIndex && !isIndex:
+		return ocispec.Descriptor{}, fmt.Errorf("image is a single platform image (%s), use the manifest target", root.MediaType)
+	case kind == AnnotateTargetManifest && platform == nil && isIndex:
+		return ocispec.Descriptor{}, errors.New("image is a multi-platform index, use the manifest:os/arch target")
+	case kind == AnnotateTargetManifest && platform != nil && !isIndex:
+		return ocispec.Descriptor{}, fmt.Errorf("image is a single platform image (%s), use the manifest target without a platform", root.MediaType)
+	}
+
+	// Annotating the root itself.
+	if platform == nil {
+		return annotateBlob(ctx, cs, root, annotations, nil)
+	}
+
+	// Annotate the manifest for the platform, then point the index at it.
+	b, err := content.ReadBlob(ctx, cs, root)
+	if err != nil {
+		return ocispec.Descriptor{}, fmt.Errorf("reading index %s failed: %v", root.Digest, err)
+	}
+	var index ocispec.Index
+	if err := json.Unmarshal(b, &index); err != nil {
+		return ocispec.Descriptor{}, fmt.Errorf("parsing index %s failed: %v", root.Digest, err)
+	}
+
+	found := -1
+	matcher := platforms.NewMatcher(*platform)
+	for i, m := range index.Manifests {
+		if m.Platform != nil && matcher.Match(platforms.Normalize(*m.Platform)) {
+			found = i
+			break
+		}
+	}
+	if found < 0 {
+		return ocispec.Descriptor{}, fmt.Errorf("index has no manifest for platform %s", platforms.Format(*platform))
+	}
+
+	manifest, err := annotateBlob(ctx, cs, index.Manifests[found], annotations, nil)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	return annotateBlob(ctx, cs, root, nil, map[digest.Digest]ocispec.Descriptor{index.Manifests[found].Digest: manifest})
+}
+
+// annotateBlob merges the annotations into the JSON blob of the descriptor,
+// replacing the digest and size of the manifests listed in replace, and
+// writes the result as a new blob. Unknown fields of the blob are kept as is.
+func annotateBlob(ctx context.Context, cs content.Store, desc ocispec.Descriptor, annotations map[string]string, replace map[digest.Digest]ocispec.Descriptor) (ocispec.Descriptor, error) {
+	b, err := content.ReadBlob(ctx, cs, desc)
+	if err != nil {
+		return ocispec.Descriptor{}, fmt.Errorf("reading blob %s failed: %v", desc.Digest, err)
+	}
+	info, err := cs.Info(ctx, desc.Digest)
+	if err != nil {
+		return ocispec.Descriptor{}, fmt.Errorf("getting info for blob %s failed: %v", desc.Digest, err)
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return ocispec.Descriptor{}, fmt.Errorf("parsing blob %s failed: %v", desc.Digest, err)
+	}
+
+	if len(annotations) > 0 {
+		current := map[string]string{}
+		if raw, ok := fields["annotations"]; ok {
+			if err := json.Unmarshal(raw, &current); err != nil {
+				return ocispec.Descriptor{}, fmt.Errorf("parsing annotations of blob %s failed: %v", desc.Digest, err)
+			}
+		}
+		for k, v := range annotations {
+			current[k] = v
+		}
+		if fields["annotations"], err = json.Marshal(current); err != nil {
+			return ocispec.Descriptor{}, err
+		}
+	}
+
+	labels := info.Labels
+	if len(replace) > 0 {
+		var manifests []map[string]interface{}
+		if err := json.Unmarshal(fields["manifests"], &manifests); err != nil {
+			return ocispec.Descriptor{}, fmt.Errorf("parsing manifests of blob %s failed: %v", desc.Digest, err)
+		}
+		for _, m := range manifests {
+			d, ok := replace[digest.Digest(fmt.Sprint(m["digest"]))]
+			if !ok {
+				continue
+			}
+			m["digest"] = d.Digest.String()
+			m["size"] = d.Size
+		}
+		if fields["manifests"], err = json.Marshal(manifests); err != nil {
+			return ocispec.Descriptor{}, err
+		}
+
+		// Keep the garbage collection references pointing at the children.
+		newLabels := map[string]string{}
+		referenced := map[digest.Digest]bool{}
+		for k, v := range labels {
+			if d, ok := replace[digest.Digest(v)]; ok && strings.HasPrefix(k, gcRefContentLabel) {
+				v = d.Digest.String()
+				referenced[d.Digest] = true
+			}
+			newLabels[k] = v
+		}
+		i := 0
+		for _, d := range replace {
+			if !referenced[d.Digest] {
+				newLabels[fmt.Sprintf("%s.annotate.%d", gcRefContentLabel, i)] = d.Digest.String()
+				i++
+			}
+		}
+		labels = newLabels
+	}
+
+	b, err = json.MarshalIndent(fields, "", "   ")
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+
+	newDesc := ocispec.Descriptor{
+		MediaType:   desc.MediaType,
+		Digest:      digest.FromBytes(b),
+		Size:        int64(len(b)),
+		Platform:    desc.Platform,
+		Annotations: desc.Annotations,
+	}
+	ref := "annotate-" + newDesc.Digest.String()
+	if err := content.WriteBlob(ctx, cs, ref, bytes.NewReader(b), newDesc, content.WithLabels(labels)); err != nil {
+		return ocispec.Descriptor{}, fmt.Errorf("writing blob %s failed: %v", newDesc.Digest, err)
+	}
+	return newDesc, nil
+}
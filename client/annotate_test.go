@@ -0,0 +1,162 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/content/local"
+	"github.com/containerd/containerd/images"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// memoryLabelStore keeps the content labels in memory.
+type memoryLabelStore map[digest.Digest]map[string]string
+
+func (s memoryLabelStore) Get(d digest.Digest) (map[string]string, error) {
+	return s[d], nil
+}
+
+func (s memoryLabelStore) Set(d digest.Digest, labels map[string]string) error {
+	s[d] = labels
+	return nil
+}
+
+func (s memoryLabelStore) Update(d digest.Digest, update map[string]string) (map[string]string, error) {
+	labels := s[d]
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	for k, v := range update {
+		if v == "" {
+			delete(labels, k)
+			continue
+		}
+		labels[k] = v
+	}
+	s[d] = labels
+	return labels, nil
+}
+
+func writeJSONBlob(t *testing.T, cs content.Store, mediaType string, v interface{}) ocispec.Descriptor {
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	desc := ocispec.Descriptor{MediaType: mediaType, Digest: digest.FromBytes(b), Size: int64(len(b))}
+	if err := content.WriteBlob(context.Background(), cs, desc.Digest.String(), bytes.NewReader(b), desc); err != nil {
+		t.Fatal(err)
+	}
+	return desc
+}
+
+func readJSONBlob(t *testing.T, cs content.Store, desc ocispec.Descriptor, v interface{}) {
+	b, err := content.ReadBlob(context.Background(), cs, desc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAnnotateRoot(t *testing.T) {
+	tmpd, err := ioutil.TempDir("", "img-annotate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpd)
+
+	cs, err := local.NewLabeledStore(tmpd, memoryLabelStore{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manifest := func(arch string) ocispec.Descriptor {
+		config := writeJSONBlob(t, cs, ocispec.MediaTypeImageConfig, map[string]string{"os": "linux", "architecture": arch})
+		desc := writeJSONBlob(t, cs, ocispec.MediaTypeImageManifest, ocispec.Manifest{
+			Versioned: specs.Versioned{SchemaVersion: 2},
+			Config:    config,
+		})
+		desc.Platform = &ocispec.Platform{OS: "linux", Architecture: arch}
+		return desc
+	}
+	amd64, arm64 := manifest("amd64"), manifest("arm64")
+	root := writeJSONBlob(t, cs, ocispec.MediaTypeImageIndex, ocispec.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		Manifests: []ocispec.Descriptor{amd64, arm64},
+	})
+
+	ctx := context.Background()
+	created := map[string]string{ocispec.AnnotationCreated: "2019-01-01T00:00:00Z"}
+
+	// Annotate the index.
+	desc, err := annotateRoot(ctx, cs, root, AnnotateTargetIndex, created)
+	if err != nil {
+		t.Fatalf("annotating index failed: %v", err)
+	}
+	if desc.Digest == root.Digest {
+		t.Fatal("expected the index digest to change")
+	}
+	var index ocispec.Index
+	readJSONBlob(t, cs, desc, &index)
+	if index.Annotations[ocispec.AnnotationCreated] != "2019-01-01T00:00:00Z" {
+		t.Fatalf("expected index annotation to be set, got: %v", index.Annotations)
+	}
+
+	// Annotate the arm64 manifest.
+	desc, err = annotateRoot(ctx, cs, desc, "manifest:linux/arm64", map[string]string{"vendor": "img"})
+	if err != nil {
+		t.Fatalf("annotating manifest failed: %v", err)
+	}
+	index = ocispec.Index{}
+	readJSONBlob(t, cs, desc, &index)
+	if index.Annotations[ocispec.AnnotationCreated] != "2019-01-01T00:00:00Z" {
+		t.Fatalf("expected index annotation to be kept, got: %v", index.Annotations)
+	}
+	if index.Manifests[0].Digest != amd64.Digest {
+		t.Fatalf("expected amd64 manifest to be unchanged, got: %s", index.Manifests[0].Digest)
+	}
+	if index.Manifests[1].Digest == arm64.Digest {
+		t.Fatal("expected arm64 manifest digest to change")
+	}
+	var m ocispec.Manifest
+	readJSONBlob(t, cs, index.Manifests[1], &m)
+	if m.Annotations["vendor"] != "img" {
+		t.Fatalf("expected manifest annotation to be set, got: %v", m.Annotations)
+	}
+
+	// Make sure the new manifest is referenced for garbage collection.
+	info, err := cs.Info(ctx, desc.Digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, v := range info.Labels {
+		if v == index.Manifests[1].Digest.String() {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("expected index labels to reference the new manifest, got: %v", info.Labels)
+	}
+
+	// Invalid targets.
+	for _, target := range []string{"manifest", "manifest:linux/s390x", "config"} {
+		if _, err := annotateRoot(ctx, cs, desc, target, created); err == nil {
+			t.Fatalf("expected annotating target %s to fail but it did not", target)
+		}
+	}
+	if _, err := annotateRoot(ctx, cs, amd64, AnnotateTargetIndex, created); err == nil {
+		t.Fatalf("expected annotating the index of a single platform image to fail but it did not")
+	}
+	if _, err := annotateRoot(ctx, cs, ocispec.Descriptor{MediaType: images.MediaTypeDockerSchema2Manifest, Digest: amd64.Digest, Size: amd64.Size}, AnnotateTargetManifest, created); err != nil {
+		t.Fatalf("annotating single platform manifest failed: %v", err)
+	}
+}
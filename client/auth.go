@@ -0,0 +1,199 @@
+package client
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/docker/cli/cli/config"
+	"github.com/docker/cli/cli/config/configfile"
+	credhelper "github.com/docker/docker-credential-helpers/client"
+	"github.com/docker/docker-credential-helpers/credentials"
+	"github.com/docker/docker/registry"
+	"github.com/moby/buildkit/session/auth"
+	"google.golang.org/grpc"
+)
+
+const (
+	dockerHubHost     = "registry-1.docker.io"
+	dockerHubAuthHost = "https://index.docker.io/v1/"
+
+	credentialHelperPrefix = "docker-credential-"
+
+	// RegistryAuthEnv is the environment variable holding a comma separated
+	// list of HOST=base64(user:pass) registry credentials.
+	RegistryAuthEnv = "REGISTRY_AUTH"
+)
+
+// authProvider is a session attachable that answers registry credential
+// requests from the docker config file. Hosts configured with a credsStore
+// or credHelpers entry are resolved by shelling out to the matching
+// docker-credential-<helper> binary.
+type authProvider struct {
+	config *configfile.ConfigFile
+
+	// static holds the credentials passed in with --registry-auth or the
+	// REGISTRY_AUTH environment variable, these take precedence over the
+	// docker config file.
+	static map[string]*auth.CredentialsResponse
+
+	// cache holds the credentials already resolved for a host so each
+	// helper is only invoked once per session.
+	cache map[string]*auth.CredentialsResponse
+	mu    sync.Mutex
+}
+
+func newAuthProvider(static map[string]*auth.CredentialsResponse) *authProvider {
+	return &authProvider{
+		config: config.LoadDefaultConfigFile(ioutil.Discard),
+		static: static,
+		cache:  map[string]*auth.CredentialsResponse{},
+	}
+}
+
+// AddRegistryAuth adds registry credentials in the HOST=base64(user:pass)
+// format to the client. They are used instead of the docker config file for
+// the matching host.
+func (c *Client) AddRegistryAuth(values ...string) error {
+	if c.registryAuth == nil {
+		c.registryAuth = map[string]*auth.CredentialsResponse{}
+	}
+	for _, value := range values {
+		host, creds, err := parseRegistryAuth(value)
+		if err != nil {
+			return err
+		}
+		c.registryAuth[host] = creds
+	}
+	return nil
+}
+
+// registryAuths returns the credentials from the REGISTRY_AUTH
+// environment variable merged with the ones added to the client, the client
+// ones taking precedence.
+func (c *Client) registryAuths() (map[string]*auth.CredentialsResponse, error) {
+	auths := map[string]*auth.CredentialsResponse{}
+	for _, value := range strings.Split(os.Getenv(RegistryAuthEnv), ",") {
+		if value = strings.TrimSpace(value); value == "" {
+			continue
+		}
+		host, creds, err := parseRegistryAuth(value)
+		if err != nil {
+			return nil, fmt.Errorf("parsing %s failed: %v", RegistryAuthEnv, err)
+		}
+		auths[host] = creds
+	}
+	for host, creds := range c.registryAuth {
+		auths[host] = creds
+	}
+	return auths, nil
+}
+
+// parseRegistryAuth parses a HOST=base64(user:pass) registry auth value.
+func parseRegistryAuth(value string) (string, *auth.CredentialsResponse, error) {
+	kv := strings.SplitN(value, "=", 2)
+	if len(kv) != 2 {
+		return "", nil, fmt.Errorf("invalid registry auth value %q, expected HOST=base64(user:pass)", value)
+	}
+
+	host := normalizeRegistryHost(kv[0])
+	if host == "" {
+		return "", nil, fmt.Errorf("invalid registry auth value %q, host cannot be empty", value)
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(kv[1])
+	if err != nil {
+		return "", nil, fmt.Errorf("decoding registry auth for %s failed: %v", host, err)
+	}
+	userpass := strings.SplitN(string(decoded), ":", 2)
+	if len(userpass) != 2 || userpass[0] == "" {
+		return "", nil, fmt.Errorf("invalid registry auth for %s, expected base64 encoded user:pass", host)
+	}
+
+	return host, &auth.CredentialsResponse{Username: userpass[0], Secret: userpass[1]}, nil
+}
+
+// normalizeRegistryHost strips the scheme and path from a registry address
+// and maps the docker hub aliases to the key used in the docker config file.
+func normalizeRegistryHost(host string) string {
+	host = registry.ConvertToHostname(strings.TrimSpace(host))
+	switch host {
+	case "docker.io", "index.docker.io", dockerHubHost:
+		return dockerHubAuthHost
+	}
+	return host
+}
+
+// Register registers the auth provider with the session grpc server.
+func (ap *authProvider) Register(server *grpc.Server) {
+	auth.RegisterAuthServer(server, ap)
+}
+
+// Credentials returns the credentials for the requested host.
+func (ap *authProvider) Credentials(ctx context.Context, req *auth.CredentialsRequest) (*auth.CredentialsResponse, error) {
+	ap.mu.Lock()
+	defer ap.mu.Unlock()
+
+	host := req.Host
+	if host == dockerHubHost {
+		host = dockerHubAuthHost
+	}
+
+	if res, ok := ap.static[host]; ok {
+		return res, nil
+	}
+	if res, ok := ap.cache[host]; ok {
+		return res, nil
+	}
+
+	res, err := ap.credentials(host)
+	if err != nil {
+		return nil, err
+	}
+	ap.cache[host] = res
+
+	return res, nil
+}
+
+func (ap *authProvider) credentials(host string) (*auth.CredentialsResponse, error) {
+	helper := ap.credentialHelper(host)
+	if helper == "" {
+		// Fall back to the plaintext auths in the config file.
+		ac, err := ap.config.GetAuthConfig(host)
+		if err != nil {
+			return nil, err
+		}
+		if ac.IdentityToken != "" {
+			return &auth.CredentialsResponse{Secret: ac.IdentityToken}, nil
+		}
+		return &auth.CredentialsResponse{Username: ac.Username, Secret: ac.Password}, nil
+	}
+
+	creds, err := credhelper.Get(credhelper.NewShellProgramFunc(credentialHelperPrefix+helper), host)
+	if err != nil {
+		if credentials.IsErrCredentialsNotFound(err) {
+			// The helper does not know about this host, use anonymous access.
+			return &auth.CredentialsResponse{}, nil
+		}
+		return nil, fmt.Errorf("getting credentials for %s from %s%s failed: %v", host, credentialHelperPrefix, helper, err)
+	}
+
+	// Credential helpers signal an identity token with the "<token>" username.
+	if creds.Username == "<token>" {
+		return &auth.CredentialsResponse{Secret: creds.Secret}, nil
+	}
+	return &auth.CredentialsResponse{Username: creds.Username, Secret: creds.Secret}, nil
+}
+
+// credentialHelper returns the name of the credential helper configured for
+// the host, preferring a per-host credHelpers entry over the global credsStore.
+func (ap *authProvider) credentialHelper(host string) string {
+	if helper, ok := ap.config.CredentialHelpers[host]; ok {
+		return helper
+	}
+	return ap.config.CredentialsStore
+}
@@ -0,0 +1,107 @@
+package client
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/cli/cli/config/configfile"
+	"github.com/moby/buildkit/session/auth"
+)
+
+const fakeCredentialHelper = `#!/bin/sh
+read host
+echo "$host" >> "$0.calls"
+echo '{"ServerURL":"'"$host"'","Username":"fakeuser","Secret":"fakesecret"}'
+`
+
+func TestAuthProviderCredentialHelper(t *testing.T) {
+	tmpd, err := ioutil.TempDir("", "img-credential-helper")
+	if err != nil {
+		t.Fatalf("creating temporary directory failed: %v", err)
+	}
+	defer os.RemoveAll(tmpd)
+
+	helper := filepath.Join(tmpd, credentialHelperPrefix+"fake")
+	if err := ioutil.WriteFile(helper, []byte(fakeCredentialHelper), 0755); err != nil {
+		t.Fatalf("writing fake credential helper failed: %v", err)
+	}
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	os.Setenv("PATH", tmpd+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	ap := &authProvider{
+		config: &configfile.ConfigFile{
+			CredentialHelpers: map[string]string{"r.j3ss.co": "fake"},
+		},
+		cache: map[string]*auth.CredentialsResponse{},
+	}
+
+	for i := 0; i < 2; i++ {
+		res, err := ap.Credentials(context.Background(), &auth.CredentialsRequest{Host: "r.j3ss.co"})
+		if err != nil {
+			t.Fatalf("getting credentials failed: %v", err)
+		}
+		if res.Username != "fakeuser" || res.Secret != "fakesecret" {
+			t.Fatalf("expected fakeuser/fakesecret from credential helper, got: %s/%s", res.Username, res.Secret)
+		}
+	}
+
+	// Make sure the helper was only invoked once.
+	calls, err := ioutil.ReadFile(helper + ".calls")
+	if err != nil {
+		t.Fatalf("reading credential helper calls failed: %v", err)
+	}
+	if string(calls) != "r.j3ss.co\n" {
+		t.Fatalf("expected credential helper to be called once for r.j3ss.co, got: %q", string(calls))
+	}
+}
+
+func TestAuthProviderRegistryAuth(t *testing.T) {
+	defer os.Setenv(RegistryAuthEnv, os.Getenv(RegistryAuthEnv))
+	os.Setenv(RegistryAuthEnv, "r.j3ss.co=ZW52dXNlcjplbnZwYXNz,docker.io=aHVidXNlcjpodWJwYXNz")
+
+	c := &Client{}
+	if err := c.AddRegistryAuth("https://r.j3ss.co/v2/=ZmxhZ3VzZXI6ZmxhZ3Bhc3M="); err != nil {
+		t.Fatalf("adding registry auth failed: %v", err)
+	}
+
+	static, err := c.registryAuths()
+	if err != nil {
+		t.Fatalf("getting registry auth failed: %v", err)
+	}
+	ap := &authProvider{
+		config: &configfile.ConfigFile{},
+		static: static,
+		cache:  map[string]*auth.CredentialsResponse{},
+	}
+
+	testCases := map[string]string{
+		// The flag takes precedence over the environment variable.
+		"r.j3ss.co":            "flaguser:flagpass",
+		"registry-1.docker.io": "hubuser:hubpass",
+	}
+	for host, expected := range testCases {
+		res, err := ap.Credentials(context.Background(), &auth.CredentialsRequest{Host: host})
+		if err != nil {
+			t.Fatalf("getting credentials for %s failed: %v", host, err)
+		}
+		if got := res.Username + ":" + res.Secret; got != expected {
+			t.Fatalf("expected credentials %s for %s, got: %s", expected, host, got)
+		}
+	}
+}
+
+func TestParseRegistryAuthInvalid(t *testing.T) {
+	for _, value := range []string{
+		"r.j3ss.co",
+		"=dXNlcjpwYXNz",
+		"r.j3ss.co=notbase64!",
+		"r.j3ss.co=dXNlcg==",
+	} {
+		if _, _, err := parseRegistryAuth(value); err == nil {
+			t.Fatalf("expected parsing registry auth %q to fail but it did not", value)
+		}
+	}
+}
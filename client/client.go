@@ -1,13 +1,22 @@
 package client
 
 import (
+	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/containerd/containerd/identifiers"
 	"github.com/containerd/containerd/snapshots/overlay"
 	"github.com/mchirico/img/types"
 	"github.com/moby/buildkit/control"
 	"github.com/moby/buildkit/session"
+	"github.com/moby/buildkit/session/auth"
+	"github.com/moby/buildkit/worker/base"
 	"github.com/sirupsen/logrus"
 )
 
@@ -18,6 +27,33 @@ type Client struct {
 	localDirs map[string]string
 	root      string
 
+	registryAuth   map[string]*auth.CredentialsResponse
+	registryToken  map[string]string
+	tokens         *tokenCache
+	tokenCacheOnce sync.Once
+	gcPolicy       *GCPolicy
+	worker         *base.Worker
+	imageConfigs   *imageConfigs
+	cacheNamespace string
+	cgroupParent   string
+	shmSize        int64
+	runtime        string
+	hostMounts     []HostMount
+	mountLabel     string
+	hooks          SolveHooks
+
+	cacheMountNamespace string
+	exportOutput        io.WriteCloser
+	exportDir           string
+	localExcludes       map[string][]string
+	localModes          map[string]FileModes
+	pullTimeout         time.Duration
+	ociLayouts          map[string]string
+
+	transfers     map[string]*transferCounter
+	registryBytes *registryCounter
+	lock          *os.File
+
 	sessionManager *session.Manager
 	controller     *control.Controller
 }
@@ -37,21 +73,121 @@ func New(root, backend string, localDirs map[string]string) (*Client, error) {
 		logrus.Debugf("using backend: %s", backend)
 	}
 
+	// Lock the state directory for the lifetime of the client.
+	lock, err := lockStateDir(root, LockTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	// Load the gc policy from the state directory.
+	gcPolicy, err := LoadGCPolicy(root)
+	if err != nil {
+		lock.Close()
+		return nil, err
+	}
+
 	// Create the root/
 	root = filepath.Join(root, name, backend)
 	if err := os.MkdirAll(root, 0700); err != nil {
+		lock.Close()
 		return nil, err
 	}
 
 	// Create the start of the client.
 	return &Client{
-		backend:   backend,
-		root:      root,
-		localDirs: localDirs,
+		backend:       backend,
+		root:          root,
+		localDirs:     localDirs,
+		gcPolicy:      gcPolicy,
+		registryBytes: &registryCounter{},
+		lock:          lock,
 	}, nil
 }
 
-// Close safely closes the client.
-// This used to shut down the FUSE server but since that was removed
-// it is basically a no-op now.
-func (c *Client) Close() {}
+// SetCacheNamespace scopes the build cache of the client to the given
+// namespace so builds in different namespaces do not share cached vertices.
+// An empty namespace uses the shared default cache.
+func (c *Client) SetCacheNamespace(ns string) error {
+	if ns != "" {
+		if err := identifiers.Validate(ns); err != nil {
+			return fmt.Errorf("invalid cache namespace: %v", err)
+		}
+	}
+	c.cacheNamespace = ns
+	return nil
+}
+
+// SetCgroupParent sets the cgroup the RUN containers of builds are created
+// under. It is either a cgroupfs path such as "/ci/img" or a systemd slice in
+// the "<slice>.slice:<prefix>:" format.
+func (c *Client) SetCgroupParent(parent string) error {
+	if parent != "" {
+		if err := validateCgroupParent(parent); err != nil {
+			return err
+		}
+	}
+	c.cgroupParent = parent
+	return nil
+}
+
+// SetPullTimeout bounds resolving and fetching each image pulled by builds,
+// leaving the other steps unbounded. Zero means no limit.
+func (c *Client) SetPullTimeout(timeout time.Duration) error {
+	if timeout < 0 {
+		return fmt.Errorf("invalid pull timeout %s, it must be positive", timeout)
+	}
+	c.pullTimeout = timeout
+	return nil
+}
+
+var cgroupPathRegexp = regexp.MustCompile(`^/?[a-zA-Z0-9_.-]+(/[a-zA-Z0-9_.-]+)*/?$`)
+
+func validateCgroupParent(parent string) error {
+	if strings.HasSuffix(parent, ":") {
+		parts := strings.Split(parent, ":")
+		if len(parts) != 3 || !strings.HasSuffix(parts[0], ".slice") || strings.ContainsAny(parts[0], "/") || parts[1] == "" {
+			return fmt.Errorf("invalid cgroup parent %s, expected a systemd slice in the '<slice>.slice:<prefix>:' format", parent)
+		}
+		return nil
+	}
+
+	if !cgroupPathRegexp.MatchString(parent) {
+		return fmt.Errorf("invalid cgroup parent %s, expected a path such as /ci/img", parent)
+	}
+	for _, elem := range strings.Split(parent, "/") {
+		if elem == "." || elem == ".." {
+			return fmt.Errorf("invalid cgroup parent %s, it must not contain . or .. elements", parent)
+		}
+	}
+	return nil
+}
+
+// SetCacheMountNamespace scopes the RUN --mount=type=cache mounts of builds
+// to the given namespace so projects using the same cache mount ids do not
+// share their contents. An empty namespace uses the shared cache mounts.
+func (c *Client) SetCacheMountNamespace(ns string) error {
+	if ns != "" {
+		if err := identifiers.Validate(ns); err != nil {
+			return fmt.Errorf("invalid cache mount namespace: %v", err)
+		}
+	}
+	c.cacheMountNamespace = ns
+	return nil
+}
+
+// cacheDBPath returns the path to the cache key storage for the cache namespace.
+func (c *Client) cacheDBPath() string {
+	if c.cacheNamespace == "" {
+		return filepath.Join(c.root, "cache.db")
+	}
+	return filepath.Join(c.root, "cache-"+c.cacheNamespace+".db")
+}
+
+// Close safely closes the client, releasing the lock on the state directory.
+// This used to shut down the FUSE server.
+func (c *Client) Close() {
+	if c.lock != nil {
+		c.lock.Close()
+		c.lock = nil
+	}
+}
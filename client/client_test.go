@@ -0,0 +1,30 @@
+package client
+
+import (
+	"testing"
+
+	executoroci "github.com/moby/buildkit/executor/oci"
+)
+
+func TestSetCgroupParent(t *testing.T) {
+	valid := []string{"/ci/img", "ci", "ci/img/", "system.slice:img:"}
+	for _, parent := range valid {
+		c := &Client{root: "/tmp/img"}
+		if err := c.SetCgroupParent(parent); err != nil {
+			t.Fatalf("setting cgroup parent %q failed: %v", parent, err)
+		}
+
+		opt := c.executorOpt(false, executoroci.ProcessSandbox)
+		if opt.DefaultCgroupParent != parent {
+			t.Fatalf("expected executor cgroup parent %q, got: %q", parent, opt.DefaultCgroupParent)
+		}
+	}
+
+	invalid := []string{"/ci/../img", "/ci//img", "ci img", "system.slice::", "system:img:", "a/b.slice:img:"}
+	for _, parent := range invalid {
+		c := &Client{root: "/tmp/img"}
+		if err := c.SetCgroupParent(parent); err == nil {
+			t.Fatalf("expected setting cgroup parent %q to fail but it did not", parent)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/moby/buildkit/exporter"
+	"github.com/moby/buildkit/exporter/containerimage/exptypes"
+)
+
+// ExporterConfigOverrides is the key of the exporter attr holding the JSON
+// of the ConfigOverrides applied to the image config of every platform
+// before it is exported.
+const ExporterConfigOverrides = "img.config.overrides"
+
+// ConfigOverrides are the fields of the image config to replace the values
+// from the Dockerfile with. A nil Entrypoint or Cmd and an empty WorkingDir or
+// User keep the value from the Dockerfile, the Env entries replace the
+// variables of the same name and the others are added. A non-nil Created
+// replaces the created time of the image and of the history entries later
+// than it, the exporter keeps the times already set.
+type ConfigOverrides struct {
+	Entrypoint []string
+	Cmd        []string
+	WorkingDir string
+	Env        []string
+	User       string
+	Created    *time.Time
+}
+
+// IsEmpty returns if the overrides do not change anything.
+func (o ConfigOverrides) IsEmpty() bool {
+	return o.Entrypoint == nil && o.Cmd == nil && o.WorkingDir == "" && len(o.Env) == 0 && o.User == "" && o.Created == nil
+}
+
+// configOverridesExporter applies the config overrides in the exporter attrs
+// to the image configs of the result before exporting it.
+type configOverridesExporter struct {
+	exporter.Exporter
+}
+
+func (e *configOverridesExporter) Resolve(ctx context.Context, opt map[string]string) (exporter.ExporterInstance, error) {
+	v, ok := opt[ExporterConfigOverrides]
+	if !ok {
+		return e.Exporter.Resolve(ctx, opt)
+	}
+
+	var overrides ConfigOverrides
+	if err := json.Unmarshal([]byte(v), &overrides); err != nil {
+		return nil, fmt.Errorf("parsing config overrides failed: %v", err)
+	}
+	// Do not pass the overrides on as metadata of the image.
+	attrs := make(map[string]string, len(opt))
+	for k, v := range opt {
+		if k != ExporterConfigOverrides {
+			attrs[k] = v
+		}
+	}
+
+	inst, err := e.Exporter.Resolve(ctx, attrs)
+	if err != nil {
+		return nil, err
+	}
+	return &configOverridesInstance{ExporterInstance: inst, overrides: overrides}, nil
+}
+
+type configOverridesInstance struct {
+	exporter.ExporterInstance
+
+	overrides ConfigOverrides
+}
+
+func (e *configOverridesInstance) Export(ctx context.Context, src exporter.Source) (map[string]string, error) {
+	metadata := make(map[string][]byte, len(src.Metadata))
+	for k, v := range src.Metadata {
+		// Multi-platform results have a config per platform.
+		if k == exptypes.ExporterImageConfigKey || strings.HasPrefix(k, exptypes.ExporterImageConfigKey+"/") {
+			config, err := applyConfigOverrides(v, e.overrides)
+			if err != nil {
+				return nil, fmt.Errorf("applying config overrides to %s failed: %v", k, err)
+			}
+			v = config
+		}
+		metadata[k] = v
+	}
+	src.Metadata = metadata
+	return e.ExporterInstance.Export(ctx, src)
+}
+
+// applyConfigOverrides returns the image config with the overrides applied.
+// The fields of the config that are not overridden are kept as is.
+func applyConfigOverrides(b []byte, o ConfigOverrides) ([]byte, error) {
+	var image map[string]json.RawMessage
+	if err := json.Unmarshal(b, &image); err != nil {
+		return nil, err
+	}
+	config := map[string]json.RawMessage{}
+	if c, ok := image["config"]; ok && string(c) != "null" {
+		if err := json.Unmarshal(c, &config); err != nil {
+			return nil, err
+		}
+	}
+
+	set := func(key string, v interface{}) error {
+		b, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		config[key] = b
+		return nil
+	}
+	if o.Entrypoint != nil {
+		if err := set("Entrypoint", o.Entrypoint); err != nil {
+			return nil, err
+		}
+	}
+	if o.Cmd != nil {
+		if err := set("Cmd", o.Cmd); err != nil {
+			return nil, err
+		}
+	}
+	if o.WorkingDir != "" {
+		if err := set("WorkingDir", o.WorkingDir); err != nil {
+			return nil, err
+		}
+	}
+	if o.User != "" {
+		if err := set("User", o.User); err != nil {
+			return nil, err
+		}
+	}
+	if len(o.Env) > 0 {
+		var env []string
+		if e, ok := config["Env"]; ok {
+			if err := json.Unmarshal(e, &env); err != nil {
+				return nil, err
+			}
+		}
+		if err := set("Env", mergeEnv(env, o.Env)); err != nil {
+			return nil, err
+		}
+	}
+
+	c, err := json.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+	image["config"] = c
+	if o.Created != nil {
+		if err := setCreated(image, o.Created.UTC()); err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(image)
+}
+
+// setCreated sets the created time of the image and clamps the created times
+// of its history entries to it. The entries without a time get it as well,
+// which the exporter would otherwise fill in with the time of the build.
+func setCreated(image map[string]json.RawMessage, created time.Time) error {
+	b, err := json.Marshal(created)
+	if err != nil {
+		return err
+	}
+	image["created"] = b
+
+	h, ok := image["history"]
+	if !ok || string(h) == "null" {
+		return nil
+	}
+	var history []map[string]json.RawMessage
+	if err := json.Unmarshal(h, &history); err != nil {
+		return err
+	}
+	for _, entry := range history {
+		var t *time.Time
+		if v, ok := entry["created"]; ok {
+			if err := json.Unmarshal(v, &t); err != nil {
+				return err
+			}
+		}
+		if t == nil || t.After(created) {
+			entry["created"] = b
+		}
+	}
+	if image["history"], err = json.Marshal(history); err != nil {
+		return err
+	}
+	return nil
+}
+
+// mergeEnv replaces the variables of env with the overrides of the same name
+// and appends the others.
+func mergeEnv(env, overrides []string) []string {
+	merged := append([]string{}, env...)
+	for _, o := range overrides {
+		name := strings.SplitN(o, "=", 2)[0]
+		replaced := false
+		for i, e := range merged {
+			if strings.SplitN(e, "=", 2)[0] == name {
+				merged[i] = o
+				replaced = true
+			}
+		}
+		if !replaced {
+			merged = append(merged, o)
+		}
+	}
+	return merged
+}
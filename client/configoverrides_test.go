@@ -0,0 +1,145 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/moby/buildkit/exporter"
+	"github.com/moby/buildkit/exporter/containerimage/exptypes"
+)
+
+// recordingExporter records the attrs it was resolved with and the source it
+// exported.
+type recordingExporter struct {
+	attrs map[string]string
+	src   exporter.Source
+}
+
+func (e *recordingExporter) Resolve(ctx context.Context, opt map[string]string) (exporter.ExporterInstance, error) {
+	e.attrs = opt
+	return e, nil
+}
+
+func (e *recordingExporter) Name() string { return "recording" }
+
+func (e *recordingExporter) Export(ctx context.Context, src exporter.Source) (map[string]string, error) {
+	e.src = src
+	return nil, nil
+}
+
+type overriddenConfig struct {
+	Architecture string `json:"architecture"`
+	Config       struct {
+		Entrypoint []string
+		Cmd        []string
+		WorkingDir string
+		Env        []string
+		User       string
+		Labels     map[string]string
+	} `json:"config"`
+}
+
+func TestConfigOverridesExporter(t *testing.T) {
+	overrides := ConfigOverrides{
+		Entrypoint: []string{"/bin/app"},
+		Cmd:        []string{"--serve"},
+		WorkingDir: "/srv",
+		Env:        []string{"MODE=prod", "NEW=1"},
+		User:       "nobody",
+	}
+	b, err := json.Marshal(overrides)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := &recordingExporter{}
+	e := &configOverridesExporter{Exporter: rec}
+	inst, err := e.Resolve(context.Background(), map[string]string{"name": "a", ExporterConfigOverrides: string(b)})
+	if err != nil {
+		t.Fatalf("resolving exporter failed: %v", err)
+	}
+	if _, ok := rec.attrs[ExporterConfigOverrides]; ok || rec.attrs["name"] != "a" {
+		t.Fatalf("expected the overrides to be removed from the attrs, got: %v", rec.attrs)
+	}
+
+	config := func(arch string) []byte {
+		return []byte(`{"architecture":"` + arch + `","config":{"Entrypoint":["/bin/sh"],"Cmd":["-c","true"],"WorkingDir":"/","Env":["PATH=/bin","MODE=dev"],"User":"root","Labels":{"a":"b"}}}`)
+	}
+	if _, err := inst.Export(context.Background(), exporter.Source{Metadata: map[string][]byte{
+		exptypes.ExporterImageConfigKey + "/linux/amd64": config("amd64"),
+		exptypes.ExporterImageConfigKey + "/linux/arm64": config("arm64"),
+		"other": []byte("kept"),
+	}}); err != nil {
+		t.Fatalf("exporting failed: %v", err)
+	}
+
+	// Every platform has the overrides, the rest of the config is kept.
+	for _, arch := range []string{"amd64", "arm64"} {
+		var got overriddenConfig
+		if err := json.Unmarshal(rec.src.Metadata[exptypes.ExporterImageConfigKey+"/linux/"+arch], &got); err != nil {
+			t.Fatalf("parsing the exported config failed: %v", err)
+		}
+		c := got.Config
+		if got.Architecture != arch ||
+			!reflect.DeepEqual(c.Entrypoint, overrides.Entrypoint) ||
+			!reflect.DeepEqual(c.Cmd, overrides.Cmd) ||
+			c.WorkingDir != "/srv" ||
+			!reflect.DeepEqual(c.Env, []string{"PATH=/bin", "MODE=prod", "NEW=1"}) ||
+			c.User != "nobody" ||
+			c.Labels["a"] != "b" {
+			t.Fatalf("expected the overrides in the %s config, got: %+v", arch, got)
+		}
+	}
+	if string(rec.src.Metadata["other"]) != "kept" {
+		t.Fatalf("expected the other metadata to be kept, got: %v", rec.src.Metadata)
+	}
+}
+
+func TestApplyConfigOverridesKeepsUnset(t *testing.T) {
+	in := []byte(`{"config":{"Entrypoint":["/bin/sh"],"WorkingDir":"/app"}}`)
+	out, err := applyConfigOverrides(in, ConfigOverrides{Cmd: []string{}})
+	if err != nil {
+		t.Fatalf("applying config overrides failed: %v", err)
+	}
+	var got overriddenConfig
+	if err := json.Unmarshal(out, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got.Config.Entrypoint, []string{"/bin/sh"}) || got.Config.WorkingDir != "/app" || got.Config.Cmd == nil || len(got.Config.Cmd) != 0 {
+		t.Fatalf("expected only the cmd to be cleared, got: %+v", got.Config)
+	}
+}
+
+func TestApplyConfigOverridesCreated(t *testing.T) {
+	in := []byte(`{"created":"2021-05-01T10:00:00Z","history":[` +
+		`{"created":"2019-01-01T00:00:00Z","created_by":"base"},` +
+		`{"created":"2021-05-01T10:00:00Z","created_by":"COPY . /src"},` +
+		`{"created_by":"RUN make"}]}`)
+	created := time.Unix(1577836800, 0)
+	out, err := applyConfigOverrides(in, ConfigOverrides{Created: &created})
+	if err != nil {
+		t.Fatalf("applying config overrides failed: %v", err)
+	}
+	var got struct {
+		Created time.Time `json:"created"`
+		History []struct {
+			Created   *time.Time `json:"created"`
+			CreatedBy string     `json:"created_by"`
+		} `json:"history"`
+	}
+	if err := json.Unmarshal(out, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !got.Created.Equal(created) {
+		t.Fatalf("expected the created time %s, got: %s", created, got.Created)
+	}
+	// The entries older than the epoch are kept, the others are clamped.
+	for i, expected := range []time.Time{time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC), created, created} {
+		if h := got.History[i]; h.Created == nil || !h.Created.Equal(expected) {
+			t.Fatalf("expected the history entry %s created at %s, got: %v", h.CreatedBy, expected, h.Created)
+		}
+	}
+}
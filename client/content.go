@@ -0,0 +1,130 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/images"
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// ContentInfo represents a blob in the content store returned from
+// ListContent. The media type is only known for blobs referenced by an image.
+type ContentInfo struct {
+	content.Info
+	MediaType  string
+	Referenced bool
+}
+
+// ListContent returns the blobs in the content store.
+func (c *Client) ListContent(ctx context.Context, filters ...string) ([]ContentInfo, error) {
+	// Create the worker opts.
+	opt, err := c.createWorkerOpt(false)
+	if err != nil {
+		return nil, fmt.Errorf("creating worker opt failed: %v", err)
+	}
+
+	refs, err := referencedContent(ctx, opt.ImageStore, opt.ContentStore)
+	if err != nil {
+		return nil, err
+	}
+
+	infos := []ContentInfo{}
+	if err := opt.ContentStore.Walk(ctx, func(info content.Info) error {
+		mediaType, referenced := refs[info.Digest]
+		infos = append(infos, ContentInfo{Info: info, MediaType: mediaType, Referenced: referenced})
+		return nil
+	}, filters...); err != nil {
+		return nil, fmt.Errorf("walking content store failed: %v", err)
+	}
+
+	return infos, nil
+}
+
+// GetContent writes the blob for the digest from the content store to the writer.
+func (c *Client) GetContent(ctx context.Context, dgst digest.Digest, writer io.Writer) error {
+	// Create the worker opts.
+	opt, err := c.createWorkerOpt(false)
+	if err != nil {
+		return fmt.Errorf("creating worker opt failed: %v", err)
+	}
+
+	info, err := opt.ContentStore.Info(ctx, dgst)
+	if err != nil {
+		return fmt.Errorf("getting content %s failed: %v", dgst, err)
+	}
+
+	ra, err := opt.ContentStore.ReaderAt(ctx, ocispec.Descriptor{Digest: info.Digest, Size: info.Size})
+	if err != nil {
+		return fmt.Errorf("getting reader for content %s failed: %v", dgst, err)
+	}
+	defer ra.Close()
+
+	if _, err := io.Copy(writer, content.NewReader(ra)); err != nil {
+		return fmt.Errorf("reading content %s failed: %v", dgst, err)
+	}
+
+	return nil
+}
+
+// RemoveContent removes the blob for the digest from the content store.
+// Blobs referenced by an image are only removed if force is true.
+func (c *Client) RemoveContent(ctx context.Context, dgst digest.Digest, force bool) error {
+	// Create the worker opts.
+	opt, err := c.createWorkerOpt(false)
+	if err != nil {
+		return fmt.Errorf("creating worker opt failed: %v", err)
+	}
+
+	if !force {
+		refs, err := referencedContent(ctx, opt.ImageStore, opt.ContentStore)
+		if err != nil {
+			return err
+		}
+		if _, ok := refs[dgst]; ok {
+			return fmt.Errorf("content %s is referenced by an image, use --force to remove it anyway", dgst)
+		}
+	}
+
+	if err := opt.ContentStore.Delete(ctx, dgst); err != nil {
+		return fmt.Errorf("removing content %s failed: %v", dgst, err)
+	}
+
+	return nil
+}
+
+// referencedContent walks all the images in the image store and returns the
+// media types of the blobs they reference keyed by digest.
+func referencedContent(ctx context.Context, imageStore images.Store, provider content.Provider) (map[digest.Digest]string, error) {
+	if imageStore == nil {
+		return nil, errors.New("image store is nil")
+	}
+
+	imgs, err := imageStore.List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("listing images failed: %v", err)
+	}
+
+	refs := map[digest.Digest]string{}
+	handler := images.HandlerFunc(func(ctx context.Context, desc ocispec.Descriptor) ([]ocispec.Descriptor, error) {
+		refs[desc.Digest] = desc.MediaType
+		children, err := images.Children(ctx, provider, desc)
+		if err != nil && errdefs.IsNotFound(err) {
+			// Manifests for platforms that were never pulled are not in the store.
+			return nil, nil
+		}
+		return children, err
+	})
+	for _, img := range imgs {
+		if err := images.Walk(ctx, handler, img.Target); err != nil {
+			return nil, fmt.Errorf("walking image %s failed: %v", img.Name, err)
+		}
+	}
+
+	return refs, nil
+}
@@ -1,9 +1,10 @@
 package client
 
 import (
+	"context"
 	"fmt"
-	"path/filepath"
 
+	"github.com/moby/buildkit/cache/remotecache"
 	"github.com/moby/buildkit/control"
 	"github.com/moby/buildkit/frontend"
 	"github.com/moby/buildkit/frontend/dockerfile/builder"
@@ -25,6 +26,11 @@ func (c *Client) createController() error {
 		return fmt.Errorf("creating worker opt failed: %v", err)
 	}
 
+	// Load the images of the OCI layouts, so they are not pulled.
+	if err := importOCILayouts(context.TODO(), opt.ContentStore, c.ociLayouts); err != nil {
+		return err
+	}
+
 	// Create the new worker.
 	w, err := base.NewWorker(opt)
 	if err != nil {
@@ -33,17 +39,18 @@ func (c *Client) createController() error {
 
 	// Create the worker controller.
 	wc := &worker.Controller{}
-	if err := wc.Add(w); err != nil {
+	c.imageConfigs = &imageConfigs{}
+	if err := wc.Add(&imgWorker{Worker: w, opt: opt, root: c.root, cacheMountNamespace: c.cacheMountNamespace, pullTimeout: c.pullTimeout, configs: c.imageConfigs}); err != nil {
 		return fmt.Errorf("adding worker to worker controller failed: %v", err)
 	}
 
 	// Add the frontends.
 	frontends := map[string]frontend.Frontend{}
-	frontends["dockerfile.v0"] = forwarder.NewGatewayForwarder(wc, builder.Build)
+	frontends["dockerfile.v0"] = forwarder.NewGatewayForwarder(wc, targetsBuild(platformArgsBuild(builder.Build)))
 	frontends["gateway.v0"] = gateway.NewGatewayFrontend(wc)
 
 	// Create the cache storage
-	cacheStorage, err := bboltcachestorage.NewStore(filepath.Join(c.root, "cache.db"))
+	cacheStorage, err := bboltcachestorage.NewStore(c.cacheDBPath())
 	if err != nil {
 		return err
 	}
@@ -54,7 +61,13 @@ func (c *Client) createController() error {
 		WorkerController: wc,
 		Frontends:        frontends,
 		CacheKeyStorage:  cacheStorage,
-		// No cache importer/exporter
+		ResolveCacheExporterFuncs: map[string]remotecache.ResolveCacheExporterFunc{
+			CacheExporterInline: resolveInlineCacheExporter,
+			CacheRegistry:       resolveRegistryCacheExporter(sm, opt.ResolveOptionsFunc),
+		},
+		ResolveCacheImporterFuncs: map[string]remotecache.ResolveCacheImporterFunc{
+			CacheRegistry: resolveRegistryCacheImporter(sm, opt.ResolveOptionsFunc),
+		},
 	})
 	if err != nil {
 		return fmt.Errorf("creating new controller failed: %v", err)
@@ -62,6 +75,7 @@ func (c *Client) createController() error {
 
 	// Set the controller for the client.
 	c.controller = controller
+	c.worker = w
 
 	return nil
 }
@@ -0,0 +1,169 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"time"
+
+	controlapi "github.com/moby/buildkit/api/services/control"
+	digest "github.com/opencontainers/go-digest"
+)
+
+// Event is an event of a build sent by SolveWithEvents, one of
+// VertexStarted, VertexCompleted, LogLine, Warning or Done.
+type Event interface {
+	isEvent()
+}
+
+// VertexStarted is sent when a step of the build starts.
+type VertexStarted struct {
+	Digest  digest.Digest
+	Name    string
+	Started time.Time
+}
+
+// VertexCompleted is sent when a step of the build completes, Error is set if
+// it failed.
+type VertexCompleted struct {
+	Digest    digest.Digest
+	Name      string
+	Completed time.Time
+	Cached    bool
+	Error     string
+}
+
+// LogLine is a line a step of the build wrote to STDOUT (stream 1) or STDERR
+// (stream 2), without the newline.
+type LogLine struct {
+	Vertex    digest.Digest
+	Stream    int
+	Line      string
+	Timestamp time.Time
+}
+
+// Warning is a line a step of the build wrote to STDERR starting with
+// "WARNING" or "warning:". The status of the vendored BuildKit does not carry
+// warnings of its own, so these are also sent as a LogLine.
+type Warning struct {
+	Vertex  digest.Digest
+	Message string
+}
+
+// Done is the last event of a build, with the response of the exporter or the
+// error of the build.
+type Done struct {
+	ExporterResponse map[string]string
+	Err              error
+}
+
+func (VertexStarted) isEvent()   {}
+func (VertexCompleted) isEvent() {}
+func (LogLine) isEvent()         {}
+func (Warning) isEvent()         {}
+func (Done) isEvent()            {}
+
+// SolveWithEvents calls Solve and sends the events of the build on the channel
+// instead of the raw status responses, so callers can render their own
+// progress. The channel is closed after the Done event.
+func (c *Client) SolveWithEvents(ctx context.Context, req *controlapi.SolveRequest, events chan<- Event) (map[string]string, error) {
+	ch := make(chan *controlapi.StatusResponse)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		translateStatus(ch, events)
+	}()
+
+	resp, err := c.Solve(ctx, req, ch)
+	<-done
+	events <- Done{ExporterResponse: resp, Err: err}
+	close(events)
+	return resp, err
+}
+
+// translateStatus sends the events for the status responses until the status
+// channel is closed. Vertices are reported again by each response until they
+// complete so this only sends the changes.
+func translateStatus(ch <-chan *controlapi.StatusResponse, events chan<- Event) {
+	started := map[digest.Digest]bool{}
+	completed := map[digest.Digest]bool{}
+	logs := newLogLines(events)
+
+	for resp := range ch {
+		for _, v := range resp.Vertexes {
+			if v.Started != nil && !started[v.Digest] {
+				started[v.Digest] = true
+				events <- VertexStarted{Digest: v.Digest, Name: v.Name, Started: *v.Started}
+			}
+			if v.Completed != nil && !completed[v.Digest] {
+				completed[v.Digest] = true
+				events <- VertexCompleted{Digest: v.Digest, Name: v.Name, Completed: *v.Completed, Cached: v.Cached, Error: v.Error}
+			}
+		}
+		for _, l := range resp.Logs {
+			logs.write(l)
+		}
+	}
+	logs.flush()
+}
+
+// logLines splits the logs of the vertices into lines, keeping the partial
+// last line of each vertex and stream until the rest of it is written.
+type logLines struct {
+	events  chan<- Event
+	partial map[logStream]*bytes.Buffer
+	order   []logStream
+	last    map[logStream]time.Time
+}
+
+type logStream struct {
+	vertex digest.Digest
+	stream int
+}
+
+func newLogLines(events chan<- Event) *logLines {
+	return &logLines{
+		events:  events,
+		partial: map[logStream]*bytes.Buffer{},
+		last:    map[logStream]time.Time{},
+	}
+}
+
+func (l *logLines) write(log *controlapi.VertexLog) {
+	key := logStream{vertex: log.Vertex, stream: int(log.Stream)}
+	buf, ok := l.partial[key]
+	if !ok {
+		buf = &bytes.Buffer{}
+		l.partial[key] = buf
+		l.order = append(l.order, key)
+	}
+	buf.Write(log.Msg)
+	l.last[key] = log.Timestamp
+
+	for {
+		i := bytes.IndexByte(buf.Bytes(), '\n')
+		if i < 0 {
+			return
+		}
+		line := string(buf.Next(i + 1))
+		l.send(key, strings.TrimSuffix(line, "\n"), log.Timestamp)
+	}
+}
+
+// flush sends the partial lines that were never terminated.
+func (l *logLines) flush() {
+	for _, key := range l.order {
+		if buf := l.partial[key]; buf.Len() > 0 {
+			l.send(key, buf.String(), l.last[key])
+			buf.Reset()
+		}
+	}
+}
+
+func (l *logLines) send(key logStream, line string, ts time.Time) {
+	line = strings.TrimSuffix(line, "\r")
+	l.events <- LogLine{Vertex: key.vertex, Stream: key.stream, Line: line, Timestamp: ts}
+	if key.stream == 2 && (strings.HasPrefix(line, "WARNING") || strings.HasPrefix(line, "warning:")) {
+		l.events <- Warning{Vertex: key.vertex, Message: line}
+	}
+}
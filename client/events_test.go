@@ -0,0 +1,61 @@
+package client
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	controlapi "github.com/moby/buildkit/api/services/control"
+	digest "github.com/opencontainers/go-digest"
+)
+
+func TestTranslateStatus(t *testing.T) {
+	now := time.Now()
+	from, run := digest.FromString("from"), digest.FromString("run")
+
+	// Simulate a build of two steps, the RUN step writes a line split over
+	// two logs, a warning and a line that is never terminated.
+	ch := make(chan *controlapi.StatusResponse)
+	go func() {
+		defer close(ch)
+		ch <- &controlapi.StatusResponse{Vertexes: []*controlapi.Vertex{
+			{Digest: from, Name: "FROM busybox", Started: &now},
+		}}
+		ch <- &controlapi.StatusResponse{Vertexes: []*controlapi.Vertex{
+			{Digest: from, Name: "FROM busybox", Started: &now, Completed: &now, Cached: true},
+			{Digest: run, Name: "RUN make", Started: &now},
+		}}
+		ch <- &controlapi.StatusResponse{Logs: []*controlapi.VertexLog{
+			{Vertex: run, Stream: 1, Msg: []byte("buil"), Timestamp: now},
+			{Vertex: run, Stream: 1, Msg: []byte("ding\n"), Timestamp: now},
+			{Vertex: run, Stream: 2, Msg: []byte("WARNING: deprecated\nno newline"), Timestamp: now},
+		}}
+		ch <- &controlapi.StatusResponse{Vertexes: []*controlapi.Vertex{
+			{Digest: run, Name: "RUN make", Started: &now, Completed: &now, Error: "exit code: 2"},
+		}}
+	}()
+
+	events := make(chan Event)
+	go func() {
+		defer close(events)
+		translateStatus(ch, events)
+	}()
+	var got []Event
+	for e := range events {
+		got = append(got, e)
+	}
+
+	expected := []Event{
+		VertexStarted{Digest: from, Name: "FROM busybox", Started: now},
+		VertexCompleted{Digest: from, Name: "FROM busybox", Completed: now, Cached: true},
+		VertexStarted{Digest: run, Name: "RUN make", Started: now},
+		LogLine{Vertex: run, Stream: 1, Line: "building", Timestamp: now},
+		LogLine{Vertex: run, Stream: 2, Line: "WARNING: deprecated", Timestamp: now},
+		Warning{Vertex: run, Message: "WARNING: deprecated"},
+		VertexCompleted{Digest: run, Name: "RUN make", Completed: now, Error: "exit code: 2"},
+		LogLine{Vertex: run, Stream: 2, Line: "no newline", Timestamp: now},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected events:\n%#v\ngot:\n%#v", expected, got)
+	}
+}
@@ -0,0 +1,293 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/containerd/containerd/mount"
+	"github.com/docker/docker/pkg/idtools"
+	"github.com/moby/buildkit/cache"
+	"github.com/moby/buildkit/executor"
+	"github.com/moby/buildkit/snapshot"
+	selinux "github.com/opencontainers/selinux/go-selinux"
+)
+
+// SetShmSize sets the size in bytes of the /dev/shm tmpfs of the RUN
+// containers of builds. Zero keeps the default size of the executor.
+func (c *Client) SetShmSize(size int64) error {
+	if size < 0 {
+		return fmt.Errorf("invalid shm size %d, it must be positive", size)
+	}
+	c.shmSize = size
+	return nil
+}
+
+// SetRuntime sets the path of the OCI runtime the executor runs the RUN
+// containers with, runc or one with the same command line like crun, instead
+// of looking up runc in PATH.
+func (c *Client) SetRuntime(path string) {
+	c.runtime = path
+}
+
+// HostMount is a directory or file of the host bind mounted into every RUN
+// container of builds.
+type HostMount struct {
+	Source   string
+	Target   string
+	Readonly bool
+}
+
+// SetHostMounts sets the host paths to bind mount into the RUN containers of
+// builds. The sources and targets must be absolute and the sources must
+// exist.
+func (c *Client) SetHostMounts(mounts []HostMount) error {
+	for _, m := range mounts {
+		if !filepath.IsAbs(m.Source) || !filepath.IsAbs(m.Target) {
+			return fmt.Errorf("invalid host mount %s:%s, the source and target must be absolute", m.Source, m.Target)
+		}
+		if _, err := os.Stat(m.Source); err != nil {
+			return fmt.Errorf("invalid host mount source: %v", err)
+		}
+	}
+	c.hostMounts = mounts
+	return nil
+}
+
+// SELinuxEnforcing returns if SELinux is enabled in enforcing mode on the
+// host. It is always false if img is built without the selinux build tag.
+func SELinuxEnforcing() bool {
+	return selinux.GetEnabled() && selinux.EnforceMode() == selinux.Enforcing
+}
+
+// SetMountLabel sets the SELinux context of the mounts of the RUN containers
+// of builds, in the user:role:type:level format. The mounts other than bind
+// mounts get it as their context= option and the sources of the host mounts
+// are relabeled with it before the first container runs.
+func (c *Client) SetMountLabel(label string) error {
+	if label != "" && len(strings.SplitN(label, ":", 4)) != 4 {
+		return fmt.Errorf("invalid SELinux label %s, expected user:role:type:level", label)
+	}
+	c.mountLabel = label
+	return nil
+}
+
+// wrapExecutor returns the executor with the client options applied on top
+// of the ones the runc executor supports.
+func (c *Client) wrapExecutor(exe executor.Executor) executor.Executor {
+	if c.mountLabel != "" {
+		// Wrap it first so it labels the mounts of the other options too.
+		var sources []string
+		for _, m := range c.hostMounts {
+			sources = append(sources, m.Source)
+		}
+		exe = &labelExecutor{Executor: exe, label: c.mountLabel, sources: sources}
+	}
+	if c.shmSize > 0 {
+		exe = &shmExecutor{Executor: exe, size: c.shmSize}
+	}
+	if len(c.hostMounts) > 0 {
+		exe = &hostMountExecutor{Executor: exe, mounts: c.hostMounts}
+	}
+	return exe
+}
+
+// shmExecutor mounts a /dev/shm tmpfs of the given size over the default one
+// of the containers it runs.
+type shmExecutor struct {
+	executor.Executor
+	size int64
+}
+
+func (e *shmExecutor) Exec(ctx context.Context, meta executor.Meta, rootfs cache.Mountable, mounts []executor.Mount, stdin io.ReadCloser, stdout, stderr io.WriteCloser) error {
+	for _, m := range mounts {
+		if filepath.Clean(m.Dest) == "/dev/shm" {
+			// Keep a /dev/shm mount of the build itself.
+			return e.Executor.Exec(ctx, meta, rootfs, mounts, stdin, stdout, stderr)
+		}
+	}
+	mounts = append(mounts, executor.Mount{
+		Src:  &shmMountable{size: e.size},
+		Dest: "/dev/shm",
+	})
+	return e.Executor.Exec(ctx, meta, rootfs, mounts, stdin, stdout, stderr)
+}
+
+// shmMountable is a cache.Mountable for a /dev/shm tmpfs.
+type shmMountable struct {
+	size int64
+}
+
+func (m *shmMountable) Mount(ctx context.Context, readonly bool) (snapshot.Mountable, error) {
+	return shmMounts{size: m.size}, nil
+}
+
+// shmMounts are the mounts of a shmMountable.
+type shmMounts struct {
+	size int64
+}
+
+func (m shmMounts) Mount() ([]mount.Mount, error) {
+	return []mount.Mount{{
+		Type:    "tmpfs",
+		Source:  "shm",
+		Options: []string{"nosuid", "noexec", "nodev", "mode=1777", fmt.Sprintf("size=%d", m.size)},
+	}}, nil
+}
+
+func (m shmMounts) Release() error {
+	return nil
+}
+
+func (m shmMounts) IdentityMapping() *idtools.IdentityMapping {
+	return nil
+}
+
+// hostMountExecutor bind mounts host paths into the containers it runs.
+type hostMountExecutor struct {
+	executor.Executor
+	mounts []HostMount
+}
+
+func (e *hostMountExecutor) Exec(ctx context.Context, meta executor.Meta, rootfs cache.Mountable, mounts []executor.Mount, stdin io.ReadCloser, stdout, stderr io.WriteCloser) error {
+	dests := map[string]bool{}
+	for _, m := range mounts {
+		dests[filepath.Clean(m.Dest)] = true
+	}
+	for _, hm := range e.mounts {
+		if dests[hm.Target] {
+			// Keep a mount of the build itself at the same target.
+			continue
+		}
+		mounts = append(mounts, executor.Mount{
+			Src:      &bindMountable{source: hm.Source},
+			Dest:     hm.Target,
+			Readonly: hm.Readonly,
+		})
+	}
+	return e.Executor.Exec(ctx, meta, rootfs, mounts, stdin, stdout, stderr)
+}
+
+// bindMountable is a cache.Mountable for a bind mount of a host path.
+type bindMountable struct {
+	source string
+}
+
+func (m *bindMountable) Mount(ctx context.Context, readonly bool) (snapshot.Mountable, error) {
+	return bindMounts{source: m.source, readonly: readonly}, nil
+}
+
+// bindMounts are the mounts of a bindMountable.
+type bindMounts struct {
+	source   string
+	readonly bool
+}
+
+func (m bindMounts) Mount() ([]mount.Mount, error) {
+	options := []string{"rbind"}
+	if m.readonly {
+		options = append(options, "ro")
+	}
+	return []mount.Mount{{
+		Type:    "bind",
+		Source:  m.source,
+		Options: options,
+	}}, nil
+}
+
+func (m bindMounts) Release() error {
+	return nil
+}
+
+func (m bindMounts) IdentityMapping() *idtools.IdentityMapping {
+	return nil
+}
+
+// labelExecutor sets the SELinux context of the mounts of the containers it
+// runs.
+type labelExecutor struct {
+	executor.Executor
+	label   string
+	sources []string
+
+	once sync.Once
+	err  error
+}
+
+func (e *labelExecutor) Exec(ctx context.Context, meta executor.Meta, rootfs cache.Mountable, mounts []executor.Mount, stdin io.ReadCloser, stdout, stderr io.WriteCloser) error {
+	e.once.Do(func() {
+		for _, source := range e.sources {
+			if err := selinux.Chcon(source, e.label, true); err != nil {
+				e.err = fmt.Errorf("relabeling host mount source %s failed: %v", source, err)
+				return
+			}
+		}
+	})
+	if e.err != nil {
+		return e.err
+	}
+
+	if rootfs != nil {
+		rootfs = &labelMountable{Mountable: rootfs, label: e.label}
+	}
+	labeled := make([]executor.Mount, len(mounts))
+	for i, m := range mounts {
+		m.Src = &labelMountable{Mountable: m.Src, label: e.label}
+		labeled[i] = m
+	}
+	return e.Executor.Exec(ctx, meta, rootfs, labeled, stdin, stdout, stderr)
+}
+
+// labelMountable is a cache.Mountable with the mounts labeled.
+type labelMountable struct {
+	cache.Mountable
+	label string
+}
+
+func (m *labelMountable) Mount(ctx context.Context, readonly bool) (snapshot.Mountable, error) {
+	mountable, err := m.Mountable.Mount(ctx, readonly)
+	if err != nil {
+		return nil, err
+	}
+	return labelMounts{Mountable: mountable, label: m.label}, nil
+}
+
+// labelMounts are the mounts of a snapshot.Mountable with the context=
+// option added to the ones other than bind mounts, which the kernel does not
+// label.
+type labelMounts struct {
+	snapshot.Mountable
+	label string
+}
+
+func (m labelMounts) Mount() ([]mount.Mount, error) {
+	mounts, err := m.Mountable.Mount()
+	if err != nil {
+		return nil, err
+	}
+	labeled := make([]mount.Mount, len(mounts))
+	for i, mnt := range mounts {
+		if !isBind(mnt) {
+			mnt.Options = append(append([]string{}, mnt.Options...), fmt.Sprintf("context=%q", m.label))
+		}
+		labeled[i] = mnt
+	}
+	return labeled, nil
+}
+
+// isBind returns if the mount is a bind mount.
+func isBind(m mount.Mount) bool {
+	if m.Type == "bind" {
+		return true
+	}
+	for _, o := range m.Options {
+		if o == "bind" || o == "rbind" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,176 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/moby/buildkit/cache"
+	"github.com/moby/buildkit/executor"
+	executoroci "github.com/moby/buildkit/executor/oci"
+)
+
+// recordingExecutor records the root filesystem and the mounts of the last
+// Exec.
+type recordingExecutor struct {
+	executor.Executor
+	rootfs cache.Mountable
+	mounts []executor.Mount
+}
+
+func (e *recordingExecutor) Exec(ctx context.Context, meta executor.Meta, rootfs cache.Mountable, mounts []executor.Mount, stdin io.ReadCloser, stdout, stderr io.WriteCloser) error {
+	e.rootfs = rootfs
+	e.mounts = mounts
+	return nil
+}
+
+func TestShmSizeExecutor(t *testing.T) {
+	c := &Client{}
+	if err := c.SetShmSize(-1); err == nil {
+		t.Fatal("expected a negative shm size to fail but it did not")
+	}
+
+	// The default executor is used as is.
+	rec := &recordingExecutor{}
+	if exe := c.wrapExecutor(rec); exe != rec {
+		t.Fatalf("expected the executor to not be wrapped without a shm size, got: %T", exe)
+	}
+
+	if err := c.SetShmSize(2 * 1024 * 1024 * 1024); err != nil {
+		t.Fatalf("setting shm size failed: %v", err)
+	}
+	ctx := context.Background()
+	if err := c.wrapExecutor(rec).Exec(ctx, executor.Meta{}, nil, nil, nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(rec.mounts) != 1 || rec.mounts[0].Dest != "/dev/shm" {
+		t.Fatalf("expected a /dev/shm mount, got: %v", rec.mounts)
+	}
+
+	mountable, err := rec.mounts[0].Src.Mount(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mounts, err := mountable.Mount()
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, o := range mounts[0].Options {
+		if o == "size=2147483648" {
+			found = true
+		}
+	}
+	if mounts[0].Type != "tmpfs" || !found {
+		t.Fatalf("expected a 2GB tmpfs mount, got: %+v", mounts[0])
+	}
+}
+
+func TestSetRuntime(t *testing.T) {
+	c := &Client{root: "/tmp/img"}
+	if opt := c.executorOpt(false, executoroci.ProcessSandbox); opt.CommandCandidates != nil {
+		t.Fatalf("expected the executor to look up the default runtimes, got: %v", opt.CommandCandidates)
+	}
+
+	c.SetRuntime("/usr/local/bin/crun")
+	opt := c.executorOpt(false, executoroci.ProcessSandbox)
+	if !reflect.DeepEqual(opt.CommandCandidates, []string{"/usr/local/bin/crun"}) {
+		t.Fatalf("expected the executor to run the runtime /usr/local/bin/crun, got: %v", opt.CommandCandidates)
+	}
+}
+
+func TestHostMountExecutor(t *testing.T) {
+	c := &Client{}
+	if err := c.SetHostMounts([]HostMount{{Source: "relative", Target: "/cache"}}); err == nil {
+		t.Fatal("expected a relative source to fail but it did not")
+	}
+	if err := c.SetHostMounts([]HostMount{{Source: "/does/not/exist", Target: "/cache"}}); err == nil {
+		t.Fatal("expected a missing source to fail but it did not")
+	}
+
+	if err := c.SetHostMounts([]HostMount{
+		{Source: "/tmp", Target: "/cache", Readonly: true},
+		{Source: "/tmp", Target: "/out"},
+		{Source: "/tmp", Target: "/src", Readonly: true},
+	}); err != nil {
+		t.Fatalf("setting host mounts failed: %v", err)
+	}
+	rec := &recordingExecutor{}
+	ctx := context.Background()
+	// A mount of the build itself is kept over the host mount.
+	build := executor.Mount{Src: &shmMountable{}, Dest: "/src/"}
+	if err := c.wrapExecutor(rec).Exec(ctx, executor.Meta{}, nil, []executor.Mount{build}, nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(rec.mounts) != 3 || rec.mounts[1].Dest != "/cache" || !rec.mounts[1].Readonly || rec.mounts[2].Dest != "/out" || rec.mounts[2].Readonly {
+		t.Fatalf("expected a read-only /cache and a writable /out mount after the mount of the build, got: %+v", rec.mounts)
+	}
+
+	for i, expected := range [][]string{{"rbind", "ro"}, {"rbind"}} {
+		m := rec.mounts[i+1]
+		mountable, err := m.Src.Mount(ctx, m.Readonly)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mounts, err := mountable.Mount()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(mounts) != 1 || mounts[0].Type != "bind" || mounts[0].Source != "/tmp" || !reflect.DeepEqual(mounts[0].Options, expected) {
+			t.Fatalf("expected a bind mount of /tmp with options %v for %s, got: %+v", expected, m.Dest, mounts)
+		}
+	}
+}
+
+func TestMountLabelExecutor(t *testing.T) {
+	c := &Client{}
+	if err := c.SetMountLabel("container_file_t"); err == nil {
+		t.Fatal("expected a label without user, role and level to fail but it did not")
+	}
+
+	label := "system_u:object_r:container_file_t:s0:c1,c2"
+	if err := c.SetMountLabel(label); err != nil {
+		t.Fatalf("setting the mount label failed: %v", err)
+	}
+	if err := c.SetShmSize(64 * 1024 * 1024); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SetHostMounts([]HostMount{{Source: "/tmp", Target: "/cache", Readonly: true}}); err != nil {
+		t.Fatal(err)
+	}
+	rec := &recordingExecutor{}
+	ctx := context.Background()
+	if err := c.wrapExecutor(rec).Exec(ctx, executor.Meta{}, &shmMountable{}, nil, nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(rec.mounts) != 2 || rec.mounts[0].Dest != "/cache" || rec.mounts[1].Dest != "/dev/shm" {
+		t.Fatalf("expected a /cache and a /dev/shm mount, got: %+v", rec.mounts)
+	}
+
+	option := fmt.Sprintf("context=%q", label)
+	for _, expected := range []struct {
+		name      string
+		mountable cache.Mountable
+		labeled   bool
+	}{
+		{"rootfs", rec.rootfs, true},
+		// The kernel does not label bind mounts, their source is relabeled.
+		{"/cache", rec.mounts[0].Src, false},
+		{"/dev/shm", rec.mounts[1].Src, true},
+	} {
+		mountable, err := expected.mountable.Mount(ctx, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mounts, err := mountable.Mount()
+		if err != nil {
+			t.Fatal(err)
+		}
+		options := mounts[0].Options
+		if labeled := options[len(options)-1] == option; labeled != expected.labeled {
+			t.Fatalf("expected the %s mount labeled %t, got the options: %v", expected.name, expected.labeled, options)
+		}
+	}
+}
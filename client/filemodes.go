@@ -0,0 +1,49 @@
+package client
+
+import (
+	"os"
+
+	fstypes "github.com/tonistiigi/fsutil/types"
+)
+
+// FileModes are the permissions the files of a local dir are sent to the
+// builder with, so the modes of a checkout with a broad umask or made on
+// Windows do not leak into the layers.
+type FileModes struct {
+	// File is the permissions of the regular files.
+	File os.FileMode
+	// Dir is the permissions of the directories.
+	Dir os.FileMode
+	// PreserveExec keeps the files with an execute bit executable, adding
+	// the execute bits for who can read them with the File permissions.
+	PreserveExec bool
+}
+
+// SetLocalModes normalizes the permissions of the files of the local dir with
+// the name to the modes. Symlinks and other files are sent as is.
+func (c *Client) SetLocalModes(name string, modes FileModes) {
+	if c.localModes == nil {
+		c.localModes = map[string]FileModes{}
+	}
+	c.localModes[name] = modes
+}
+
+// specialBits are the mode bits replaced along with the permissions.
+const specialBits = os.ModeSetuid | os.ModeSetgid | os.ModeSticky
+
+// apply replaces the permissions of the stat of a regular file or directory
+// walked by the filesync provider.
+func (m FileModes) apply(_ string, st *fstypes.Stat) bool {
+	mode := os.FileMode(st.Mode)
+	switch {
+	case mode.IsRegular():
+		perm := m.File
+		if m.PreserveExec && mode&0111 != 0 {
+			perm |= (perm & 0444) >> 2
+		}
+		st.Mode = uint32(mode&^(os.ModePerm|specialBits) | perm)
+	case mode.IsDir():
+		st.Mode = uint32(mode&^(os.ModePerm|specialBits) | m.Dir)
+	}
+	return true
+}
@@ -0,0 +1,101 @@
+package client
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/tonistiigi/fsutil"
+)
+
+func TestFileModes(t *testing.T) {
+	tmpd, err := ioutil.TempDir("", "img-filemodes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpd)
+
+	// Prepare a context with the modes of a broad umask.
+	files := map[string]os.FileMode{
+		"README.md":         0666,
+		"bin/build.sh":      0777,
+		"src/main.go":       0600,
+		"src/private/a.txt": 0640,
+	}
+	for name, mode := range files {
+		p := filepath.Join(tmpd, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(name), mode); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chmod(p, mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Chmod(filepath.Join(tmpd, "src", "private"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("README.md", filepath.Join(tmpd, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	walk := func(modes FileModes) map[string]os.FileMode {
+		got := map[string]os.FileMode{}
+		// The filesync provider sends the stats as mapped by the walk.
+		if err := fsutil.Walk(context.Background(), tmpd, &fsutil.WalkOpt{Map: modes.apply}, func(path string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			got[path] = fi.Mode()
+			return nil
+		}); err != nil {
+			t.Fatalf("walking the context failed: %v", err)
+		}
+		return got
+	}
+
+	testCases := []struct {
+		modes    FileModes
+		expected map[string]os.FileMode
+	}{
+		{
+			modes: FileModes{File: 0644, Dir: 0755},
+			expected: map[string]os.FileMode{
+				"README.md":         0644,
+				"bin":               os.ModeDir | 0755,
+				"bin/build.sh":      0644,
+				"src":               os.ModeDir | 0755,
+				"src/main.go":       0644,
+				"src/private":       os.ModeDir | 0755,
+				"src/private/a.txt": 0644,
+			},
+		},
+		{
+			modes: FileModes{File: 0640, Dir: 0750, PreserveExec: true},
+			expected: map[string]os.FileMode{
+				"README.md":         0640,
+				"bin":               os.ModeDir | 0750,
+				"bin/build.sh":      0750,
+				"src":               os.ModeDir | 0750,
+				"src/main.go":       0640,
+				"src/private":       os.ModeDir | 0750,
+				"src/private/a.txt": 0640,
+			},
+		},
+	}
+	for _, tc := range testCases {
+		got := walk(tc.modes)
+		for path, expected := range tc.expected {
+			if got[path] != expected {
+				t.Fatalf("expected %s to be sent with mode %s for %+v, got: %s", path, expected, tc.modes, got[path])
+			}
+		}
+		if got["link"]&os.ModeSymlink == 0 || got["link"].Perm() != 0777 {
+			t.Fatalf("expected the symlink to be sent as is, got: %s", got["link"])
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	units "github.com/docker/go-units"
+	"github.com/moby/buildkit/client"
+)
+
+const gcPolicyFile = "gc.json"
+
+// GCPolicy holds the garbage collection policy for the build cache. It is
+// stored in the state directory and applied by builds once they succeed.
+type GCPolicy struct {
+	KeepBytes    int64         `json:"keepBytes,omitempty"`
+	KeepDuration time.Duration `json:"keepDuration,omitempty"`
+}
+
+// ParseGCPolicy parses a gc policy in the 'keep-bytes=10gb,keep-duration=168h'
+// format.
+func ParseGCPolicy(value string) (GCPolicy, error) {
+	var policy GCPolicy
+	for _, field := range strings.Split(value, ",") {
+		kv := strings.SplitN(strings.TrimSpace(field), "=", 2)
+		if len(kv) != 2 {
+			return policy, fmt.Errorf("invalid gc policy value %q, expected key=value", field)
+		}
+		switch kv[0] {
+		case "keep-bytes":
+			b, err := units.RAMInBytes(kv[1])
+			if err != nil {
+				return policy, fmt.Errorf("parsing keep-bytes %q failed: %v", kv[1], err)
+			}
+			policy.KeepBytes = b
+		case "keep-duration":
+			d, err := time.ParseDuration(kv[1])
+			if err != nil {
+				return policy, fmt.Errorf("parsing keep-duration %q failed: %v", kv[1], err)
+			}
+			policy.KeepDuration = d
+		default:
+			return policy, fmt.Errorf("%q is not a valid gc policy key", kv[0])
+		}
+	}
+	return policy, nil
+}
+
+// LoadGCPolicy reads the gc policy from the state directory. It returns nil
+// if no policy has been configured.
+func LoadGCPolicy(stateDir string) (*GCPolicy, error) {
+	b, err := ioutil.ReadFile(filepath.Join(stateDir, gcPolicyFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("reading gc policy failed: %v", err)
+	}
+
+	var policy GCPolicy
+	if err := json.Unmarshal(b, &policy); err != nil {
+		return nil, fmt.Errorf("parsing gc policy failed: %v", err)
+	}
+	return &policy, nil
+}
+
+// SaveGCPolicy writes the gc policy to the state directory.
+func SaveGCPolicy(stateDir string, policy GCPolicy) error {
+	if err := os.MkdirAll(stateDir, 0700); err != nil {
+		return err
+	}
+
+	b, err := json.MarshalIndent(policy, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(stateDir, gcPolicyFile), b, 0600)
+}
+
+// GC collects the build cache with the gc policy of the state directory on
+// the worker of the build, leaving out the records kept by builds with
+// --keep-days, and returns the bytes freed. The controller only collects a
+// second after a solve starts, img exits before that for most builds. It is
+// a no-op without a policy or before a solve.
+func (c *Client) GC(ctx context.Context) (int64, error) {
+	if c.gcPolicy == nil || c.worker == nil {
+		return 0, nil
+	}
+	usage, err := c.prune(ctx, c.worker, c.gcPolicy.pruneInfo()...)
+	if err != nil {
+		return 0, fmt.Errorf("collecting the build cache failed: %v", err)
+	}
+	var size int64
+	for _, u := range usage {
+		size += u.Size_
+	}
+	return size, nil
+}
+
+// pruneInfo converts the gc policy into the prune options used by the worker.
+func (p *GCPolicy) pruneInfo() []client.PruneInfo {
+	if p == nil {
+		return nil
+	}
+	return []client.PruneInfo{{
+		All:          true,
+		KeepBytes:    p.KeepBytes,
+		KeepDuration: p.KeepDuration,
+	}}
+}
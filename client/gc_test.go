@@ -0,0 +1,52 @@
+package client
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/mchirico/img/types"
+)
+
+func TestGCPolicy(t *testing.T) {
+	stateDir, err := ioutil.TempDir("", "img-gc")
+	if err != nil {
+		t.Fatalf("creating temporary directory failed: %v", err)
+	}
+	defer os.RemoveAll(stateDir)
+
+	policy, err := ParseGCPolicy("keep-bytes=10gb,keep-duration=168h")
+	if err != nil {
+		t.Fatalf("parsing gc policy failed: %v", err)
+	}
+	expected := GCPolicy{KeepBytes: 10 * 1024 * 1024 * 1024, KeepDuration: 168 * time.Hour}
+	if policy != expected {
+		t.Fatalf("expected gc policy %+v, got: %+v", expected, policy)
+	}
+
+	if err := SaveGCPolicy(stateDir, policy); err != nil {
+		t.Fatalf("saving gc policy failed: %v", err)
+	}
+
+	// Make sure the policy is loaded when creating the client.
+	c, err := New(stateDir, types.NativeBackend, nil)
+	if err != nil {
+		t.Fatalf("creating client failed: %v", err)
+	}
+	if c.gcPolicy == nil || *c.gcPolicy != expected {
+		t.Fatalf("expected client gc policy %+v, got: %+v", expected, c.gcPolicy)
+	}
+	if pi := c.gcPolicy.pruneInfo(); len(pi) != 1 || pi[0].KeepBytes != expected.KeepBytes || pi[0].KeepDuration != expected.KeepDuration {
+		t.Fatalf("expected prune info to match gc policy %+v, got: %+v", expected, pi)
+	}
+	// There is nothing to collect before a build.
+	if size, err := c.GC(context.Background()); size != 0 || err != nil {
+		t.Fatalf("expected gc before a build to be a no-op, got: %d, %v", size, err)
+	}
+
+	if _, err := ParseGCPolicy("keep-forever=true"); err == nil {
+		t.Fatal("expected parsing an invalid gc policy to fail but it did not")
+	}
+}
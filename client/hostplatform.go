@@ -0,0 +1,68 @@
+package client
+
+import (
+	"bytes"
+
+	"github.com/containerd/containerd/platforms"
+	specs "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// kernelMachine returns the machine of the kernel img is running on, as
+// reported by uname -m. Tests replace it to stub the detection.
+var kernelMachine = func() string {
+	var uts unix.Utsname
+	if err := unix.Uname(&uts); err != nil {
+		logrus.Debugf("getting the kernel machine failed: %v", err)
+		return ""
+	}
+	return string(bytes.TrimRight(uts.Machine[:], "\x00"))
+}
+
+// machinePlatforms are the platforms of the uname machines.
+var machinePlatforms = map[string]specs.Platform{
+	"x86_64":  {OS: "linux", Architecture: "amd64"},
+	"amd64":   {OS: "linux", Architecture: "amd64"},
+	"i386":    {OS: "linux", Architecture: "386"},
+	"i686":    {OS: "linux", Architecture: "386"},
+	"aarch64": {OS: "linux", Architecture: "arm64"},
+	"arm64":   {OS: "linux", Architecture: "arm64"},
+	"armv6l":  {OS: "linux", Architecture: "arm", Variant: "v6"},
+	"armv7l":  {OS: "linux", Architecture: "arm", Variant: "v7"},
+	"armv8l":  {OS: "linux", Architecture: "arm", Variant: "v7"},
+	"ppc64le": {OS: "linux", Architecture: "ppc64le"},
+	"s390x":   {OS: "linux", Architecture: "s390x"},
+	"riscv64": {OS: "linux", Architecture: "riscv64"},
+}
+
+// nativeArchitectures are the architectures the kernel of an architecture
+// runs without emulation, besides its own.
+var nativeArchitectures = map[string][]string{
+	"amd64": {"386"},
+	"arm64": {"arm"},
+}
+
+// HostPlatform returns the platform of the machine img is running on. This is
+// the platform img was built for, unless the kernel can not run it natively,
+// such as an amd64 img emulated in an arm64 Linux VM on Apple Silicon. The
+// platform of the kernel is returned then, so builds default to the platform
+// the RUN steps run natively on.
+func HostPlatform() specs.Platform {
+	return hostPlatform(platforms.DefaultSpec(), kernelMachine())
+}
+
+func hostPlatform(binary specs.Platform, machine string) specs.Platform {
+	binary = platforms.Normalize(binary)
+	kernel, ok := machinePlatforms[machine]
+	if !ok || binary.OS != kernel.OS || binary.Architecture == kernel.Architecture {
+		return binary
+	}
+	for _, arch := range nativeArchitectures[kernel.Architecture] {
+		if binary.Architecture == arch {
+			return binary
+		}
+	}
+	logrus.Debugf("img is built for %s but runs emulated on a %s kernel, using %s as the host platform", platforms.Format(binary), machine, platforms.Format(kernel))
+	return kernel
+}
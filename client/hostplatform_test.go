@@ -0,0 +1,44 @@
+package client
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/containerd/containerd/platforms"
+	specs "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestHostPlatform(t *testing.T) {
+	amd64 := specs.Platform{OS: "linux", Architecture: "amd64"}
+	arm64 := specs.Platform{OS: "linux", Architecture: "arm64"}
+	armv6 := specs.Platform{OS: "linux", Architecture: "arm", Variant: "v6"}
+	testCases := []struct {
+		binary   specs.Platform
+		machine  string
+		expected specs.Platform
+	}{
+		{amd64, "x86_64", amd64},
+		{arm64, "aarch64", arm64},
+		// An amd64 img emulated in an arm64 VM, like on Apple Silicon.
+		{amd64, "aarch64", arm64},
+		{arm64, "x86_64", amd64},
+		// The kernel runs these natively, so the platform of img is kept.
+		{armv6, "aarch64", armv6},
+		{specs.Platform{OS: "linux", Architecture: "386"}, "x86_64", specs.Platform{OS: "linux", Architecture: "386"}},
+		{specs.Platform{OS: "linux", Architecture: "arm", Variant: "v7"}, "armv7l", specs.Platform{OS: "linux", Architecture: "arm", Variant: "v7"}},
+		// An unknown machine keeps the platform of img.
+		{amd64, "", amd64},
+		{amd64, "mips", amd64},
+	}
+	for _, tc := range testCases {
+		if p := hostPlatform(tc.binary, tc.machine); !reflect.DeepEqual(p, tc.expected) {
+			t.Fatalf("expected %s on a %s kernel to be the host platform %s, got: %s", platforms.Format(tc.binary), tc.machine, platforms.Format(tc.expected), platforms.Format(p))
+		}
+	}
+
+	defer func(machine func() string) { kernelMachine = machine }(kernelMachine)
+	kernelMachine = func() string { return "aarch64" }
+	if p := HostPlatform(); p.Architecture != "arm64" {
+		t.Fatalf("expected the host platform of an arm64 kernel to be linux/arm64, got: %s", platforms.Format(p))
+	}
+}
@@ -0,0 +1,89 @@
+package client
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/containerd/containerd/platforms"
+	controlapi "github.com/moby/buildkit/api/services/control"
+	apitypes "github.com/moby/buildkit/api/types"
+	"github.com/moby/buildkit/client"
+	"github.com/moby/buildkit/worker"
+	specs "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// BuilderInfo describes the workers of the builder and what it supports.
+type BuilderInfo struct {
+	Workers        []WorkerInfo `json:"workers"`
+	Frontends      []string     `json:"frontends"`
+	Exporters      []string     `json:"exporters"`
+	CacheExporters []string     `json:"cacheExporters"`
+	CacheImporters []string     `json:"cacheImporters"`
+}
+
+// WorkerInfo describes a worker of the builder, with the platforms it can
+// run RUN instructions for.
+type WorkerInfo struct {
+	ID          string            `json:"id"`
+	Executor    string            `json:"executor"`
+	Snapshotter string            `json:"snapshotter"`
+	Platforms   []string          `json:"platforms"`
+	Labels      map[string]string `json:"labels,omitempty"`
+}
+
+// The frontends, exporters and cache exporters and importers of the
+// controller and worker created by createController. The controller has no
+// API to list them.
+var (
+	builderFrontends      = []string{"dockerfile.v0", "gateway.v0"}
+	builderExporters      = []string{client.ExporterImage, ExporterRegistry, client.ExporterOCI, client.ExporterDocker, client.ExporterLocal, client.ExporterTar}
+	builderCacheExporters = []string{CacheExporterInline, CacheRegistry}
+	builderCacheImporters = []string{CacheRegistry}
+)
+
+// Info returns what the builder supports, from the workers of the controller.
+func (c *Client) Info(ctx context.Context) (*BuilderInfo, error) {
+	if c.controller == nil {
+		// Create the controller.
+		if err := c.createController(); err != nil {
+			return nil, err
+		}
+	}
+
+	resp, err := c.controller.ListWorkers(ctx, &controlapi.ListWorkersRequest{})
+	if err != nil {
+		return nil, fmt.Errorf("listing workers failed: %v", err)
+	}
+
+	return builderInfo(resp.Record), nil
+}
+
+func builderInfo(records []*apitypes.WorkerRecord) *BuilderInfo {
+	info := &BuilderInfo{
+		Workers:        []WorkerInfo{},
+		Frontends:      builderFrontends,
+		Exporters:      builderExporters,
+		CacheExporters: builderCacheExporters,
+		CacheImporters: builderCacheImporters,
+	}
+	for _, record := range records {
+		w := WorkerInfo{
+			ID:          record.ID,
+			Executor:    record.Labels[worker.LabelExecutor],
+			Snapshotter: record.Labels[worker.LabelSnapshotter],
+			Platforms:   []string{},
+			Labels:      map[string]string{},
+		}
+		for _, p := range record.Platforms {
+			w.Platforms = append(w.Platforms, platforms.Format(specs.Platform{OS: p.OS, Architecture: p.Architecture, Variant: p.Variant}))
+		}
+		// Keep the labels that are not already fields.
+		for k, v := range record.Labels {
+			if k != worker.LabelExecutor && k != worker.LabelSnapshotter {
+				w.Labels[k] = v
+			}
+		}
+		info.Workers = append(info.Workers, w)
+	}
+	return info
+}
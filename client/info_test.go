@@ -0,0 +1,39 @@
+package client
+
+import (
+	"reflect"
+	"testing"
+
+	apitypes "github.com/moby/buildkit/api/types"
+	"github.com/moby/buildkit/solver/pb"
+	"github.com/moby/buildkit/worker"
+)
+
+func TestBuilderInfo(t *testing.T) {
+	info := builderInfo([]*apitypes.WorkerRecord{{
+		ID: "w0",
+		Labels: map[string]string{
+			worker.LabelExecutor:    "oci",
+			worker.LabelSnapshotter: "overlayfs",
+			worker.LabelHostname:    "builder",
+		},
+		Platforms: []pb.Platform{
+			{OS: "linux", Architecture: "amd64"},
+			{OS: "linux", Architecture: "arm", Variant: "v7"},
+		},
+	}})
+
+	expected := []WorkerInfo{{
+		ID:          "w0",
+		Executor:    "oci",
+		Snapshotter: "overlayfs",
+		Platforms:   []string{"linux/amd64", "linux/arm/v7"},
+		Labels:      map[string]string{worker.LabelHostname: "builder"},
+	}}
+	if !reflect.DeepEqual(info.Workers, expected) {
+		t.Fatalf("expected workers %+v, got: %+v", expected, info.Workers)
+	}
+	if !reflect.DeepEqual(info.CacheImporters, []string{CacheRegistry}) {
+		t.Fatalf("expected the registry cache importer, got: %v", info.CacheImporters)
+	}
+}
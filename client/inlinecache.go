@@ -0,0 +1,104 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/moby/buildkit/cache/remotecache"
+	v1 "github.com/moby/buildkit/cache/remotecache/v1"
+	"github.com/moby/buildkit/solver"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/sirupsen/logrus"
+)
+
+// CacheExporterInline is the type of the cache export that embeds the build
+// cache metadata in the config of the exported image, so builds using the
+// pushed image as a cache source can reuse its layers.
+const CacheExporterInline = "inline"
+
+// resolveInlineCacheExporter returns a new inline cache exporter, it takes no
+// attrs.
+func resolveInlineCacheExporter(ctx context.Context, _ map[string]string) (remotecache.Exporter, error) {
+	cc := v1.NewCacheChains()
+	return &inlineCacheExporter{CacheExporterTarget: cc, chains: cc}, nil
+}
+
+// inlineCacheExporter collects the cache chains of the build. The solver
+// asks it for the cache metadata of the layers of the image with
+// ExportForLayers before the image is exported, nothing is written on
+// Finalize.
+type inlineCacheExporter struct {
+	solver.CacheExporterTarget
+	chains *v1.CacheChains
+}
+
+func (e *inlineCacheExporter) Finalize(ctx context.Context) (map[string]string, error) {
+	return nil, nil
+}
+
+// reset drops the collected chains so the next result, e.g. of another
+// platform, starts from scratch.
+func (e *inlineCacheExporter) reset() {
+	cc := v1.NewCacheChains()
+	e.CacheExporterTarget = cc
+	e.chains = cc
+}
+
+// ExportForLayers returns the cache records of the collected chains whose
+// results are the given layers of the image, with the layers referenced by
+// their index in the image.
+func (e *inlineCacheExporter) ExportForLayers(layers []digest.Digest) ([]byte, error) {
+	config, descs, err := e.chains.Marshal()
+	if err != nil {
+		return nil, err
+	}
+
+	matched := v1.DescriptorProvider{}
+	for _, l := range layers {
+		if d, ok := descs[l]; ok {
+			matched[l] = d
+		}
+	}
+
+	cc := v1.NewCacheChains()
+	if err := v1.ParseConfig(*config, matched, cc); err != nil {
+		return nil, err
+	}
+	cfg, _, err := cc.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	if len(cfg.Layers) == 0 {
+		logrus.Warn("no build cache matched the layers of the image, not adding the inline cache")
+		return nil, nil
+	}
+
+	// The layers are referenced by their position in the image.
+	memo := map[int]int{}
+	for i, r := range cfg.Records {
+		for j, res := range r.Results {
+			r.Results[j].LayerIndex = layerIndex(res.LayerIndex, cfg.Layers, memo)
+		}
+		cfg.Records[i] = r
+	}
+
+	b, err := json.Marshal(cfg.Records)
+	if err != nil {
+		return nil, err
+	}
+	e.reset()
+	return b, nil
+}
+
+// layerIndex returns the position in the image of the layer at idx of the
+// cache config, which is the number of its parents.
+func layerIndex(idx int, layers []v1.CacheLayer, memo map[int]int) int {
+	if idx == -1 {
+		return -1
+	}
+	if i, ok := memo[idx]; ok {
+		return i
+	}
+	memo[idx] = layerIndex(layers[idx].ParentIndex, layers, memo) + 1
+	return memo[idx]
+}
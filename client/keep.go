@@ -0,0 +1,180 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/moby/buildkit/cache"
+	"github.com/moby/buildkit/client"
+	"github.com/moby/buildkit/exporter"
+)
+
+// ExporterKeepDuration is the key of the exporter attr holding how long the
+// build cache records of the exported image are kept from garbage collection
+// and pruning, over the gc policy.
+const ExporterKeepDuration = "img.keep.duration"
+
+const keepFile = "keep.json"
+
+// keepLease keeps the build cache records of an image until it expires.
+type keepLease struct {
+	Image   string    `json:"image,omitempty"`
+	Records []string  `json:"records"`
+	Expires time.Time `json:"expires"`
+}
+
+// loadKeepLeases reads the leases that have not expired from the root of the
+// backend.
+func loadKeepLeases(root string, now time.Time) ([]keepLease, error) {
+	b, err := ioutil.ReadFile(filepath.Join(root, keepFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("reading keep leases failed: %v", err)
+	}
+
+	var leases []keepLease
+	if err := json.Unmarshal(b, &leases); err != nil {
+		return nil, fmt.Errorf("parsing keep leases failed: %v", err)
+	}
+	var active []keepLease
+	for _, lease := range leases {
+		if lease.Expires.After(now) {
+			active = append(active, lease)
+		}
+	}
+	return active, nil
+}
+
+// addKeepLease adds the lease to the others that have not expired.
+func addKeepLease(root string, lease keepLease) error {
+	leases, err := loadKeepLeases(root, time.Now())
+	if err != nil {
+		return err
+	}
+	leases = append(leases, lease)
+
+	b, err := json.MarshalIndent(leases, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, keepFile), b, 0600); err != nil {
+		return fmt.Errorf("writing keep leases failed: %v", err)
+	}
+	return nil
+}
+
+// keepPruneInfo adds the records kept by the leases in the root to the
+// filters of the prune options so they are left out. The filters of an
+// option all have to match, so the records are added to each of them.
+func keepPruneInfo(root string, opts []client.PruneInfo) ([]client.PruneInfo, error) {
+	leases, err := loadKeepLeases(root, time.Now())
+	if err != nil || len(leases) == 0 {
+		return opts, err
+	}
+
+	var kept []string
+	for _, lease := range leases {
+		for _, id := range lease.Records {
+			kept = append(kept, "id!="+id)
+		}
+	}
+	keep := strings.Join(kept, ",")
+
+	pi := make([]client.PruneInfo, 0, len(opts))
+	for _, opt := range opts {
+		filters := []string{keep}
+		if len(opt.Filter) > 0 {
+			filters = make([]string, 0, len(opt.Filter))
+			for _, f := range opt.Filter {
+				filters = append(filters, f+","+keep)
+			}
+		}
+		opt.Filter = filters
+		pi = append(pi, opt)
+	}
+	return pi, nil
+}
+
+// keepExporter adds a lease on the build cache records of the exported image
+// for the keep duration in the exporter attrs, so they are not collected
+// before it expires.
+type keepExporter struct {
+	exporter.Exporter
+
+	root string
+}
+
+func (e *keepExporter) Resolve(ctx context.Context, opt map[string]string) (exporter.ExporterInstance, error) {
+	v, ok := opt[ExporterKeepDuration]
+	if !ok {
+		return e.Exporter.Resolve(ctx, opt)
+	}
+
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return nil, fmt.Errorf("parsing keep duration failed: %v", err)
+	}
+	// Do not pass the duration on as metadata of the image.
+	attrs := make(map[string]string, len(opt))
+	for k, v := range opt {
+		if k != ExporterKeepDuration {
+			attrs[k] = v
+		}
+	}
+
+	inst, err := e.Exporter.Resolve(ctx, attrs)
+	if err != nil {
+		return nil, err
+	}
+	return &keepInstance{ExporterInstance: inst, root: e.root, image: attrs["name"], duration: d}, nil
+}
+
+type keepInstance struct {
+	exporter.ExporterInstance
+
+	root     string
+	image    string
+	duration time.Duration
+}
+
+func (e *keepInstance) Export(ctx context.Context, src exporter.Source) (map[string]string, error) {
+	resp, err := e.ExporterInstance.Export(ctx, src)
+	if err != nil {
+		return nil, err
+	}
+
+	lease := keepLease{Image: e.image, Records: []string{}, Expires: time.Now().Add(e.duration)}
+	refs := []cache.ImmutableRef{src.Ref}
+	for _, ref := range src.Refs {
+		refs = append(refs, ref)
+	}
+	for _, ref := range refs {
+		lease.Records = append(lease.Records, refChain(ctx, ref)...)
+	}
+	if err := addKeepLease(e.root, lease); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// refChain returns the ids of the record of the ref and of its parents.
+func refChain(ctx context.Context, ref cache.ImmutableRef) []string {
+	var ids []string
+	for r := ref; r != nil; {
+		ids = append(ids, r.ID())
+		parent := r.Parent()
+		if r != ref {
+			r.Release(ctx)
+		}
+		r = parent
+	}
+	return ids
+}
@@ -0,0 +1,114 @@
+package client
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/containerd/containerd/namespaces"
+	"github.com/mchirico/img/types"
+	"github.com/moby/buildkit/client"
+	"github.com/moby/buildkit/worker/base"
+)
+
+func TestKeepPruneInfo(t *testing.T) {
+	root, err := ioutil.TempDir("", "img-keep")
+	if err != nil {
+		t.Fatalf("creating temporary directory failed: %v", err)
+	}
+	defer os.RemoveAll(root)
+
+	opts := []client.PruneInfo{{All: true}, {Filter: []string{"type==exec.cachemount", "type==regular"}}}
+	if pi, err := keepPruneInfo(root, opts); err != nil || !reflect.DeepEqual(pi, opts) {
+		t.Fatalf("expected the prune options without leases to be unchanged, got: %+v (%v)", pi, err)
+	}
+
+	for _, lease := range []keepLease{
+		{Image: "kept", Records: []string{"a", "b"}, Expires: time.Now().Add(time.Hour)},
+		{Image: "expired", Records: []string{"c"}, Expires: time.Now().Add(-time.Hour)},
+	} {
+		if err := addKeepLease(root, lease); err != nil {
+			t.Fatalf("adding keep lease failed: %v", err)
+		}
+	}
+
+	expected := []client.PruneInfo{
+		{All: true, Filter: []string{"id!=a,id!=b"}},
+		{Filter: []string{"type==exec.cachemount,id!=a,id!=b", "type==regular,id!=a,id!=b"}},
+	}
+	pi, err := keepPruneInfo(root, opts)
+	if err != nil {
+		t.Fatalf("adding the kept records to the prune options failed: %v", err)
+	}
+	if !reflect.DeepEqual(pi, expected) {
+		t.Fatalf("expected prune options %+v, got: %+v", expected, pi)
+	}
+}
+
+func TestKeepSurvivesPrune(t *testing.T) {
+	stateDir, err := ioutil.TempDir("", "img-keep")
+	if err != nil {
+		t.Fatalf("creating temporary directory failed: %v", err)
+	}
+	defer os.RemoveAll(stateDir)
+
+	c, err := New(stateDir, types.NativeBackend, nil)
+	if err != nil {
+		t.Fatalf("creating client failed: %v", err)
+	}
+	defer c.Close()
+
+	opt, err := c.createWorkerOpt(false)
+	if err != nil {
+		t.Fatalf("creating worker opt failed: %v", err)
+	}
+	w, err := base.NewWorker(opt)
+	if err != nil {
+		t.Fatalf("creating worker failed: %v", err)
+	}
+
+	// Create a record for the kept image and one for another.
+	ctx := namespaces.WithNamespace(context.Background(), "buildkit")
+	var ids []string
+	for i := 0; i < 2; i++ {
+		mref, err := w.CacheManager.New(ctx, nil)
+		if err != nil {
+			t.Fatalf("creating record failed: %v", err)
+		}
+		ref, err := mref.Commit(ctx)
+		if err != nil {
+			t.Fatalf("committing record failed: %v", err)
+		}
+		if err := ref.Finalize(ctx, true); err != nil {
+			t.Fatalf("finalizing record failed: %v", err)
+		}
+		ids = append(ids, ref.ID())
+		if err := ref.Release(ctx); err != nil {
+			t.Fatalf("releasing record failed: %v", err)
+		}
+	}
+	if err := addKeepLease(c.root, keepLease{Image: "kept", Records: ids[:1], Expires: time.Now().Add(time.Hour)}); err != nil {
+		t.Fatalf("adding keep lease failed: %v", err)
+	}
+
+	usage, err := c.prune(ctx, w, client.PruneInfo{All: true})
+	if err != nil {
+		t.Fatalf("pruning failed: %v", err)
+	}
+	pruned := map[string]bool{}
+	for _, u := range usage {
+		pruned[u.ID] = true
+	}
+	if pruned[ids[0]] {
+		t.Fatalf("expected the kept record %s to survive the prune, got: %v", ids[0], pruned)
+	}
+	if !pruned[ids[1]] {
+		t.Fatalf("expected the record %s to be pruned, got: %v", ids[1], pruned)
+	}
+	if _, err := w.CacheManager.Get(ctx, ids[0]); err != nil {
+		t.Fatalf("expected the kept record %s to still exist: %v", ids[0], err)
+	}
+}
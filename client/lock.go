@@ -0,0 +1,55 @@
+package client
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// lockFileName is the name of the file in the state directory locked by New.
+const lockFileName = "img.lock"
+
+// lockRetryInterval is how often the lock is retried while another process
+// holds it.
+const lockRetryInterval = 100 * time.Millisecond
+
+// LockTimeout is how long New waits for another img process to release the
+// lock on the state directory. Zero waits until the lock is released.
+var LockTimeout time.Duration
+
+// lockStateDir takes an exclusive lock on the state directory so concurrent
+// img processes do not use the stores in it at the same time. The lock is
+// held until the returned file is closed, or the process exits.
+func lockStateDir(root string, timeout time.Duration) (*os.File, error) {
+	if err := os.MkdirAll(root, 0700); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(filepath.Join(root, lockFileName), os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("opening lock file failed: %v", err)
+	}
+
+	deadline := time.Now().Add(timeout)
+	for waiting := false; ; waiting = true {
+		err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			return f, nil
+		}
+		if err != syscall.EWOULDBLOCK {
+			f.Close()
+			return nil, fmt.Errorf("locking state directory %s failed: %v", root, err)
+		}
+		if timeout > 0 && time.Now().After(deadline) {
+			f.Close()
+			return nil, fmt.Errorf("another img process holds the lock on state directory %s, gave up after %s", root, timeout)
+		}
+		if !waiting {
+			logrus.Infof("another img process holds the lock on state directory %s, waiting for it", root)
+		}
+		time.Sleep(lockRetryInterval)
+	}
+}
@@ -0,0 +1,44 @@
+package client
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mchirico/img/types"
+)
+
+func TestNewLocksStateDir(t *testing.T) {
+	root, err := ioutil.TempDir("", "img-lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	defer func(timeout time.Duration) { LockTimeout = timeout }(LockTimeout)
+	LockTimeout = 200 * time.Millisecond
+
+	c, err := New(root, types.NativeBackend, nil)
+	if err != nil {
+		t.Fatalf("creating client failed: %v", err)
+	}
+
+	// A second client waits for the lock, then gives up.
+	start := time.Now()
+	if _, err := New(root, types.NativeBackend, nil); err == nil || !strings.Contains(err.Error(), "another img process holds the lock") {
+		t.Fatalf("expected creating a second client to fail on the lock, got: %v", err)
+	}
+	if waited := time.Since(start); waited < LockTimeout {
+		t.Fatalf("expected the second client to wait %s for the lock, waited: %s", LockTimeout, waited)
+	}
+
+	// Closing the first client releases the lock.
+	c.Close()
+	c, err = New(root, types.NativeBackend, nil)
+	if err != nil {
+		t.Fatalf("creating client after the lock was released failed: %v", err)
+	}
+	c.Close()
+}
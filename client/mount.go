@@ -0,0 +1,251 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/containerd/containerd/diff"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/mount"
+	"github.com/containerd/containerd/platforms"
+	"github.com/containerd/containerd/snapshots"
+	"github.com/docker/distribution/reference"
+	"github.com/moby/buildkit/identity"
+	"github.com/moby/buildkit/snapshot"
+	digest "github.com/opencontainers/go-digest"
+	imageidentity "github.com/opencontainers/image-spec/identity"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// MountModeSnapshot mounts a read-only view of the image snapshot.
+	MountModeSnapshot = "snapshot"
+	// MountModeUnpack unpacks the image rootfs to the target directory.
+	MountModeUnpack = "unpack"
+)
+
+// MountInfo describes an image rootfs mounted with Mount.
+type MountInfo struct {
+	Image    string `json:"image"`
+	Target   string `json:"target"`
+	Mode     string `json:"mode"`
+	Snapshot string `json:"snapshot,omitempty"`
+}
+
+// Mount mounts the rootfs of an image from the image store at the target
+// directory. With MountModeSnapshot the layers are applied to the snapshotter
+// and a read-only view is mounted, with MountModeUnpack the layers are
+// unpacked to the target instead. The mount is recorded in the state
+// directory so it can be undone with Umount.
+func (c *Client) Mount(ctx context.Context, image, target, mode string, platform platforms.MatchComparer) (*MountInfo, error) {
+	target, err := filepath.Abs(target)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(c.mountRecordPath(target)); err == nil {
+		return nil, fmt.Errorf("an image is already mounted at %s", target)
+	}
+
+	// Parse the image name and tag.
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return nil, fmt.Errorf("parsing image name %q failed: %v", image, err)
+	}
+	// Add the latest lag if they did not provide one.
+	named = reference.TagNameOnly(named)
+	image = named.String()
+
+	info := &MountInfo{Image: image, Target: target, Mode: mode}
+	switch mode {
+	case MountModeUnpack:
+		if err := c.unpack(ctx, image, target, platform); err != nil {
+			return nil, err
+		}
+	case MountModeSnapshot:
+		info.Snapshot, err = c.mountSnapshot(ctx, image, target, platform)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("%q is not a valid mount mode", mode)
+	}
+
+	if err := c.saveMountRecord(info); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// mountSnapshot applies the layers of the image to the snapshotter and mounts
+// a read-only view of the top layer at target. It returns the key of the view.
+func (c *Client) mountSnapshot(ctx context.Context, image, target string, platform platforms.MatchComparer) (string, error) {
+	// Create the worker opts.
+	opt, err := c.createWorkerOpt(true)
+	if err != nil {
+		return "", fmt.Errorf("creating worker opt failed: %v", err)
+	}
+
+	if opt.ImageStore == nil {
+		return "", errors.New("image store is nil")
+	}
+
+	img, err := opt.ImageStore.Get(ctx, image)
+	if err != nil {
+		return "", fmt.Errorf("getting image %s from image store failed: %v", image, err)
+	}
+
+	manifest, err := images.Manifest(ctx, opt.ContentStore, img.Target, platform)
+	if err != nil {
+		return "", fmt.Errorf("getting image manifest failed: %v", err)
+	}
+	diffIDs, err := images.RootFS(ctx, opt.ContentStore, manifest.Config)
+	if err != nil {
+		return "", fmt.Errorf("getting image rootfs failed: %v", err)
+	}
+	if len(diffIDs) != len(manifest.Layers) {
+		return "", fmt.Errorf("mismatched image rootfs and manifest layers")
+	}
+
+	// Apply the layers that are not already committed as snapshots.
+	var parent string
+	for i, desc := range manifest.Layers {
+		chainID := imageidentity.ChainID(diffIDs[:i+1]).String()
+		if _, err := opt.Snapshotter.Stat(ctx, chainID); err == nil {
+			parent = chainID
+			continue
+		} else if !errdefs.IsNotFound(err) {
+			return "", fmt.Errorf("stat snapshot %s failed: %v", chainID, err)
+		}
+
+		logrus.Debugf("Applying layer %s", desc.Digest.String())
+
+		key := "img-extract-" + identity.NewID()
+		if err := opt.Snapshotter.Prepare(ctx, key, parent); err != nil {
+			return "", fmt.Errorf("preparing snapshot for layer %s failed: %v", desc.Digest.String(), err)
+		}
+		if err := applyLayer(ctx, opt.Snapshotter, opt.Applier, key, desc); err != nil {
+			opt.Snapshotter.Remove(ctx, key)
+			return "", err
+		}
+		if err := opt.Snapshotter.Commit(ctx, chainID, key); err != nil {
+			return "", fmt.Errorf("committing snapshot for layer %s failed: %v", desc.Digest.String(), err)
+		}
+		parent = chainID
+	}
+
+	// Mount a read-only view of the top layer, labeled as a gc root so it is
+	// kept until it is unmounted.
+	key := "img-mount-" + identity.NewID()
+	mountable, err := opt.Snapshotter.View(ctx, key, parent, snapshots.WithLabels(map[string]string{
+		"containerd.io/gc.root": time.Now().UTC().Format(time.RFC3339),
+	}))
+	if err != nil {
+		return "", fmt.Errorf("creating view snapshot failed: %v", err)
+	}
+	mounts, err := mountable.Mount()
+	if err != nil {
+		opt.Snapshotter.Remove(ctx, key)
+		return "", fmt.Errorf("getting mounts for view snapshot failed: %v", err)
+	}
+	defer mountable.Release()
+
+	if err := os.MkdirAll(target, 0755); err != nil {
+		opt.Snapshotter.Remove(ctx, key)
+		return "", err
+	}
+	if err := mount.All(mounts, target); err != nil {
+		opt.Snapshotter.Remove(ctx, key)
+		return "", fmt.Errorf("mounting image rootfs to %s failed: %v", target, err)
+	}
+
+	return key, nil
+}
+
+// applyLayer applies the layer to the active snapshot with the key.
+func applyLayer(ctx context.Context, sn snapshot.Snapshotter, applier diff.Applier, key string, desc ocispec.Descriptor) error {
+	mountable, err := sn.Mounts(ctx, key)
+	if err != nil {
+		return fmt.Errorf("getting mounts for snapshot %s failed: %v", key, err)
+	}
+	mounts, err := mountable.Mount()
+	if err != nil {
+		return fmt.Errorf("getting mounts for snapshot %s failed: %v", key, err)
+	}
+	defer mountable.Release()
+
+	if _, err := applier.Apply(ctx, desc, mounts); err != nil {
+		return fmt.Errorf("applying layer %s failed: %v", desc.Digest.String(), err)
+	}
+	return nil
+}
+
+// Umount undoes a Mount at the target directory. For unpacked images the
+// target directory is removed.
+func (c *Client) Umount(ctx context.Context, target string) (*MountInfo, error) {
+	target, err := filepath.Abs(target)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := ioutil.ReadFile(c.mountRecordPath(target))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("no image is mounted at %s", target)
+		}
+		return nil, err
+	}
+	var info MountInfo
+	if err := json.Unmarshal(b, &info); err != nil {
+		return nil, fmt.Errorf("parsing mount record for %s failed: %v", target, err)
+	}
+
+	switch info.Mode {
+	case MountModeUnpack:
+		if err := os.RemoveAll(target); err != nil {
+			return nil, fmt.Errorf("removing unpacked rootfs %s failed: %v", target, err)
+		}
+	case MountModeSnapshot:
+		if err := mount.UnmountAll(target, 0); err != nil {
+			return nil, fmt.Errorf("unmounting %s failed: %v", target, err)
+		}
+
+		// Create the worker opts.
+		opt, err := c.createWorkerOpt(false)
+		if err != nil {
+			return nil, fmt.Errorf("creating worker opt failed: %v", err)
+		}
+		if err := opt.Snapshotter.Remove(ctx, info.Snapshot); err != nil && !errdefs.IsNotFound(err) {
+			return nil, fmt.Errorf("removing view snapshot %s failed: %v", info.Snapshot, err)
+		}
+	}
+
+	if err := os.Remove(c.mountRecordPath(target)); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// mountRecordPath returns the path of the record for a mount at the target.
+func (c *Client) mountRecordPath(target string) string {
+	return filepath.Join(c.root, "mounts", digest.FromString(target).Encoded()+".json")
+}
+
+func (c *Client) saveMountRecord(info *MountInfo) error {
+	b, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	p := c.mountRecordPath(info.Target)
+	if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(p, b, 0600)
+}
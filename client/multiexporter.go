@@ -0,0 +1,139 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/moby/buildkit/exporter"
+	"github.com/moby/buildkit/session"
+)
+
+// ExporterMulti is the name of the exporter that runs several exporters on
+// the result of a single build. Its attrs are built with MultiExporterAttrs.
+const ExporterMulti = "img.multi"
+
+// MultiExporterAttrs returns the attrs of the multi exporter running the
+// exporters in order, each with its attrs. The attrs of exporter i are
+// prefixed with "i.", its name is "i.type".
+func MultiExporterAttrs(exporters []string, attrs []map[string]string) map[string]string {
+	multi := map[string]string{}
+	for i, name := range exporters {
+		prefix := strconv.Itoa(i) + "."
+		multi[prefix+"type"] = name
+		for k, v := range attrs[i] {
+			multi[prefix+k] = v
+		}
+	}
+	return multi
+}
+
+// multiExporter resolves the exporters in the attrs of the multi exporter.
+type multiExporter struct {
+	w  *imgWorker
+	sm *session.Manager
+}
+
+func (e *multiExporter) Resolve(ctx context.Context, opt map[string]string) (exporter.ExporterInstance, error) {
+	exporters, attrs, err := parseMultiExporterAttrs(opt)
+	if err != nil {
+		return nil, err
+	}
+
+	inst := &multiExporterInstance{}
+	for i, name := range exporters {
+		if name == ExporterMulti {
+			return nil, fmt.Errorf("the %s exporter can not be nested", ExporterMulti)
+		}
+		sub, err := e.w.exporter(name, e.sm)
+		if err != nil {
+			return nil, fmt.Errorf("getting exporter %s failed: %v", name, err)
+		}
+		target, ok := attrs[i][ExporterTarget]
+		delete(attrs[i], ExporterTarget)
+		subInst, err := sub.Resolve(ctx, attrs[i])
+		if err != nil {
+			return nil, fmt.Errorf("resolving exporter %s failed: %v", name, err)
+		}
+		if ok {
+			// Export the result of the target of a build of several targets.
+			subInst = &targetInstance{ExporterInstance: subInst, target: target}
+		}
+		inst.instances = append(inst.instances, subInst)
+	}
+	return inst, nil
+}
+
+// parseMultiExporterAttrs returns the exporters and their attrs in order
+// from the attrs of the multi exporter.
+func parseMultiExporterAttrs(opt map[string]string) ([]string, []map[string]string, error) {
+	byIndex := map[int]map[string]string{}
+	for k, v := range opt {
+		kv := strings.SplitN(k, ".", 2)
+		i, err := strconv.Atoi(kv[0])
+		if len(kv) != 2 || err != nil || i < 0 {
+			return nil, nil, fmt.Errorf("invalid %s exporter attr %s, expected <index>.<key>", ExporterMulti, k)
+		}
+		if byIndex[i] == nil {
+			byIndex[i] = map[string]string{}
+		}
+		byIndex[i][kv[1]] = v
+	}
+
+	indexes := make([]int, 0, len(byIndex))
+	for i := range byIndex {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+
+	var (
+		exporters []string
+		attrs     []map[string]string
+	)
+	for _, i := range indexes {
+		name := byIndex[i]["type"]
+		if name == "" {
+			return nil, nil, fmt.Errorf("%s exporter %d has no type", ExporterMulti, i)
+		}
+		delete(byIndex[i], "type")
+		exporters = append(exporters, name)
+		attrs = append(attrs, byIndex[i])
+	}
+	if len(exporters) < 1 {
+		return nil, nil, fmt.Errorf("the %s exporter requires at least one exporter", ExporterMulti)
+	}
+	return exporters, attrs, nil
+}
+
+// multiExporterInstance exports the result with each of the exporters in
+// order. The response merges the responses of the exporters, the first
+// exporter to set a key wins.
+type multiExporterInstance struct {
+	instances []exporter.ExporterInstance
+}
+
+func (e *multiExporterInstance) Name() string {
+	names := make([]string, 0, len(e.instances))
+	for _, inst := range e.instances {
+		names = append(names, inst.Name())
+	}
+	return strings.Join(names, ", ")
+}
+
+func (e *multiExporterInstance) Export(ctx context.Context, src exporter.Source) (map[string]string, error) {
+	resp := map[string]string{}
+	for _, inst := range e.instances {
+		r, err := inst.Export(ctx, src)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range r {
+			if _, ok := resp[k]; !ok {
+				resp[k] = v
+			}
+		}
+	}
+	return resp, nil
+}
@@ -0,0 +1,32 @@
+package client
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseMultiExporterAttrs(t *testing.T) {
+	exporters := []string{"image", "oci"}
+	attrs := []map[string]string{
+		{"name": "docker.io/library/a:latest", "push": "true"},
+		{"dest": "a.tar"},
+	}
+
+	gotExporters, gotAttrs, err := parseMultiExporterAttrs(MultiExporterAttrs(exporters, attrs))
+	if err != nil {
+		t.Fatalf("parsing multi exporter attrs failed: %v", err)
+	}
+	if !reflect.DeepEqual(gotExporters, exporters) || !reflect.DeepEqual(gotAttrs, attrs) {
+		t.Fatalf("expected exporters %v with attrs %v, got: %v %v", exporters, attrs, gotExporters, gotAttrs)
+	}
+
+	for _, opt := range []map[string]string{
+		{},
+		{"name": "a"},
+		{"0.name": "a"},
+	} {
+		if _, _, err := parseMultiExporterAttrs(opt); err == nil {
+			t.Fatalf("expected parsing multi exporter attrs %v to fail but it did not", opt)
+		}
+	}
+}
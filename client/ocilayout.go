@@ -0,0 +1,249 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/remotes"
+	"github.com/docker/distribution/reference"
+	"github.com/docker/docker/pkg/archive"
+	"github.com/moby/buildkit/util/imageutil"
+	digest "github.com/opencontainers/go-digest"
+	specs "github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// OCILayoutDomain is the registry domain of the image references the images
+// of OCI layouts are built from. The .invalid domain never resolves, so the
+// images are only ever loaded from their layout and never pulled.
+const OCILayoutDomain = "oci-layout.invalid"
+
+// SetOCILayouts sets the OCI layout directories, keyed by the digested image
+// reference in the OCI layout domain, whose images are loaded into the
+// content store before building.
+func (c *Client) SetOCILayouts(layouts map[string]string) {
+	c.ociLayouts = layouts
+}
+
+// ValidateOCILayout makes sure dir is an OCI layout holding the blob with the
+// digest.
+func ValidateOCILayout(dir string, dgst digest.Digest) error {
+	if err := dgst.Validate(); err != nil {
+		return fmt.Errorf("invalid digest %s: %v", dgst, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, ocispec.ImageLayoutFile)); err != nil {
+		return fmt.Errorf("%s is not an OCI layout: %v", dir, err)
+	}
+	if _, err := os.Stat(ociLayout(dir).blobPath(dgst)); err != nil {
+		return fmt.Errorf("digest %s not found in OCI layout %s", dgst, dir)
+	}
+	return nil
+}
+
+// WriteDirLayout writes an OCI layout to dest holding an image with a single
+// layer of the files of the directory dir, so a local directory can be used
+// like an image. It returns the digest of the manifest of the image.
+func WriteDirLayout(dir, dest string) (digest.Digest, error) {
+	layout := ociLayout(dest)
+	if err := os.MkdirAll(filepath.Join(dest, "blobs", string(digest.Canonical)), 0755); err != nil {
+		return "", err
+	}
+
+	rc, err := archive.TarWithOptions(dir, &archive.TarOptions{})
+	if err != nil {
+		return "", fmt.Errorf("archiving %s failed: %v", dir, err)
+	}
+	defer rc.Close()
+	layer, err := layout.writeBlob(ocispec.MediaTypeImageLayer, rc)
+	if err != nil {
+		return "", fmt.Errorf("writing layer of %s failed: %v", dir, err)
+	}
+
+	// The layer is not compressed, so its digest is also its diff id.
+	config, err := layout.writeJSON(ocispec.MediaTypeImageConfig, ocispec.Image{
+		Architecture: runtime.GOARCH,
+		OS:           "linux",
+		RootFS:       ocispec.RootFS{Type: "layers", DiffIDs: []digest.Digest{layer.Digest}},
+	})
+	if err != nil {
+		return "", err
+	}
+	manifest, err := layout.writeJSON(ocispec.MediaTypeImageManifest, ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		Config:    config,
+		Layers:    []ocispec.Descriptor{layer},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	index, err := json.Marshal(ocispec.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		Manifests: []ocispec.Descriptor{manifest},
+	})
+	if err != nil {
+		return "", err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dest, "index.json"), index, 0644); err != nil {
+		return "", err
+	}
+	b, err := json.Marshal(ocispec.ImageLayout{Version: ocispec.ImageLayoutVersion})
+	if err != nil {
+		return "", err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dest, ocispec.ImageLayoutFile), b, 0644); err != nil {
+		return "", err
+	}
+	return manifest.Digest, nil
+}
+
+// importOCILayouts copies the images of the OCI layouts into the content
+// store, so builds resolve their digested references without pulling.
+func importOCILayouts(ctx context.Context, store content.Store, layouts map[string]string) error {
+	for ref, dir := range layouts {
+		named, err := reference.ParseNormalizedNamed(ref)
+		if err != nil {
+			return fmt.Errorf("parsing image name %q failed: %v", ref, err)
+		}
+		digested, ok := named.(reference.Digested)
+		if !ok {
+			return fmt.Errorf("image %s of OCI layout %s has no digest", ref, dir)
+		}
+		if err := importOCILayout(ctx, store, ociLayout(dir), digested.Digest()); err != nil {
+			return fmt.Errorf("loading %s from OCI layout %s failed: %v", digested.Digest(), dir, err)
+		}
+	}
+	return nil
+}
+
+// importOCILayout copies the blob with the digest and its children from the
+// layout into the content store. Layouts often hold only some of the
+// platforms of an index, so the manifests missing from the layout are
+// skipped.
+func importOCILayout(ctx context.Context, store content.Store, layout ociLayout, dgst digest.Digest) error {
+	root, err := layout.descriptor(dgst)
+	if err != nil {
+		return err
+	}
+
+	children := images.HandlerFunc(func(ctx context.Context, desc ocispec.Descriptor) ([]ocispec.Descriptor, error) {
+		descs, err := images.Children(ctx, store, desc)
+		if err != nil {
+			return nil, err
+		}
+		if desc.MediaType != images.MediaTypeDockerSchema2ManifestList && desc.MediaType != ocispec.MediaTypeImageIndex {
+			return descs, nil
+		}
+		present := []ocispec.Descriptor{}
+		for _, d := range descs {
+			if _, err := os.Stat(layout.blobPath(d.Digest)); err == nil {
+				present = append(present, d)
+			}
+		}
+		return present, nil
+	})
+
+	return images.Dispatch(ctx, images.Handlers(remotes.FetchHandler(store, layout), children), nil, root)
+}
+
+// ociLayout is the directory of an OCI image layout. It fetches the blobs of
+// the layout for remotes.FetchHandler.
+type ociLayout string
+
+func (l ociLayout) blobPath(dgst digest.Digest) string {
+	return filepath.Join(string(l), "blobs", dgst.Algorithm().String(), dgst.Hex())
+}
+
+// writeBlob writes the blob read from r to the layout and returns its
+// descriptor.
+func (l ociLayout) writeBlob(mediaType string, r io.Reader) (ocispec.Descriptor, error) {
+	f, err := ioutil.TempFile(filepath.Join(string(l), "blobs"), "blob-")
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	digester := digest.Canonical.Digester()
+	n, err := io.Copy(io.MultiWriter(f, digester.Hash()), r)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	if err := f.Close(); err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	desc := ocispec.Descriptor{MediaType: mediaType, Digest: digester.Digest(), Size: n}
+	if err := os.Rename(f.Name(), l.blobPath(desc.Digest)); err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	return desc, nil
+}
+
+// writeJSON writes v as a JSON blob to the layout and returns its descriptor.
+func (l ociLayout) writeJSON(mediaType string, v interface{}) (ocispec.Descriptor, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	return l.writeBlob(mediaType, bytes.NewReader(b))
+}
+
+// Fetch opens the blob of the descriptor.
+func (l ociLayout) Fetch(ctx context.Context, desc ocispec.Descriptor) (io.ReadCloser, error) {
+	return os.Open(l.blobPath(desc.Digest))
+}
+
+// descriptor returns the descriptor of the blob with the digest. It is taken
+// from the index of the layout if listed there, otherwise the media type is
+// detected from the blob, which must then be a manifest or an index.
+func (l ociLayout) descriptor(dgst digest.Digest) (ocispec.Descriptor, error) {
+	b, err := ioutil.ReadFile(filepath.Join(string(l), "index.json"))
+	if err != nil {
+		return ocispec.Descriptor{}, fmt.Errorf("reading index.json failed: %v", err)
+	}
+	var index ocispec.Index
+	if err := json.Unmarshal(b, &index); err != nil {
+		return ocispec.Descriptor{}, fmt.Errorf("parsing index.json failed: %v", err)
+	}
+	for _, desc := range index.Manifests {
+		if desc.Digest == dgst {
+			return desc, nil
+		}
+	}
+
+	b, err = ioutil.ReadFile(l.blobPath(dgst))
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	mediaType, err := imageutil.DetectManifestMediaType(bytesReaderAt(b))
+	if err != nil {
+		return ocispec.Descriptor{}, fmt.Errorf("detecting media type of %s failed: %v", dgst, err)
+	}
+	return ocispec.Descriptor{MediaType: mediaType, Digest: dgst, Size: int64(len(b))}, nil
+}
+
+// bytesReaderAt is a content.ReaderAt for a blob read into memory.
+type bytesReaderAt []byte
+
+func (b bytesReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off >= int64(len(b)) {
+		return 0, io.EOF
+	}
+	n := copy(p, b[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (b bytesReaderAt) Close() error { return nil }
+func (b bytesReaderAt) Size() int64  { return int64(len(b)) }
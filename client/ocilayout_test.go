@@ -0,0 +1,94 @@
+package client
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containerd/containerd/content/local"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/platforms"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestImportOCILayouts(t *testing.T) {
+	tmpd, err := ioutil.TempDir("", "img-oci-layout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpd)
+
+	// Write the layout with the blob layout of a local content store.
+	dir := filepath.Join(tmpd, "layout")
+	layout, err := local.NewStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest := func(arch string) ocispec.Descriptor {
+		config := writeJSONBlob(t, layout, ocispec.MediaTypeImageConfig, ocispec.Image{Architecture: arch, OS: "linux"})
+		desc := writeJSONBlob(t, layout, ocispec.MediaTypeImageManifest, ocispec.Manifest{
+			Versioned: specs.Versioned{SchemaVersion: 2},
+			Config:    config,
+		})
+		desc.Platform = &ocispec.Platform{OS: "linux", Architecture: arch}
+		return desc
+	}
+	amd64, arm64 := manifest("amd64"), manifest("arm64")
+	index := writeJSONBlob(t, layout, images.MediaTypeDockerSchema2ManifestList, ocispec.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		Manifests: []ocispec.Descriptor{amd64, arm64},
+	})
+	if err := ioutil.WriteFile(filepath.Join(dir, ocispec.ImageLayoutFile), []byte(`{"imageLayoutVersion":"1.0.0"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "index.json"), []byte(`{"schemaVersion":2,"manifests":[]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// Only keep the amd64 platform of the index in the layout.
+	if err := os.Remove(ociLayout(dir).blobPath(arm64.Digest)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ValidateOCILayout(dir, index.Digest); err != nil {
+		t.Fatalf("validating OCI layout failed: %v", err)
+	}
+	if err := ValidateOCILayout(dir, arm64.Digest); err == nil {
+		t.Fatal("expected validating a digest missing from the layout to fail but it did not")
+	}
+	if err := ValidateOCILayout(tmpd, index.Digest); err == nil {
+		t.Fatal("expected validating a directory that is not a layout to fail but it did not")
+	}
+
+	cs, err := local.NewStore(filepath.Join(tmpd, "content"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	ref := OCILayoutDomain + "/base@" + index.Digest.String()
+	if err := importOCILayouts(ctx, cs, map[string]string{ref: dir}); err != nil {
+		t.Fatalf("importing OCI layout failed: %v", err)
+	}
+
+	for _, desc := range []ocispec.Descriptor{index, amd64} {
+		if _, err := cs.Info(ctx, desc.Digest); err != nil {
+			t.Fatalf("expected %s to be imported: %v", desc.Digest, err)
+		}
+	}
+	if _, err := cs.Info(ctx, arm64.Digest); err == nil {
+		t.Fatal("expected the manifest missing from the layout to be skipped")
+	}
+
+	// The imported image resolves by digest without a registry.
+	config, err := images.Config(ctx, cs, index, platforms.Only(*amd64.Platform))
+	if err != nil {
+		t.Fatalf("resolving the config of the imported image failed: %v", err)
+	}
+	var m ocispec.Manifest
+	readJSONBlob(t, layout, amd64, &m)
+	if config.Digest != m.Config.Digest {
+		t.Fatalf("expected the config of the amd64 manifest %s, got: %s", m.Config.Digest, config.Digest)
+	}
+}
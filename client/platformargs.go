@@ -0,0 +1,178 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/containerd/containerd/platforms"
+	"github.com/moby/buildkit/exporter/containerimage/exptypes"
+	gwclient "github.com/moby/buildkit/frontend/gateway/client"
+	"golang.org/x/sync/errgroup"
+)
+
+// PlatformBuildArgPrefix is the prefix of the frontend attrs of the build args
+// scoped to a platform, e.g. "platform-build-arg:linux/arm64:GOARCH". The
+// platform is normalized.
+const PlatformBuildArgPrefix = "platform-build-arg:"
+
+// platformArgsBuild wraps the build of the dockerfile frontend so the build
+// args scoped to a platform are only set for the build of that platform, over
+// the build args of the same name for every platform. The frontend sets the
+// same build args for every platform, so a multi-platform build with scoped
+// args is built one platform at a time and the results are merged.
+func platformArgsBuild(build gwclient.BuildFunc) gwclient.BuildFunc {
+	return func(ctx context.Context, c gwclient.Client) (*gwclient.Result, error) {
+		opts := c.BuildOpts().Opts
+		if !hasPlatformBuildArgs(opts) {
+			return build(ctx, c)
+		}
+
+		targets, err := targetPlatforms(c.BuildOpts(), opts["platform"])
+		if err != nil {
+			return nil, err
+		}
+		if len(targets) == 1 {
+			return build(ctx, &optsClient{Client: c, opts: platformOpts(opts, targets[0], false)})
+		}
+
+		results := make([]*gwclient.Result, len(targets))
+		eg, ctx := errgroup.WithContext(ctx)
+		for i, target := range targets {
+			i, target := i, target
+			eg.Go(func() error {
+				res, err := build(ctx, &optsClient{Client: c, opts: platformOpts(opts, target, true)})
+				if err != nil {
+					return err
+				}
+				results[i] = res
+				return nil
+			})
+		}
+		if err := eg.Wait(); err != nil {
+			return nil, err
+		}
+		return mergePlatformResults(results)
+	}
+}
+
+// optsClient is a gateway client with other frontend opts.
+type optsClient struct {
+	gwclient.Client
+	opts map[string]string
+}
+
+func (c *optsClient) BuildOpts() gwclient.BuildOpts {
+	opts := c.Client.BuildOpts()
+	opts.Opts = c.opts
+	return opts
+}
+
+func hasPlatformBuildArgs(opts map[string]string) bool {
+	for k := range opts {
+		if strings.HasPrefix(k, PlatformBuildArgPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// targetPlatforms returns the normalized platforms of the platform opt, the
+// default platform of the worker if it is empty like the frontend does.
+func targetPlatforms(bopts gwclient.BuildOpts, value string) ([]string, error) {
+	if value == "" {
+		p := HostPlatform()
+		if len(bopts.Workers) > 0 && len(bopts.Workers[0].Platforms) > 0 {
+			p = bopts.Workers[0].Platforms[0]
+		}
+		return []string{platforms.Format(platforms.Normalize(p))}, nil
+	}
+
+	var targets []string
+	for _, v := range strings.Split(value, ",") {
+		p, err := platforms.Parse(v)
+		if err != nil {
+			return nil, fmt.Errorf("parsing platform %s failed: %v", v, err)
+		}
+		targets = append(targets, platforms.Format(platforms.Normalize(p)))
+	}
+	return targets, nil
+}
+
+// platformOpts returns the frontend opts of the build of the platform, with
+// its scoped build args set over the others and the scoped args of every
+// platform removed. multiPlatform makes the frontend return the result keyed
+// by platform so it can be merged with the others.
+func platformOpts(opts map[string]string, platform string, multiPlatform bool) map[string]string {
+	po := map[string]string{}
+	scoped := map[string]string{}
+	for k, v := range opts {
+		if !strings.HasPrefix(k, PlatformBuildArgPrefix) {
+			po[k] = v
+			continue
+		}
+		rest := strings.TrimPrefix(k, PlatformBuildArgPrefix)
+		i := strings.LastIndex(rest, ":")
+		if i < 0 || rest[:i] != platform {
+			continue
+		}
+		scoped["build-arg:"+rest[i+1:]] = v
+	}
+	for k, v := range scoped {
+		po[k] = v
+	}
+	po["platform"] = platform
+	if multiPlatform {
+		po["multi-platform"] = "true"
+	}
+	return po
+}
+
+// mergePlatformResults merges the results of the builds of each platform in
+// the order of the platforms.
+func mergePlatformResults(results []*gwclient.Result) (*gwclient.Result, error) {
+	merged := gwclient.NewResult()
+	var ps exptypes.Platforms
+	for _, res := range results {
+		for k, ref := range res.Refs {
+			merged.AddRef(k, ref)
+		}
+		for k, v := range res.Metadata {
+			if k != exptypes.ExporterPlatformsKey {
+				merged.AddMeta(k, v)
+				continue
+			}
+			var p exptypes.Platforms
+			if err := json.Unmarshal(v, &p); err != nil {
+				return nil, fmt.Errorf("parsing the platforms of the result failed: %v", err)
+			}
+			ps.Platforms = append(ps.Platforms, p.Platforms...)
+		}
+	}
+
+	dt, err := json.Marshal(ps)
+	if err != nil {
+		return nil, err
+	}
+	merged.AddMeta(exptypes.ExporterPlatformsKey, dt)
+	return merged, nil
+}
+
+// ParsePlatformBuildArg splits a build arg name scoped to a platform, such as
+// linux/arm64:GOARCH, into the name and the normalized platform, which is
+// empty for the build args of every platform.
+func ParsePlatformBuildArg(name string) (string, string, error) {
+	i := strings.LastIndex(name, ":")
+	if i < 0 {
+		return name, "", nil
+	}
+	p, err := platforms.Parse(name[:i])
+	if err != nil {
+		return "", "", fmt.Errorf("invalid platform of build-arg %s: %v", name, err)
+	}
+	if name[i+1:] == "" {
+		return "", "", fmt.Errorf("invalid build-arg %s, missing the name after the platform", name)
+	}
+	return name[i+1:], platforms.Format(platforms.Normalize(p)), nil
+}
@@ -0,0 +1,65 @@
+package client
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParsePlatformBuildArg(t *testing.T) {
+	for arg, expected := range map[string][2]string{
+		"GOARCH":               {"GOARCH", ""},
+		"linux/arm64:GOARCH":   {"GOARCH", "linux/arm64"},
+		"linux/aarch64:GOARCH": {"GOARCH", "linux/arm64"},
+		"linux/arm/v7:GOARM":   {"GOARM", "linux/arm/v7"},
+	} {
+		name, platform, err := ParsePlatformBuildArg(arg)
+		if err != nil {
+			t.Fatalf("parsing build-arg %s failed: %v", arg, err)
+		}
+		if name != expected[0] || platform != expected[1] {
+			t.Fatalf("expected build-arg %s to be %s for %q, got: %s for %q", arg, expected[0], expected[1], name, platform)
+		}
+	}
+
+	for _, invalid := range []string{"linux/arm64:", "not a platform:GOARCH"} {
+		if _, _, err := ParsePlatformBuildArg(invalid); err == nil {
+			t.Fatalf("expected parsing build-arg %s to fail but it did not", invalid)
+		}
+	}
+}
+
+func TestPlatformOpts(t *testing.T) {
+	opts := map[string]string{
+		"platform":          "linux/amd64,linux/arm64",
+		"build-arg:GOARCH":  "global",
+		"build-arg:VERSION": "1.0.0",
+		PlatformBuildArgPrefix + "linux/arm64:GOARCH": "arm64",
+		PlatformBuildArgPrefix + "linux/arm64:CC":     "aarch64-linux-gnu-gcc",
+	}
+
+	// The platform without scoped args falls back to the global ones.
+	expected := map[string]string{
+		"platform":          "linux/amd64",
+		"multi-platform":    "true",
+		"build-arg:GOARCH":  "global",
+		"build-arg:VERSION": "1.0.0",
+	}
+	if got := platformOpts(opts, "linux/amd64", true); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected the opts of linux/amd64 to be %v, got: %v", expected, got)
+	}
+
+	expected = map[string]string{
+		"platform":          "linux/arm64",
+		"multi-platform":    "true",
+		"build-arg:GOARCH":  "arm64",
+		"build-arg:VERSION": "1.0.0",
+		"build-arg:CC":      "aarch64-linux-gnu-gcc",
+	}
+	if got := platformOpts(opts, "linux/arm64", true); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected the opts of linux/arm64 to be %v, got: %v", expected, got)
+	}
+
+	if got := platformOpts(opts, "linux/arm64", false); got["multi-platform"] != "" {
+		t.Fatalf("expected a single platform build to keep a single result, got: %v", got)
+	}
+}
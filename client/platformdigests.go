@@ -0,0 +1,109 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/platforms"
+	"github.com/moby/buildkit/exporter"
+	"github.com/moby/buildkit/util/imageutil"
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+const (
+	// ExporterImageDigest is the key of the exporter response holding the
+	// digest of the exported index or manifest.
+	ExporterImageDigest = "containerimage.digest"
+	// ExporterPlatformDigests is the key of the exporter response holding
+	// the JSON map of the platforms of the exported image to the digests of
+	// their manifests.
+	ExporterPlatformDigests = "img.platform.digests"
+)
+
+// platformDigestsExporter adds the digests of the manifest of each platform
+// of the exported image to the exporter response.
+type platformDigestsExporter struct {
+	exporter.Exporter
+
+	provider content.Provider
+}
+
+func (e *platformDigestsExporter) Resolve(ctx context.Context, opt map[string]string) (exporter.ExporterInstance, error) {
+	inst, err := e.Exporter.Resolve(ctx, opt)
+	if err != nil {
+		return nil, err
+	}
+	return &platformDigestsInstance{ExporterInstance: inst, provider: e.provider}, nil
+}
+
+type platformDigestsInstance struct {
+	exporter.ExporterInstance
+
+	provider content.Provider
+}
+
+func (e *platformDigestsInstance) Export(ctx context.Context, src exporter.Source) (map[string]string, error) {
+	resp, err := e.ExporterInstance.Export(ctx, src)
+	if err != nil || resp[ExporterImageDigest] == "" {
+		return resp, err
+	}
+
+	digests, err := platformDigests(ctx, e.provider, digest.Digest(resp[ExporterImageDigest]))
+	if err != nil {
+		return nil, fmt.Errorf("reading platform digests failed: %v", err)
+	}
+	b, err := json.Marshal(digests)
+	if err != nil {
+		return nil, err
+	}
+	resp[ExporterPlatformDigests] = string(b)
+	return resp, nil
+}
+
+// platformDigests returns the digest of the manifest of each platform of the
+// index or manifest with the digest. A manifest is a single platform, taken
+// from its config.
+func platformDigests(ctx context.Context, provider content.Provider, dgst digest.Digest) (map[string]digest.Digest, error) {
+	b, err := content.ReadBlob(ctx, provider, ocispec.Descriptor{Digest: dgst})
+	if err != nil {
+		return nil, err
+	}
+	mediaType, err := imageutil.DetectManifestMediaType(bytesReaderAt(b))
+	if err != nil {
+		return nil, err
+	}
+
+	digests := map[string]digest.Digest{}
+	switch mediaType {
+	case images.MediaTypeDockerSchema2ManifestList, ocispec.MediaTypeImageIndex:
+		var index ocispec.Index
+		if err := json.Unmarshal(b, &index); err != nil {
+			return nil, err
+		}
+		for _, m := range index.Manifests {
+			if m.Platform != nil {
+				digests[platforms.Format(platforms.Normalize(*m.Platform))] = m.Digest
+			}
+		}
+	default:
+		var manifest ocispec.Manifest
+		if err := json.Unmarshal(b, &manifest); err != nil {
+			return nil, err
+		}
+		cb, err := content.ReadBlob(ctx, provider, manifest.Config)
+		if err != nil {
+			return nil, err
+		}
+		var config ocispec.Image
+		if err := json.Unmarshal(cb, &config); err != nil {
+			return nil, err
+		}
+		p := ocispec.Platform{OS: config.OS, Architecture: config.Architecture}
+		digests[platforms.Format(platforms.Normalize(p))] = dgst
+	}
+	return digests, nil
+}
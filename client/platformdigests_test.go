@@ -0,0 +1,63 @@
+package client
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/containerd/containerd/content/local"
+	"github.com/containerd/containerd/images"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestPlatformDigests(t *testing.T) {
+	tmpd, err := ioutil.TempDir("", "img-platform-digests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpd)
+
+	cs, err := local.NewStore(tmpd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	manifest := func(arch, variant string) ocispec.Descriptor {
+		config := writeJSONBlob(t, cs, ocispec.MediaTypeImageConfig, ocispec.Image{Architecture: arch, OS: "linux"})
+		desc := writeJSONBlob(t, cs, ocispec.MediaTypeImageManifest, ocispec.Manifest{
+			Versioned: specs.Versioned{SchemaVersion: 2},
+			Config:    config,
+		})
+		desc.Platform = &ocispec.Platform{OS: "linux", Architecture: arch, Variant: variant}
+		return desc
+	}
+	amd64, armv7 := manifest("amd64", ""), manifest("arm", "v7")
+	index := writeJSONBlob(t, cs, images.MediaTypeDockerSchema2ManifestList, ocispec.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		Manifests: []ocispec.Descriptor{amd64, armv7},
+	})
+
+	digests, err := platformDigests(ctx, cs, index.Digest)
+	if err != nil {
+		t.Fatalf("getting platform digests of index failed: %v", err)
+	}
+	expected := map[string]digest.Digest{"linux/amd64": amd64.Digest, "linux/arm/v7": armv7.Digest}
+	if !reflect.DeepEqual(digests, expected) {
+		t.Fatalf("expected digests %v, got: %v", expected, digests)
+	}
+
+	// A single platform image has the platform of its config.
+	digests, err = platformDigests(ctx, cs, amd64.Digest)
+	if err != nil {
+		t.Fatalf("getting platform digests of manifest failed: %v", err)
+	}
+	expected = map[string]digest.Digest{"linux/amd64": amd64.Digest}
+	if !reflect.DeepEqual(digests, expected) {
+		t.Fatalf("expected digests %v, got: %v", expected, digests)
+	}
+}
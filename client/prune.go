@@ -10,10 +10,9 @@ import (
 	"golang.org/x/sync/errgroup"
 )
 
-// Prune calls Prune on the worker.
-func (c *Client) Prune(ctx context.Context) ([]*controlapi.UsageRecord, error) {
-	ch := make(chan client.UsageInfo)
-
+// Prune calls Prune on the worker with the given prune options, leaving out
+// the records kept by builds with --keep-days.
+func (c *Client) Prune(ctx context.Context, opts ...client.PruneInfo) ([]*controlapi.UsageRecord, error) {
 	// Create the worker opts.
 	opt, err := c.createWorkerOpt(false)
 	if err != nil {
@@ -26,10 +25,20 @@ func (c *Client) Prune(ctx context.Context) ([]*controlapi.UsageRecord, error) {
 		return nil, fmt.Errorf("creating worker failed: %v", err)
 	}
 
+	return c.prune(ctx, w, opts...)
+}
+
+func (c *Client) prune(ctx context.Context, w *base.Worker, opts ...client.PruneInfo) ([]*controlapi.UsageRecord, error) {
+	opts, err := keepPruneInfo(c.root, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	ch := make(chan client.UsageInfo)
 	eg, ctx := errgroup.WithContext(ctx)
 	eg.Go(func() error {
 		// Call prune on the worker.
-		return w.Prune(ctx, ch)
+		return w.Prune(ctx, ch, opts...)
 	})
 
 	eg2, ctx := errgroup.WithContext(ctx)
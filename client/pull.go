@@ -3,14 +3,22 @@ package client
 import (
 	"context"
 	"fmt"
+	"sync"
+	"time"
 
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/images"
 	"github.com/containerd/containerd/platforms"
+	"github.com/containerd/containerd/remotes"
 	"github.com/docker/distribution/reference"
 	"github.com/moby/buildkit/cache"
 	"github.com/moby/buildkit/exporter"
 	imageexporter "github.com/moby/buildkit/exporter/containerimage"
 	"github.com/moby/buildkit/source"
 	"github.com/moby/buildkit/source/containerimage"
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
 // Pull retrieves an image from a remote registry.
@@ -106,3 +114,87 @@ func (c *Client) Pull(ctx context.Context, image string) (*ListedImage, error) {
 
 	return &ListedImage{Image: img, ContentSize: size}, nil
 }
+
+// PullAllPlatforms retrieves the manifest list of an image and every
+// manifest, config and layer it references from the registry, and stores the
+// image as the manifest list. Saving it or pushing it to another registry
+// then works offline for any of its platforms. The layers are not unpacked,
+// the size is the total of the content pulled.
+func (c *Client) PullAllPlatforms(ctx context.Context, image string) (*ListedImage, error) {
+	// Parse the image name and tag.
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return nil, fmt.Errorf("parsing image name %q failed: %v", image, err)
+	}
+	// Add the latest lag if they did not provide one.
+	named = reference.TagNameOnly(named)
+	image = named.String()
+
+	// Create the worker opts.
+	opt, err := c.createWorkerOpt(false)
+	if err != nil {
+		return nil, fmt.Errorf("creating worker opt failed: %v", err)
+	}
+
+	r, err := c.resolver()
+	if err != nil {
+		return nil, err
+	}
+	name, desc, err := r.Resolve(ctx, image)
+	if err != nil {
+		return nil, fmt.Errorf("resolving %s failed: %v", image, err)
+	}
+	fetcher, err := r.Fetcher(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	size, err := fetchAll(ctx, opt.ContentStore, fetcher, desc)
+	if err != nil {
+		return nil, fmt.Errorf("pulling %s failed: %v", image, err)
+	}
+
+	// Update the image. Create it if it does not exist.
+	img := images.Image{
+		Name:      image,
+		Target:    desc,
+		CreatedAt: time.Now(),
+	}
+	if _, err := opt.ImageStore.Update(ctx, img); err != nil {
+		if !errdefs.IsNotFound(err) {
+			return nil, fmt.Errorf("updating image store for %s failed: %v", image, err)
+		}
+
+		// Create it if we didn't find it.
+		if _, err := opt.ImageStore.Create(ctx, img); err != nil {
+			return nil, fmt.Errorf("creating image in image store for %s failed: %v", image, err)
+		}
+	}
+
+	return &ListedImage{Image: img, ContentSize: size}, nil
+}
+
+// fetchAll fetches the descriptor and all of its children, the manifests of
+// every platform of a manifest list included, into the content store. The
+// parents are labeled with their children so they are kept together by the
+// garbage collection. It returns the total size of the distinct blobs.
+func fetchAll(ctx context.Context, store content.Store, fetcher remotes.Fetcher, desc ocispec.Descriptor) (int64, error) {
+	var (
+		mu   sync.Mutex
+		size int64
+		seen = map[digest.Digest]bool{}
+	)
+	count := images.HandlerFunc(func(ctx context.Context, desc ocispec.Descriptor) ([]ocispec.Descriptor, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if !seen[desc.Digest] {
+			seen[desc.Digest] = true
+			size += desc.Size
+		}
+		return nil, nil
+	})
+	children := images.SetChildrenLabels(store, images.ChildrenHandler(store))
+	if err := images.Dispatch(ctx, images.Handlers(count, remotes.FetchHandler(store, fetcher), children), nil, desc); err != nil {
+		return 0, err
+	}
+	return size, nil
+}
@@ -0,0 +1,147 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/content/local"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/platforms"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// storeFetcher fetches the blobs of a content store like a registry.
+type storeFetcher struct {
+	content.Provider
+}
+
+func (f storeFetcher) Fetch(ctx context.Context, desc ocispec.Descriptor) (io.ReadCloser, error) {
+	ra, err := f.ReaderAt(ctx, desc)
+	if err != nil {
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{content.NewReader(ra), ra}, nil
+}
+
+// syncLabelStore is a memoryLabelStore safe for the concurrent fetches.
+type syncLabelStore struct {
+	mu sync.Mutex
+	s  memoryLabelStore
+}
+
+func (s *syncLabelStore) Get(d digest.Digest) (map[string]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.s.Get(d)
+}
+
+func (s *syncLabelStore) Set(d digest.Digest, labels map[string]string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.s.Set(d, labels)
+}
+
+func (s *syncLabelStore) Update(d digest.Digest, update map[string]string) (map[string]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.s.Update(d, update)
+}
+
+func TestFetchAll(t *testing.T) {
+	tmpd, err := ioutil.TempDir("", "img-pull")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpd)
+
+	// Write a multi-platform image sharing a layer between its platforms in
+	// the store of the registry.
+	registry, err := local.NewStore(filepath.Join(tmpd, "registry"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	writeBlob := func(b []byte) ocispec.Descriptor {
+		desc := ocispec.Descriptor{MediaType: ocispec.MediaTypeImageLayer, Digest: digest.FromBytes(b), Size: int64(len(b))}
+		if err := content.WriteBlob(ctx, registry, desc.Digest.String(), bytes.NewReader(b), desc); err != nil {
+			t.Fatal(err)
+		}
+		return desc
+	}
+	shared := writeBlob([]byte("shared layer"))
+	blobs := []ocispec.Descriptor{shared}
+	var manifests []ocispec.Descriptor
+	for _, p := range []ocispec.Platform{
+		{OS: "linux", Architecture: "amd64"},
+		{OS: "linux", Architecture: "arm64"},
+		{OS: "linux", Architecture: "arm", Variant: "v7"},
+	} {
+		config := writeJSONBlob(t, registry, ocispec.MediaTypeImageConfig, ocispec.Image{OS: p.OS, Architecture: p.Architecture})
+		layer := writeBlob([]byte(fmt.Sprintf("layer of %s/%s", p.Architecture, p.Variant)))
+		desc := writeJSONBlob(t, registry, ocispec.MediaTypeImageManifest, ocispec.Manifest{
+			Versioned: specs.Versioned{SchemaVersion: 2},
+			Config:    config,
+			Layers:    []ocispec.Descriptor{shared, layer},
+		})
+		p := p
+		desc.Platform = &p
+		manifests = append(manifests, desc)
+		blobs = append(blobs, config, layer, desc)
+	}
+	index := writeJSONBlob(t, registry, ocispec.MediaTypeImageIndex, ocispec.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		Manifests: manifests,
+	})
+	blobs = append(blobs, index)
+
+	cs, err := local.NewLabeledStore(filepath.Join(tmpd, "content"), &syncLabelStore{s: memoryLabelStore{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	size, err := fetchAll(ctx, cs, storeFetcher{registry}, index)
+	if err != nil {
+		t.Fatalf("fetching all platforms failed: %v", err)
+	}
+
+	var expected int64
+	for _, desc := range blobs {
+		if _, err := cs.Info(ctx, desc.Digest); err != nil {
+			t.Fatalf("expected %s (%s) to be fetched: %v", desc.Digest, desc.MediaType, err)
+		}
+		expected += desc.Size
+	}
+	if size != expected {
+		t.Fatalf("expected the size of the distinct blobs %d, got: %d", expected, size)
+	}
+
+	// The manifest list is labeled with its manifests for the gc.
+	info, err := cs.Info(ctx, index.Digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, m := range manifests {
+		if label := info.Labels[fmt.Sprintf("containerd.io/gc.ref.content.%d", i)]; label != m.Digest.String() {
+			t.Fatalf("expected the manifest list to reference manifest %s, got: %q", m.Digest, label)
+		}
+	}
+
+	// Every platform resolves from the store without the registry.
+	for _, m := range manifests {
+		if _, err := images.Config(ctx, cs, index, platforms.Only(*m.Platform)); err != nil {
+			t.Fatalf("resolving the config of %s/%s from the store failed: %v", m.Platform.OS, m.Platform.Architecture, err)
+		}
+	}
+}
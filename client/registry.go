@@ -0,0 +1,210 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+
+	"github.com/containerd/containerd/content/local"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/platforms"
+	"github.com/containerd/containerd/remotes"
+	"github.com/containerd/containerd/remotes/docker"
+	"github.com/docker/distribution/reference"
+	"github.com/moby/buildkit/util/imageutil"
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// resolver returns a registry resolver using the same credentials as the
+// session auth provider.
+func (c *Client) resolver() (remotes.Resolver, error) {
+	registryAuth, err := c.registryAuths()
+	if err != nil {
+		return nil, err
+	}
+	tokens, err := c.registryTokens()
+	if err != nil {
+		return nil, err
+	}
+	ap := newAuthProvider(registryAuth)
+
+	return docker.NewResolver(docker.ResolverOptions{
+		Client:     http.DefaultClient,
+		Authorizer: &tokenAuthorizer{tokens: tokens, next: c.authorizer(ap)},
+	}), nil
+}
+
+// fetchManifest resolves the image in the registry and returns the
+// descriptor and contents of its root manifest.
+func (c *Client) fetchManifest(ctx context.Context, image string) (ocispec.Descriptor, []byte, error) {
+	// Parse the image name and tag.
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return ocispec.Descriptor{}, nil, fmt.Errorf("parsing image name %q failed: %v", image, err)
+	}
+	// Add the latest lag if they did not provide one.
+	named = reference.TagNameOnly(named)
+	image = named.String()
+
+	r, err := c.resolver()
+	if err != nil {
+		return ocispec.Descriptor{}, nil, err
+	}
+	name, desc, err := r.Resolve(ctx, image)
+	if err != nil {
+		return ocispec.Descriptor{}, nil, fmt.Errorf("resolving %s failed: %v", image, err)
+	}
+	fetcher, err := r.Fetcher(ctx, name)
+	if err != nil {
+		return desc, nil, err
+	}
+	rc, err := fetcher.Fetch(ctx, desc)
+	if err != nil {
+		return desc, nil, fmt.Errorf("fetching manifest for %s failed: %v", image, err)
+	}
+	defer rc.Close()
+
+	b, err := ioutil.ReadAll(rc)
+	if err != nil {
+		return desc, nil, fmt.Errorf("reading manifest for %s failed: %v", image, err)
+	}
+	return desc, b, nil
+}
+
+// ResolveDigest resolves the image in the registry and returns the digest of
+// its root manifest, the manifest list of a multi-platform image.
+func (c *Client) ResolveDigest(ctx context.Context, image string) (digest.Digest, error) {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return "", fmt.Errorf("parsing image name %q failed: %v", image, err)
+	}
+	image = reference.TagNameOnly(named).String()
+
+	r, err := c.resolver()
+	if err != nil {
+		return "", err
+	}
+	_, desc, err := r.Resolve(ctx, image)
+	if err != nil {
+		return "", fmt.Errorf("resolving %s failed: %v", image, err)
+	}
+	return desc.Digest, nil
+}
+
+// ImagePlatforms returns the platforms of the manifest list of an image in
+// the registry. It errors if the image is a single platform image.
+func (c *Client) ImagePlatforms(ctx context.Context, image string) ([]string, error) {
+	desc, b, err := c.fetchManifest(ctx, image)
+	if err != nil {
+		return nil, err
+	}
+
+	switch desc.MediaType {
+	case images.MediaTypeDockerSchema2ManifestList, ocispec.MediaTypeImageIndex:
+	default:
+		return nil, fmt.Errorf("%s is a single platform image (%s)", image, desc.MediaType)
+	}
+
+	return platformsFromIndex(b)
+}
+
+// IndexPlatforms returns the normalized platform of every manifest in the
+// manifest list of an image in the registry, in order and including the
+// platforms of several manifests. It returns nil for a single platform image.
+func (c *Client) IndexPlatforms(ctx context.Context, image string) ([]string, error) {
+	desc, b, err := c.fetchManifest(ctx, image)
+	if err != nil {
+		return nil, err
+	}
+
+	switch desc.MediaType {
+	case images.MediaTypeDockerSchema2ManifestList, ocispec.MediaTypeImageIndex:
+	default:
+		return nil, nil
+	}
+
+	return indexPlatforms(b)
+}
+
+// platformsFromIndex returns the normalized platforms of the manifests in a
+// manifest list or OCI index.
+func platformsFromIndex(b []byte) ([]string, error) {
+	all, err := indexPlatforms(b)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+	var ps []string
+	for _, p := range all {
+		if seen[p] {
+			continue
+		}
+		seen[p] = true
+		ps = append(ps, p)
+	}
+	if len(ps) < 1 {
+		return nil, fmt.Errorf("manifest list contains no platforms")
+	}
+	return ps, nil
+}
+
+// indexPlatforms returns the normalized platform of each manifest in a
+// manifest list or OCI index.
+func indexPlatforms(b []byte) ([]string, error) {
+	var index ocispec.Index
+	if err := json.Unmarshal(b, &index); err != nil {
+		return nil, fmt.Errorf("parsing manifest list failed: %v", err)
+	}
+
+	ps := []string{}
+	for _, m := range index.Manifests {
+		// Skip manifests without a platform, such as attestations.
+		if m.Platform == nil || m.Platform.OS == "" || m.Platform.OS == "unknown" {
+			continue
+		}
+		ps = append(ps, platforms.Format(platforms.Normalize(*m.Platform)))
+	}
+	return ps, nil
+}
+
+// ImageConfig fetches the config of an image in the registry for the given
+// platform, the default platform is used if it is nil. It returns the digest
+// of the root manifest with the raw config.
+func (c *Client) ImageConfig(ctx context.Context, image string, platform *ocispec.Platform) (digest.Digest, []byte, error) {
+	// Parse the image name and tag.
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return "", nil, fmt.Errorf("parsing image name %q failed: %v", image, err)
+	}
+	// Add the latest lag if they did not provide one.
+	named = reference.TagNameOnly(named)
+	image = named.String()
+
+	r, err := c.resolver()
+	if err != nil {
+		return "", nil, err
+	}
+
+	// Use a throwaway content store so we do not have to open the metadata
+	// database the build is going to use.
+	tmpDir, err := ioutil.TempDir("", "img-resolve-")
+	if err != nil {
+		return "", nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+	cache, err := local.NewStore(tmpDir)
+	if err != nil {
+		return "", nil, fmt.Errorf("creating content store failed: %v", err)
+	}
+
+	dgst, config, err := imageutil.Config(ctx, image, r, cache, platform)
+	if err != nil {
+		return "", nil, fmt.Errorf("fetching config for %s failed: %v", image, err)
+	}
+	return dgst, config, nil
+}
@@ -0,0 +1,70 @@
+package client
+
+import (
+	"reflect"
+	"testing"
+)
+
+const fakeManifestList = `{
+  "schemaVersion": 2,
+  "mediaType": "application/vnd.docker.distribution.manifest.list.v2+json",
+  "manifests": [
+    {
+      "mediaType": "application/vnd.docker.distribution.manifest.v2+json",
+      "digest": "sha256:0000000000000000000000000000000000000000000000000000000000000001",
+      "size": 528,
+      "platform": {"architecture": "amd64", "os": "linux"}
+    },
+    {
+      "mediaType": "application/vnd.docker.distribution.manifest.v2+json",
+      "digest": "sha256:0000000000000000000000000000000000000000000000000000000000000002",
+      "size": 528,
+      "platform": {"architecture": "arm", "os": "linux", "variant": "v7"}
+    },
+    {
+      "mediaType": "application/vnd.docker.distribution.manifest.v2+json",
+      "digest": "sha256:0000000000000000000000000000000000000000000000000000000000000003",
+      "size": 528,
+      "platform": {"architecture": "aarch64", "os": "linux"}
+    },
+    {
+      "mediaType": "application/vnd.oci.image.manifest.v1+json",
+      "digest": "sha256:0000000000000000000000000000000000000000000000000000000000000004",
+      "size": 528,
+      "platform": {"architecture": "unknown", "os": "unknown"}
+    }
+  ]
+}`
+
+func TestPlatformsFromIndex(t *testing.T) {
+	ps, err := platformsFromIndex([]byte(fakeManifestList))
+	if err != nil {
+		t.Fatalf("getting platforms from manifest list failed: %v", err)
+	}
+
+	expected := []string{"linux/amd64", "linux/arm/v7", "linux/arm64"}
+	if !reflect.DeepEqual(ps, expected) {
+		t.Fatalf("expected platforms %v, got: %v", expected, ps)
+	}
+
+	if _, err := platformsFromIndex([]byte(`{"schemaVersion": 2, "manifests": []}`)); err == nil {
+		t.Fatal("expected a manifest list without platforms to fail but it did not")
+	}
+}
+
+func TestIndexPlatforms(t *testing.T) {
+	ps, err := indexPlatforms([]byte(`{"schemaVersion": 2, "manifests": [
+    {"platform": {"architecture": "arm", "os": "linux"}},
+    {"platform": {"architecture": "arm", "os": "linux", "variant": "v7"}},
+    {"platform": {"architecture": "amd64", "os": "linux"}}
+  ]}`))
+	if err != nil {
+		t.Fatalf("getting platforms from manifest list failed: %v", err)
+	}
+
+	// Every manifest is listed, so a platform of several manifests shows up.
+	expected := []string{"linux/arm/v7", "linux/arm/v7", "linux/amd64"}
+	if !reflect.DeepEqual(ps, expected) {
+		t.Fatalf("expected platforms %v, got: %v", expected, ps)
+	}
+}
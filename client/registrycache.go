@@ -0,0 +1,91 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/containerd/containerd/remotes"
+	"github.com/containerd/containerd/remotes/docker"
+	"github.com/docker/distribution/reference"
+	"github.com/moby/buildkit/cache/remotecache"
+	"github.com/moby/buildkit/session"
+	"github.com/moby/buildkit/session/auth"
+	"github.com/moby/buildkit/util/contentutil"
+	"github.com/moby/buildkit/util/resolver"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// CacheRegistry is the type of the cache import and export that read and
+// write the build cache as a manifest in a registry, named by the ref attr.
+const CacheRegistry = "registry"
+
+// resolveRegistryCacheExporter returns the func resolving the registry cache
+// exports, which push the cache of the build to the ref attr on Finalize.
+func resolveRegistryCacheExporter(sm *session.Manager, rfn resolver.ResolveOptionsFunc) remotecache.ResolveCacheExporterFunc {
+	return func(ctx context.Context, attrs map[string]string) (remotecache.Exporter, error) {
+		ref, err := registryCacheRef(attrs)
+		if err != nil {
+			return nil, err
+		}
+		pusher, err := registryCacheResolver(ctx, sm, rfn, ref).Pusher(ctx, ref)
+		if err != nil {
+			return nil, fmt.Errorf("creating pusher for cache %s failed: %v", ref, err)
+		}
+		return remotecache.NewExporter(contentutil.FromPusher(pusher)), nil
+	}
+}
+
+// resolveRegistryCacheImporter returns the func resolving the registry cache
+// imports, which fetch the cache manifest of the ref attr.
+func resolveRegistryCacheImporter(sm *session.Manager, rfn resolver.ResolveOptionsFunc) remotecache.ResolveCacheImporterFunc {
+	return func(ctx context.Context, attrs map[string]string) (remotecache.Importer, ocispec.Descriptor, error) {
+		ref, err := registryCacheRef(attrs)
+		if err != nil {
+			return nil, ocispec.Descriptor{}, err
+		}
+		r := registryCacheResolver(ctx, sm, rfn, ref)
+		name, desc, err := r.Resolve(ctx, ref)
+		if err != nil {
+			return nil, ocispec.Descriptor{}, fmt.Errorf("resolving cache %s failed: %v", ref, err)
+		}
+		fetcher, err := r.Fetcher(ctx, name)
+		if err != nil {
+			return nil, ocispec.Descriptor{}, err
+		}
+		return remotecache.NewImporter(contentutil.FromFetcher(fetcher)), desc, nil
+	}
+}
+
+// registryCacheRef returns the normalized ref attr, with the latest tag if it
+// has none.
+func registryCacheRef(attrs map[string]string) (string, error) {
+	ref, ok := attrs["ref"]
+	if !ok || ref == "" {
+		return "", fmt.Errorf("the registry cache needs a ref")
+	}
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return "", fmt.Errorf("parsing cache ref %q failed: %v", ref, err)
+	}
+	return reference.TagNameOnly(named).String(), nil
+}
+
+// registryCacheResolver returns a resolver for the ref with the resolve
+// options of the worker and the credentials of the session of the build.
+func registryCacheResolver(ctx context.Context, sm *session.Manager, rfn resolver.ResolveOptionsFunc, ref string) remotes.Resolver {
+	opt := rfn(ref)
+	if id := session.FromContext(ctx); id != "" {
+		opt.Credentials = func(host string) (string, string, error) {
+			timeoutCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			caller, err := sm.Get(timeoutCtx, id)
+			if err != nil {
+				return "", "", err
+			}
+			return auth.CredentialsFunc(context.TODO(), caller)(host)
+		}
+	}
+	return docker.NewResolver(opt)
+}
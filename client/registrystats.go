@@ -0,0 +1,93 @@
+package client
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/containerd/containerd/remotes/docker"
+	"github.com/moby/buildkit/util/resolver"
+)
+
+// RegistryStats are the bytes of the blobs the builds of the client pulled
+// from and pushed to registries.
+type RegistryStats struct {
+	Pulled int64
+	Pushed int64
+}
+
+// RegistryStats returns the bytes of the blobs pulled and pushed so far.
+func (c *Client) RegistryStats() RegistryStats {
+	if c.registryBytes == nil {
+		return RegistryStats{}
+	}
+	return RegistryStats{
+		Pulled: atomic.LoadInt64(&c.registryBytes.pulled),
+		Pushed: atomic.LoadInt64(&c.registryBytes.pushed),
+	}
+}
+
+// registryCounter counts the bytes of the blobs read from and written to
+// registries by the resolvers of the worker.
+type registryCounter struct {
+	pulled int64
+	pushed int64
+}
+
+// countingResolveOptions wraps the resolve options of the worker so the
+// bytes of the blobs are counted by the transport of their http client.
+func countingResolveOptions(rfn resolver.ResolveOptionsFunc, counter *registryCounter) resolver.ResolveOptionsFunc {
+	if counter == nil {
+		return rfn
+	}
+	return func(ref string) docker.ResolverOptions {
+		opt := rfn(ref)
+		client := http.Client{}
+		if opt.Client != nil {
+			client = *opt.Client
+		}
+		next := client.Transport
+		if next == nil {
+			next = http.DefaultTransport
+		}
+		client.Transport = &countingTransport{next: next, counter: counter}
+		opt.Client = &client
+		return opt
+	}
+}
+
+// countingTransport counts the bytes of the request bodies uploading blobs
+// and of the response bodies of the blobs fetched.
+type countingTransport struct {
+	next    http.RoundTripper
+	counter *registryCounter
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	blob := strings.Contains(req.URL.Path, "/blobs/")
+	if blob && req.Body != nil && (req.Method == http.MethodPut || req.Method == http.MethodPatch || req.Method == http.MethodPost) {
+		// Do not modify the request of the caller.
+		req = req.WithContext(req.Context())
+		req.Body = &countingReader{ReadCloser: req.Body, n: &t.counter.pushed}
+	}
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if blob && req.Method == http.MethodGet {
+		resp.Body = &countingReader{ReadCloser: resp.Body, n: &t.counter.pulled}
+	}
+	return resp, nil
+}
+
+type countingReader struct {
+	io.ReadCloser
+	n *int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	atomic.AddInt64(r.n, int64(n))
+	return n, err
+}
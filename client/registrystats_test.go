@@ -0,0 +1,49 @@
+package client
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/containerd/containerd/remotes/docker"
+)
+
+func TestCountingResolveOptions(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ioutil.ReadAll(r.Body)
+		if r.Method == http.MethodGet {
+			w.Write([]byte("layer content"))
+		}
+	}))
+	defer srv.Close()
+
+	c := &Client{registryBytes: &registryCounter{}}
+	rfn := countingResolveOptions(func(string) docker.ResolverOptions {
+		return docker.ResolverOptions{Client: srv.Client()}
+	}, c.registryBytes)
+	client := rfn("docker.io/library/busybox").Client
+
+	do := func(method, path, body string) {
+		req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+	}
+	do(http.MethodGet, "/v2/library/busybox/blobs/sha256:abc", "")
+	do(http.MethodGet, "/v2/library/busybox/manifests/latest", "")
+	do(http.MethodPut, "/v2/img/blobs/uploads/1?digest=sha256:def", "pushed")
+	do(http.MethodPut, "/v2/img/manifests/latest", "manifest")
+
+	expected := RegistryStats{Pulled: int64(len("layer content")), Pushed: int64(len("pushed"))}
+	if stats := c.RegistryStats(); stats != expected {
+		t.Fatalf("expected only the blobs to be counted %+v, got: %+v", expected, stats)
+	}
+}
@@ -6,10 +6,12 @@ import (
 	"fmt"
 	"io"
 
+	"github.com/containerd/containerd/content"
 	"github.com/containerd/containerd/images"
 	"github.com/containerd/containerd/images/oci"
 	"github.com/docker/distribution/reference"
 	"github.com/moby/buildkit/util/dockerexporter"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
 // SaveImage exports an image as a tarball which can then be imported by docker.
@@ -50,9 +52,45 @@ func (c *Client) SaveImage(ctx context.Context, image, format string, writer io.
 		return fmt.Errorf("%q is not a valid format", format)
 	}
 
-	if err := exporter.Export(ctx, opt.ContentStore, img.Target, writer); err != nil {
+	return exportArchive(ctx, exporter, opt.ContentStore, image, img.Target, writer)
+}
+
+// exportArchive exports the image to the writer and closes it. A failed write
+// fails the export even if the exporter does not check for it, and the
+// writes after it fail too so the end of the archive is not written after a
+// truncated entry, which would make the archive look complete.
+func exportArchive(ctx context.Context, exporter images.Exporter, store content.Provider, image string, desc ocispec.Descriptor, writer io.WriteCloser) error {
+	aw := &archiveWriter{w: writer}
+	err := exporter.Export(ctx, store, desc, aw)
+	if aw.err != nil {
+		writer.Close()
+		return fmt.Errorf("failed writing archive: %v", aw.err)
+	}
+	if err != nil {
+		writer.Close()
 		return fmt.Errorf("exporting image %s failed: %v", image, err)
 	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("failed writing archive: %v", err)
+	}
+	return nil
+}
+
+// archiveWriter keeps the first error writing to w and returns it for every
+// write after it.
+type archiveWriter struct {
+	w   io.Writer
+	err error
+}
 
-	return writer.Close()
+func (a *archiveWriter) Write(p []byte) (int, error) {
+	if a.err != nil {
+		return 0, a.err
+	}
+	n, err := a.w.Write(p)
+	if err == nil && n < len(p) {
+		err = io.ErrShortWrite
+	}
+	a.err = err
+	return n, err
 }
@@ -0,0 +1,112 @@
+package client
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/containerd/containerd/content"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// tarExporter writes an archive of a few large entries, closing the tar
+// writer without checking for errors like the docker exporter.
+type tarExporter struct{}
+
+func (tarExporter) Export(ctx context.Context, store content.Provider, desc ocispec.Descriptor, w io.Writer) error {
+	tw := tar.NewWriter(w)
+	defer tw.Close()
+
+	for _, name := range []string{"one", "two", "three"} {
+		b := bytes.Repeat([]byte(name), 4096)
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(b))}); err != nil {
+			return err
+		}
+		if _, err := tw.Write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// failingWriter fails once more than limit bytes are written to it.
+type failingWriter struct {
+	bytes.Buffer
+	limit  int
+	failed bool
+	after  int
+	closed bool
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if w.failed {
+		w.after++
+	}
+	if w.Len()+len(p) > w.limit {
+		w.failed = true
+		return 0, errors.New("broken pipe")
+	}
+	return w.Buffer.Write(p)
+}
+
+func (w *failingWriter) Close() error {
+	w.closed = true
+	return nil
+}
+
+func TestExportArchive(t *testing.T) {
+	w := &failingWriter{limit: 1 << 20}
+	if err := exportArchive(context.Background(), tarExporter{}, nil, "img", ocispec.Descriptor{}, w); err != nil {
+		t.Fatalf("exporting archive failed: %v", err)
+	}
+	if !w.closed {
+		t.Fatal("expected the writer to be closed")
+	}
+	tr := tar.NewReader(&w.Buffer)
+	var names []string
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("reading archive failed: %v", err)
+		}
+		names = append(names, h.Name)
+	}
+	if strings.Join(names, ",") != "one,two,three" {
+		t.Fatalf("expected the archive to have every entry, got: %v", names)
+	}
+}
+
+func TestExportArchiveWriteError(t *testing.T) {
+	// Fail in the middle of the second entry.
+	w := &failingWriter{limit: 16 * 1024}
+	err := exportArchive(context.Background(), tarExporter{}, nil, "img", ocispec.Descriptor{}, w)
+	if err == nil || !strings.Contains(err.Error(), "failed writing archive: broken pipe") {
+		t.Fatalf("expected a failed write to fail the export, got: %v", err)
+	}
+
+	// Nothing is written after the failed write, so the end of the archive
+	// is missing and a reader sees it is truncated.
+	if w.after != 0 {
+		t.Fatalf("expected no writes after the failed one, got: %d", w.after)
+	}
+	tr := tar.NewReader(&w.Buffer)
+	for {
+		if _, err = tr.Next(); err != nil {
+			break
+		}
+		if _, err = io.Copy(ioutil.Discard, tr); err != nil {
+			break
+		}
+	}
+	if err != io.ErrUnexpectedEOF {
+		t.Fatalf("expected the archive to be truncated, got: %v", err)
+	}
+}
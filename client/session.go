@@ -2,12 +2,15 @@ package client
 
 import (
 	"context"
+	"io"
+	"os"
+	"sync/atomic"
 
 	"github.com/moby/buildkit/session"
-	"github.com/moby/buildkit/session/auth/authprovider"
 	"github.com/moby/buildkit/session/filesync"
 	"github.com/moby/buildkit/session/testutil"
 	"github.com/pkg/errors"
+	fstypes "github.com/tonistiigi/fsutil/types"
 )
 
 func (c *Client) getSessionManager() (*session.Manager, error) {
@@ -34,14 +37,94 @@ func (c *Client) Session(ctx context.Context) (*session.Session, session.Dialer,
 		return nil, nil, errors.Wrap(err, "failed to create session")
 	}
 	syncedDirs := make([]filesync.SyncedDir, 0, len(c.localDirs))
+	c.transfers = make(map[string]*transferCounter, len(c.localDirs))
 	for name, d := range c.localDirs {
-		syncedDirs = append(syncedDirs, filesync.SyncedDir{Name: name, Dir: d})
+		counter := &transferCounter{}
+		c.transfers[name] = counter
+		mapFn := counter.count
+		if modes, ok := c.localModes[name]; ok {
+			mapFn = func(path string, st *fstypes.Stat) bool {
+				return modes.apply(path, st) && counter.count(path, st)
+			}
+		}
+		syncedDirs = append(syncedDirs, filesync.SyncedDir{Name: name, Dir: d, Excludes: c.localExcludes[name], Map: mapFn})
 	}
 	s.Allow(filesync.NewFSSyncProvider(syncedDirs))
-	s.Allow(authprovider.NewDockerAuthProvider())
+	if c.exportOutput != nil {
+		// Receive the tarball of exporters such as oci.
+		s.Allow(filesync.NewFSSyncTarget(c.exportOutput))
+	} else if c.exportDir != "" {
+		// Receive the files of the local exporter.
+		s.Allow(filesync.NewFSSyncTargetDir(c.exportDir))
+	}
+	registryAuth, err := c.registryAuths()
+	if err != nil {
+		return nil, nil, err
+	}
+	s.Allow(newAuthProvider(registryAuth))
 	return s, sessionDialer(s, m), err
 }
 
+// TransferStats are the files and bytes of a local dir sent to the builder.
+type TransferStats struct {
+	Files int64
+	Bytes int64
+}
+
+// TransferStats returns the files and bytes sent so far for each of the local
+// dirs of the session, keyed by their name.
+func (c *Client) TransferStats() map[string]TransferStats {
+	stats := make(map[string]TransferStats, len(c.transfers))
+	for name, counter := range c.transfers {
+		stats[name] = counter.stats()
+	}
+	return stats
+}
+
+// transferCounter counts the regular files and their bytes walked by the
+// filesync provider to send a local dir.
+type transferCounter struct {
+	files int64
+	bytes int64
+}
+
+func (t *transferCounter) count(_ string, st *fstypes.Stat) bool {
+	if os.FileMode(st.Mode).IsRegular() {
+		atomic.AddInt64(&t.files, 1)
+		atomic.AddInt64(&t.bytes, st.Size_)
+	}
+	return true
+}
+
+func (t *transferCounter) stats() TransferStats {
+	return TransferStats{
+		Files: atomic.LoadInt64(&t.files),
+		Bytes: atomic.LoadInt64(&t.bytes),
+	}
+}
+
+// SetExportOutput sets the writer the tarball of exporters such as oci is
+// sent to over the session. It is closed once the tarball is written.
+func (c *Client) SetExportOutput(w io.WriteCloser) {
+	c.exportOutput = w
+}
+
+// SetExportDir sets the directory the files of the local exporter are
+// written to over the session. It can not be combined with SetExportOutput.
+func (c *Client) SetExportDir(dir string) {
+	c.exportDir = dir
+}
+
+// SetLocalExcludes sets the exclude patterns of the local dir with the name,
+// in the .dockerignore syntax. They replace the patterns the frontend asks
+// for, so they have to include the patterns of the .dockerignore file.
+func (c *Client) SetLocalExcludes(name string, patterns []string) {
+	if c.localExcludes == nil {
+		c.localExcludes = map[string][]string{}
+	}
+	c.localExcludes[name] = patterns
+}
+
 func sessionDialer(s *session.Session, m *session.Manager) session.Dialer {
 	// FIXME: rename testutil
 	return session.Dialer(testutil.TestStream(testutil.Handler(m.HandleConn)))
@@ -0,0 +1,32 @@
+package client
+
+import (
+	"os"
+	"testing"
+
+	fstypes "github.com/tonistiigi/fsutil/types"
+)
+
+func TestTransferStats(t *testing.T) {
+	files := []*fstypes.Stat{
+		{Path: "src", Mode: uint32(os.ModeDir | 0755)},
+		{Path: "src/main.go", Mode: 0644, Size_: 1200},
+		{Path: "src/link", Mode: uint32(os.ModeSymlink | 0777), Linkname: "main.go"},
+		{Path: "Dockerfile", Mode: 0644, Size_: 34},
+		{Path: "empty", Mode: 0600},
+	}
+
+	counter := &transferCounter{}
+	c := &Client{transfers: map[string]*transferCounter{"context": counter}}
+	for _, st := range files {
+		if !counter.count(st.Path, st) {
+			t.Fatalf("expected %s to be sent", st.Path)
+		}
+	}
+
+	stats := c.TransferStats()
+	expected := TransferStats{Files: 3, Bytes: 1234}
+	if stats["context"] != expected {
+		t.Fatalf("expected %+v, got: %+v", expected, stats["context"])
+	}
+}
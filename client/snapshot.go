@@ -0,0 +1,129 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	ctdsnapshot "github.com/containerd/containerd/snapshots"
+	"github.com/moby/buildkit/cache/metadata"
+)
+
+// SnapshotInfo represents a snapshot of the snapshotter returned from
+// ListSnapshots. A snapshot is in use if a build cache record or another
+// snapshot refers to it, otherwise it is orphaned.
+type SnapshotInfo struct {
+	Key    string `json:"key"`
+	Parent string `json:"parent,omitempty"`
+	Kind   string `json:"kind"`
+	Size   int64  `json:"size"`
+	InUse  bool   `json:"inUse"`
+}
+
+// snapshotStore is the part of the snapshotter used to list and remove
+// snapshots.
+type snapshotStore interface {
+	Walk(ctx context.Context, fn func(context.Context, ctdsnapshot.Info) error) error
+	Usage(ctx context.Context, key string) (ctdsnapshot.Usage, error)
+	Remove(ctx context.Context, key string) error
+}
+
+// ListSnapshots returns the snapshots of the snapshotter sorted by key.
+func (c *Client) ListSnapshots(ctx context.Context) ([]SnapshotInfo, error) {
+	// Create the worker opts.
+	opt, err := c.createWorkerOpt(false)
+	if err != nil {
+		return nil, fmt.Errorf("creating worker opt failed: %v", err)
+	}
+
+	records, err := cacheRecords(opt.MetadataStore)
+	if err != nil {
+		return nil, err
+	}
+	return listSnapshots(ctx, opt.Snapshotter, records)
+}
+
+// RemoveSnapshot removes the orphaned snapshot with the key from the
+// snapshotter. Snapshots in use are not removed, prune removes those along
+// with their build cache record.
+func (c *Client) RemoveSnapshot(ctx context.Context, key string) error {
+	// Create the worker opts.
+	opt, err := c.createWorkerOpt(false)
+	if err != nil {
+		return fmt.Errorf("creating worker opt failed: %v", err)
+	}
+
+	records, err := cacheRecords(opt.MetadataStore)
+	if err != nil {
+		return err
+	}
+	return removeSnapshot(ctx, opt.Snapshotter, records, key)
+}
+
+// cacheRecords returns the ids of the build cache records, which are the keys
+// of their snapshots.
+func cacheRecords(md *metadata.Store) (map[string]bool, error) {
+	items, err := md.All()
+	if err != nil {
+		return nil, fmt.Errorf("listing build cache records failed: %v", err)
+	}
+	records := map[string]bool{}
+	for _, item := range items {
+		records[item.ID()] = true
+	}
+	return records, nil
+}
+
+func listSnapshots(ctx context.Context, s snapshotStore, records map[string]bool) ([]SnapshotInfo, error) {
+	var infos []ctdsnapshot.Info
+	if err := s.Walk(ctx, func(ctx context.Context, info ctdsnapshot.Info) error {
+		infos = append(infos, info)
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("walking snapshots failed: %v", err)
+	}
+
+	parents := map[string]bool{}
+	for _, info := range infos {
+		if info.Parent != "" {
+			parents[info.Parent] = true
+		}
+	}
+
+	snapshots := make([]SnapshotInfo, 0, len(infos))
+	for _, info := range infos {
+		usage, err := s.Usage(ctx, info.Name)
+		if err != nil {
+			return nil, fmt.Errorf("getting usage of snapshot %s failed: %v", info.Name, err)
+		}
+		snapshots = append(snapshots, SnapshotInfo{
+			Key:    info.Name,
+			Parent: info.Parent,
+			Kind:   info.Kind.String(),
+			Size:   usage.Size,
+			InUse:  records[info.Name] || parents[info.Name],
+		})
+	}
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].Key < snapshots[j].Key })
+	return snapshots, nil
+}
+
+func removeSnapshot(ctx context.Context, s snapshotStore, records map[string]bool, key string) error {
+	snapshots, err := listSnapshots(ctx, s, records)
+	if err != nil {
+		return err
+	}
+	for _, snapshot := range snapshots {
+		if snapshot.Key != key {
+			continue
+		}
+		if snapshot.InUse {
+			return fmt.Errorf("snapshot %s is in use by the build cache or another snapshot, use prune to remove it", key)
+		}
+		if err := s.Remove(ctx, key); err != nil {
+			return fmt.Errorf("removing snapshot %s failed: %v", key, err)
+		}
+		return nil
+	}
+	return fmt.Errorf("snapshot %s not found", key)
+}
@@ -0,0 +1,82 @@
+package client
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/containerd/containerd/errdefs"
+	ctdsnapshot "github.com/containerd/containerd/snapshots"
+)
+
+// fakeSnapshotter is a snapshotter of the snapshots and their sizes.
+type fakeSnapshotter struct {
+	infos []ctdsnapshot.Info
+	sizes map[string]int64
+}
+
+func (s *fakeSnapshotter) Walk(ctx context.Context, fn func(context.Context, ctdsnapshot.Info) error) error {
+	for _, info := range s.infos {
+		if err := fn(ctx, info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *fakeSnapshotter) Usage(ctx context.Context, key string) (ctdsnapshot.Usage, error) {
+	return ctdsnapshot.Usage{Size: s.sizes[key]}, nil
+}
+
+func (s *fakeSnapshotter) Remove(ctx context.Context, key string) error {
+	for i, info := range s.infos {
+		if info.Name == key {
+			s.infos = append(s.infos[:i], s.infos[i+1:]...)
+			return nil
+		}
+	}
+	return errdefs.ErrNotFound
+}
+
+func TestListAndRemoveSnapshots(t *testing.T) {
+	s := &fakeSnapshotter{
+		infos: []ctdsnapshot.Info{
+			{Name: "layer", Kind: ctdsnapshot.KindCommitted},
+			{Name: "run", Parent: "layer", Kind: ctdsnapshot.KindActive},
+			{Name: "orphan", Kind: ctdsnapshot.KindCommitted},
+		},
+		sizes: map[string]int64{"layer": 1024, "run": 42, "orphan": 2048},
+	}
+	// Only the RUN step has a build cache record, its parent is in use by it.
+	records := map[string]bool{"run": true}
+	ctx := context.Background()
+
+	snapshots, err := listSnapshots(ctx, s, records)
+	if err != nil {
+		t.Fatalf("listing snapshots failed: %v", err)
+	}
+	expected := []SnapshotInfo{
+		{Key: "layer", Kind: "Committed", Size: 1024, InUse: true},
+		{Key: "orphan", Kind: "Committed", Size: 2048},
+		{Key: "run", Parent: "layer", Kind: "Active", Size: 42, InUse: true},
+	}
+	if !reflect.DeepEqual(snapshots, expected) {
+		t.Fatalf("expected snapshots %#v, got: %#v", expected, snapshots)
+	}
+
+	for _, key := range []string{"layer", "run"} {
+		if err := removeSnapshot(ctx, s, records, key); err == nil || !strings.Contains(err.Error(), "is in use") {
+			t.Fatalf("expected removing snapshot %s in use to fail, got: %v", key, err)
+		}
+	}
+	if err := removeSnapshot(ctx, s, records, "missing"); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Fatalf("expected removing a missing snapshot to fail, got: %v", err)
+	}
+	if err := removeSnapshot(ctx, s, records, "orphan"); err != nil {
+		t.Fatalf("removing orphaned snapshot failed: %v", err)
+	}
+	if len(s.infos) != 2 {
+		t.Fatalf("expected only the orphaned snapshot to be removed, got: %#v", s.infos)
+	}
+}
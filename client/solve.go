@@ -10,16 +10,18 @@ import (
 	"google.golang.org/grpc"
 )
 
-// Solve calls Solve on the controller.
-func (c *Client) Solve(ctx context.Context, req *controlapi.SolveRequest, ch chan *controlapi.StatusResponse) error {
+// Solve calls Solve on the controller and returns the response of the
+// exporter.
+func (c *Client) Solve(ctx context.Context, req *controlapi.SolveRequest, ch chan *controlapi.StatusResponse) (map[string]string, error) {
 	defer close(ch)
 	if c.controller == nil {
 		// Create the controller.
 		if err := c.createController(); err != nil {
-			return err
+			return nil, err
 		}
 	}
 
+	var exporterResponse map[string]string
 	statusCtx, cancelStatus := context.WithCancel(context.Background())
 	eg, ctx := errgroup.WithContext(ctx)
 	eg.Go(func() error {
@@ -29,10 +31,11 @@ func (c *Client) Solve(ctx context.Context, req *controlapi.SolveRequest, ch cha
 				cancelStatus()
 			}()
 		}()
-		_, err := c.controller.Solve(ctx, req)
+		resp, err := c.hooks.solve(ctx, req, c.controller.Solve)
 		if err != nil {
 			return errors.Wrap(err, "failed to solve")
 		}
+		exporterResponse = resp.ExporterResponse
 		return nil
 	})
 
@@ -45,7 +48,38 @@ func (c *Client) Solve(ctx context.Context, req *controlapi.SolveRequest, ch cha
 			Ref: req.Ref,
 		}, srv)
 	})
-	return eg.Wait()
+	if err := eg.Wait(); err != nil {
+		return nil, err
+	}
+	return exporterResponse, nil
+}
+
+// SolveHooks are called around the solve of each build of the client, so
+// embedders can record metrics or audit logs of the builds. Either hook may be
+// nil.
+type SolveHooks struct {
+	// PreSolve is called with the request before it is solved.
+	PreSolve func(ctx context.Context, req *controlapi.SolveRequest)
+	// PostSolve is called with the request and the response or the error of
+	// the solve once it returns.
+	PostSolve func(ctx context.Context, req *controlapi.SolveRequest, resp *controlapi.SolveResponse, err error)
+}
+
+// SetSolveHooks sets the hooks called around the solve of each build.
+func (c *Client) SetSolveHooks(hooks SolveHooks) {
+	c.hooks = hooks
+}
+
+// solve calls the solve function with the hooks around it.
+func (h SolveHooks) solve(ctx context.Context, req *controlapi.SolveRequest, solve func(context.Context, *controlapi.SolveRequest) (*controlapi.SolveResponse, error)) (*controlapi.SolveResponse, error) {
+	if h.PreSolve != nil {
+		h.PreSolve(ctx, req)
+	}
+	resp, err := solve(ctx, req)
+	if h.PostSolve != nil {
+		h.PostSolve(ctx, req, resp, err)
+	}
+	return resp, err
 }
 
 type controlStatusServer struct {
@@ -0,0 +1,68 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	controlapi "github.com/moby/buildkit/api/services/control"
+)
+
+func TestSolveHooks(t *testing.T) {
+	var calls []string
+	var preReq, postReq *controlapi.SolveRequest
+	var postResp *controlapi.SolveResponse
+	var postErr error
+	c := &Client{}
+	c.SetSolveHooks(SolveHooks{
+		PreSolve: func(ctx context.Context, req *controlapi.SolveRequest) {
+			calls = append(calls, "pre")
+			preReq = req
+		},
+		PostSolve: func(ctx context.Context, req *controlapi.SolveRequest, resp *controlapi.SolveResponse, err error) {
+			calls = append(calls, "post")
+			postReq, postResp, postErr = req, resp, err
+		},
+	})
+
+	req := &controlapi.SolveRequest{Ref: "build-ref", Exporter: "image"}
+	expected := &controlapi.SolveResponse{ExporterResponse: map[string]string{"containerimage.digest": "sha256:abc"}}
+	resp, err := c.hooks.solve(context.Background(), req, func(ctx context.Context, r *controlapi.SolveRequest) (*controlapi.SolveResponse, error) {
+		calls = append(calls, "solve")
+		return expected, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp != expected {
+		t.Fatalf("expected the response of the solve, got: %v", resp)
+	}
+	if len(calls) != 3 || calls[0] != "pre" || calls[1] != "solve" || calls[2] != "post" {
+		t.Fatalf("expected the hooks to be called around the solve, got: %v", calls)
+	}
+	if preReq != req || postReq != req {
+		t.Fatalf("expected both hooks to get the request, got: %v and %v", preReq, postReq)
+	}
+	if postResp != expected || postErr != nil {
+		t.Fatalf("expected the post hook to get the response, got: %v, %v", postResp, postErr)
+	}
+
+	// The post hook gets the error of a failed solve.
+	solveErr := errors.New("exit code: 2")
+	if _, err := c.hooks.solve(context.Background(), req, func(ctx context.Context, r *controlapi.SolveRequest) (*controlapi.SolveResponse, error) {
+		return nil, solveErr
+	}); err != solveErr {
+		t.Fatalf("expected the error of the solve, got: %v", err)
+	}
+	if postResp != nil || postErr != solveErr {
+		t.Fatalf("expected the post hook to get the error, got: %v, %v", postResp, postErr)
+	}
+
+	// Builds without hooks only solve.
+	c.SetSolveHooks(SolveHooks{})
+	if _, err := c.hooks.solve(context.Background(), req, func(ctx context.Context, r *controlapi.SolveRequest) (*controlapi.SolveResponse, error) {
+		return expected, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
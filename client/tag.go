@@ -4,32 +4,50 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"sort"
 	"time"
 
 	"github.com/containerd/containerd/errdefs"
 	"github.com/containerd/containerd/images"
 	"github.com/docker/distribution/reference"
+	digest "github.com/opencontainers/go-digest"
 )
 
 // TagImage creates a reference to an image with a specific name in the image store.
 func (c *Client) TagImage(ctx context.Context, src, dest string) error {
+	return c.tagImage(ctx, src, dest, false)
+}
+
+// MoveImage creates a reference to an image with a specific name in the image
+// store and removes the reference of the source image. Both references must be
+// in the same registry. Other img processes do not see the store in between
+// since they wait for the lock on the state directory.
+func (c *Client) MoveImage(ctx context.Context, src, dest string) error {
+	return c.tagImage(ctx, src, dest, true)
+}
+
+func (c *Client) tagImage(ctx context.Context, src, dest string, move bool) error {
 	// Parse the image name and tag for the src image.
-	named, err := reference.ParseNormalizedNamed(src)
+	srcNamed, err := reference.ParseNormalizedNamed(src)
 	if err != nil {
 		return fmt.Errorf("parsing image name %q failed: %v", src, err)
 	}
 	// Add the latest lag if they did not provide one.
-	named = reference.TagNameOnly(named)
-	src = named.String()
+	srcNamed = reference.TagNameOnly(srcNamed)
+	src = srcNamed.String()
 
 	// Parse the image name and tag for the dest image.
-	named, err = reference.ParseNormalizedNamed(dest)
+	destNamed, err := reference.ParseNormalizedNamed(dest)
 	if err != nil {
 		return fmt.Errorf("parsing image name %q failed: %v", dest, err)
 	}
 	// Add the latest lag if they did not provide one.
-	named = reference.TagNameOnly(named)
-	dest = named.String()
+	destNamed = reference.TagNameOnly(destNamed)
+	dest = destNamed.String()
+
+	if move && reference.Domain(srcNamed) != reference.Domain(destNamed) {
+		return fmt.Errorf("cannot move %s to %s: the registries %s and %s differ", src, dest, reference.Domain(srcNamed), reference.Domain(destNamed))
+	}
 
 	// Create the worker opts.
 	opt, err := c.createWorkerOpt(false)
@@ -64,5 +82,72 @@ func (c *Client) TagImage(ctx context.Context, src, dest string) error {
 		}
 	}
 
+	if !move || src == dest {
+		return nil
+	}
+
+	// Only remove the source image if the target now references the same image.
+	moved, err := opt.ImageStore.Get(ctx, dest)
+	if err != nil {
+		return fmt.Errorf("getting image %s from image store failed: %v", dest, err)
+	}
+	if moved.Target.Digest != image.Target.Digest {
+		return fmt.Errorf("image %s references %s instead of %s, not removing %s", dest, moved.Target.Digest, image.Target.Digest, src)
+	}
+	if err := opt.ImageStore.Delete(ctx, src); err != nil {
+		return fmt.Errorf("removing image %s from image store failed: %v", src, err)
+	}
+
 	return nil
 }
+
+// ImageTags returns the sorted names of the images in the image store that
+// reference the same manifest as the image, given by name or by the digest
+// of the manifest.
+func (c *Client) ImageTags(ctx context.Context, image string) ([]string, error) {
+	listed, err := c.ListImages(ctx)
+	if err != nil {
+		return nil, err
+	}
+	imgs := make([]images.Image, 0, len(listed))
+	for _, l := range listed {
+		imgs = append(imgs, l.Image)
+	}
+
+	if dgst, err := digest.Parse(image); err == nil {
+		tags := imageTags(imgs, dgst)
+		if len(tags) < 1 {
+			return nil, fmt.Errorf("no image references the manifest %s", dgst)
+		}
+		return tags, nil
+	}
+
+	// Parse the image name and tag.
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return nil, fmt.Errorf("parsing image name %q failed: %v", image, err)
+	}
+	// Add the latest lag if they did not provide one.
+	named = reference.TagNameOnly(named)
+	image = named.String()
+
+	for _, img := range imgs {
+		if img.Name == image {
+			return imageTags(imgs, img.Target.Digest), nil
+		}
+	}
+	return nil, fmt.Errorf("image %s not found", image)
+}
+
+// imageTags returns the sorted names of the images whose target is the
+// manifest with the digest.
+func imageTags(imgs []images.Image, dgst digest.Digest) []string {
+	var tags []string
+	for _, img := range imgs {
+		if img.Target.Digest == dgst {
+			tags = append(tags, img.Name)
+		}
+	}
+	sort.Strings(tags)
+	return tags
+}
@@ -0,0 +1,144 @@
+package client
+
+import (
+	"context"
+	"strings"
+
+	"github.com/moby/buildkit/cache"
+	"github.com/moby/buildkit/exporter"
+	gwclient "github.com/moby/buildkit/frontend/gateway/client"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	// FrontendTargets is the key of the frontend attr holding the comma
+	// separated target stages to build in one build, instead of "target".
+	FrontendTargets = "img.targets"
+	// ExporterTarget is the key of the attr of an exporter of the multi
+	// exporter selecting the target stage of the result it exports.
+	ExporterTarget = "img.target"
+)
+
+// targetsBuild wraps the build of the dockerfile frontend to build each of
+// the target stages in the frontend attrs in the same build so the stages
+// they share are only solved once. The result holds the refs and metadata of
+// every target, keyed by the target, which targetSource selects from for the
+// exporter of each target.
+func targetsBuild(build gwclient.BuildFunc) gwclient.BuildFunc {
+	return func(ctx context.Context, c gwclient.Client) (*gwclient.Result, error) {
+		opts := c.BuildOpts().Opts
+		if opts[FrontendTargets] == "" {
+			return build(ctx, c)
+		}
+
+		targets := strings.Split(opts[FrontendTargets], ",")
+		results := make([]*gwclient.Result, len(targets))
+		eg, ctx := errgroup.WithContext(ctx)
+		for i, target := range targets {
+			i, target := i, target
+			eg.Go(func() error {
+				res, err := build(ctx, &optsClient{Client: c, opts: targetOpts(opts, target)})
+				if err != nil {
+					return err
+				}
+				results[i] = res
+				return nil
+			})
+		}
+		if err := eg.Wait(); err != nil {
+			return nil, err
+		}
+		return mergeTargetResults(targets, results), nil
+	}
+}
+
+// targetOpts returns the frontend opts of the build of the target.
+func targetOpts(opts map[string]string, target string) map[string]string {
+	to := make(map[string]string, len(opts))
+	for k, v := range opts {
+		if k != FrontendTargets {
+			to[k] = v
+		}
+	}
+	to["target"] = target
+	return to
+}
+
+// mergeTargetResults merges the results of the builds of each target. The ref
+// of a target is keyed by the target and its refs by platform are keyed by
+// "<target>/<platform>". The metadata keys get the target after the first
+// part, so "containerimage.config" is "containerimage.config/<target>" and
+// "containerimage.config/<platform>" is
+// "containerimage.config/<target>/<platform>", like the metadata of the
+// refs by platform.
+func mergeTargetResults(targets []string, results []*gwclient.Result) *gwclient.Result {
+	merged := gwclient.NewResult()
+	for i, res := range results {
+		target := targets[i]
+		// The forwarder does not take nil refs, a target without a ref is
+		// exported empty like a build of the target alone.
+		if res.Refs == nil && res.Ref != nil {
+			merged.AddRef(target, res.Ref)
+		}
+		for k, ref := range res.Refs {
+			merged.AddRef(target+"/"+k, ref)
+		}
+		for k, v := range res.Metadata {
+			merged.AddMeta(targetKey(k, target), v)
+		}
+	}
+	return merged
+}
+
+func targetKey(k, target string) string {
+	if i := strings.Index(k, "/"); i >= 0 {
+		return k[:i] + "/" + target + k[i:]
+	}
+	return k + "/" + target
+}
+
+// targetSource returns the source of the target from the source of a build
+// of several targets merged by mergeTargetResults.
+func targetSource(src exporter.Source, target string) exporter.Source {
+	ts := exporter.Source{Metadata: map[string][]byte{}}
+	if ref, ok := src.Refs[target]; ok {
+		ts.Ref = ref
+	} else {
+		for k, ref := range src.Refs {
+			if strings.HasPrefix(k, target+"/") {
+				if ts.Refs == nil {
+					ts.Refs = map[string]cache.ImmutableRef{}
+				}
+				ts.Refs[strings.TrimPrefix(k, target+"/")] = ref
+			}
+		}
+	}
+
+	for k, v := range src.Metadata {
+		i := strings.Index(k, "/")
+		if i < 0 {
+			// The metadata not keyed by target is shared by every target.
+			ts.Metadata[k] = v
+			continue
+		}
+		switch rest := k[i+1:]; {
+		case rest == target:
+			ts.Metadata[k[:i]] = v
+		case strings.HasPrefix(rest, target+"/"):
+			ts.Metadata[k[:i]+strings.TrimPrefix(rest, target)] = v
+		}
+	}
+	return ts
+}
+
+// targetInstance exports the result of the target of a build of several
+// targets.
+type targetInstance struct {
+	exporter.ExporterInstance
+
+	target string
+}
+
+func (e *targetInstance) Export(ctx context.Context, src exporter.Source) (map[string]string, error) {
+	return e.ExporterInstance.Export(ctx, targetSource(src, e.target))
+}
@@ -0,0 +1,37 @@
+package client
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/moby/buildkit/exporter"
+	"github.com/moby/buildkit/exporter/containerimage/exptypes"
+	gwclient "github.com/moby/buildkit/frontend/gateway/client"
+)
+
+func TestTargetSource(t *testing.T) {
+	one := gwclient.NewResult()
+	one.AddMeta(exptypes.ExporterImageConfigKey, []byte("one"))
+	two := gwclient.NewResult()
+	two.AddMeta(exptypes.ExporterImageConfigKey+"/linux/amd64", []byte("two amd64"))
+	two.AddMeta(exptypes.ExporterImageConfigKey+"/linux/arm64", []byte("two arm64"))
+	two.AddMeta(exptypes.ExporterPlatformsKey, []byte("platforms"))
+
+	merged := mergeTargetResults([]string{"one", "two"}, []*gwclient.Result{one, two})
+	expected := map[string][]byte{
+		exptypes.ExporterImageConfigKey + "/one":             []byte("one"),
+		exptypes.ExporterImageConfigKey + "/two/linux/amd64": []byte("two amd64"),
+		exptypes.ExporterImageConfigKey + "/two/linux/arm64": []byte("two arm64"),
+		exptypes.ExporterPlatformsKey + "/two":               []byte("platforms"),
+	}
+	if !reflect.DeepEqual(merged.Metadata, expected) {
+		t.Fatalf("expected the merged metadata %q, got: %q", expected, merged.Metadata)
+	}
+
+	src := exporter.Source{Metadata: merged.Metadata}
+	for target, res := range map[string]*gwclient.Result{"one": one, "two": two} {
+		if got := targetSource(src, target); !reflect.DeepEqual(got.Metadata, res.Metadata) {
+			t.Fatalf("expected the metadata of target %s to be %q, got: %q", target, res.Metadata, got.Metadata)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/remotes/docker"
+	"github.com/docker/docker/registry"
+	"github.com/moby/buildkit/util/resolver"
+)
+
+// RegistryTokenEnv is the environment variable holding a comma separated list
+// of HOST=TOKEN registry bearer tokens.
+const RegistryTokenEnv = "REGISTRY_TOKEN"
+
+// AddRegistryToken adds pre-obtained registry bearer tokens in the HOST=TOKEN
+// format to the client. They are sent as is to the matching host instead of
+// exchanging credentials for a token.
+func (c *Client) AddRegistryToken(values ...string) error {
+	if c.registryToken == nil {
+		c.registryToken = map[string]string{}
+	}
+	for _, value := range values {
+		host, token, err := parseRegistryToken(value)
+		if err != nil {
+			return err
+		}
+		c.registryToken[host] = token
+	}
+	return nil
+}
+
+// registryTokens returns the tokens from the REGISTRY_TOKEN environment
+// variable merged with the ones added to the client, the client ones taking
+// precedence.
+func (c *Client) registryTokens() (map[string]string, error) {
+	tokens := map[string]string{}
+	for _, value := range strings.Split(os.Getenv(RegistryTokenEnv), ",") {
+		if value = strings.TrimSpace(value); value == "" {
+			continue
+		}
+		host, token, err := parseRegistryToken(value)
+		if err != nil {
+			return nil, fmt.Errorf("parsing %s failed: %v", RegistryTokenEnv, err)
+		}
+		tokens[host] = token
+	}
+	for host, token := range c.registryToken {
+		tokens[host] = token
+	}
+	return tokens, nil
+}
+
+// parseRegistryToken parses a HOST=TOKEN registry token value.
+func parseRegistryToken(value string) (string, string, error) {
+	kv := strings.SplitN(value, "=", 2)
+	if len(kv) != 2 {
+		return "", "", errors.New("invalid registry token value, expected HOST=TOKEN")
+	}
+
+	host := normalizeTokenHost(kv[0])
+	if host == "" {
+		return "", "", errors.New("invalid registry token value, host cannot be empty")
+	}
+	token := strings.TrimSpace(kv[1])
+	if token == "" {
+		return "", "", fmt.Errorf("invalid registry token for %s, token cannot be empty", host)
+	}
+	return host, token, nil
+}
+
+// normalizeTokenHost strips the scheme and path from a registry address and
+// maps the docker hub aliases to the host the requests are sent to.
+func normalizeTokenHost(host string) string {
+	host = registry.ConvertToHostname(strings.TrimSpace(host))
+	switch host {
+	case "docker.io", "index.docker.io":
+		return dockerHubHost
+	}
+	return host
+}
+
+// tokenAuthorizer authorizes the requests to the hosts with a registry token
+// with it as a bearer token and the others with next, if set.
+type tokenAuthorizer struct {
+	tokens map[string]string
+	next   docker.Authorizer
+}
+
+func (a *tokenAuthorizer) Authorize(ctx context.Context, req *http.Request) error {
+	if token, ok := a.tokens[req.URL.Host]; ok {
+		req.Header.Set("Authorization", "Bearer "+token)
+		return nil
+	}
+	if a.next == nil {
+		return nil
+	}
+	return a.next.Authorize(ctx, req)
+}
+
+func (a *tokenAuthorizer) AddResponses(ctx context.Context, responses []*http.Response) error {
+	last := responses[len(responses)-1]
+	if _, ok := a.tokens[last.Request.URL.Host]; ok {
+		// There is nothing to retry with, the token is all we have.
+		return fmt.Errorf("the registry token for %s was rejected: %s", last.Request.URL.Host, last.Status)
+	}
+	if a.next == nil {
+		return errdefs.ErrNotImplemented
+	}
+	return a.next.AddResponses(ctx, responses)
+}
+
+// tokenResolveOptions wraps the resolve options of the worker so the
+// references to hosts with a registry token are authorized with it and the
+// others with next, which caches their tokens for the invocation. The
+// credentials BuildKit sets on the options afterwards are not used by either.
+func tokenResolveOptions(rfn resolver.ResolveOptionsFunc, tokens map[string]string, next docker.Authorizer) resolver.ResolveOptionsFunc {
+	return func(ref string) docker.ResolverOptions {
+		opt := rfn(ref)
+		opt.Authorizer = &tokenAuthorizer{tokens: tokens, next: next}
+		return opt
+	}
+}
@@ -0,0 +1,55 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"testing"
+)
+
+func TestTokenAuthorizer(t *testing.T) {
+	defer os.Setenv(RegistryTokenEnv, os.Getenv(RegistryTokenEnv))
+	os.Setenv(RegistryTokenEnv, "ghcr.io=envtoken,docker.io=hubtoken")
+
+	c := &Client{}
+	if err := c.AddRegistryToken("https://ghcr.io/v2/=flagtoken"); err != nil {
+		t.Fatalf("adding registry token failed: %v", err)
+	}
+	tokens, err := c.registryTokens()
+	if err != nil {
+		t.Fatalf("getting registry tokens failed: %v", err)
+	}
+
+	a := &tokenAuthorizer{tokens: tokens}
+	testCases := map[string]string{
+		"https://ghcr.io/v2/img/manifests/latest":              "Bearer flagtoken",
+		"https://registry-1.docker.io/v2/img/manifests/latest": "Bearer hubtoken",
+		"https://r.j3ss.co/v2/img/manifests/latest":            "",
+	}
+	for url, expected := range testCases {
+		req, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := a.Authorize(context.Background(), req); err != nil {
+			t.Fatalf("authorizing %s failed: %v", url, err)
+		}
+		if got := req.Header.Get("Authorization"); got != expected {
+			t.Fatalf("expected authorization %q for %s, got: %q", expected, url, got)
+		}
+	}
+
+	// A rejected token is not retried.
+	req, _ := http.NewRequest("GET", "https://ghcr.io/v2/img/manifests/latest", nil)
+	if err := a.AddResponses(context.Background(), []*http.Response{{Status: "401 Unauthorized", StatusCode: 401, Request: req}}); err == nil {
+		t.Fatal("expected a rejected registry token to fail")
+	}
+}
+
+func TestParseRegistryTokenInvalid(t *testing.T) {
+	for _, value := range []string{"ghcr.io", "=token", "ghcr.io="} {
+		if _, _, err := parseRegistryToken(value); err == nil {
+			t.Fatalf("expected parsing registry token %q to fail", value)
+		}
+	}
+}
@@ -0,0 +1,288 @@
+package client
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/remotes/docker"
+	"github.com/docker/distribution/registry/client/auth/challenge"
+	"github.com/moby/buildkit/session/auth"
+	"github.com/pkg/errors"
+)
+
+// defaultTokenExpiry is how long a token is valid when the token server does
+// not say, as in the docker registry token spec.
+const defaultTokenExpiry = 60 * time.Second
+
+// tokenCache holds the authorizations obtained from the registries during
+// the invocation, keyed by the host and the scope of the request, so the
+// credentials are exchanged for a token once instead of for every resolver.
+type tokenCache struct {
+	mu      sync.Mutex
+	entries map[string]cachedAuth
+	now     func() time.Time
+}
+
+// cachedAuth is the Authorization header for a host and scope. A zero
+// expires never expires, like the basic auth of a host.
+type cachedAuth struct {
+	header  string
+	expires time.Time
+}
+
+func newTokenCache() *tokenCache {
+	return &tokenCache{entries: map[string]cachedAuth{}, now: time.Now}
+}
+
+func (tc *tokenCache) get(key string) (string, bool) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	e, ok := tc.entries[key]
+	if !ok {
+		return "", false
+	}
+	if !e.expires.IsZero() && !tc.now().Before(e.expires) {
+		delete(tc.entries, key)
+		return "", false
+	}
+	return e.header, true
+}
+
+func (tc *tokenCache) set(key, header string, expiresIn time.Duration) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	e := cachedAuth{header: header}
+	if expiresIn > 0 {
+		e.expires = tc.now().Add(expiresIn)
+	}
+	tc.entries[key] = e
+}
+
+func (tc *tokenCache) remove(key string) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	delete(tc.entries, key)
+}
+
+// tokenCache returns the token cache of the invocation, shared by the
+// resolvers of img and of the worker.
+func (c *Client) tokenCache() *tokenCache {
+	c.tokenCacheOnce.Do(func() {
+		c.tokens = newTokenCache()
+	})
+	return c.tokens
+}
+
+// authorizer returns an authorizer caching the tokens in the token cache of
+// the client, using the credentials of the auth provider.
+func (c *Client) authorizer(ap *authProvider) docker.Authorizer {
+	return &cachingAuthorizer{
+		cache:  c.tokenCache(),
+		client: http.DefaultClient,
+		credentials: func(host string) (string, string, error) {
+			res, err := ap.Credentials(context.Background(), &auth.CredentialsRequest{Host: host})
+			if err != nil {
+				return "", "", err
+			}
+			return res.Username, res.Secret, nil
+		},
+	}
+}
+
+// cachingAuthorizer authorizes registry requests like the authorizer of
+// containerd, except that the tokens are kept in a token cache by host and
+// scope until they expire.
+type cachingAuthorizer struct {
+	cache       *tokenCache
+	client      *http.Client
+	credentials func(host string) (string, string, error)
+}
+
+func (a *cachingAuthorizer) Authorize(ctx context.Context, req *http.Request) error {
+	if header, ok := a.cache.get(authKey(req)); ok {
+		req.Header.Set("Authorization", header)
+	} else if header, ok := a.cache.get(req.URL.Host); ok {
+		// The host uses basic auth for every scope.
+		req.Header.Set("Authorization", header)
+	}
+	return nil
+}
+
+func (a *cachingAuthorizer) AddResponses(ctx context.Context, responses []*http.Response) error {
+	last := responses[len(responses)-1]
+	host := last.Request.URL.Host
+	key := authKey(last.Request)
+	for _, c := range challenge.ResponseChallenges(last) {
+		switch strings.ToLower(c.Scheme) {
+		case "bearer":
+			if msg := c.Parameters["error"]; msg != "" && len(responses) > 1 {
+				a.cache.remove(key)
+				return fmt.Errorf("registry %s rejected the token: %s: %s", host, msg, c.Parameters["error_description"])
+			}
+			header, expiresIn, err := a.fetchToken(ctx, host, c.Parameters, requestScope(last.Request))
+			if err != nil {
+				a.cache.remove(key)
+				return err
+			}
+			a.cache.set(key, header, expiresIn)
+			return nil
+		case "basic":
+			if a.credentials == nil {
+				continue
+			}
+			username, secret, err := a.credentials(host)
+			if err != nil {
+				return err
+			}
+			if username != "" && secret != "" {
+				a.cache.set(host, "Basic "+base64.StdEncoding.EncodeToString([]byte(username+":"+secret)), 0)
+				return nil
+			}
+		}
+	}
+	return errors.Wrap(errdefs.ErrNotImplemented, "failed to find supported auth scheme")
+}
+
+// tokenResponse is the response of a token server to both the GET token
+// request and the POST oauth request.
+type tokenResponse struct {
+	Token       string `json:"token"`
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+// fetchToken exchanges the credentials for the host for a token at the realm
+// of the challenge. It returns the Authorization header and how long it is
+// valid for.
+func (a *cachingAuthorizer) fetchToken(ctx context.Context, host string, params map[string]string, scope string) (string, time.Duration, error) {
+	realm, ok := params["realm"]
+	if !ok {
+		return "", 0, errors.New("no realm specified for token auth challenge")
+	}
+	if _, err := url.Parse(realm); err != nil {
+		return "", 0, fmt.Errorf("invalid token auth challenge realm: %v", err)
+	}
+	scopes := strings.Fields(params["scope"])
+	if len(scopes) == 0 && scope != "" {
+		scopes = []string{scope}
+	}
+	if len(scopes) == 0 {
+		return "", 0, errors.New("no scope specified for token auth challenge")
+	}
+
+	var username, secret string
+	if a.credentials != nil {
+		var err error
+		if username, secret, err = a.credentials(host); err != nil {
+			return "", 0, err
+		}
+	}
+
+	var resp *http.Response
+	if secret != "" {
+		// Use the oauth endpoint with credentials, the same as containerd.
+		form := url.Values{}
+		form.Set("scope", strings.Join(scopes, " "))
+		form.Set("service", params["service"])
+		form.Set("client_id", "containerd-client")
+		if username == "" {
+			form.Set("grant_type", "refresh_token")
+			form.Set("refresh_token", secret)
+		} else {
+			form.Set("grant_type", "password")
+			form.Set("username", username)
+			form.Set("password", secret)
+		}
+		req, err := http.NewRequest(http.MethodPost, realm, strings.NewReader(form.Encode()))
+		if err != nil {
+			return "", 0, err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+		resp, err = a.client.Do(req.WithContext(ctx))
+		if err != nil {
+			return "", 0, fmt.Errorf("fetching oauth token failed: %v", err)
+		}
+		// Registries without support for POST fall back to GET.
+		if (resp.StatusCode == http.StatusMethodNotAllowed && username != "") || resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusUnauthorized {
+			resp.Body.Close()
+			resp = nil
+		}
+	}
+	if resp == nil {
+		req, err := http.NewRequest(http.MethodGet, realm, nil)
+		if err != nil {
+			return "", 0, err
+		}
+		q := req.URL.Query()
+		if service := params["service"]; service != "" {
+			q.Add("service", service)
+		}
+		for _, s := range scopes {
+			q.Add("scope", s)
+		}
+		req.URL.RawQuery = q.Encode()
+		if secret != "" {
+			req.SetBasicAuth(username, secret)
+		}
+		resp, err = a.client.Do(req.WithContext(ctx))
+		if err != nil {
+			return "", 0, fmt.Errorf("fetching token failed: %v", err)
+		}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+		return "", 0, fmt.Errorf("fetching token from %s failed: unexpected status: %s", realm, resp.Status)
+	}
+	var tr tokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tr); err != nil {
+		return "", 0, fmt.Errorf("unable to decode token response: %v", err)
+	}
+	if tr.AccessToken != "" {
+		tr.Token = tr.AccessToken
+	}
+	if tr.Token == "" {
+		return "", 0, docker.ErrNoToken
+	}
+	expiresIn := defaultTokenExpiry
+	if tr.ExpiresIn > 0 {
+		expiresIn = time.Duration(tr.ExpiresIn) * time.Second
+	}
+	return "Bearer " + tr.Token, expiresIn, nil
+}
+
+// authKey is the key of the authorization of the request in the token cache.
+func authKey(req *http.Request) string {
+	return req.URL.Host + " " + requestScope(req)
+}
+
+// requestScope returns the scope a token needs for the request to a
+// repository, pull for reads and push for writes. It is empty for requests to
+// the registry itself.
+func requestScope(req *http.Request) string {
+	p := strings.TrimPrefix(req.URL.Path, "/v2/")
+	if p == req.URL.Path {
+		return ""
+	}
+	for _, kind := range []string{"/manifests/", "/blobs/", "/tags/"} {
+		if i := strings.LastIndex(p, kind); i > 0 {
+			action := "pull"
+			if req.Method != http.MethodGet && req.Method != http.MethodHead {
+				action = "pull,push"
+			}
+			return "repository:" + p[:i] + ":" + action
+		}
+	}
+	return ""
+}
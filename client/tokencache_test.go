@@ -0,0 +1,94 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/containerd/containerd/remotes/docker"
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestCachingAuthorizer(t *testing.T) {
+	var exchanges int32
+	manifest := []byte(`{"schemaVersion":2}`)
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			n := atomic.AddInt32(&exchanges, 1)
+			fmt.Fprintf(w, `{"token":"token-%d","expires_in":300}`, n)
+			return
+		}
+		repo := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/v2/"), "/manifests/", 2)[0]
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer token-") {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="https://%s/token",service="test",scope="repository:%s:pull"`, r.Host, repo))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", ocispec.MediaTypeImageManifest)
+		w.Header().Set("Docker-Content-Digest", digest.FromBytes(manifest).String())
+		w.Header().Set("Content-Length", fmt.Sprint(len(manifest)))
+		w.Write(manifest)
+	}))
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "https://")
+
+	cache := newTokenCache()
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+	resolve := func(ref string) {
+		// Each pull gets its own resolver, like the pulls of the worker.
+		r := docker.NewResolver(docker.ResolverOptions{
+			Client:     srv.Client(),
+			Authorizer: &cachingAuthorizer{cache: cache, client: srv.Client()},
+		})
+		if _, _, err := r.Resolve(context.Background(), host+"/"+ref); err != nil {
+			t.Fatalf("resolving %s failed: %v", ref, err)
+		}
+	}
+
+	resolve("img:one")
+	resolve("img:two")
+	if n := atomic.LoadInt32(&exchanges); n != 1 {
+		t.Fatalf("expected two pulls from the same repository to exchange one token, got: %d", n)
+	}
+
+	// Another repository needs a token with its own scope.
+	resolve("other:one")
+	if n := atomic.LoadInt32(&exchanges); n != 2 {
+		t.Fatalf("expected a pull from another repository to exchange a token, got: %d", n)
+	}
+
+	// The token is exchanged again once it expires.
+	now = now.Add(301 * time.Second)
+	resolve("img:one")
+	if n := atomic.LoadInt32(&exchanges); n != 3 {
+		t.Fatalf("expected an expired token to be exchanged again, got: %d", n)
+	}
+}
+
+func TestRequestScope(t *testing.T) {
+	testCases := []struct {
+		method, url, expected string
+	}{
+		{http.MethodHead, "https://r.j3ss.co/v2/img/manifests/latest", "repository:img:pull"},
+		{http.MethodGet, "https://r.j3ss.co/v2/library/img/blobs/sha256:abc", "repository:library/img:pull"},
+		{http.MethodPost, "https://r.j3ss.co/v2/img/blobs/uploads/", "repository:img:pull,push"},
+		{http.MethodPut, "https://r.j3ss.co/v2/img/manifests/v1", "repository:img:pull,push"},
+		{http.MethodGet, "https://r.j3ss.co/v2/", ""},
+	}
+	for _, tc := range testCases {
+		req, err := http.NewRequest(tc.method, tc.url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if scope := requestScope(req); scope != tc.expected {
+			t.Fatalf("expected the scope of %s %s to be %q, got: %q", tc.method, tc.url, tc.expected, scope)
+		}
+	}
+}
@@ -33,6 +33,11 @@ func (c *Client) Unpack(ctx context.Context, image, dest string) error {
 	named = reference.TagNameOnly(named)
 	image = named.String()
 
+	return c.unpack(ctx, image, dest, platforms.Default())
+}
+
+// unpack extracts the layers of the image for the platform to dest.
+func (c *Client) unpack(ctx context.Context, image, dest string, platform platforms.MatchComparer) error {
 	// Create the worker opts.
 	opt, err := c.createWorkerOpt(true)
 	if err != nil {
@@ -48,7 +53,7 @@ func (c *Client) Unpack(ctx context.Context, image, dest string) error {
 		return fmt.Errorf("getting image %s from image store failed: %v", image, err)
 	}
 
-	manifest, err := images.Manifest(ctx, opt.ContentStore, img.Target, platforms.Default())
+	manifest, err := images.Manifest(ctx, opt.ContentStore, img.Target, platform)
 	if err != nil {
 		return fmt.Errorf("getting image manifest failed: %v", err)
 	}
@@ -0,0 +1,101 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/platforms"
+	"github.com/docker/distribution/reference"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// Verify walks the index, manifests, configs and layers of the image in the
+// image store and recomputes the digest of every blob from the content
+// store. Only the manifests matching the platform are walked, nil walks all
+// of them. It returns the verified blobs or an error for the first blob that
+// is missing or does not match its digest.
+func (c *Client) Verify(ctx context.Context, image string, platform platforms.MatchComparer) ([]ocispec.Descriptor, error) {
+	// Parse the image name and tag.
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return nil, fmt.Errorf("parsing image name %q failed: %v", image, err)
+	}
+	// Add the latest lag if they did not provide one.
+	named = reference.TagNameOnly(named)
+	image = named.String()
+
+	// Create the worker opts.
+	opt, err := c.createWorkerOpt(false)
+	if err != nil {
+		return nil, fmt.Errorf("creating worker opt failed: %v", err)
+	}
+
+	if opt.ImageStore == nil {
+		return nil, errors.New("image store is nil")
+	}
+
+	img, err := opt.ImageStore.Get(ctx, image)
+	if err != nil {
+		return nil, fmt.Errorf("getting image %s from image store failed: %v", image, err)
+	}
+
+	return verifyImage(ctx, opt.ContentStore, img.Target, platform)
+}
+
+// verifyImage verifies the blobs of the image with the root descriptor.
+func verifyImage(ctx context.Context, provider content.Provider, root ocispec.Descriptor, platform platforms.MatchComparer) ([]ocispec.Descriptor, error) {
+	verified := []ocispec.Descriptor{}
+	verify := images.HandlerFunc(func(ctx context.Context, desc ocispec.Descriptor) ([]ocispec.Descriptor, error) {
+		if err := verifyBlob(ctx, provider, desc); err != nil {
+			return nil, err
+		}
+		verified = append(verified, desc)
+		return nil, nil
+	})
+
+	children := images.ChildrenHandler(provider)
+	if platform != nil {
+		children = images.FilterPlatforms(children, platform)
+	}
+
+	if err := images.Walk(ctx, images.Handlers(verify, children), root); err != nil {
+		return nil, err
+	}
+	return verified, nil
+}
+
+// verifyBlob recomputes the digest and size of the blob for desc from the
+// content store.
+func verifyBlob(ctx context.Context, provider content.Provider, desc ocispec.Descriptor) error {
+	if err := desc.Digest.Validate(); err != nil {
+		return fmt.Errorf("invalid digest %s: %v", desc.Digest, err)
+	}
+
+	ra, err := provider.ReaderAt(ctx, desc)
+	if err != nil {
+		if errdefs.IsNotFound(err) {
+			return fmt.Errorf("blob %s (%s) is missing from the content store", desc.Digest, desc.MediaType)
+		}
+		return fmt.Errorf("getting reader for blob %s failed: %v", desc.Digest, err)
+	}
+	defer ra.Close()
+
+	digester := desc.Digest.Algorithm().Digester()
+	size, err := io.Copy(digester.Hash(), content.NewReader(ra))
+	if err != nil {
+		return fmt.Errorf("reading blob %s failed: %v", desc.Digest, err)
+	}
+
+	if dgst := digester.Digest(); dgst != desc.Digest {
+		return fmt.Errorf("blob %s (%s) is corrupted, its content has digest %s", desc.Digest, desc.MediaType, dgst)
+	}
+	if size != desc.Size {
+		return fmt.Errorf("blob %s (%s) is corrupted, expected %d bytes, got %d", desc.Digest, desc.MediaType, desc.Size, size)
+	}
+	return nil
+}
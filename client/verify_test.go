@@ -0,0 +1,95 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/content/local"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/platforms"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestVerifyImage(t *testing.T) {
+	tmpd, err := ioutil.TempDir("", "img-verify")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpd)
+
+	cs, err := local.NewStore(tmpd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	layer := []byte("not really a layer")
+	layerDesc := ocispec.Descriptor{MediaType: ocispec.MediaTypeImageLayerGzip, Digest: digest.FromBytes(layer), Size: int64(len(layer))}
+	if err := content.WriteBlob(ctx, cs, layerDesc.Digest.String(), bytes.NewReader(layer), layerDesc); err != nil {
+		t.Fatal(err)
+	}
+
+	manifest := func(arch string) ocispec.Descriptor {
+		config := writeJSONBlob(t, cs, ocispec.MediaTypeImageConfig, ocispec.Image{Architecture: arch, OS: "linux"})
+		desc := writeJSONBlob(t, cs, ocispec.MediaTypeImageManifest, ocispec.Manifest{
+			Versioned: specs.Versioned{SchemaVersion: 2},
+			Config:    config,
+			Layers:    []ocispec.Descriptor{layerDesc},
+		})
+		desc.Platform = &ocispec.Platform{OS: "linux", Architecture: arch}
+		return desc
+	}
+	amd64, arm64 := manifest("amd64"), manifest("arm64")
+	index := writeJSONBlob(t, cs, images.MediaTypeDockerSchema2ManifestList, ocispec.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		Manifests: []ocispec.Descriptor{amd64, arm64},
+	})
+
+	// The index, manifest, config and layer of one platform.
+	verified, err := verifyImage(ctx, cs, index, platforms.Only(ocispec.Platform{OS: "linux", Architecture: "amd64"}))
+	if err != nil {
+		t.Fatalf("verifying image failed: %v", err)
+	}
+	if len(verified) != 4 {
+		t.Fatalf("expected 4 verified blobs for a single platform, got: %d", len(verified))
+	}
+
+	// The layer is shared by both platforms.
+	verified, err = verifyImage(ctx, cs, index, nil)
+	if err != nil {
+		t.Fatalf("verifying all platforms failed: %v", err)
+	}
+	if len(verified) != 7 {
+		t.Fatalf("expected 7 verified blobs for all platforms, got: %d", len(verified))
+	}
+
+	// Corrupt the layer in the content store.
+	layerPath := filepath.Join(tmpd, "blobs", "sha256", layerDesc.Digest.Hex())
+	if err := os.Chmod(layerPath, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(layerPath, []byte("not really a layer!"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err = verifyImage(ctx, cs, index, nil)
+	if err == nil || !strings.Contains(err.Error(), layerDesc.Digest.String()) || !strings.Contains(err.Error(), "corrupted") {
+		t.Fatalf("expected verify to detect the corrupted layer %s, got: %v", layerDesc.Digest, err)
+	}
+
+	// Remove the layer altogether.
+	if err := cs.Delete(ctx, layerDesc.Digest); err != nil {
+		t.Fatal(err)
+	}
+	_, err = verifyImage(ctx, cs, amd64, nil)
+	if err == nil || !strings.Contains(err.Error(), layerDesc.Digest.String()) || !strings.Contains(err.Error(), "missing") {
+		t.Fatalf("expected verify to detect the missing layer %s, got: %v", layerDesc.Digest, err)
+	}
+}
@@ -0,0 +1,232 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/moby/buildkit/client"
+	"github.com/moby/buildkit/exporter"
+	imageexporter "github.com/moby/buildkit/exporter/containerimage"
+	"github.com/moby/buildkit/frontend"
+	gw "github.com/moby/buildkit/frontend/gateway/client"
+	"github.com/moby/buildkit/session"
+	"github.com/moby/buildkit/solver"
+	"github.com/moby/buildkit/solver/llbsolver/ops"
+	"github.com/moby/buildkit/solver/pb"
+	"github.com/moby/buildkit/worker/base"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/sirupsen/logrus"
+)
+
+// ExporterRegistry is the name of the exporter that pushes the image
+// straight to the registry without storing it in the local image store.
+const ExporterRegistry = "registry"
+
+// imgWorker wraps the buildkit base worker to add the exporters and op
+// options specific to img.
+type imgWorker struct {
+	*base.Worker
+
+	opt                 base.WorkerOpt
+	root                string
+	cacheMountNamespace string
+	pullTimeout         time.Duration
+	configs             *imageConfigs
+}
+
+// Exporter returns the exporter for the given name, applying the config
+// overrides to the image, adding the digests of the platforms of the
+// exported image to its response and keeping its records for --keep-days.
+func (w *imgWorker) Exporter(name string, sm *session.Manager) (exporter.Exporter, error) {
+	e, err := w.exporter(name, sm)
+	if err != nil {
+		return nil, err
+	}
+	e = &configOverridesExporter{Exporter: e}
+	e = &platformDigestsExporter{Exporter: e, provider: w.opt.ContentStore}
+	return &keepExporter{Exporter: e, root: w.root}, nil
+}
+
+// GCPolicy returns the gc policy of the worker leaving out the records kept
+// by the leases in the state directory. The leases are read on every
+// collection as builds add them. The collection is skipped if they cannot be
+// read so the kept records are not collected.
+func (w *imgWorker) GCPolicy() []client.PruneInfo {
+	policy, err := keepPruneInfo(w.root, w.Worker.GCPolicy())
+	if err != nil {
+		logrus.Errorf("skipping gc: %v", err)
+		return nil
+	}
+	return policy
+}
+
+func (w *imgWorker) exporter(name string, sm *session.Manager) (exporter.Exporter, error) {
+	switch name {
+	case ExporterRegistry:
+		iw, err := imageexporter.NewImageWriter(imageexporter.WriterOpt{
+			Snapshotter:  w.opt.Snapshotter,
+			ContentStore: w.opt.ContentStore,
+			Differ:       w.opt.Differ,
+		})
+		if err != nil {
+			return nil, err
+		}
+		// Leave the image store out so the image is only pushed.
+		return imageexporter.New(imageexporter.Opt{
+			SessionManager: sm,
+			ImageWriter:    iw,
+			ResolverOpt:    w.opt.ResolveOptionsFunc,
+		})
+	case ExporterMulti:
+		return &multiExporter{w: w, sm: sm}, nil
+	default:
+		return w.Worker.Exporter(name, sm)
+	}
+}
+
+// ResolveOp returns the op for the vertex, scoping the ids of the cache
+// mounts of exec ops to the cache mount namespace and bounding image pulls
+// by the pull timeout.
+func (w *imgWorker) ResolveOp(v solver.Vertex, s frontend.FrontendLLBBridge, sm *session.Manager) (solver.Op, error) {
+	if baseOp, ok := v.Sys().(*pb.Op); ok {
+		if op, ok := baseOp.Op.(*pb.Op_Exec); ok && w.cacheMountNamespace != "" {
+			op = namespaceCacheMounts(op, w.cacheMountNamespace)
+			return ops.NewExecOp(v, op, baseOp.Platform, w.CacheManager, sm, w.MetadataStore, w.Executor, w)
+		}
+		if ref, ok := imagePullRef(baseOp); ok && w.pullTimeout > 0 {
+			op, err := w.Worker.ResolveOp(v, s, sm)
+			if err != nil {
+				return nil, err
+			}
+			return &pullTimeoutOp{Op: op, ref: ref, timeout: w.pullTimeout}, nil
+		}
+	}
+	return w.Worker.ResolveOp(v, s, sm)
+}
+
+// ResolveImageConfig resolves the config of the image in the registry, bounded
+// by the pull timeout, and records it for ResolvedImageConfig.
+func (w *imgWorker) ResolveImageConfig(ctx context.Context, ref string, opt gw.ResolveImageConfigOpt, sm *session.Manager) (digest.Digest, []byte, error) {
+	if w.pullTimeout <= 0 {
+		dgst, config, err := w.Worker.ResolveImageConfig(ctx, ref, opt, sm)
+		if err == nil {
+			w.configs.add(ref, config)
+		}
+		return dgst, config, err
+	}
+
+	pullCtx, cancel := context.WithTimeout(ctx, w.pullTimeout)
+	defer cancel()
+	dgst, config, err := w.Worker.ResolveImageConfig(pullCtx, ref, opt, sm)
+	if err == nil {
+		w.configs.add(ref, config)
+	}
+	return dgst, config, pullTimeoutError(pullCtx, ctx, ref, w.pullTimeout, err)
+}
+
+// imageConfigs are the image configs resolved by the frontend during the
+// solves of the client, by reference.
+type imageConfigs struct {
+	mu      sync.Mutex
+	configs map[string][]byte
+}
+
+// add records the config of the image. It is a no-op on nil image configs.
+func (s *imageConfigs) add(ref string, config []byte) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.configs == nil {
+		s.configs = map[string][]byte{}
+	}
+	s.configs[ref] = config
+}
+
+// get returns the config of the image, false if it was not resolved.
+func (s *imageConfigs) get(ref string) ([]byte, bool) {
+	if s == nil {
+		return nil, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	config, ok := s.configs[ref]
+	return config, ok
+}
+
+// ResolvedImageConfig returns the config of the image as the frontend
+// resolved it during a solve of the client, false if it did not. The
+// reference is the normalized one of the Dockerfile, e.g.
+// docker.io/library/busybox:latest.
+func (c *Client) ResolvedImageConfig(ref string) ([]byte, bool) {
+	return c.imageConfigs.get(ref)
+}
+
+// imagePullRef returns the image reference of ops pulling an image.
+func imagePullRef(op *pb.Op) (string, bool) {
+	source, ok := op.Op.(*pb.Op_Source)
+	if !ok || source.Source == nil {
+		return "", false
+	}
+	const scheme = "docker-image://"
+	if !strings.HasPrefix(source.Source.Identifier, scheme) {
+		return "", false
+	}
+	return strings.TrimPrefix(source.Source.Identifier, scheme), true
+}
+
+// pullTimeoutOp bounds resolving and fetching an image, done in CacheMap and
+// Exec of its source op, by the timeout.
+type pullTimeoutOp struct {
+	solver.Op
+
+	ref     string
+	timeout time.Duration
+}
+
+func (op *pullTimeoutOp) CacheMap(ctx context.Context, index int) (*solver.CacheMap, bool, error) {
+	pullCtx, cancel := context.WithTimeout(ctx, op.timeout)
+	defer cancel()
+	cm, done, err := op.Op.CacheMap(pullCtx, index)
+	return cm, done, pullTimeoutError(pullCtx, ctx, op.ref, op.timeout, err)
+}
+
+func (op *pullTimeoutOp) Exec(ctx context.Context, inputs []solver.Result) ([]solver.Result, error) {
+	pullCtx, cancel := context.WithTimeout(ctx, op.timeout)
+	defer cancel()
+	outputs, err := op.Op.Exec(pullCtx, inputs)
+	return outputs, pullTimeoutError(pullCtx, ctx, op.ref, op.timeout, err)
+}
+
+// pullTimeoutError replaces err with a pull timed out error if the pull
+// context hit its deadline while the parent context is still alive.
+func pullTimeoutError(pullCtx, ctx context.Context, ref string, timeout time.Duration, err error) error {
+	if err != nil && pullCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+		return fmt.Errorf("pull timed out: pulling %s took longer than %s", ref, timeout)
+	}
+	return err
+}
+
+// namespaceCacheMounts returns a copy of the exec op with the ids of its
+// cache mounts prefixed with the namespace, so cache mounts with the same id
+// in different namespaces do not share their contents.
+func namespaceCacheMounts(op *pb.Op_Exec, ns string) *pb.Op_Exec {
+	exec := *op.Exec
+	exec.Mounts = make([]*pb.Mount, len(op.Exec.Mounts))
+	for i, m := range op.Exec.Mounts {
+		if m.MountType != pb.MountType_CACHE || m.CacheOpt == nil {
+			exec.Mounts[i] = m
+			continue
+		}
+		mount := *m
+		cacheOpt := *m.CacheOpt
+		cacheOpt.ID = ns + "/" + cacheOpt.ID
+		mount.CacheOpt = &cacheOpt
+		exec.Mounts[i] = &mount
+	}
+	return &pb.Op_Exec{Exec: &exec}
+}
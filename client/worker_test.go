@@ -0,0 +1,176 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	gw "github.com/moby/buildkit/frontend/gateway/client"
+	"github.com/moby/buildkit/session"
+	"github.com/moby/buildkit/solver"
+	"github.com/moby/buildkit/solver/pb"
+	"github.com/moby/buildkit/source"
+	"github.com/moby/buildkit/worker/base"
+	digest "github.com/opencontainers/go-digest"
+)
+
+func TestNamespaceCacheMounts(t *testing.T) {
+	op := &pb.Op_Exec{Exec: &pb.ExecOp{
+		Meta: &pb.Meta{Args: []string{"go", "build"}},
+		Mounts: []*pb.Mount{
+			{Dest: "/", MountType: pb.MountType_BIND},
+			{Dest: "/root/.cache/go-build", MountType: pb.MountType_CACHE, CacheOpt: &pb.CacheOpt{ID: "go-build"}},
+		},
+	}}
+
+	one := namespaceCacheMounts(op, "one")
+	two := namespaceCacheMounts(op, "two")
+
+	if id := one.Exec.Mounts[1].CacheOpt.ID; id != "one/go-build" {
+		t.Fatalf("expected cache mount id one/go-build, got: %s", id)
+	}
+	if one.Exec.Mounts[1].CacheOpt.ID == two.Exec.Mounts[1].CacheOpt.ID {
+		t.Fatalf("expected distinct cache mount ids for distinct namespaces, got: %s", one.Exec.Mounts[1].CacheOpt.ID)
+	}
+	if one.Exec.Mounts[0] != op.Exec.Mounts[0] {
+		t.Fatal("expected non cache mounts to be kept as is")
+	}
+
+	// The original op is left untouched.
+	if id := op.Exec.Mounts[1].CacheOpt.ID; id != "go-build" {
+		t.Fatalf("expected the original cache mount id to be unchanged, got: %s", id)
+	}
+}
+
+// fetchOp stands in for the source op of an image, fetching from a registry
+// in both CacheMap and Exec.
+type fetchOp struct {
+	url string
+}
+
+func (op *fetchOp) fetch(ctx context.Context) error {
+	req, err := http.NewRequest("GET", op.url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func (op *fetchOp) CacheMap(ctx context.Context, index int) (*solver.CacheMap, bool, error) {
+	return &solver.CacheMap{}, true, op.fetch(ctx)
+}
+
+func (op *fetchOp) Exec(ctx context.Context, inputs []solver.Result) ([]solver.Result, error) {
+	return nil, op.fetch(ctx)
+}
+
+func TestPullTimeoutOp(t *testing.T) {
+	done := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-done:
+		case <-r.Context().Done():
+		}
+	}))
+	defer slow.Close()
+	defer close(done)
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer fast.Close()
+
+	ctx := context.Background()
+
+	op := &pullTimeoutOp{Op: &fetchOp{url: slow.URL}, ref: "docker.io/library/busybox:latest", timeout: 50 * time.Millisecond}
+	if _, _, err := op.CacheMap(ctx, 0); err == nil || !strings.Contains(err.Error(), "pull timed out") {
+		t.Fatalf("expected resolving from the slow registry to time out, got: %v", err)
+	}
+	if _, err := op.Exec(ctx, nil); err == nil || !strings.Contains(err.Error(), "pull timed out") {
+		t.Fatalf("expected fetching from the slow registry to time out, got: %v", err)
+	}
+
+	op = &pullTimeoutOp{Op: &fetchOp{url: fast.URL}, ref: "docker.io/library/busybox:latest", timeout: time.Minute}
+	if _, err := op.Exec(ctx, nil); err != nil {
+		t.Fatalf("expected fetching from the fast registry to succeed, got: %v", err)
+	}
+
+	// A canceled build is not a pull timeout.
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	op = &pullTimeoutOp{Op: &fetchOp{url: slow.URL}, ref: "docker.io/library/busybox:latest", timeout: time.Minute}
+	if _, err := op.Exec(canceled, nil); err == nil || strings.Contains(err.Error(), "pull timed out") {
+		t.Fatalf("expected a canceled pull to not be reported as timed out, got: %v", err)
+	}
+}
+
+func TestImagePullRef(t *testing.T) {
+	testCases := []struct {
+		op       *pb.Op
+		expected string
+		pull     bool
+	}{
+		{
+			op:       &pb.Op{Op: &pb.Op_Source{Source: &pb.SourceOp{Identifier: "docker-image://docker.io/library/busybox:latest"}}},
+			expected: "docker.io/library/busybox:latest",
+			pull:     true,
+		},
+		// Local steps are never bounded by the pull timeout.
+		{op: &pb.Op{Op: &pb.Op_Source{Source: &pb.SourceOp{Identifier: "local://context"}}}},
+		{op: &pb.Op{Op: &pb.Op_Exec{Exec: &pb.ExecOp{Meta: &pb.Meta{Args: []string{"sleep", "600"}}}}}},
+	}
+	for _, tc := range testCases {
+		ref, pull := imagePullRef(tc.op)
+		if ref != tc.expected || pull != tc.pull {
+			t.Fatalf("expected pull %t of %q for %v, got: %t of %q", tc.pull, tc.expected, tc.op, pull, ref)
+		}
+	}
+}
+
+// configSource is an image source resolving the configs of a map.
+type configSource struct {
+	source.Source
+	configs map[string][]byte
+}
+
+func (s *configSource) ResolveImageConfig(ctx context.Context, ref string, opt gw.ResolveImageConfigOpt, sm *session.Manager) (digest.Digest, []byte, error) {
+	config, ok := s.configs[ref]
+	if !ok {
+		return "", nil, errors.New("not found")
+	}
+	return digest.FromBytes(config), config, nil
+}
+
+func TestResolvedImageConfig(t *testing.T) {
+	config := []byte(`{"config":{"OnBuild":["RUN make"]}}`)
+	c := &Client{imageConfigs: &imageConfigs{}}
+	for _, timeout := range []time.Duration{0, time.Minute} {
+		w := &imgWorker{
+			Worker:      &base.Worker{ImageSource: &configSource{configs: map[string][]byte{"docker.io/library/onbuild:latest": config}}},
+			pullTimeout: timeout,
+			configs:     c.imageConfigs,
+		}
+		if _, _, err := w.ResolveImageConfig(context.Background(), "docker.io/library/onbuild:latest", gw.ResolveImageConfigOpt{}, nil); err != nil {
+			t.Fatalf("resolving the image config failed: %v", err)
+		}
+		if _, _, err := w.ResolveImageConfig(context.Background(), "docker.io/library/missing:latest", gw.ResolveImageConfigOpt{}, nil); err == nil {
+			t.Fatal("expected resolving a missing image config to fail but it did not")
+		}
+	}
+
+	if got, ok := c.ResolvedImageConfig("docker.io/library/onbuild:latest"); !ok || string(got) != string(config) {
+		t.Fatalf("expected the resolved config of the image, got: %s, %t", got, ok)
+	}
+	if _, ok := c.ResolvedImageConfig("docker.io/library/missing:latest"); ok {
+		t.Fatal("expected no config for an image that failed to resolve")
+	}
+	// A client without a solve resolved nothing.
+	if _, ok := (&Client{}).ResolvedImageConfig("docker.io/library/onbuild:latest"); ok {
+		t.Fatal("expected no config before a solve")
+	}
+}
@@ -35,6 +35,15 @@ import (
 
 // createWorkerOpt creates a base.WorkerOpt to be used for a new worker.
 func (c *Client) createWorkerOpt(withExecutor bool) (opt base.WorkerOpt, err error) {
+	tokens, err := c.registryTokens()
+	if err != nil {
+		return opt, err
+	}
+	registryAuth, err := c.registryAuths()
+	if err != nil {
+		return opt, err
+	}
+
 	// Create the metadata store.
 	md, err := metadata.NewStore(filepath.Join(c.root, "metadata.db"))
 	if err != nil {
@@ -64,15 +73,11 @@ func (c *Client) createWorkerOpt(withExecutor bool) (opt base.WorkerOpt, err err
 
 	var exe executor.Executor
 	if withExecutor {
-		exeOpt := runcexecutor.Opt{
-			Root:        filepath.Join(c.root, "executor"),
-			Rootless:    unprivileged,
-			ProcessMode: processMode(),
-		}
-		exe, err = runcexecutor.New(exeOpt, network.Default())
+		exe, err = runcexecutor.New(c.executorOpt(unprivileged, processMode()), network.Default())
 		if err != nil {
 			return opt, err
 		}
+		exe = c.wrapExecutor(exe)
 	}
 
 	// Create the content store locally.
@@ -119,18 +124,24 @@ func (c *Client) createWorkerOpt(withExecutor bool) (opt base.WorkerOpt, err err
 
 	xlabels := base.Labels("oci", c.backend)
 
-	var supportedPlatforms []specs.Platform
+	// The host platform comes first as it is the default of the builds, the
+	// binfmt_misc package starts with the platform img was built for.
+	host := HostPlatform()
+	supportedPlatforms := []specs.Platform{host}
 	for _, p := range binfmt_misc.SupportedPlatforms() {
 		parsed, err := platforms.Parse(p)
 		if err != nil {
 			return opt, err
 		}
-		supportedPlatforms = append(supportedPlatforms, platforms.Normalize(parsed))
+		if parsed = platforms.Normalize(parsed); platforms.Format(parsed) != platforms.Format(host) {
+			supportedPlatforms = append(supportedPlatforms, parsed)
+		}
 	}
 
 	opt = base.WorkerOpt{
 		ID:                 id,
 		Labels:             xlabels,
+		GCPolicy:           c.gcPolicy.pruneInfo(),
 		MetadataStore:      md,
 		Executor:           exe,
 		Snapshotter:        containerdsnapshot.NewSnapshotter(c.backend, mdb.Snapshotter(c.backend), contentStore, md, "buildkit", gc, nil),
@@ -139,12 +150,26 @@ func (c *Client) createWorkerOpt(withExecutor bool) (opt base.WorkerOpt, err err
 		Differ:             walking.NewWalkingDiff(contentStore),
 		ImageStore:         imageStore,
 		Platforms:          supportedPlatforms,
-		ResolveOptionsFunc: resolver.NewResolveOptionsFunc(nil),
+		ResolveOptionsFunc: countingResolveOptions(tokenResolveOptions(resolver.NewResolveOptionsFunc(nil), tokens, c.authorizer(newAuthProvider(registryAuth))), c.registryBytes),
 	}
 
 	return opt, err
 }
 
+// executorOpt returns the options for the runc executor of the worker.
+func (c *Client) executorOpt(unprivileged bool, mode executoroci.ProcessMode) runcexecutor.Opt {
+	opt := runcexecutor.Opt{
+		Root:                filepath.Join(c.root, "executor"),
+		Rootless:            unprivileged,
+		ProcessMode:         mode,
+		DefaultCgroupParent: c.cgroupParent,
+	}
+	if c.runtime != "" {
+		opt.CommandCandidates = []string{c.runtime}
+	}
+	return opt
+}
+
 func processMode() executoroci.ProcessMode {
 	mountArgs := []string{"-t", "proc", "none", "/proc"}
 	cmd := exec.Command("mount", mountArgs...)
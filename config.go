@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/genuinetools/pkg/cli"
+)
+
+const configShortHelp = `Manage the defaults for the flags of img.`
+
+var configLongHelp = `Manage the defaults for the flags of img.
+
+The defaults are stored in ` + configFileName + ` in the state directory. Global
+flags are configured by their name, e.g. "backend", and the flags of a
+command by the command and flag name, e.g. "build.cache-ns".
+
+Flags passed on the command line take precedence over the IMG_<KEY>
+environment variable, e.g. IMG_BUILD_CACHE_NS, which takes precedence over
+the config file.
+
+Operations:
+  get KEY          print the value of KEY
+  set KEY VALUE    set the default of KEY to VALUE
+  unset KEY        remove the default of KEY
+  list             print all the configured defaults`
+
+func (cmd *configCommand) Name() string      { return "config" }
+func (cmd *configCommand) Args() string      { return "get KEY | set KEY VALUE | unset KEY | list" }
+func (cmd *configCommand) ShortHelp() string { return configShortHelp }
+func (cmd *configCommand) LongHelp() string  { return configLongHelp }
+func (cmd *configCommand) Hidden() bool      { return false }
+
+func (cmd *configCommand) Register(fs *flag.FlagSet) {}
+
+type configCommand struct{}
+
+func (cmd *configCommand) Run(ctx context.Context, args []string) error {
+	if len(args) < 1 {
+		return usageErrorf("must pass an operation: get, set, unset or list")
+	}
+
+	cfg, err := loadConfig(stateDir)
+	if err != nil {
+		return err
+	}
+
+	switch op, args := args[0], args[1:]; op {
+	case "get":
+		if len(args) != 1 {
+			return usageErrorf("must pass a key to get")
+		}
+		if _, err := lookupConfigFlag(args[0]); err != nil {
+			return err
+		}
+		value, ok := cfg[args[0]]
+		if !ok {
+			return fmt.Errorf("%s is not set", args[0])
+		}
+		fmt.Println(value)
+		return nil
+	case "set":
+		if len(args) != 2 {
+			return usageErrorf("must pass a key and a value to set")
+		}
+		f, err := lookupConfigFlag(args[0])
+		if err != nil {
+			return err
+		}
+		if err := validateConfigValue(args[0], f, args[1]); err != nil {
+			return err
+		}
+		cfg[args[0]] = args[1]
+		return saveConfig(stateDir, cfg)
+	case "unset":
+		if len(args) != 1 {
+			return usageErrorf("must pass a key to unset")
+		}
+		if _, err := lookupConfigFlag(args[0]); err != nil {
+			return err
+		}
+		delete(cfg, args[0])
+		return saveConfig(stateDir, cfg)
+	case "list":
+		keys := make([]string, 0, len(cfg))
+		for k := range cfg {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Printf("%s=%s\n", k, cfg[k])
+		}
+		return nil
+	default:
+		return usageErrorf("%s is not a valid operation, must be one of get, set, unset or list", op)
+	}
+}
+
+// configFileName is the name of the file in the state directory holding the
+// defaults set with `img config`.
+const configFileName = "config.json"
+
+// configFlags are the flags that can be configured, keyed by the flag name
+// for global flags and by <command>.<flag> for the flags of a command.
+var configFlags = map[string]*flag.Flag{}
+
+// registerConfigFlags collects the configurable flags of the global flag set
+// and of every command. The state directory can not be configured since it
+// holds the config file.
+func registerConfigFlags(global *flag.FlagSet, commands []cli.Command) {
+	for name, f := range longFlags(global) {
+		if name != "state" {
+			configFlags[name] = f
+		}
+	}
+
+	for _, command := range commands {
+		fs := flag.NewFlagSet(command.Name(), flag.ContinueOnError)
+		command.Register(fs)
+		for name, f := range longFlags(fs) {
+			configFlags[command.Name()+"."+name] = f
+		}
+	}
+}
+
+// longFlags returns the flags of fs keyed by name, leaving out the short
+// aliases of flags that have a longer name.
+func longFlags(fs *flag.FlagSet) map[string]*flag.Flag {
+	byUsage := map[string]*flag.Flag{}
+	fs.VisitAll(func(f *flag.Flag) {
+		if alias, ok := byUsage[f.Usage]; !ok || len(f.Name) > len(alias.Name) {
+			byUsage[f.Usage] = f
+		}
+	})
+
+	flags := make(map[string]*flag.Flag, len(byUsage))
+	for _, f := range byUsage {
+		flags[f.Name] = f
+	}
+	return flags
+}
+
+func lookupConfigFlag(key string) (*flag.Flag, error) {
+	f, ok := configFlags[key]
+	if !ok {
+		return nil, fmt.Errorf("%s is not a valid config key", key)
+	}
+	return f, nil
+}
+
+// validateConfigValue makes sure value can be parsed by the flag of key.
+// Flags with custom values, like the repeatable ones, take any string.
+func validateConfigValue(key string, f *flag.Flag, value string) error {
+	if key == "backend" {
+		for _, vb := range validBackends {
+			if vb == value {
+				return nil
+			}
+		}
+		return fmt.Errorf("%s is not a valid snapshots backend", value)
+	}
+
+	g, ok := f.Value.(flag.Getter)
+	if !ok {
+		return nil
+	}
+
+	var err error
+	switch g.Get().(type) {
+	case bool:
+		_, err = strconv.ParseBool(value)
+	case int, int64:
+		_, err = strconv.ParseInt(value, 0, 64)
+	case uint, uint64:
+		_, err = strconv.ParseUint(value, 0, 64)
+	case float64:
+		_, err = strconv.ParseFloat(value, 64)
+	case time.Duration:
+		_, err = time.ParseDuration(value)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid value %q for %s: %v", value, key, err)
+	}
+	return nil
+}
+
+// configEnv returns the environment variable overriding the config of key.
+func configEnv(key string) string {
+	return "IMG_" + strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(key))
+}
+
+// applyConfig sets the flags of the command that were not passed on the
+// command line from their environment variable or the config.
+func applyConfig(fs *flag.FlagSet, command string, cfg map[string]string) error {
+	// Aliases share their usage, so track that instead of the name.
+	passed := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		passed[f.Usage] = true
+	})
+
+	for key := range configFlags {
+		name := key
+		if strings.Contains(key, ".") {
+			if !strings.HasPrefix(key, command+".") {
+				continue
+			}
+			name = strings.TrimPrefix(key, command+".")
+		}
+
+		f := fs.Lookup(name)
+		if f == nil || passed[f.Usage] {
+			continue
+		}
+
+		value, ok := os.LookupEnv(configEnv(key))
+		if !ok {
+			value, ok = cfg[key]
+		}
+		if !ok {
+			continue
+		}
+		if err := fs.Set(name, value); err != nil {
+			return fmt.Errorf("setting %s from config failed: %v", key, err)
+		}
+	}
+	return nil
+}
+
+// loadConfig reads the config from the state directory, a missing config
+// file is an empty config.
+func loadConfig(dir string) (map[string]string, error) {
+	cfg := map[string]string{}
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, configFileName))
+	if os.IsNotExist(err) {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading config failed: %v", err)
+	}
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return nil, fmt.Errorf("parsing config %s failed: %v", filepath.Join(dir, configFileName), err)
+	}
+	return cfg, nil
+}
+
+func saveConfig(dir string, cfg map[string]string) error {
+	b, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal config failed: %v", err)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("creating state directory failed: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, configFileName), append(b, '\n'), 0644); err != nil {
+		return fmt.Errorf("writing config failed: %v", err)
+	}
+	return nil
+}
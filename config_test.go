@@ -0,0 +1,148 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/genuinetools/pkg/cli"
+)
+
+func TestApplyConfig(t *testing.T) {
+	defer func(flags map[string]*flag.Flag) { configFlags = flags }(configFlags)
+	configFlags = map[string]*flag.Flag{}
+
+	var (
+		debugFlag   bool
+		backendFlag string
+		cmd         buildCommand
+	)
+	fs := flag.NewFlagSet("img", flag.ContinueOnError)
+	fs.BoolVar(&debugFlag, "debug", false, "enable debug logging")
+	fs.BoolVar(&debugFlag, "d", false, "enable debug logging")
+	fs.StringVar(&backendFlag, "backend", defaultBackend, "backend for snapshots")
+	registerConfigFlags(fs, nil)
+	registerConfigFlags(flag.NewFlagSet("img", flag.ContinueOnError), []cli.Command{&cmd, &pruneCommand{}})
+	cmd.Register(fs)
+
+	if _, ok := configFlags["d"]; ok {
+		t.Fatal("expected the short alias d to not be a config key")
+	}
+	if _, ok := configFlags["build.cache-ns"]; !ok {
+		t.Fatal("expected build.cache-ns to be a config key")
+	}
+
+	// The flag passed by its alias must win over the config.
+	if err := fs.Parse([]string{"-d=false", "--platform", "linux/s390x"}); err != nil {
+		t.Fatalf("parsing flags failed: %v", err)
+	}
+	defer os.Unsetenv("IMG_BUILD_TARGET")
+	os.Setenv("IMG_BUILD_TARGET", "fromenv")
+
+	cfg := map[string]string{
+		"debug":              "true",
+		"backend":            "native",
+		"build.cache-ns":     "myproject",
+		"build.platform":     "linux/arm64",
+		"build.target":       "fromconfig",
+		"prune.cache-mounts": "true",
+	}
+	if err := applyConfig(fs, "build", cfg); err != nil {
+		t.Fatalf("applying config failed: %v", err)
+	}
+
+	if debugFlag {
+		t.Fatal("expected the debug flag passed on the command line to take precedence")
+	}
+	if backendFlag != "native" {
+		t.Fatalf("expected backend native from the config, got: %s", backendFlag)
+	}
+	if cmd.cacheNamespace != "myproject" {
+		t.Fatalf("expected cache-ns myproject from the config, got: %s", cmd.cacheNamespace)
+	}
+	if !reflect.DeepEqual([]string(cmd.platforms), []string{"linux/s390x"}) {
+		t.Fatalf("expected the platform passed on the command line to take precedence, got: %v", cmd.platforms)
+	}
+	if !reflect.DeepEqual([]string(cmd.targets), []string{"fromenv"}) {
+		t.Fatalf("expected the environment to take precedence over the config, got: %v", cmd.targets)
+	}
+}
+
+func TestSaveConfig(t *testing.T) {
+	tmpd, err := ioutil.TempDir("", "img-config")
+	if err != nil {
+		t.Fatalf("creating temporary directory failed: %v", err)
+	}
+	defer os.RemoveAll(tmpd)
+
+	cfg, err := loadConfig(tmpd)
+	if err != nil {
+		t.Fatalf("loading a missing config failed: %v", err)
+	}
+	if len(cfg) > 0 {
+		t.Fatalf("expected an empty config, got: %v", cfg)
+	}
+
+	cfg["backend"] = "native"
+	if err := saveConfig(tmpd, cfg); err != nil {
+		t.Fatalf("saving config failed: %v", err)
+	}
+	loaded, err := loadConfig(tmpd)
+	if err != nil {
+		t.Fatalf("loading config failed: %v", err)
+	}
+	if !reflect.DeepEqual(loaded, cfg) {
+		t.Fatalf("expected config %v, got: %v", cfg, loaded)
+	}
+}
+
+func TestConfigDefaults(t *testing.T) {
+	run(t, "config", "set", "build.platform", "linux/arm64")
+	defer run(t, "config", "unset", "build.platform")
+
+	if out := run(t, "config", "list"); !strings.Contains(out, "build.platform=linux/arm64") {
+		t.Fatalf("expected config list to have build.platform=linux/arm64, got: %s", out)
+	}
+
+	for _, args := range [][]string{
+		{"config", "set", "build.nope", "foo"},
+		{"config", "set", "build.no-cache", "maybe"},
+		{"config", "set", "backend", "nope"},
+		{"config", "set", "state", "/tmp"},
+	} {
+		if out, err := doRun(args, nil); err == nil {
+			t.Fatalf("expected img %v to fail, got: %s", args, out)
+		}
+	}
+
+	// The default is applied on the next build.
+	args := []string{"build", "--dry-run", "-t", "testconfigdefaults", "-"}
+	out, err := doRun(args, withDockerfile(`
+  FROM busybox
+  RUN echo config
+  `))
+	if err != nil {
+		t.Logf("img %v failed unexpectedly: %v", args, err)
+		t.FailNow()
+	}
+	if !strings.Contains(out, "linux/arm64") {
+		t.Fatalf("expected the build to use the configured platform linux/arm64, got: %s", out)
+	}
+
+	// Flags take precedence over the config.
+	args = []string{"build", "--dry-run", "--platform", "linux/s390x", "-t", "testconfigdefaults", "-"}
+	out, err = doRun(args, withDockerfile(`
+  FROM busybox
+  RUN echo config
+  `))
+	if err != nil {
+		t.Logf("img %v failed unexpectedly: %v", args, err)
+		t.FailNow()
+	}
+	if strings.Contains(out, "linux/arm64") || !strings.Contains(out, "linux/s390x") {
+		t.Fatalf("expected the build to use the platform flag linux/s390x, got: %s", out)
+	}
+}
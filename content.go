@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/containerd/containerd/namespaces"
+	"github.com/mchirico/img/client"
+	"github.com/moby/buildkit/identity"
+	"github.com/moby/buildkit/session"
+	digest "github.com/opencontainers/go-digest"
+)
+
+const contentShortHelp = `Manage the local content store.`
+
+const contentLongHelp = `Manage the local content store.
+
+Commands:
+
+  ls              List the blobs in the content store.
+  get DIGEST      Write a blob to STDOUT or the file given with --output.
+  rm DIGEST...    Remove blobs, refusing blobs referenced by an image unless --force is given.`
+
+func (cmd *contentCommand) Name() string      { return "content" }
+func (cmd *contentCommand) Args() string      { return "[OPTIONS] COMMAND [DIGEST...]" }
+func (cmd *contentCommand) ShortHelp() string { return contentShortHelp }
+func (cmd *contentCommand) LongHelp() string  { return contentLongHelp }
+func (cmd *contentCommand) Hidden() bool      { return false }
+
+func (cmd *contentCommand) Register(fs *flag.FlagSet) {
+	fs.Var(&cmd.filters, "filter", "Filter ls output based on conditions provided")
+	fs.StringVar(&cmd.output, "output", "", "Write the blob for get to a file, instead of STDOUT")
+	fs.StringVar(&cmd.output, "o", "", "Write the blob for get to a file, instead of STDOUT")
+	fs.BoolVar(&cmd.force, "force", false, "Remove blobs even if they are referenced by an image")
+}
+
+type contentCommand struct {
+	filters stringSlice
+	output  string
+	force   bool
+}
+
+func (cmd *contentCommand) Run(ctx context.Context, args []string) (err error) {
+	if len(args) < 1 {
+		return usageErrorf("must pass a content command (ls, get, rm)")
+	}
+
+	reexec()
+
+	// Create the context.
+	id := identity.NewID()
+	ctx = session.NewContext(ctx, id)
+	ctx = namespaces.WithNamespace(ctx, "buildkit")
+
+	// Create the client.
+	c, err := client.New(stateDir, backend, nil)
+	if err != nil {
+		return systemError{err}
+	}
+	defer c.Close()
+
+	switch args[0] {
+	case "ls":
+		return cmd.list(ctx, c)
+	case "get":
+		if len(args) != 2 {
+			return usageErrorf("must pass a single digest to get")
+		}
+		return cmd.get(ctx, c, args[1])
+	case "rm":
+		if len(args) < 2 {
+			return usageErrorf("must pass a digest to remove")
+		}
+		return cmd.remove(ctx, c, args[1:])
+	default:
+		return usageErrorf("%q is not a valid content command (ls, get, rm)", args[0])
+	}
+}
+
+func (cmd *contentCommand) list(ctx context.Context, c *client.Client) error {
+	infos, err := c.ListContent(ctx, cmd.filters...)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 1, 8, 1, '\t', 0)
+
+	fmt.Fprintln(tw, "DIGEST\tSIZE\tMEDIA TYPE\tREFERENCED")
+
+	for _, info := range infos {
+		mediaType := info.MediaType
+		if mediaType == "" {
+			mediaType = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%t\n",
+			info.Digest,
+			formatBytes(info.Size),
+			mediaType,
+			info.Referenced,
+		)
+	}
+
+	tw.Flush()
+
+	return nil
+}
+
+func (cmd *contentCommand) get(ctx context.Context, c *client.Client, value string) error {
+	dgst, err := digest.Parse(value)
+	if err != nil {
+		return fmt.Errorf("parsing digest %q failed: %v", value, err)
+	}
+
+	var writer io.WriteCloser = os.Stdout
+	if cmd.output != "" {
+		writer, err = os.Create(cmd.output)
+		if err != nil {
+			return err
+		}
+	}
+	defer writer.Close()
+
+	return c.GetContent(ctx, dgst, writer)
+}
+
+func (cmd *contentCommand) remove(ctx context.Context, c *client.Client, values []string) error {
+	for _, value := range values {
+		dgst, err := digest.Parse(value)
+		if err != nil {
+			return fmt.Errorf("parsing digest %q failed: %v", value, err)
+		}
+
+		if err := c.RemoveContent(ctx, dgst, cmd.force); err != nil {
+			return err
+		}
+
+		fmt.Printf("Successfully removed %s\n", dgst)
+	}
+
+	return nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestContentListAndGet(t *testing.T) {
+	runBuild(t, "contentthing", withDockerfile(`
+    FROM busybox
+    RUN echo contenttest
+    `))
+
+	// Get the manifest digest of the image from the ls output.
+	var dgst string
+	for _, line := range strings.Split(run(t, "ls"), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 0 && fields[0] == "contentthing:latest" {
+			dgst = fields[len(fields)-1]
+			break
+		}
+	}
+	if dgst == "" {
+		t.Fatal("expected contentthing:latest in ls output but it was not")
+	}
+
+	out := run(t, "content", "ls")
+	if !strings.Contains(out, dgst) || !strings.Contains(out, "application/vnd.docker.distribution.manifest.v2+json") {
+		t.Fatalf("expected manifest %s in content ls output, got: %s", dgst, out)
+	}
+
+	out = run(t, "content", "get", dgst)
+	if !strings.Contains(out, `"schemaVersion": 2`) && !strings.Contains(out, `"schemaVersion":2`) {
+		t.Fatalf("expected manifest from content get, got: %s", out)
+	}
+
+	// Make sure referenced blobs are not removed without --force.
+	if out, err := doRun([]string{"content", "rm", dgst}, nil); err == nil {
+		t.Fatalf("expected removing referenced content to fail but it did not: %s", out)
+	}
+}
@@ -8,7 +8,6 @@ import (
 	"text/tabwriter"
 
 	"github.com/containerd/containerd/namespaces"
-	units "github.com/docker/go-units"
 	"github.com/mchirico/img/client"
 	controlapi "github.com/moby/buildkit/api/services/control"
 	"github.com/moby/buildkit/identity"
@@ -30,13 +29,20 @@ func (cmd *diskUsageCommand) RequiresRunc() bool { return true }
 func (cmd *diskUsageCommand) Register(fs *flag.FlagSet) {
 	fs.Var(&cmd.filters, "f", "Filter output based on conditions provided")
 	fs.Var(&cmd.filters, "filter", "Filter output based on conditions provided")
+	fs.StringVar(&cmd.format, "format", formatTable, formatHelp)
 }
 
 type diskUsageCommand struct {
 	filters stringSlice
+	format  string
 }
 
 func (cmd *diskUsageCommand) Run(ctx context.Context, args []string) (err error) {
+	format, err := parseListFormat(cmd.format)
+	if err != nil {
+		return err
+	}
+
 	reexec()
 
 	// Create the context.
@@ -47,7 +53,7 @@ func (cmd *diskUsageCommand) Run(ctx context.Context, args []string) (err error)
 	// Create the client.
 	c, err := client.New(stateDir, backend, nil)
 	if err != nil {
-		return err
+		return systemError{err}
 	}
 	defer c.Close()
 
@@ -56,6 +62,15 @@ func (cmd *diskUsageCommand) Run(ctx context.Context, args []string) (err error)
 		return err
 	}
 
+	// The totals are only part of the table.
+	if !format.isTable() {
+		records := make([]interface{}, 0, len(resp.Record))
+		for _, di := range resp.Record {
+			records = append(records, di)
+		}
+		return format.write(os.Stdout, records)
+	}
+
 	tw := tabwriter.NewWriter(os.Stdout, 1, 8, 1, '\t', 0)
 
 	if debug {
@@ -72,7 +87,7 @@ func (cmd *diskUsageCommand) Run(ctx context.Context, args []string) (err error)
 			if len(desc) > 50 {
 				desc = desc[0:50] + "..."
 			}
-			fmt.Fprintf(tw, "%s\t%t\t%s\t%s\n", id, !di.InUse, units.BytesSize(float64(di.Size_)), desc)
+			fmt.Fprintf(tw, "%s\t%t\t%s\t%s\n", id, !di.InUse, formatBytes(di.Size_), desc)
 		}
 
 		tw.Flush()
@@ -92,8 +107,8 @@ func (cmd *diskUsageCommand) Run(ctx context.Context, args []string) (err error)
 		}
 
 		tw = tabwriter.NewWriter(os.Stdout, 1, 8, 1, '\t', 0)
-		fmt.Fprintf(tw, "Reclaimable:\t%s\n", units.BytesSize(float64(reclaimable)))
-		fmt.Fprintf(tw, "Total:\t%s\n", units.BytesSize(float64(total)))
+		fmt.Fprintf(tw, "Reclaimable:\t%s\n", formatBytes(reclaimable))
+		fmt.Fprintf(tw, "Total:\t%s\n", formatBytes(total))
 		tw.Flush()
 	}
 
@@ -109,7 +124,7 @@ func printDebug(tw *tabwriter.Writer, du []*controlapi.UsageRecord) {
 		fmt.Fprintf(tw, "%s:\t%v\n", "Created at", di.CreatedAt)
 		fmt.Fprintf(tw, "%s:\t%v\n", "Mutable", di.Mutable)
 		fmt.Fprintf(tw, "%s:\t%v\n", "Reclaimable", !di.InUse)
-		fmt.Fprintf(tw, "%s:\t%s\n", "Size", units.BytesSize(float64(di.Size_)))
+		fmt.Fprintf(tw, "%s:\t%s\n", "Size", formatBytes(di.Size_))
 		if di.Description != "" {
 			fmt.Fprintf(tw, "%s:\t%v\n", "Description", di.Description)
 		}
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/moby/buildkit/frontend/dockerfile/instructions"
+	"github.com/moby/buildkit/frontend/dockerfile/parser"
+	"github.com/moby/buildkit/frontend/dockerfile/shell"
+)
+
+// dockerfile holds the parsed stages and global ARGs of a Dockerfile so
+// the build can inspect it before handing it over to the frontend.
+type dockerfile struct {
+	stages      []instructions.Stage
+	metaArgs    []instructions.ArgCommand
+	escapeToken rune
+}
+
+// parseDockerfile parses the Dockerfile at the given path.
+func parseDockerfile(path string) (*dockerfile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	result, err := parser.Parse(f)
+	if err != nil {
+		return nil, fmt.Errorf("parsing dockerfile %s failed: %v", path, err)
+	}
+	stages, metaArgs, err := instructions.Parse(result.AST)
+	if err != nil {
+		return nil, fmt.Errorf("parsing dockerfile %s failed: %v", path, err)
+	}
+	if len(stages) < 1 {
+		return nil, fmt.Errorf("dockerfile %s contains no stages", path)
+	}
+
+	return &dockerfile{
+		stages:      stages,
+		metaArgs:    metaArgs,
+		escapeToken: result.EscapeToken,
+	}, nil
+}
+
+// stageIndex returns the index of the stage with the given name. An empty
+// name returns the last stage, like the frontend does for an empty target.
+func (d *dockerfile) stageIndex(name string) (int, error) {
+	if name == "" {
+		return len(d.stages) - 1, nil
+	}
+	for i, stage := range d.stages {
+		if strings.EqualFold(stage.Name, name) {
+			return i, nil
+		}
+	}
+	return -1, fmt.Errorf("target stage %s could not be found", name)
+}
+
+// baseImage returns the image the target stage is ultimately built from,
+// following FROM references to previous stages. It returns an empty string
+// for stages built from scratch.
+func (d *dockerfile) baseImage(target string, buildArgs map[string]string) (string, error) {
+	i, err := d.stageIndex(target)
+	if err != nil {
+		return "", err
+	}
+	return d.stageBaseImage(i, buildArgs)
+}
+
+// stageBaseImage returns the image the stage at index i is ultimately built
+// from, like baseImage.
+func (d *dockerfile) stageBaseImage(i int, buildArgs map[string]string) (string, error) {
+	lex := shell.NewLex(d.escapeToken)
+	args := d.globalArgs(buildArgs)
+	for {
+		name, err := lex.ProcessWordWithMap(d.stages[i].BaseName, args)
+		if err != nil {
+			return "", fmt.Errorf("expanding base name %s failed: %v", d.stages[i].BaseName, err)
+		}
+		if name == "" {
+			return "", fmt.Errorf("base name %s of stage %d expands to an empty value", d.stages[i].BaseName, i)
+		}
+		if name == "scratch" {
+			return "", nil
+		}
+
+		// Follow the reference if the base is one of the previous stages.
+		found := false
+		for j := 0; j < i; j++ {
+			if strings.EqualFold(d.stages[j].Name, name) {
+				i, found = j, true
+				break
+			}
+		}
+		if !found {
+			return name, nil
+		}
+	}
+}
+
+// stageBases returns the expanded base image of each stage, empty for the
+// stages built from scratch or from a previous stage.
+func (d *dockerfile) stageBases(buildArgs map[string]string) ([]string, error) {
+	lex := shell.NewLex(d.escapeToken)
+	args := d.globalArgs(buildArgs)
+	bases := make([]string, len(d.stages))
+	for i, stage := range d.stages {
+		name, err := lex.ProcessWordWithMap(stage.BaseName, args)
+		if err != nil {
+			return nil, fmt.Errorf("expanding base name %s failed: %v", stage.BaseName, err)
+		}
+		if name == "" {
+			return nil, fmt.Errorf("base name %s of stage %d expands to an empty value", stage.BaseName, i)
+		}
+		if _, ok := d.previousStage(i, name); ok || name == "scratch" {
+			continue
+		}
+		bases[i] = name
+	}
+	return bases, nil
+}
+
+// hasRunCommands returns if any stage of the dockerfile has a RUN
+// instruction, which needs to execute binaries of the target platform.
+func (d *dockerfile) hasRunCommands() bool {
+	for _, stage := range d.stages {
+		for _, cmd := range stage.Commands {
+			if _, ok := cmd.(*instructions.RunCommand); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// reachableStages returns which stages the target stages depend on through
+// FROM and COPY --from, including the targets themselves. No targets is the
+// last stage.
+func (d *dockerfile) reachableStages(targets []string, buildArgs map[string]string) ([]bool, error) {
+	if len(targets) == 0 {
+		targets = []string{""}
+	}
+	var pending []int
+	for _, target := range targets {
+		i, err := d.stageIndex(target)
+		if err != nil {
+			return nil, err
+		}
+		pending = append(pending, i)
+	}
+
+	lex := shell.NewLex(d.escapeToken)
+	args := d.globalArgs(buildArgs)
+	reachable := make([]bool, len(d.stages))
+	for len(pending) > 0 {
+		i := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if reachable[i] {
+			continue
+		}
+		reachable[i] = true
+
+		name, err := lex.ProcessWordWithMap(d.stages[i].BaseName, args)
+		if err != nil {
+			return nil, fmt.Errorf("expanding base name %s failed: %v", d.stages[i].BaseName, err)
+		}
+		if j, ok := d.previousStage(i, name); ok {
+			pending = append(pending, j)
+		}
+		for _, cmd := range d.stages[i].Commands {
+			if c, ok := cmd.(*instructions.CopyCommand); ok && c.From != "" {
+				if j, ok := d.previousStage(i, c.From); ok {
+					pending = append(pending, j)
+				}
+			}
+		}
+	}
+	return reachable, nil
+}
+
+// previousStage returns the index of the stage before the stage at index i
+// referenced by name or by index, like FROM and COPY --from do.
+func (d *dockerfile) previousStage(i int, ref string) (int, bool) {
+	for j := 0; j < i; j++ {
+		if strings.EqualFold(d.stages[j].Name, ref) {
+			return j, true
+		}
+	}
+	if j, err := strconv.Atoi(ref); err == nil && j >= 0 && j < i {
+		return j, true
+	}
+	return -1, false
+}
+
+// unreachableStages returns the names of the stages the target stages do not
+// depend on, or their index for stages without a name, in dockerfile order.
+func (d *dockerfile) unreachableStages(targets []string, buildArgs map[string]string) ([]string, error) {
+	reachable, err := d.reachableStages(targets, buildArgs)
+	if err != nil {
+		return nil, err
+	}
+	var unreachable []string
+	for i, stage := range d.stages {
+		if reachable[i] {
+			continue
+		}
+		name := stage.Name
+		if name == "" {
+			name = strconv.Itoa(i)
+		}
+		unreachable = append(unreachable, name)
+	}
+	return unreachable, nil
+}
+
+// unusedArgs returns the sorted names of the build args that none of the
+// stages the targets depend on use: they are not declared with ARG in those
+// stages and global ARGs are not referenced by their FROM either.
+func (d *dockerfile) unusedArgs(targets []string, buildArgs map[string]string) ([]string, error) {
+	reachable, err := d.reachableStages(targets, buildArgs)
+	if err != nil {
+		return nil, err
+	}
+
+	used := map[string]bool{}
+	for i, stage := range d.stages {
+		if !reachable[i] {
+			continue
+		}
+		for _, cmd := range stage.Commands {
+			if arg, ok := cmd.(*instructions.ArgCommand); ok {
+				used[arg.Key] = true
+			}
+		}
+		for _, arg := range d.metaArgs {
+			if referencesArg(stage.BaseName, arg.Key) {
+				used[arg.Key] = true
+			}
+		}
+	}
+
+	var unused []string
+	for name := range buildArgs {
+		if !used[name] && !builtinArgs[name] {
+			unused = append(unused, name)
+		}
+	}
+	sort.Strings(unused)
+	return unused, nil
+}
+
+// printUnused warns about the build args the target stages do not use and
+// the stages they do not depend on, so they can be cleaned up.
+func (d *dockerfile) printUnused(w io.Writer, targets []string, buildArgs map[string]string) error {
+	args, err := d.unusedArgs(targets, buildArgs)
+	if err != nil {
+		return err
+	}
+	stages, err := d.unreachableStages(targets, buildArgs)
+	if err != nil {
+		return err
+	}
+	for _, arg := range args {
+		fmt.Fprintf(w, "WARNING: build-arg %s was set but is not used by the build\n", arg)
+	}
+	for _, stage := range stages {
+		fmt.Fprintf(w, "WARNING: stage %s is not used by the build of the target stage\n", stage)
+	}
+	return nil
+}
+
+// referencesArg returns if the word references the variable as $name or
+// ${name}, with or without a modifier.
+func referencesArg(word, name string) bool {
+	return regexp.MustCompile(`\$(\{` + regexp.QuoteMeta(name) + `[}:]|` + regexp.QuoteMeta(name) + `\b)`).MatchString(word)
+}
+
+// builtinArgs are the build args that can be used without being declared
+// with ARG.
+var builtinArgs = map[string]bool{
+	"HTTP_PROXY":  true,
+	"http_proxy":  true,
+	"HTTPS_PROXY": true,
+	"https_proxy": true,
+	"FTP_PROXY":   true,
+	"ftp_proxy":   true,
+	"NO_PROXY":    true,
+	"no_proxy":    true,
+	"ALL_PROXY":   true,
+	"all_proxy":   true,
+}
+
+// undeclaredArgs returns the sorted names of the build args that are not
+// declared with ARG in any stage or before the first FROM.
+func (d *dockerfile) undeclaredArgs(buildArgs map[string]string) []string {
+	declared := map[string]bool{}
+	for _, arg := range d.metaArgs {
+		declared[arg.Key] = true
+	}
+	for _, stage := range d.stages {
+		for _, cmd := range stage.Commands {
+			if arg, ok := cmd.(*instructions.ArgCommand); ok {
+				declared[arg.Key] = true
+			}
+		}
+	}
+
+	var undeclared []string
+	for name := range buildArgs {
+		if !declared[name] && !builtinArgs[name] {
+			undeclared = append(undeclared, name)
+		}
+	}
+	sort.Strings(undeclared)
+	return undeclared
+}
+
+// globalArgs returns the ARGs declared before the first FROM with their
+// default values overridden by the given build args.
+func (d *dockerfile) globalArgs(buildArgs map[string]string) map[string]string {
+	args := map[string]string{}
+	for _, arg := range d.metaArgs {
+		if v, ok := buildArgs[arg.Key]; ok {
+			args[arg.Key] = v
+			continue
+		}
+		if arg.Value != nil {
+			args[arg.Key] = *arg.Value
+		}
+	}
+	return args
+}
+
+// imageConfig holds the parts of a base image config that are inspected
+// before the build.
+type imageConfig struct {
+	Config struct {
+		OnBuild []string          `json:"OnBuild,omitempty"`
+		Labels  map[string]string `json:"Labels,omitempty"`
+	} `json:"config"`
+}
+
+// printOnBuildTriggers prints a note listing the ONBUILD triggers of the base
+// image config, since they ran as part of the build.
+func printOnBuildTriggers(w io.Writer, base string, config []byte) error {
+	var img imageConfig
+	if err := json.Unmarshal(config, &img); err != nil {
+		return fmt.Errorf("parsing image config failed: %v", err)
+	}
+	if len(img.Config.OnBuild) < 1 {
+		return nil
+	}
+
+	fmt.Fprintf(w, "Note: base image %s has ONBUILD triggers that ran as part of this build:\n", base)
+	for _, trigger := range img.Config.OnBuild {
+		fmt.Fprintf(w, "  ONBUILD %s\n", trigger)
+	}
+	return nil
+}
+
+// inheritLabels returns the labels of the base image config merged with the
+// given labels, which take precedence.
+func inheritLabels(config []byte, labels map[string]string) (map[string]string, error) {
+	var img imageConfig
+	if err := json.Unmarshal(config, &img); err != nil {
+		return nil, fmt.Errorf("parsing image config failed: %v", err)
+	}
+
+	merged := map[string]string{}
+	for k, v := range img.Config.Labels {
+		merged[k] = v
+	}
+	for k, v := range labels {
+		merged[k] = v
+	}
+	return merged, nil
+}
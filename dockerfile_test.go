@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDockerfileBaseImage(t *testing.T) {
+	tmpd, err := ioutil.TempDir("", "img-dockerfile")
+	if err != nil {
+		t.Fatalf("creating temporary directory failed: %v", err)
+	}
+	defer os.RemoveAll(tmpd)
+
+	dockerfilePath := filepath.Join(tmpd, "Dockerfile")
+	if err := ioutil.WriteFile(dockerfilePath, []byte(`
+ARG BASE=alpine
+FROM ${BASE} AS builder
+RUN echo builder
+FROM builder AS final
+RUN echo final
+FROM scratch AS empty
+`), 0644); err != nil {
+		t.Fatalf("writing dockerfile failed: %v", err)
+	}
+
+	df, err := parseDockerfile(dockerfilePath)
+	if err != nil {
+		t.Fatalf("parsing dockerfile failed: %v", err)
+	}
+	if !df.hasRunCommands() {
+		t.Fatal("expected dockerfile to have RUN commands")
+	}
+
+	testCases := []struct {
+		target    string
+		buildArgs map[string]string
+		expected  string
+	}{
+		{target: "final", expected: "alpine"},
+		{target: "final", buildArgs: map[string]string{"BASE": "busybox"}, expected: "busybox"},
+		{target: "", expected: ""},
+	}
+	for _, tc := range testCases {
+		base, err := df.baseImage(tc.target, tc.buildArgs)
+		if err != nil {
+			t.Fatalf("getting base image for target %q failed: %v", tc.target, err)
+		}
+		if base != tc.expected {
+			t.Fatalf("expected base image %q for target %q, got: %q", tc.expected, tc.target, base)
+		}
+	}
+
+	if _, err := df.baseImage("nope", nil); err == nil {
+		t.Fatal("expected getting the base image of a missing target to fail but it did not")
+	}
+}
+
+func TestPrintOnBuildTriggers(t *testing.T) {
+	var buf bytes.Buffer
+	config := `{"architecture":"amd64","os":"linux","config":{"OnBuild":["COPY . /app","RUN make"]}}`
+	if err := printOnBuildTriggers(&buf, "onbuild:latest", []byte(config)); err != nil {
+		t.Fatalf("printing ONBUILD triggers failed: %v", err)
+	}
+
+	out := buf.String()
+	if !strings.Contains(out, "onbuild:latest has ONBUILD triggers") ||
+		!strings.Contains(out, "ONBUILD COPY . /app") ||
+		!strings.Contains(out, "ONBUILD RUN make") {
+		t.Fatalf("expected note listing the ONBUILD triggers, got: %s", out)
+	}
+
+	// Make sure nothing is printed without triggers.
+	buf.Reset()
+	if err := printOnBuildTriggers(&buf, "busybox:latest", []byte(`{"config":{}}`)); err != nil {
+		t.Fatalf("printing ONBUILD triggers failed: %v", err)
+	}
+	if buf.Len() > 0 {
+		t.Fatalf("expected no note for an image without ONBUILD triggers, got: %s", buf.String())
+	}
+}
+
+func TestInheritLabels(t *testing.T) {
+	config := `{"config":{"Labels":{"maintainer":"base@example.com","vendor":"Base Inc."}}}`
+
+	labels, err := inheritLabels([]byte(config), map[string]string{"vendor": "img", "version": "1.0"})
+	if err != nil {
+		t.Fatalf("inheriting labels failed: %v", err)
+	}
+
+	expected := map[string]string{
+		"maintainer": "base@example.com",
+		"vendor":     "img",
+		"version":    "1.0",
+	}
+	if !reflect.DeepEqual(labels, expected) {
+		t.Fatalf("expected labels %v, got: %v", expected, labels)
+	}
+}
+
+func TestDockerfileUnused(t *testing.T) {
+	tmpd, err := ioutil.TempDir("", "img-dockerfile")
+	if err != nil {
+		t.Fatalf("creating temporary directory failed: %v", err)
+	}
+	defer os.RemoveAll(tmpd)
+
+	dockerfilePath := filepath.Join(tmpd, "Dockerfile")
+	if err := ioutil.WriteFile(dockerfilePath, []byte(`
+ARG BASE=alpine
+FROM ${BASE} AS builder
+ARG VERSION
+RUN echo $VERSION > /version
+FROM busybox AS orphan
+ARG DEBUG
+FROM scratch
+COPY --from=builder /version /version
+`), 0644); err != nil {
+		t.Fatalf("writing dockerfile failed: %v", err)
+	}
+
+	df, err := parseDockerfile(dockerfilePath)
+	if err != nil {
+		t.Fatalf("parsing dockerfile failed: %v", err)
+	}
+
+	buildArgs := map[string]string{"BASE": "busybox", "VERSION": "1.0", "DEBUG": "1", "HTTP_PROXY": "http://proxy"}
+	var buf bytes.Buffer
+	if err := df.printUnused(&buf, nil, buildArgs); err != nil {
+		t.Fatalf("checking for unused build-args and stages failed: %v", err)
+	}
+
+	expected := `WARNING: build-arg DEBUG was set but is not used by the build
+WARNING: stage orphan is not used by the build of the target stage
+`
+	if buf.String() != expected {
+		t.Fatalf("expected warnings %q, got: %q", expected, buf.String())
+	}
+
+	// Building the orphan stage itself uses DEBUG but none of the others.
+	buf.Reset()
+	if err := df.printUnused(&buf, []string{"orphan"}, buildArgs); err != nil {
+		t.Fatalf("checking for unused build-args and stages failed: %v", err)
+	}
+	if out := buf.String(); !strings.Contains(out, "build-arg VERSION") || strings.Contains(out, "build-arg DEBUG") || !strings.Contains(out, "stage builder") || !strings.Contains(out, "stage 2") {
+		t.Fatalf("expected warnings for VERSION, builder and the unnamed stage, got: %s", out)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/containerd/containerd/platforms"
+	specs "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// binfmtMiscDir is where the kernel exposes the registered binfmt_misc
+// handlers.
+var binfmtMiscDir = "/proc/sys/fs/binfmt_misc"
+
+// qemuArchs maps the architectures of the OCI spec to the names used by the
+// qemu-user binfmt handlers.
+var qemuArchs = map[string]string{
+	"386":      "i386",
+	"amd64":    "x86_64",
+	"arm":      "arm",
+	"arm64":    "aarch64",
+	"mips64le": "mips64el",
+	"ppc64le":  "ppc64le",
+	"riscv64":  "riscv64",
+	"s390x":    "s390x",
+}
+
+// compatArchs are the architectures a host can run natively besides its own.
+var compatArchs = map[string][]string{
+	"amd64": {"386"},
+	"arm64": {"arm"},
+}
+
+// checkEmulation makes sure there is a binfmt_misc handler registered for
+// every target platform the host can not run natively.
+func checkEmulation(dir string, host specs.Platform, targets []string) error {
+	_, missing, err := emulatedPlatforms(dir, host, targets)
+	if err != nil {
+		return err
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("no emulator is registered in %s for %s, RUN instructions will fail: install qemu-user-static or run the binfmt setup (e.g. `docker run --privileged --rm tonistiigi/binfmt --install all`)", dir, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// fallbackPlatforms returns the target platforms the host can run natively or
+// through an emulator, for --platform-fallback, along with the ones it skips.
+// It errors if none of them can be built.
+func fallbackPlatforms(dir string, host specs.Platform, targets []string) ([]string, []string, error) {
+	supported, missing, err := emulatedPlatforms(dir, host, targets)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(supported) < 1 {
+		return nil, nil, fmt.Errorf("no emulator is registered in %s for any of the platforms %s, there is nothing left to build", dir, strings.Join(missing, ", "))
+	}
+	return supported, missing, nil
+}
+
+// emulatedPlatforms splits the target platforms into the ones the host can
+// run natively or through a registered binfmt_misc handler and the ones it can
+// not, keeping their order.
+func emulatedPlatforms(dir string, host specs.Platform, targets []string) ([]string, []string, error) {
+	native := platforms.Only(host)
+
+	var supported, missing []string
+	for _, target := range targets {
+		p, err := parsePlatform(target)
+		if err != nil {
+			return nil, nil, fmt.Errorf("parsing platform %s failed: %v", target, err)
+		}
+		if native.Match(p) || p.OS != host.OS || isCompatArch(host.Architecture, p.Architecture) || binfmtRegistered(dir, p.Architecture) {
+			supported = append(supported, target)
+			continue
+		}
+		missing = append(missing, platforms.Format(p))
+	}
+	return supported, missing, nil
+}
+
+func isCompatArch(host, arch string) bool {
+	for _, a := range compatArchs[host] {
+		if a == arch {
+			return true
+		}
+	}
+	return false
+}
+
+// binfmtRegistered returns if there is an enabled binfmt_misc handler for
+// the architecture.
+func binfmtRegistered(dir, arch string) bool {
+	name, ok := qemuArchs[arch]
+	if !ok {
+		name = arch
+	}
+	b, err := ioutil.ReadFile(filepath.Join(dir, "qemu-"+name))
+	if err != nil {
+		return false
+	}
+	return strings.HasPrefix(string(b), "enabled")
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	specs "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestCheckEmulation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "img-binfmt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	host := specs.Platform{OS: "linux", Architecture: "amd64"}
+
+	// Native platforms never need an emulator.
+	if err := checkEmulation(dir, host, []string{"linux/amd64", "linux/386"}); err != nil {
+		t.Fatalf("expected no error for native platforms, got: %v", err)
+	}
+
+	err = checkEmulation(dir, host, []string{"linux/amd64", "linux/arm64"})
+	if err == nil || !strings.Contains(err.Error(), "linux/arm64") || !strings.Contains(err.Error(), "qemu-user-static") {
+		t.Fatalf("expected missing emulator error for linux/arm64, got: %v", err)
+	}
+
+	// Disabled handlers do not count.
+	if err := ioutil.WriteFile(filepath.Join(dir, "qemu-aarch64"), []byte("disabled\ninterpreter /usr/bin/qemu-aarch64\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkEmulation(dir, host, []string{"linux/arm64"}); err == nil {
+		t.Fatal("expected missing emulator error for a disabled handler")
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "qemu-aarch64"), []byte("enabled\ninterpreter /usr/bin/qemu-aarch64\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkEmulation(dir, host, []string{"linux/arm64"}); err != nil {
+		t.Fatalf("expected no error with a registered handler, got: %v", err)
+	}
+}
+
+func TestFallbackPlatforms(t *testing.T) {
+	dir, err := ioutil.TempDir("", "img-binfmt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	host := specs.Platform{OS: "linux", Architecture: "amd64"}
+
+	// Only the platform without an emulator is skipped.
+	supported, skipped, err := fallbackPlatforms(dir, host, []string{"linux/amd64", "linux/arm64"})
+	if err != nil {
+		t.Fatalf("expected the build to proceed for linux/amd64, got: %v", err)
+	}
+	if !reflect.DeepEqual(supported, []string{"linux/amd64"}) {
+		t.Fatalf("expected to build linux/amd64, got: %v", supported)
+	}
+	if !reflect.DeepEqual(skipped, []string{"linux/arm64"}) {
+		t.Fatalf("expected to skip linux/arm64, got: %v", skipped)
+	}
+
+	// Nothing is left to build without any emulator.
+	if _, _, err := fallbackPlatforms(dir, host, []string{"linux/arm64", "linux/s390x"}); err == nil || !strings.Contains(err.Error(), "nothing left to build") {
+		t.Fatalf("expected an error when no platform can be built, got: %v", err)
+	}
+}
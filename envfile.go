@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// autoEnvFile is the env file in the root of the context the build args and
+// labels are loaded from unless --no-env-auto is set.
+var autoEnvFile = filepath.Join(".img", "build.env")
+
+// autoEnvLabelPrefix is the prefix of the keys of the auto env file that set
+// a label instead of a build arg.
+const autoEnvLabelPrefix = "label:"
+
+// readAutoEnvFile reads the build args and labels of the auto env file in the
+// context directory, both are empty if there is none.
+func readAutoEnvFile(contextDir string) (map[string]string, map[string]string, error) {
+	path := filepath.Join(contextDir, autoEnvFile)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil, nil, nil
+	}
+	env, err := readEnvFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	args, labels := map[string]string{}, map[string]string{}
+	for k, v := range env {
+		if strings.HasPrefix(k, autoEnvLabelPrefix) {
+			labels[strings.TrimPrefix(k, autoEnvLabelPrefix)] = v
+			continue
+		}
+		args[k] = v
+	}
+	return args, labels, nil
+}
+
+// readEnvFile reads the KEY=VALUE lines of an env file for --env-file.
+func readEnvFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening env file failed: %v", err)
+	}
+	defer f.Close()
+
+	env, err := parseEnvFile(f)
+	if err != nil {
+		return nil, fmt.Errorf("parsing env file %s failed: %v", path, err)
+	}
+	return env, nil
+}
+
+// parseEnvFile parses the lines of a .env file. Empty lines and lines
+// starting with # are skipped, an `export ` prefix is allowed and values can
+// be double quoted, with escapes, or single quoted, taken literally.
+func parseEnvFile(r io.Reader) (map[string]string, error) {
+	env := map[string]string{}
+
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimSpace(strings.TrimPrefix(line, "export "))
+
+		kv := strings.SplitN(line, "=", 2)
+		key := strings.TrimSpace(kv[0])
+		if len(kv) != 2 || key == "" || strings.ContainsAny(key, " \t") {
+			return nil, fmt.Errorf("line %d: invalid variable %q, must be KEY=VALUE", n, line)
+		}
+
+		value, err := parseEnvValue(strings.TrimSpace(kv[1]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid value for %s: %v", n, key, err)
+		}
+		env[key] = value
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	return env, nil
+}
+
+// parseEnvValue unquotes a value and strips a trailing comment.
+func parseEnvValue(value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+
+	quote := value[0]
+	if quote != '"' && quote != '\'' {
+		// Unquoted values end at a comment.
+		if i := strings.Index(value, " #"); i >= 0 {
+			value = value[:i]
+		}
+		return strings.TrimSpace(value), nil
+	}
+
+	// Find the closing quote, skipping escaped ones in double quotes.
+	end := -1
+	for i := 1; i < len(value); i++ {
+		if quote == '"' && value[i] == '\\' {
+			i++
+			continue
+		}
+		if value[i] == quote {
+			end = i
+			break
+		}
+	}
+	if end < 0 {
+		return "", fmt.Errorf("missing closing quote in %s", value)
+	}
+	if rest := strings.TrimSpace(value[end+1:]); rest != "" && !strings.HasPrefix(rest, "#") {
+		return "", fmt.Errorf("unexpected %q after the closing quote", rest)
+	}
+
+	if quote == '\'' {
+		return value[1:end], nil
+	}
+	return strconv.Unquote(value[:end+1])
+}
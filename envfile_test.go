@@ -0,0 +1,48 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseEnvFile(t *testing.T) {
+	env, err := parseEnvFile(strings.NewReader(`# The version to build.
+VERSION=1.0.0
+
+export GOFLAGS=-mod=vendor
+  NAME = img
+GREETING="hello \"world\"\n" # double quoted
+RAW='no $escapes\n here'
+EMPTY=
+URL=https://example.com/#anchor # unquoted with a comment
+`))
+	if err != nil {
+		t.Fatalf("parsing env file failed: %v", err)
+	}
+
+	expected := map[string]string{
+		"VERSION":  "1.0.0",
+		"GOFLAGS":  "-mod=vendor",
+		"NAME":     "img",
+		"GREETING": "hello \"world\"\n",
+		"RAW":      `no $escapes\n here`,
+		"EMPTY":    "",
+		"URL":      "https://example.com/#anchor",
+	}
+	if !reflect.DeepEqual(env, expected) {
+		t.Fatalf("expected env %v, got: %v", expected, env)
+	}
+
+	for _, invalid := range []string{
+		"NOVALUE",
+		"=value",
+		"BAD KEY=value",
+		`UNTERMINATED="value`,
+		`TRAILING="value" junk`,
+	} {
+		if _, err := parseEnvFile(strings.NewReader(invalid)); err == nil {
+			t.Fatalf("expected parsing %q to fail but it did not", invalid)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/genuinetools/pkg/cli"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// The exit codes of img.
+const (
+	exitSuccess = 0
+	// exitError is any failure that does not have a more specific code.
+	exitError = 1
+	// exitUsage is a command invoked with missing or invalid arguments.
+	exitUsage = 2
+	// exitSystem is a failure setting up img itself, such as creating the
+	// client for the state directory or re-executing in a user namespace.
+	exitSystem = 125
+	// exitInterrupted is a command stopped by SIGINT or SIGTERM.
+	exitInterrupted = 130
+)
+
+// usageError is returned for a command invoked with missing or invalid
+// arguments.
+type usageError string
+
+func (e usageError) Error() string { return string(e) }
+
+func usageErrorf(format string, a ...interface{}) error {
+	return usageError(fmt.Sprintf(format, a...))
+}
+
+// systemError wraps the errors of setting up img itself.
+type systemError struct {
+	err error
+}
+
+func (e systemError) Error() string { return e.err.Error() }
+
+// quietError prints the error it wraps on a single line, for scripts reading
+// the error of build -q. The exit code is the one of the wrapped error.
+type quietError struct {
+	err error
+}
+
+func (e quietError) Error() string {
+	var lines []string
+	for _, line := range strings.Split(e.err.Error(), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, " ")
+}
+
+// Cause returns the wrapped error for exitCode.
+func (e quietError) Cause() error { return e.err }
+
+// exitCode returns the exit code for the error returned by a command.
+func exitCode(err error) int {
+	if err == nil {
+		return exitSuccess
+	}
+
+	switch cause := errors.Cause(err); cause.(type) {
+	case usageError:
+		return exitUsage
+	case systemError:
+		return exitSystem
+	default:
+		if cause == context.Canceled {
+			return exitInterrupted
+		}
+	}
+	return exitError
+}
+
+// exitOnError prints the error and exits with its exit code. Generic errors
+// are returned as is so that the cli program prints them and exits with 1.
+func exitOnError(err error) error {
+	code := exitCode(err)
+	if code == exitSuccess || code == exitError {
+		return err
+	}
+	fmt.Fprintln(os.Stderr, err.Error())
+	os.Exit(code)
+	return nil
+}
+
+// fatalSystemf logs the error and exits with exitSystem.
+func fatalSystemf(format string, args ...interface{}) {
+	logrus.Errorf(format, args...)
+	os.Exit(exitSystem)
+}
+
+// exitCodeCommand makes the command exit with the exit code of its error.
+type exitCodeCommand struct {
+	cli.Command
+}
+
+func (cmd exitCodeCommand) Run(ctx context.Context, args []string) error {
+	return exitOnError(cmd.Command.Run(ctx, args))
+}
+
+// withExitCodes wraps the commands to exit with the exit code of their errors.
+func withExitCodes(commands []cli.Command) []cli.Command {
+	wrapped := make([]cli.Command, 0, len(commands))
+	for _, command := range commands {
+		wrapped = append(wrapped, exitCodeCommand{Command: command})
+	}
+	return wrapped
+}
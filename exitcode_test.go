@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"testing"
+
+	pkgerrors "github.com/pkg/errors"
+)
+
+func TestExitCode(t *testing.T) {
+	testCases := []struct {
+		err      error
+		expected int
+	}{
+		{err: nil, expected: exitSuccess},
+		{err: errors.New("nope"), expected: exitError},
+		{err: usageErrorf("must pass an image to %s", "pull"), expected: exitUsage},
+		{err: systemError{errors.New("creating state directory failed")}, expected: exitSystem},
+		{err: pkgerrors.Wrap(context.Canceled, "failed to solve"), expected: exitInterrupted},
+		{err: quietError{usageErrorf("must pass a path to build")}, expected: exitUsage},
+		{err: quietError{systemError{errors.New("creating state directory failed")}}, expected: exitSystem},
+		// Errors formatted into another error lose their type.
+		{err: fmt.Errorf("wrapped: %v", usageErrorf("usage")), expected: exitError},
+	}
+	for _, tc := range testCases {
+		if code := exitCode(tc.err); code != tc.expected {
+			t.Fatalf("expected exit code %d for %v, got: %d", tc.expected, tc.err, code)
+		}
+	}
+}
+
+func TestUsageExitCodes(t *testing.T) {
+	for _, args := range [][]string{
+		{"build"},
+		{"tag", "busybox"},
+		{"content", "nope"},
+		{"config", "nope"},
+		{"ls", "--backend", "nope"},
+		{"ls", "--nope"},
+		{"build", "-o", "type=oci,dest=image.tar", "-t", "named", "-"},
+		{"build", "--platform", "all", "--platform", "linux/amd64", "-t", "named", "-"},
+		{"build", "--all-platforms", "--platform", "linux/arm64", "-t", "named", "-"},
+		{"build", "--attest", "type=sbom", "-t", "named", "-"},
+	} {
+		cmd := exec.Command("./testimg"+exeSuffix, append([]string{args[0], "--state", testStateDir}, args[1:]...)...)
+		err := cmd.Run()
+		exitErr, ok := err.(*exec.ExitError)
+		if !ok {
+			t.Fatalf("expected img %v to exit with an error, got: %v", args, err)
+		}
+		if code := exitErr.ExitCode(); code != exitUsage {
+			t.Fatalf("expected img %v to exit with %d, got: %d", args, exitUsage, code)
+		}
+	}
+}
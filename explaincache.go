@@ -0,0 +1,277 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	controlapi "github.com/moby/buildkit/api/services/control"
+	digest "github.com/opencontainers/go-digest"
+)
+
+// explainCacheDir is the directory in the state directory holding the record
+// of the previous build of each context for --explain-cache.
+const explainCacheDir = "explain-cache"
+
+// maxExplainedFiles is how many of the changed files are listed for a step.
+const maxExplainedFiles = 3
+
+// cacheRecord is what a build is compared with by --explain-cache: the build
+// args, the digests of the files in the context and the digests of the
+// vertices of the dockerfile steps by name.
+type cacheRecord struct {
+	BuildArgs map[string]string        `json:"buildArgs"`
+	Files     map[string]digest.Digest `json:"files"`
+	Steps     map[string]digest.Digest `json:"steps"`
+}
+
+// cacheResult is whether a step hit the cache and, if not, why.
+type cacheResult struct {
+	Step   string
+	Cached bool
+	Reason string
+}
+
+// cacheExplainer collects the vertices of the dockerfile steps from the
+// status responses and explains the cache misses by comparing the build with
+// the record of the previous one.
+type cacheExplainer struct {
+	mu sync.Mutex
+
+	previous *cacheRecord
+	current  cacheRecord
+	order    []digest.Digest
+	vertices map[digest.Digest]*controlapi.Vertex
+}
+
+// newCacheExplainer returns an explainer of the build with the build args and
+// context files, previous is nil for the first build.
+func newCacheExplainer(previous *cacheRecord, buildArgs map[string]string, files map[string]digest.Digest) *cacheExplainer {
+	return &cacheExplainer{
+		previous: previous,
+		current:  cacheRecord{BuildArgs: buildArgs, Files: files, Steps: map[string]digest.Digest{}},
+		vertices: map[digest.Digest]*controlapi.Vertex{},
+	}
+}
+
+// update records the vertices of the dockerfile steps in the status
+// response. It is a no-op on a nil explainer.
+func (e *cacheExplainer) update(resp *controlapi.StatusResponse) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, v := range resp.Vertexes {
+		if !isDockerfileStep(v.Name) {
+			continue
+		}
+		if _, ok := e.vertices[v.Digest]; !ok {
+			e.order = append(e.order, v.Digest)
+		}
+		e.vertices[v.Digest] = v
+	}
+}
+
+// record returns the record of the build to compare the next one with, with
+// the steps that completed.
+func (e *cacheExplainer) record() cacheRecord {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, dgst := range e.order {
+		if v := e.vertices[dgst]; v.Completed != nil && v.Error == "" {
+			e.current.Steps[v.Name] = v.Digest
+		}
+	}
+	return e.current
+}
+
+// results returns whether each step hit the cache in the order they started,
+// with the reason of each miss.
+func (e *cacheExplainer) results() []cacheResult {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	results := make([]cacheResult, 0, len(e.order))
+	for _, dgst := range e.order {
+		v := e.vertices[dgst]
+		result := cacheResult{Step: v.Name, Cached: v.Cached}
+		if !v.Cached {
+			result.Reason = e.reason(v)
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// reason explains why the vertex missed the cache.
+func (e *cacheExplainer) reason(v *controlapi.Vertex) string {
+	if e.previous == nil {
+		return "no previous build to compare with"
+	}
+	previous, ok := e.previous.Steps[v.Name]
+	if !ok {
+		return "the step is new or changed in the dockerfile"
+	}
+
+	instruction := stepInstruction(v.Name)
+	if instruction == "FROM" && previous != v.Digest {
+		return "the base image resolved to a different digest"
+	}
+	for _, input := range v.Inputs {
+		if in, ok := e.vertices[input]; ok && !in.Cached {
+			return fmt.Sprintf("the input step %s missed the cache", in.Name)
+		}
+	}
+	if previous == v.Digest {
+		return "the step did not change, its cache was pruned"
+	}
+	switch instruction {
+	case "RUN":
+		if changed := changedKeys(e.previous.BuildArgs, e.current.BuildArgs); len(changed) > 0 {
+			return "build-args changed: " + strings.Join(changed, ", ")
+		}
+	case "COPY", "ADD":
+		if changed := changedFiles(e.previous.Files, e.current.Files); len(changed) > 0 {
+			if len(changed) > maxExplainedFiles {
+				changed = append(changed[:maxExplainedFiles], fmt.Sprintf("and %d more", len(changed)-maxExplainedFiles))
+			}
+			return "files changed: " + strings.Join(changed, ", ")
+		}
+	}
+	return "the step or its inputs changed"
+}
+
+// print writes the results as a table.
+func (e *cacheExplainer) print(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 1, 8, 1, '\t', 0)
+	fmt.Fprintln(tw, "STEP\tCACHE\tREASON")
+	for _, result := range e.results() {
+		cache := "hit"
+		if !result.Cached {
+			cache = "miss"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", result.Step, cache, result.Reason)
+	}
+	tw.Flush()
+}
+
+// isDockerfileStep returns if the vertex is a step of the dockerfile, which
+// the frontend names like "[2/3] RUN make", and not an internal one.
+func isDockerfileStep(name string) bool {
+	return strings.HasPrefix(name, "[") && !strings.HasPrefix(name, "[internal]") && strings.Contains(name, "] ")
+}
+
+// stepInstruction returns the instruction of the dockerfile step, e.g. RUN.
+func stepInstruction(name string) string {
+	step := name[strings.Index(name, "] ")+2:]
+	return strings.ToUpper(strings.SplitN(step, " ", 2)[0])
+}
+
+// changedKeys returns the sorted keys that were added, removed or whose
+// values changed.
+func changedKeys(previous, current map[string]string) []string {
+	var changed []string
+	for k, v := range current {
+		if p, ok := previous[k]; !ok || p != v {
+			changed = append(changed, k)
+		}
+	}
+	for k := range previous {
+		if _, ok := current[k]; !ok {
+			changed = append(changed, k)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// changedFiles returns the sorted paths of the files that were added, removed
+// or whose content changed.
+func changedFiles(previous, current map[string]digest.Digest) []string {
+	p := make(map[string]string, len(previous))
+	for k, v := range previous {
+		p[k] = v.String()
+	}
+	c := make(map[string]string, len(current))
+	for k, v := range current {
+		c[k] = v.String()
+	}
+	return changedKeys(p, c)
+}
+
+// contextFiles returns the digests of the regular files in the context
+// directory by their path relative to it.
+func contextFiles(dir string) (map[string]digest.Digest, error) {
+	files := map[string]digest.Digest{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		dgst, err := digest.FromReader(f)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)] = dgst
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("hashing the files of context %s failed: %v", dir, err)
+	}
+	return files, nil
+}
+
+// cacheRecordPath returns the path of the record in the state directory for
+// the build identified by key.
+func cacheRecordPath(root, key string) string {
+	return filepath.Join(root, explainCacheDir, digest.FromString(key).Hex()+".json")
+}
+
+// readCacheRecord reads the record at path, nil if there is none yet.
+func readCacheRecord(path string) (*cacheRecord, error) {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading cache record failed: %v", err)
+	}
+	var record cacheRecord
+	if err := json.Unmarshal(b, &record); err != nil {
+		return nil, fmt.Errorf("parsing cache record %s failed: %v", path, err)
+	}
+	return &record, nil
+}
+
+// writeCacheRecord writes the record to path for the next build.
+func writeCacheRecord(path string, record cacheRecord) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	b, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path, b, 0600); err != nil {
+		return fmt.Errorf("writing cache record failed: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	controlapi "github.com/moby/buildkit/api/services/control"
+	digest "github.com/opencontainers/go-digest"
+)
+
+func TestCacheExplainerBuildArg(t *testing.T) {
+	now := time.Now()
+	vertex := func(name string, cached bool, dgst digest.Digest, inputs ...digest.Digest) *controlapi.Vertex {
+		return &controlapi.Vertex{Digest: dgst, Name: name, Inputs: inputs, Started: &now, Completed: &now, Cached: cached}
+	}
+	from := vertex("[1/3] FROM docker.io/library/busybox:latest", true, digest.FromString("from"))
+	copyFiles := vertex("[2/3] COPY . /src", true, digest.FromString("copy"), from.Digest)
+	// The RUN step sees the new value of VERSION in its environment.
+	run := vertex("[3/3] RUN make VERSION=$VERSION", false, digest.FromString("run v2"), copyFiles.Digest)
+
+	previous := &cacheRecord{
+		BuildArgs: map[string]string{"VERSION": "1", "OTHER": "same"},
+		Files:     map[string]digest.Digest{"main.go": digest.FromString("main")},
+		Steps: map[string]digest.Digest{
+			from.Name:      from.Digest,
+			copyFiles.Name: copyFiles.Digest,
+			run.Name:       digest.FromString("run v1"),
+		},
+	}
+	e := newCacheExplainer(previous, map[string]string{"VERSION": "2", "OTHER": "same"}, previous.Files)
+	e.update(&controlapi.StatusResponse{Vertexes: []*controlapi.Vertex{
+		vertex("[internal] load build definition from Dockerfile", false, digest.FromString("internal")),
+		from, copyFiles, run,
+	}})
+
+	expected := []cacheResult{
+		{Step: from.Name, Cached: true},
+		{Step: copyFiles.Name, Cached: true},
+		{Step: run.Name, Reason: "build-args changed: VERSION"},
+	}
+	if results := e.results(); !reflect.DeepEqual(results, expected) {
+		t.Fatalf("expected results %#v, got: %#v", expected, results)
+	}
+
+	// The record of the build is compared with by the next one.
+	tmpd, err := ioutil.TempDir("", "img-explain-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpd)
+	path := cacheRecordPath(tmpd, "context")
+	if record, err := readCacheRecord(path); err != nil || record != nil {
+		t.Fatalf("expected no record before the first build, got: %v %v", record, err)
+	}
+	if err := writeCacheRecord(path, e.record()); err != nil {
+		t.Fatalf("writing cache record failed: %v", err)
+	}
+	record, err := readCacheRecord(path)
+	if err != nil {
+		t.Fatalf("reading cache record failed: %v", err)
+	}
+	if record.Steps[run.Name] != run.Digest || record.BuildArgs["VERSION"] != "2" {
+		t.Fatalf("expected the record of the build, got: %#v", record)
+	}
+	if filepath.Dir(path) != filepath.Join(tmpd, explainCacheDir) {
+		t.Fatalf("expected the record in %s, got: %s", explainCacheDir, path)
+	}
+}
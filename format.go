@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/template"
+)
+
+// The --format values of the listing commands, anything else is a Go
+// template executed for each record.
+const (
+	formatTable = "table"
+	formatJSON  = "json"
+)
+
+// formatHelp is the help of the --format flag of the listing commands.
+const formatHelp = "Format of the output: table, json for a JSON object per line, or a Go template executed for each record"
+
+// listFormat is a parsed --format of a listing command.
+type listFormat struct {
+	name string
+	tmpl *template.Template
+}
+
+// parseListFormat parses the --format value, a template is parsed right away
+// so a bad one fails before doing any work.
+func parseListFormat(value string) (listFormat, error) {
+	switch value {
+	case formatTable, formatJSON:
+		return listFormat{name: value}, nil
+	case "":
+		return listFormat{}, usageErrorf("the format can not be empty (table, json or a Go template)")
+	}
+	tmpl, err := template.New("format").Parse(value)
+	if err != nil {
+		return listFormat{}, usageErrorf("invalid format template %q: %v", value, err)
+	}
+	return listFormat{name: value, tmpl: tmpl}, nil
+}
+
+// isTable returns if the records are printed by the table of the command.
+func (f listFormat) isTable() bool {
+	return f.name == formatTable
+}
+
+// write writes each of the records on its own line, as a JSON object or
+// with the template. Tables are printed by the commands themselves.
+func (f listFormat) write(w io.Writer, records []interface{}) error {
+	if f.name == formatJSON {
+		// Encode writes the newline after each object.
+		enc := json.NewEncoder(w)
+		for _, record := range records {
+			if err := enc.Encode(record); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for _, record := range records {
+		var b strings.Builder
+		if err := f.tmpl.Execute(&b, record); err != nil {
+			return fmt.Errorf("executing format template failed: %v", err)
+		}
+		if _, err := fmt.Fprintln(w, b.String()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/mchirico/img/client"
+)
+
+func TestListFormat(t *testing.T) {
+	snapshots := []client.SnapshotInfo{
+		{Key: "sha256:a", Kind: "Committed", Size: 10, InUse: true},
+		{Key: "sha256:b", Parent: "sha256:a", Kind: "Active", Size: 20},
+		{Key: "sha256:c", Parent: "sha256:b", Kind: "Committed", Size: 30},
+	}
+
+	format, err := parseListFormat(formatJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	if err := printSnapshots(&b, snapshots, format); err != nil {
+		t.Fatal(err)
+	}
+	// Each line is a JSON object of its own.
+	lines := strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n")
+	if len(lines) != len(snapshots) {
+		t.Fatalf("expected %d lines, got: %q", len(snapshots), b.String())
+	}
+	for i, line := range lines {
+		var snapshot client.SnapshotInfo
+		if err := json.Unmarshal([]byte(line), &snapshot); err != nil {
+			t.Fatalf("line %d is not a JSON object: %v: %s", i, err, line)
+		}
+		if snapshot != snapshots[i] {
+			t.Fatalf("expected line %d to be %+v, got: %+v", i, snapshots[i], snapshot)
+		}
+	}
+
+	format, err = parseListFormat("{{.Key}} {{.Size}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Reset()
+	if err := printSnapshots(&b, snapshots, format); err != nil {
+		t.Fatal(err)
+	}
+	expected := "sha256:a 10\nsha256:b 20\nsha256:c 30\n"
+	if b.String() != expected {
+		t.Fatalf("expected template output %q, got: %q", expected, b.String())
+	}
+
+	format, err = parseListFormat("{{.Missing}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := printSnapshots(&b, snapshots, format); err == nil {
+		t.Fatal("expected a template with an unknown field to fail but it did not")
+	}
+
+	for _, invalid := range []string{"", "{{.Key"} {
+		if _, err := parseListFormat(invalid); exitCode(err) != exitUsage {
+			t.Fatalf("expected parsing format %q to be a usage error, got: %v", invalid, err)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/mchirico/img/client"
+)
+
+const gcShortHelp = `Configure the build cache garbage collection policy.`
+
+var gcLongHelp = gcShortHelp + `
+
+The policy is stored in the state directory and applied at the end of every
+build that succeeds. Use --set with 'keep-bytes=10gb,keep-duration=168h' to write the
+policy, with no flags the current policy is shown.`
+
+func (cmd *gcCommand) Name() string      { return "gc" }
+func (cmd *gcCommand) Args() string      { return "[OPTIONS]" }
+func (cmd *gcCommand) ShortHelp() string { return gcShortHelp }
+func (cmd *gcCommand) LongHelp() string  { return gcLongHelp }
+func (cmd *gcCommand) Hidden() bool      { return false }
+
+func (cmd *gcCommand) Register(fs *flag.FlagSet) {
+	fs.StringVar(&cmd.set, "set", "", "Set the gc policy in the 'keep-bytes=10gb,keep-duration=168h' format")
+	fs.BoolVar(&cmd.show, "show", false, "Show the current gc policy")
+}
+
+type gcCommand struct {
+	set  string
+	show bool
+}
+
+func (cmd *gcCommand) Run(ctx context.Context, args []string) (err error) {
+	if cmd.set != "" {
+		policy, err := client.ParseGCPolicy(cmd.set)
+		if err != nil {
+			return err
+		}
+		if err := client.SaveGCPolicy(stateDir, policy); err != nil {
+			return fmt.Errorf("saving gc policy failed: %v", err)
+		}
+		fmt.Println("Successfully set gc policy")
+
+		if !cmd.show {
+			return nil
+		}
+	}
+
+	policy, err := client.LoadGCPolicy(stateDir)
+	if err != nil {
+		return err
+	}
+	if policy == nil {
+		fmt.Println("No gc policy set")
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 1, 8, 1, '\t', 0)
+	fmt.Fprintf(tw, "Keep bytes:\t%s\n", formatBytes(policy.KeepBytes))
+	fmt.Fprintf(tw, "Keep duration:\t%s\n", policy.KeepDuration)
+	tw.Flush()
+
+	return nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	securejoin "github.com/cyphar/filepath-securejoin"
+)
+
+// gitURLRegexp matches the http(s) URLs of git repositories, which end in .git
+// optionally followed by a #ref:dir fragment.
+var gitURLRegexp = regexp.MustCompile(`^https?://.+\.git(#.+)?$`)
+
+// isGitContext returns if the build context is the URL of a git repository,
+// detected the same way as by docker build.
+func isGitContext(ref string) bool {
+	for _, prefix := range []string{"git://", "github.com/", "git@"} {
+		if strings.HasPrefix(ref, prefix) {
+			return true
+		}
+	}
+	return gitURLRegexp.MatchString(ref)
+}
+
+// parseGitContext splits a git context into the repository to clone and the
+// ref to check out and the directory to use as the context from the
+// optional #ref:dir fragment.
+func parseGitContext(ref string) (remote, checkout, dir string) {
+	parts := strings.SplitN(ref, "#", 2)
+	remote = parts[0]
+	if strings.HasPrefix(remote, "github.com/") {
+		remote = "https://" + remote
+	}
+	if len(parts) > 1 {
+		fragment := strings.SplitN(parts[1], ":", 2)
+		checkout = fragment[0]
+		if len(fragment) > 1 {
+			dir = fragment[1]
+		}
+	}
+	return remote, checkout, dir
+}
+
+// gitCommit is the commit checked out for a git context.
+type gitCommit struct {
+	revision string
+	time     time.Time
+}
+
+// contextFromGit clones the git context into a temporary directory. It
+// returns the temporary directory to clean up and the directory within it to
+// use as the build context, and the commit checked out. The .git directory
+// is removed from the checkout unless keepGitDir is true.
+func contextFromGit(ref string, keepGitDir bool) (string, string, gitCommit, error) {
+	remote, checkout, dir := parseGitContext(ref)
+
+	tmpDir, err := ioutil.TempDir("", "img-build-context-")
+	if err != nil {
+		return "", "", gitCommit{}, fmt.Errorf("unable to create temporary context directory: %v", err)
+	}
+
+	if err := git("", "clone", "--quiet", "--recurse-submodules", remote, tmpDir); err != nil {
+		return tmpDir, "", gitCommit{}, err
+	}
+	if checkout != "" {
+		if err := git(tmpDir, "checkout", "--quiet", checkout); err != nil {
+			return tmpDir, "", gitCommit{}, err
+		}
+		if err := git(tmpDir, "submodule", "update", "--quiet", "--init", "--recursive"); err != nil {
+			return tmpDir, "", gitCommit{}, err
+		}
+	}
+	revision, err := gitOutput(tmpDir, "rev-parse", "HEAD")
+	if err != nil {
+		return tmpDir, "", gitCommit{}, err
+	}
+	ct, err := gitOutput(tmpDir, "log", "-1", "--format=%ct", "HEAD")
+	if err != nil {
+		return tmpDir, "", gitCommit{}, err
+	}
+	epoch, err := strconv.ParseInt(ct, 10, 64)
+	if err != nil {
+		return tmpDir, "", gitCommit{}, fmt.Errorf("parsing commit time %q failed: %v", ct, err)
+	}
+	commit := gitCommit{revision: revision, time: time.Unix(epoch, 0)}
+
+	if !keepGitDir {
+		if err := os.RemoveAll(filepath.Join(tmpDir, ".git")); err != nil {
+			return tmpDir, "", gitCommit{}, fmt.Errorf("removing .git directory failed: %v", err)
+		}
+	}
+
+	contextDir, err := securejoin.SecureJoin(tmpDir, dir)
+	if err != nil {
+		return tmpDir, "", gitCommit{}, err
+	}
+	fi, err := os.Stat(contextDir)
+	if err != nil {
+		return tmpDir, "", gitCommit{}, fmt.Errorf("context directory %s not found in the repository", dir)
+	}
+	if !fi.IsDir() {
+		return tmpDir, "", gitCommit{}, fmt.Errorf("context %s in the repository is not a directory", dir)
+	}
+	return tmpDir, contextDir, commit, nil
+}
+
+// git runs a git command in dir, adding its stderr to the error.
+func git(dir string, args ...string) error {
+	c := exec.Command("git", args...)
+	c.Dir = dir
+	var stderr bytes.Buffer
+	c.Stderr = &stderr
+	if err := c.Run(); err != nil {
+		return fmt.Errorf("git %s failed: %v: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// gitOutput runs a git command in dir and returns its trimmed stdout.
+func gitOutput(dir string, args ...string) (string, error) {
+	c := exec.Command("git", args...)
+	c.Dir = dir
+	var stderr bytes.Buffer
+	c.Stderr = &stderr
+	out, err := c.Output()
+	if err != nil {
+		return "", fmt.Errorf("git %s failed: %v: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
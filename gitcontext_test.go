@@ -0,0 +1,130 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseGitContext(t *testing.T) {
+	testcases := []struct {
+		ref      string
+		isGit    bool
+		remote   string
+		checkout string
+		dir      string
+	}{
+		{ref: ".", isGit: false, remote: "."},
+		{ref: "https://example.com/context.tar.gz", isGit: false, remote: "https://example.com/context.tar.gz"},
+		{ref: "https://github.com/genuinetools/img.git", isGit: true, remote: "https://github.com/genuinetools/img.git"},
+		{ref: "https://github.com/genuinetools/img.git#v0.5.7:contrib", isGit: true, remote: "https://github.com/genuinetools/img.git", checkout: "v0.5.7", dir: "contrib"},
+		{ref: "github.com/genuinetools/img#master", isGit: true, remote: "https://github.com/genuinetools/img", checkout: "master"},
+		{ref: "git@github.com:genuinetools/img.git#:contrib", isGit: true, remote: "git@github.com:genuinetools/img.git", dir: "contrib"},
+		{ref: "git://example.com/img", isGit: true, remote: "git://example.com/img"},
+	}
+
+	for _, tc := range testcases {
+		if isGit := isGitContext(tc.ref); isGit != tc.isGit {
+			t.Fatalf("expected %s to be a git context: %t, got: %t", tc.ref, tc.isGit, isGit)
+		}
+		remote, checkout, dir := parseGitContext(tc.ref)
+		if remote != tc.remote || checkout != tc.checkout || dir != tc.dir {
+			t.Fatalf("expected %s to parse as (%q, %q, %q), got: (%q, %q, %q)", tc.ref, tc.remote, tc.checkout, tc.dir, remote, checkout, dir)
+		}
+	}
+}
+
+func TestContextFromGitKeepGitDir(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	repo, err := ioutil.TempDir("", "img-git-context")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(repo)
+
+	if err := os.MkdirAll(filepath.Join(repo, "app"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(repo, "app", defaultDockerfileName), []byte("FROM busybox\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{
+		{"init", "--quiet"},
+		{"add", "."},
+		{"-c", "user.name=img", "-c", "user.email=img@example.com", "commit", "--quiet", "-m", "init"},
+		{"tag", "v1"},
+	} {
+		if err := git(repo, args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, keepGitDir := range []bool{false, true} {
+		tmpDir, dir, _, err := contextFromGit(repo+"#v1:app", keepGitDir)
+		if tmpDir != "" {
+			defer os.RemoveAll(tmpDir)
+		}
+		if err != nil {
+			t.Fatalf("cloning git context (keep: %t) failed: %v", keepGitDir, err)
+		}
+
+		if dir != filepath.Join(tmpDir, "app") {
+			t.Fatalf("expected the context to be the app directory of %s, got: %s", tmpDir, dir)
+		}
+		if _, err := os.Stat(filepath.Join(dir, defaultDockerfileName)); err != nil {
+			t.Fatalf("expected the dockerfile in the checkout: %v", err)
+		}
+
+		_, err = os.Stat(filepath.Join(tmpDir, ".git"))
+		if keepGitDir && err != nil {
+			t.Fatalf("expected .git to be kept in the checkout: %v", err)
+		}
+		if !keepGitDir && !os.IsNotExist(err) {
+			t.Fatalf("expected .git to be removed from the checkout, got: %v", err)
+		}
+	}
+}
+
+func TestContextFromGitCommitTime(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	repo, err := ioutil.TempDir("", "img-git-context")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(repo)
+
+	if err := ioutil.WriteFile(filepath.Join(repo, defaultDockerfileName), []byte("FROM scratch\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// The author time differs to make sure the commit time is used.
+	defer setEnv("GIT_AUTHOR_DATE", "1400000000 +0000")()
+	defer setEnv("GIT_COMMITTER_DATE", "1500000000 +0200")()
+	for _, args := range [][]string{
+		{"init", "--quiet"},
+		{"add", "."},
+		{"-c", "user.name=img", "-c", "user.email=img@example.com", "commit", "--quiet", "-m", "init"},
+	} {
+		if err := git(repo, args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tmpDir, _, commit, err := contextFromGit(repo, false)
+	if tmpDir != "" {
+		defer os.RemoveAll(tmpDir)
+	}
+	if err != nil {
+		t.Fatalf("cloning git context failed: %v", err)
+	}
+	if commit.time.Unix() != 1500000000 {
+		t.Fatalf("expected the commit time 1500000000, got: %d", commit.time.Unix())
+	}
+}
@@ -15,6 +15,7 @@ require (
 	github.com/docker/cli v0.0.0-20190321234815-f40f9c240ab0
 	github.com/docker/distribution v2.7.1-0.20190205005809-0d3efadf0154+incompatible
 	github.com/docker/docker v1.14.0-0.20190319215453-e7b5f7dbe98c
+	github.com/docker/docker-credential-helpers v0.6.1
 	github.com/docker/go-connections v0.4.0 // indirect
 	github.com/docker/go-units v0.3.3
 	github.com/genuinetools/pkg v0.0.0-20180910213200-1c141f661797
@@ -26,6 +27,7 @@ require (
 	github.com/mitchellh/hashstructure v1.0.0 // indirect
 	github.com/moby/buildkit v0.5.1
 	github.com/mrunalp/fileutils v0.0.0-20171103030105-7d4729fb3618
+	github.com/opencontainers/go-digest v1.0.0-rc1
 	github.com/opencontainers/image-spec v1.0.1
 	github.com/opencontainers/runc v1.0.1-0.20190307181833-2b18fe1d885e
 	github.com/opencontainers/runtime-spec v1.0.1
@@ -33,9 +35,11 @@ require (
 	github.com/opentracing-contrib/go-stdlib v0.0.0-20180702182724-07a764486eb1 // indirect
 	github.com/opentracing/opentracing-go v1.0.2 // indirect
 	github.com/pkg/errors v0.8.1
+	github.com/prometheus/client_golang v0.0.0-20180924113449-f69c853d21c1
 	github.com/seccomp/libseccomp-golang v0.9.0
 	github.com/sirupsen/logrus v1.3.0
 	github.com/syndtr/gocapability v0.0.0-20180916011248-d98352740cb2
+	github.com/tonistiigi/fsutil v0.0.0-20190327153851-3bbb99cdbd76
 	github.com/urfave/cli v0.0.0-20171014202726-7bc6a0acffa5
 	github.com/vishvananda/netlink v1.0.0
 	go.etcd.io/bbolt v1.3.2
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/mchirico/img/client"
+)
+
+// allowMountRW is the --allow value for writable --mount bind mounts.
+const allowMountRW = "mount.rw"
+
+// parseAllow checks the --allow values are known.
+func parseAllow(values []string) (map[string]bool, error) {
+	allow := map[string]bool{}
+	for _, v := range values {
+		if v != allowMountRW {
+			return nil, usageErrorf("invalid --allow value %s, expected %s", v, allowMountRW)
+		}
+		allow[v] = true
+	}
+	return allow, nil
+}
+
+// parseHostMounts parses the --mount values in the
+// 'type=bind,src=HOST,dst=TARGET[,ro|rw]' format. The mounts are read-only
+// unless rw is set, which needs --allow mount.rw. The source is resolved to
+// an absolute path without symlinks and must exist. The target is only
+// cleaned, runc resolves it in the root filesystem of each container.
+func parseHostMounts(values []string, allow map[string]bool) ([]client.HostMount, error) {
+	var mounts []client.HostMount
+	for _, value := range values {
+		fields, err := csv.NewReader(strings.NewReader(value)).Read()
+		if err != nil {
+			return nil, fmt.Errorf("parsing mount value %q failed: %v", value, err)
+		}
+
+		m := client.HostMount{Readonly: true}
+		typ := ""
+		for _, field := range fields {
+			kv := strings.SplitN(field, "=", 2)
+			key := strings.ToLower(kv[0])
+			switch {
+			case key == "ro" || key == "readonly" || key == "rw":
+				m.Readonly = key != "rw"
+				if len(kv) == 2 {
+					b, err := strconv.ParseBool(kv[1])
+					if err != nil {
+						return nil, fmt.Errorf("invalid value %s of %s in mount %s", kv[1], kv[0], value)
+					}
+					m.Readonly = b == (key != "rw")
+				}
+			case len(kv) != 2:
+				return nil, fmt.Errorf("invalid field %s in mount %s, expected key=value", field, value)
+			case key == "type":
+				typ = kv[1]
+			case key == "src" || key == "source":
+				m.Source = kv[1]
+			case key == "dst" || key == "destination" || key == "target":
+				m.Target = kv[1]
+			default:
+				return nil, fmt.Errorf("unknown key %s in mount %s", kv[0], value)
+			}
+		}
+		if typ != "bind" {
+			return nil, fmt.Errorf("invalid mount %s, only type=bind is supported", value)
+		}
+		if m.Source == "" || m.Target == "" {
+			return nil, fmt.Errorf("invalid mount %s, it needs a src and a dst", value)
+		}
+		if !m.Readonly && !allow[allowMountRW] {
+			return nil, usageErrorf("mount %s is writable, pass --allow %s to allow writable mounts", value, allowMountRW)
+		}
+
+		src, err := filepath.Abs(m.Source)
+		if err != nil {
+			return nil, err
+		}
+		if m.Source, err = filepath.EvalSymlinks(src); err != nil {
+			return nil, fmt.Errorf("invalid mount source of %s: %v", value, err)
+		}
+		if !path.IsAbs(m.Target) || path.Clean(m.Target) == "/" {
+			return nil, fmt.Errorf("invalid mount %s, the dst must be an absolute path other than /", value)
+		}
+		m.Target = path.Clean(m.Target)
+		mounts = append(mounts, m)
+	}
+	return mounts, nil
+}
+
+// parseSecurityOpts parses the --security-opt values in the 'label=CONTEXT'
+// format and returns the SELinux context of the mounts.
+func parseSecurityOpts(values []string) (string, error) {
+	label := ""
+	for _, value := range values {
+		kv := strings.SplitN(value, "=", 2)
+		if len(kv) != 2 || kv[0] != "label" || kv[1] == "" {
+			return "", usageErrorf("invalid --security-opt value %s, expected label=CONTEXT", value)
+		}
+		label = kv[1]
+	}
+	return label, nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mchirico/img/client"
+)
+
+func TestParseHostMounts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "img-mount")
+	if err != nil {
+		t.Fatalf("creating temporary directory failed: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	// The temporary directory may be behind a symlink.
+	if dir, err = filepath.EvalSymlinks(dir); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(dir, "link")
+	if err := os.Symlink(dir, link); err != nil {
+		t.Fatal(err)
+	}
+
+	mounts, err := parseHostMounts([]string{
+		"type=bind,src=" + dir + ",dst=/cache",
+		"type=bind,source=" + link + ",target=/var/cache/apt/,rw",
+		"type=bind,src=" + dir + ",dst=/ro,readonly=true",
+	}, map[string]bool{allowMountRW: true})
+	if err != nil {
+		t.Fatalf("parsing mounts failed: %v", err)
+	}
+	expected := []client.HostMount{
+		{Source: dir, Target: "/cache", Readonly: true},
+		{Source: dir, Target: "/var/cache/apt"},
+		{Source: dir, Target: "/ro", Readonly: true},
+	}
+	if !reflect.DeepEqual(mounts, expected) {
+		t.Fatalf("expected mounts %+v, got: %+v", expected, mounts)
+	}
+
+	for value, msg := range map[string]string{
+		"type=bind,src=" + dir + ",dst=/cache,rw":         "pass --allow mount.rw",
+		"type=bind,src=" + dir + ",dst=/cache,ro=false":   "pass --allow mount.rw",
+		"type=volume,src=" + dir + ",dst=/cache":          "only type=bind is supported",
+		"type=bind,dst=/cache":                            "it needs a src and a dst",
+		"type=bind,src=" + dir + ",dst=cache":             "the dst must be an absolute path",
+		"type=bind,src=" + dir + ",dst=/../":              "the dst must be an absolute path",
+		"type=bind,src=" + dir + "/missing,dst=/cache":    "no such file or directory",
+		"type=bind,src=" + dir + ",dst=/cache,uid=1000":   "unknown key uid",
+		"type=bind,src=" + dir + ",dst=/cache,ro=maybe":   "invalid value maybe of ro",
+		"type=bind,src=" + dir + ",dst=/cache,propagated": "expected key=value",
+	} {
+		if _, err := parseHostMounts([]string{value}, nil); err == nil || !strings.Contains(err.Error(), msg) {
+			t.Fatalf("expected mount %s to fail with %q, got: %v", value, msg, err)
+		}
+	}
+
+	if _, err := parseAllow([]string{"network.host"}); err == nil {
+		t.Fatal("expected an unknown --allow value to fail but it did not")
+	}
+}
+
+func TestParseSecurityOpts(t *testing.T) {
+	var cmd buildCommand
+	fs := flag.NewFlagSet("build", flag.ContinueOnError)
+	cmd.Register(fs)
+	if err := fs.Parse([]string{"--security-opt", "label=system_u:object_r:container_file_t:s0"}); err != nil {
+		t.Fatalf("parsing flags failed: %v", err)
+	}
+	label, err := parseSecurityOpts(cmd.securityOpts)
+	if err != nil {
+		t.Fatalf("parsing security options failed: %v", err)
+	}
+	if label != "system_u:object_r:container_file_t:s0" {
+		t.Fatalf("expected the container_file_t label, got: %q", label)
+	}
+	if label, err := parseSecurityOpts(nil); err != nil || label != "" {
+		t.Fatalf("expected no label without security options, got: %q, %v", label, err)
+	}
+
+	for _, value := range []string{"label", "label=", "seccomp=unconfined"} {
+		if _, err := parseSecurityOpts([]string{value}); exitCode(err) != exitUsage {
+			t.Fatalf("expected %s to fail with a usage error, got: %v", value, err)
+		}
+	}
+}
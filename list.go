@@ -4,6 +4,7 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"io"
 	"os"
 	"text/tabwriter"
 	"time"
@@ -26,13 +27,20 @@ func (cmd *listCommand) Hidden() bool      { return false }
 func (cmd *listCommand) Register(fs *flag.FlagSet) {
 	fs.Var(&cmd.filters, "f", "Filter output based on conditions provided")
 	fs.Var(&cmd.filters, "filter", "Filter output based on conditions provided")
+	fs.StringVar(&cmd.format, "format", formatTable, formatHelp)
 }
 
 type listCommand struct {
 	filters stringSlice
+	format  string
 }
 
 func (cmd *listCommand) Run(ctx context.Context, args []string) (err error) {
+	format, err := parseListFormat(cmd.format)
+	if err != nil {
+		return err
+	}
+
 	reexec()
 
 	// Create the context.
@@ -43,7 +51,7 @@ func (cmd *listCommand) Run(ctx context.Context, args []string) (err error) {
 	// Create the client.
 	c, err := client.New(stateDir, backend, nil)
 	if err != nil {
-		return err
+		return systemError{err}
 	}
 	defer c.Close()
 
@@ -52,21 +60,32 @@ func (cmd *listCommand) Run(ctx context.Context, args []string) (err error) {
 		return err
 	}
 
-	tw := tabwriter.NewWriter(os.Stdout, 1, 8, 1, '\t', 0)
+	return printImages(os.Stdout, images, format)
+}
+
+// printImages writes the images as a table or with the format.
+func printImages(w io.Writer, images []client.ListedImage, format listFormat) error {
+	if !format.isTable() {
+		records := make([]interface{}, 0, len(images))
+		for _, image := range images {
+			records = append(records, image)
+		}
+		return format.write(w, records)
+	}
+
+	tw := tabwriter.NewWriter(w, 1, 8, 1, '\t', 0)
 
 	fmt.Fprintln(tw, "NAME\tSIZE\tCREATED AT\tUPDATED AT\tDIGEST")
 
 	for _, image := range images {
 		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n",
 			image.Name,
-			units.BytesSize(float64(image.ContentSize)),
+			formatBytes(image.ContentSize),
 			units.HumanDuration(time.Now().UTC().Sub(image.CreatedAt))+" ago",
 			units.HumanDuration(time.Now().UTC().Sub(image.UpdatedAt))+" ago",
 			image.Target.Digest,
 		)
 	}
 
-	tw.Flush()
-
-	return nil
+	return tw.Flush()
 }
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// prefixWriter prepends a prefix to every line written to it for
+// --log-prefix, so the output of builds running in parallel can be told
+// apart. A line written in several parts is prefixed once.
+type prefixWriter struct {
+	mu        sync.Mutex
+	w         io.Writer
+	prefix    []byte
+	lineStart bool
+}
+
+// newPrefixWriter returns a writer prefixing the lines written to w.
+func newPrefixWriter(w io.Writer, prefix string) *prefixWriter {
+	return &prefixWriter{w: w, prefix: []byte(prefix), lineStart: true}
+}
+
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var buf bytes.Buffer
+	for _, line := range bytes.SplitAfter(b, []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		if p.lineStart {
+			buf.Write(p.prefix)
+		}
+		buf.Write(line)
+		p.lineStart = line[len(line)-1] == '\n'
+	}
+	if _, err := p.w.Write(buf.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
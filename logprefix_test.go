@@ -0,0 +1,20 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPrefixWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := newPrefixWriter(&buf, "[app] ")
+	for _, s := range []string{"#1 [internal] load ", "build definition\n#1 DONE 0.0s\n\n", "#2 COPY", " Dockerfile /\n"} {
+		if n, err := w.Write([]byte(s)); err != nil || n != len(s) {
+			t.Fatalf("writing %q returned %d, %v", s, n, err)
+		}
+	}
+	expected := "[app] #1 [internal] load build definition\n[app] #1 DONE 0.0s\n[app] \n[app] #2 COPY Dockerfile /\n"
+	if buf.String() != expected {
+		t.Fatalf("expected every line prefixed once:\n%q\ngot:\n%q", expected, buf.String())
+	}
+}
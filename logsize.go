@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+
+	units "github.com/docker/go-units"
+	controlapi "github.com/moby/buildkit/api/services/control"
+	digest "github.com/opencontainers/go-digest"
+)
+
+// logLimiter caps the log data of each vertex handed to the progress UI,
+// which keeps all of it in memory, for --max-log-size. The first half of the
+// limit is passed on as it comes, after that only the last half is kept and
+// passed on with a marker of the bytes dropped in between once the vertex
+// completes.
+type logLimiter struct {
+	max      int64
+	vertices map[digest.Digest]*vertexLogs
+}
+
+// vertexLogs is the log data of a vertex seen by the limiter.
+type vertexLogs struct {
+	sent      int64
+	truncated int64
+	tail      []*controlapi.VertexLog
+	tailSize  int64
+}
+
+// newLogLimiter returns a limiter of the log data of each vertex to max
+// bytes, nil if max is not positive.
+func newLogLimiter(max int64) *logLimiter {
+	if max <= 0 {
+		return nil
+	}
+	return &logLimiter{max: max, vertices: map[digest.Digest]*vertexLogs{}}
+}
+
+// limit replaces the logs of the status response with the ones to pass on.
+// It is a no-op on a nil limiter.
+func (l *logLimiter) limit(resp *controlapi.StatusResponse) {
+	if l == nil {
+		return
+	}
+
+	head := l.max - l.max/2
+	var logs []*controlapi.VertexLog
+	for _, log := range resp.Logs {
+		v, ok := l.vertices[log.Vertex]
+		if !ok {
+			v = &vertexLogs{}
+			l.vertices[log.Vertex] = v
+		}
+
+		msg := log.Msg
+		if v.tail == nil && v.sent < head {
+			n := int64(len(msg))
+			if n > head-v.sent {
+				n = head - v.sent
+			}
+			logs = append(logs, &controlapi.VertexLog{Vertex: log.Vertex, Timestamp: log.Timestamp, Stream: log.Stream, Msg: msg[:n]})
+			v.sent += n
+			msg = msg[n:]
+		}
+		if len(msg) > 0 {
+			v.keep(&controlapi.VertexLog{Vertex: log.Vertex, Timestamp: log.Timestamp, Stream: log.Stream, Msg: msg}, l.max/2)
+		}
+	}
+	for _, vertex := range resp.Vertexes {
+		if vertex.Completed != nil {
+			logs = append(logs, l.release(vertex.Digest)...)
+		}
+	}
+	resp.Logs = logs
+}
+
+// flush returns the logs kept for the vertices that never completed.
+func (l *logLimiter) flush() []*controlapi.VertexLog {
+	if l == nil {
+		return nil
+	}
+	var logs []*controlapi.VertexLog
+	for dgst := range l.vertices {
+		logs = append(logs, l.release(dgst)...)
+	}
+	return logs
+}
+
+// keep adds the log to the tail of the vertex, dropping the oldest data
+// beyond size bytes.
+func (v *vertexLogs) keep(log *controlapi.VertexLog, size int64) {
+	v.tail = append(v.tail, log)
+	v.tailSize += int64(len(log.Msg))
+	for v.tailSize > size {
+		first := v.tail[0]
+		drop := v.tailSize - size
+		if drop >= int64(len(first.Msg)) {
+			drop = int64(len(first.Msg))
+			v.tail = v.tail[1:]
+		} else {
+			first.Msg = first.Msg[drop:]
+		}
+		v.tailSize -= drop
+		v.truncated += drop
+	}
+}
+
+// release returns the kept tail of the vertex, after the truncation marker if
+// any data was dropped, and forgets about it.
+func (l *logLimiter) release(dgst digest.Digest) []*controlapi.VertexLog {
+	v, ok := l.vertices[dgst]
+	if !ok {
+		return nil
+	}
+	delete(l.vertices, dgst)
+
+	if v.truncated == 0 {
+		return v.tail
+	}
+	var marker *controlapi.VertexLog
+	if len(v.tail) > 0 {
+		marker = &controlapi.VertexLog{Vertex: dgst, Timestamp: v.tail[0].Timestamp, Stream: v.tail[0].Stream}
+	} else {
+		marker = &controlapi.VertexLog{Vertex: dgst, Stream: 1}
+	}
+	marker.Msg = []byte(fmt.Sprintf("\n... (truncated %d bytes)\n", v.truncated))
+	return append([]*controlapi.VertexLog{marker}, v.tail...)
+}
+
+// parseMaxLogSize parses a human readable --max-log-size value such as 1m,
+// empty is unlimited.
+func parseMaxLogSize(value string) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	size, err := units.RAMInBytes(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid max-log-size value %s: %v", value, err)
+	}
+	if size <= 0 {
+		return 0, fmt.Errorf("invalid max-log-size value %s, it must be positive", value)
+	}
+	return size, nil
+}
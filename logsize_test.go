@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	controlapi "github.com/moby/buildkit/api/services/control"
+	digest "github.com/opencontainers/go-digest"
+)
+
+func TestLogLimiter(t *testing.T) {
+	dgst := digest.FromString("run")
+	l := newLogLimiter(20)
+
+	var out bytes.Buffer
+	write := func(resp *controlapi.StatusResponse) {
+		l.limit(resp)
+		for _, log := range resp.Logs {
+			out.Write(log.Msg)
+		}
+	}
+
+	// Feed 100 bytes of logs in chunks of 10.
+	for i := 0; i < 10; i++ {
+		write(&controlapi.StatusResponse{Logs: []*controlapi.VertexLog{
+			{Vertex: dgst, Stream: 1, Msg: []byte(strings.Repeat(string('a'+rune(i)), 10))},
+		}})
+	}
+	if out.String() != "aaaaaaaaaa" {
+		t.Fatalf("expected only the first half of the limit before the vertex completes, got: %q", out.String())
+	}
+
+	now := time.Now()
+	write(&controlapi.StatusResponse{Vertexes: []*controlapi.Vertex{{Digest: dgst, Completed: &now}}})
+
+	expected := "aaaaaaaaaa\n... (truncated 80 bytes)\njjjjjjjjjj"
+	if out.String() != expected {
+		t.Fatalf("expected truncated logs %q, got: %q", expected, out.String())
+	}
+	if logs := l.flush(); len(logs) != 0 {
+		t.Fatalf("expected nothing left to flush, got: %v", logs)
+	}
+
+	// Logs within the limit are passed on as is.
+	out.Reset()
+	write(&controlapi.StatusResponse{Logs: []*controlapi.VertexLog{{Vertex: dgst, Stream: 1, Msg: []byte("short")}}})
+	if out.String() != "short" {
+		t.Fatalf("expected logs within the limit to be passed on, got: %q", out.String())
+	}
+
+	// A nil limiter keeps everything.
+	var unlimited *logLimiter
+	resp := &controlapi.StatusResponse{Logs: []*controlapi.VertexLog{{Vertex: dgst, Msg: bytes.Repeat([]byte("x"), 100)}}}
+	unlimited.limit(resp)
+	if len(resp.Logs) != 1 || len(resp.Logs[0].Msg) != 100 {
+		t.Fatalf("expected a nil limiter to keep the logs, got: %v", resp.Logs)
+	}
+}
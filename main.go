@@ -5,14 +5,18 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"strings"
+	"time"
 
+	"github.com/mchirico/img/client"
 	"github.com/mchirico/img/internal/binutils"
 	_ "github.com/mchirico/img/internal/unshare"
 	"github.com/mchirico/img/types"
 	"github.com/mchirico/img/version"
 	"github.com/genuinetools/pkg/cli"
+	"github.com/opencontainers/runc/libcontainer/system"
 	"github.com/sirupsen/logrus"
 )
 
@@ -23,9 +27,10 @@ const (
 )
 
 var (
-	backend  string
-	stateDir string
-	debug    bool
+	backend     string
+	stateDir    string
+	debug       bool
+	lockTimeout time.Duration
 
 	validBackends = []string{types.AutoBackend, types.NativeBackend, types.OverlayFSBackend}
 )
@@ -53,19 +58,30 @@ func main() {
 
 	// Build the list of available commands.
 	p.Commands = []cli.Command{
+		&annotateCommand{},
 		&buildCommand{},
+		&configCommand{},
+		&contentCommand{},
 		&diskUsageCommand{},
+		&gcCommand{},
 		&listCommand{},
 		&loginCommand{},
 		&logoutCommand{},
+		&mountCommand{},
 		&pruneCommand{},
 		&pullCommand{},
 		&pushCommand{},
 		&removeCommand{},
 		&saveCommand{},
+		&snapshotCommand{},
+		&systemCommand{},
 		&tagCommand{},
+		&umountCommand{},
 		&unpackCommand{},
+		&verifyCommand{},
 	}
+	// Exit with the exit codes of the errors the commands return.
+	p.Commands = withExitCodes(p.Commands)
 
 	defaultStateDir := defaultStateDirectory()
 
@@ -77,9 +93,29 @@ func main() {
 	p.FlagSet.StringVar(&backend, "b", defaultBackend, fmt.Sprintf("backend for snapshots (%v)", validBackends))
 	p.FlagSet.StringVar(&stateDir, "state", defaultStateDir, fmt.Sprintf("directory to hold the global state"))
 	p.FlagSet.StringVar(&stateDir, "s", defaultStateDir, fmt.Sprintf("directory to hold the global state"))
+	p.FlagSet.BoolVar(&rawBytes, "bytes", false, "print sizes as raw byte counts")
+	p.FlagSet.DurationVar(&lockTimeout, "lock-timeout", 0, "how long to wait for another img process to release the lock on the state directory, 0 waits until it is released")
+
+	// Collect the flags that can be configured with the config command.
+	registerConfigFlags(p.FlagSet, p.Commands)
 
 	// Set the before function.
 	p.Before = func(ctx context.Context) error {
+		// Apply the defaults from the config. The config command is left
+		// out so that a broken config can still be fixed.
+		if command := os.Args[1]; command != "config" {
+			cfg, err := loadConfig(stateDir)
+			if err != nil {
+				return err
+			}
+			if err := applyConfig(p.FlagSet, command, cfg); err != nil {
+				return err
+			}
+		}
+
+		// Set how long to wait for the lock on the state directory.
+		client.LockTimeout = lockTimeout
+
 		// Set the log level.
 		if debug {
 			logrus.SetLevel(logrus.DebugLevel)
@@ -94,46 +130,99 @@ func main() {
 			}
 		}
 		if !found {
-			return fmt.Errorf("%s is not a valid snapshots backend", backend)
+			return exitOnError(usageErrorf("%s is not a valid snapshots backend", backend))
 		}
 
 		return nil
 	}
 
+	// Take out the hidden --no-reexec flag before the flags are parsed.
+	os.Args, noReexec = parseNoReexec(os.Args)
+
 	// Run our program.
 	p.Run()
 }
 
 func defaultStateDirectory() string {
+	// Use the euid outside of the user namespace since we are root inside
+	// of it after the reexec.
+	return userStateDirectory(fmt.Sprint(system.GetParentNSeuid()))
+}
+
+// userStateDirectory returns the default state directory for the user with
+// the given euid. The euid is part of the name so that users sharing a HOME,
+// e.g. with sudo, or the /tmp fallback get isolated stores.
+func userStateDirectory(uid string) string {
+	name := "img-" + uid
+
 	//  pam_systemd sets XDG_RUNTIME_DIR but not other dirs.
 	xdgDataHome := os.Getenv("XDG_DATA_HOME")
 	if xdgDataHome != "" {
 		dirs := strings.Split(xdgDataHome, ":")
-		return filepath.Join(dirs[0], "img")
+		return filepath.Join(dirs[0], name)
 	}
 	home := os.Getenv("HOME")
 	if home != "" {
-		return filepath.Join(home, ".local", "share", "img")
+		return filepath.Join(home, ".local", "share", name)
 	}
-	return "/tmp/img"
+	return filepath.Join("/tmp", name)
 }
 
+// installRunc installs the embedded runc binary, tests replace it to check
+// it is not called.
+var installRunc = binutils.InstallRuncBinary
+
 // If the command requires runc and we do not have it installed,
-// install it from the embedded asset.
-func installRuncIfDNE() error {
+// install it from the embedded asset. A runcPath is used instead of looking
+// runc up and noInstall errors instead of installing it.
+func installRuncIfDNE(runcPath string, noInstall bool) error {
+	if runcPath != "" {
+		return checkRuntimePath(runcPath)
+	}
+
 	if binutils.RuncBinaryExists() {
 		// return early.
 		return nil
 	}
 
-	if len(os.Getenv("IMG_DISABLE_EMBEDDED_RUNC")) > 0 {
+	if noInstall || len(os.Getenv("IMG_DISABLE_EMBEDDED_RUNC")) > 0 {
 		// Fail early with the error to install runc.
-		return fmt.Errorf("please install `runc`")
+		return fmt.Errorf("runc was not found in PATH (%s), please install `runc` or pass its path with --runc-path", os.Getenv("PATH"))
 	}
 
-	if _, err := binutils.InstallRuncBinary(); err != nil {
+	if _, err := installRunc(); err != nil {
 		return fmt.Errorf("installing embedded runc binary failed: %v", err)
 	}
 
 	return nil
 }
+
+// checkRuntimePath makes sure the OCI runtime binary at path is an executable
+// file.
+func checkRuntimePath(path string) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("runtime %s: %v", path, err)
+	}
+	if fi.IsDir() || fi.Mode()&0111 == 0 {
+		return fmt.Errorf("runtime %s is not an executable file", path)
+	}
+	return nil
+}
+
+// resolveRuntime returns the absolute path of the OCI runtime of --runtime,
+// a path or the name of a binary in PATH like crun.
+func resolveRuntime(runtime string) (string, error) {
+	if !strings.Contains(runtime, "/") {
+		path, err := exec.LookPath(runtime)
+		if err != nil {
+			return "", fmt.Errorf("runtime %s was not found in PATH (%s)", runtime, os.Getenv("PATH"))
+		}
+		runtime = path
+	}
+	path, err := filepath.Abs(runtime)
+	if err != nil {
+		return "", err
+	}
+	return path, checkRuntimePath(path)
+}
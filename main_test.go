@@ -6,6 +6,7 @@ import (
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"runtime"
 	"strings"
 	"sync"
@@ -111,3 +112,95 @@ func runBuild(t *testing.T, name string, stdin io.Reader) {
 func withDockerfile(dockerfile string) io.Reader {
 	return strings.NewReader(dockerfile)
 }
+
+func TestUserStateDirectory(t *testing.T) {
+	defer setEnv("XDG_DATA_HOME", "")()
+	defer setEnv("HOME", "/home/user")()
+
+	if dir := userStateDirectory("1000"); dir != "/home/user/.local/share/img-1000" {
+		t.Fatalf("expected state directory /home/user/.local/share/img-1000, got: %s", dir)
+	}
+	if userStateDirectory("1000") == userStateDirectory("1001") {
+		t.Fatalf("expected distinct state directories for distinct users, got: %s", userStateDirectory("1000"))
+	}
+
+	os.Setenv("XDG_DATA_HOME", "/data/one:/data/two")
+	if dir := userStateDirectory("1000"); dir != "/data/one/img-1000" {
+		t.Fatalf("expected state directory in XDG_DATA_HOME /data/one/img-1000, got: %s", dir)
+	}
+
+	os.Unsetenv("XDG_DATA_HOME")
+	os.Unsetenv("HOME")
+	if dir := userStateDirectory("0"); dir != "/tmp/img-0" {
+		t.Fatalf("expected state directory /tmp/img-0, got: %s", dir)
+	}
+}
+
+func TestInstallRuncIfDNE(t *testing.T) {
+	dir, err := ioutil.TempDir("", "img-runc")
+	if err != nil {
+		t.Fatalf("creating temporary directory failed: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// runc is not in the PATH and installing it fails the test.
+	defer setEnv("PATH", dir)()
+	defer setEnv("IMG_DISABLE_EMBEDDED_RUNC", "")()
+	defer func(install func() (string, error)) { installRunc = install }(installRunc)
+	installRunc = func() (string, error) {
+		t.Fatal("expected runc to not be installed")
+		return "", nil
+	}
+
+	runc := filepath.Join(dir, "bin", "runc")
+	if err := os.MkdirAll(filepath.Dir(runc), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(runc, []byte("#!/bin/sh\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := installRuncIfDNE(runc, false); err == nil || !strings.Contains(err.Error(), "not an executable file") {
+		t.Fatalf("expected a runc path that is not executable to fail, got: %v", err)
+	}
+	if err := os.Chmod(runc, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := installRuncIfDNE(runc, false); err != nil {
+		t.Fatalf("expected the runc path to bypass the install, got: %v", err)
+	}
+
+	err = installRuncIfDNE("", true)
+	if err == nil || !strings.Contains(err.Error(), "runc was not found in PATH ("+dir+")") {
+		t.Fatalf("expected a missing runc to fail without installing it, got: %v", err)
+	}
+}
+
+func TestResolveRuntime(t *testing.T) {
+	dir, err := ioutil.TempDir("", "img-runtime")
+	if err != nil {
+		t.Fatalf("creating temporary directory failed: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	defer setEnv("PATH", dir)()
+
+	crun := filepath.Join(dir, "crun")
+	if err := ioutil.WriteFile(crun, []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, runtime := range []string{"crun", crun} {
+		path, err := resolveRuntime(runtime)
+		if err != nil {
+			t.Fatalf("resolving runtime %s failed: %v", runtime, err)
+		}
+		if path != crun {
+			t.Fatalf("expected runtime %s to resolve to %s, got: %s", runtime, crun, path)
+		}
+	}
+
+	if _, err := resolveRuntime("youki"); err == nil || !strings.Contains(err.Error(), "runtime youki was not found in PATH") {
+		t.Fatalf("expected a runtime missing from PATH to fail, got: %v", err)
+	}
+	if _, err := resolveRuntime(dir); err == nil || !strings.Contains(err.Error(), "is not an executable file") {
+		t.Fatalf("expected a runtime path that is a directory to fail, got: %v", err)
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/mchirico/img/client"
+	controlapi "github.com/moby/buildkit/api/services/control"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// buildMetrics serves the Prometheus metrics of the build on --metrics-addr
+// while img runs. The cache hits are counted from the completed vertices of
+// the status responses, like the progress output shows them as CACHED.
+type buildMetrics struct {
+	mu        sync.Mutex
+	completed map[digest.Digest]bool
+	started   time.Time
+
+	builds   *prometheus.CounterVec
+	duration prometheus.Histogram
+	vertices prometheus.Counter
+	cached   prometheus.Counter
+	hitRatio prometheus.Gauge
+	total    int
+	hits     int
+
+	ln  net.Listener
+	srv *http.Server
+}
+
+// newBuildMetrics returns the metrics of a build, with the bytes pulled and
+// pushed read from the registry stats when they are scraped.
+func newBuildMetrics(stats func() client.RegistryStats) (*buildMetrics, *prometheus.Registry) {
+	m := &buildMetrics{
+		completed: map[digest.Digest]bool{},
+		started:   time.Now(),
+		builds: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "img_builds_total",
+			Help: "Number of builds, by result.",
+		}, []string{"result"}),
+		duration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "img_build_duration_seconds",
+			Help:    "Duration of the builds.",
+			Buckets: prometheus.ExponentialBuckets(1, 2, 12),
+		}),
+		vertices: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "img_build_vertices_total",
+			Help: "Number of completed build steps.",
+		}),
+		cached: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "img_build_cached_vertices_total",
+			Help: "Number of completed build steps that hit the cache.",
+		}),
+		hitRatio: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "img_build_cache_hit_ratio",
+			Help: "Ratio of the completed build steps that hit the cache.",
+		}),
+	}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(m.builds, m.duration, m.vertices, m.cached, m.hitRatio,
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "img_registry_pulled_bytes_total",
+			Help: "Bytes of the blobs pulled from registries.",
+		}, func() float64 { return float64(stats().Pulled) }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "img_registry_pushed_bytes_total",
+			Help: "Bytes of the blobs pushed to registries.",
+		}, func() float64 { return float64(stats().Pushed) }),
+	)
+	return m, registry
+}
+
+// startBuildMetrics serves the metrics of the build on addr until close. It
+// returns nil if addr is empty.
+func startBuildMetrics(addr string, stats func() client.RegistryStats) (*buildMetrics, error) {
+	if addr == "" {
+		return nil, nil
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("listening on metrics address %s failed: %v", addr, err)
+	}
+
+	m, registry := newBuildMetrics(stats)
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	m.ln = ln
+	m.srv = &http.Server{Handler: mux}
+	go m.srv.Serve(ln)
+	return m, nil
+}
+
+// update counts the vertices completing in the status response. It is a
+// no-op on nil metrics.
+func (m *buildMetrics) update(resp *controlapi.StatusResponse) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, v := range resp.Vertexes {
+		if v.Completed == nil || m.completed[v.Digest] {
+			continue
+		}
+		m.completed[v.Digest] = true
+		m.total++
+		m.vertices.Inc()
+		if v.Cached {
+			m.hits++
+			m.cached.Inc()
+		}
+		m.hitRatio.Set(float64(m.hits) / float64(m.total))
+	}
+}
+
+// finish records the result and the duration of the build. It is a no-op on
+// nil metrics.
+func (m *buildMetrics) finish(err error) {
+	if m == nil {
+		return
+	}
+	result := "succeeded"
+	if err != nil {
+		result = "failed"
+	}
+	m.builds.WithLabelValues(result).Inc()
+	m.duration.Observe(time.Since(m.started).Seconds())
+}
+
+// close shuts the metrics server down. It is a no-op on nil metrics.
+func (m *buildMetrics) close() {
+	if m == nil || m.srv == nil {
+		return
+	}
+	m.srv.Close()
+	m.srv = nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mchirico/img/client"
+	controlapi "github.com/moby/buildkit/api/services/control"
+	digest "github.com/opencontainers/go-digest"
+)
+
+func TestBuildMetrics(t *testing.T) {
+	stats := func() client.RegistryStats { return client.RegistryStats{Pulled: 2048, Pushed: 512} }
+	m, err := startBuildMetrics("127.0.0.1:0", stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.close()
+
+	// Simulate a build of three steps, one of them cached. Vertices are sent
+	// again by the following status responses.
+	now := time.Now()
+	from, cp, run := digest.FromString("from"), digest.FromString("copy"), digest.FromString("run")
+	m.update(&controlapi.StatusResponse{Vertexes: []*controlapi.Vertex{
+		{Digest: from, Name: "FROM busybox", Started: &now, Completed: &now, Cached: true},
+		{Digest: cp, Name: "COPY . .", Started: &now},
+	}})
+	m.update(&controlapi.StatusResponse{Vertexes: []*controlapi.Vertex{
+		{Digest: from, Name: "FROM busybox", Started: &now, Completed: &now, Cached: true},
+		{Digest: cp, Name: "COPY . .", Started: &now, Completed: &now},
+		{Digest: run, Name: "RUN make", Started: &now, Completed: &now},
+	}})
+	m.finish(nil)
+
+	resp, err := http.Get(fmt.Sprintf("http://%s/metrics", m.ln.Addr()))
+	if err != nil {
+		t.Fatalf("scraping the metrics failed: %v", err)
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := string(b)
+
+	for _, expected := range []string{
+		`img_builds_total{result="succeeded"} 1`,
+		"img_build_duration_seconds_count 1",
+		"img_build_vertices_total 3",
+		"img_build_cached_vertices_total 1",
+		"img_build_cache_hit_ratio 0.3333333333333333",
+		"img_registry_pulled_bytes_total 2048",
+		"img_registry_pushed_bytes_total 512",
+	} {
+		if !strings.Contains(body, expected+"\n") {
+			t.Fatalf("expected the metrics to contain %q, got:\n%s", expected, body)
+		}
+	}
+
+	// The server is shut down on close.
+	m.close()
+	if _, err := http.Get(fmt.Sprintf("http://%s/metrics", m.ln.Addr())); err == nil {
+		t.Fatal("expected the metrics server to be shut down")
+	}
+}
+
+func TestBuildMetricsDisabled(t *testing.T) {
+	m, err := startBuildMetrics("", nil)
+	if err != nil || m != nil {
+		t.Fatalf("expected no metrics without an address, got: %v, %v", m, err)
+	}
+	// The methods are no-ops on nil metrics.
+	m.update(&controlapi.StatusResponse{})
+	m.finish(nil)
+	m.close()
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/containerd/containerd/namespaces"
+	"github.com/containerd/containerd/platforms"
+	"github.com/mchirico/img/client"
+	"github.com/moby/buildkit/identity"
+	"github.com/moby/buildkit/session"
+	"github.com/opencontainers/runc/libcontainer/system"
+)
+
+const mountShortHelp = `Mount an image's rootfs to browse it.`
+
+var mountLongHelp = mountShortHelp + `
+
+The rootfs is mounted read-only from the snapshotter at the directory given
+with --target, use 'img umount DIR' to unmount it again. When running
+unprivileged mounts made inside img's user namespace are not visible outside
+of it, so the rootfs is unpacked to the target instead.`
+
+func (cmd *mountCommand) Name() string       { return "mount" }
+func (cmd *mountCommand) Args() string       { return "[OPTIONS] IMAGE" }
+func (cmd *mountCommand) ShortHelp() string  { return mountShortHelp }
+func (cmd *mountCommand) LongHelp() string   { return mountLongHelp }
+func (cmd *mountCommand) Hidden() bool       { return false }
+func (cmd *mountCommand) DoReexec() bool     { return true }
+func (cmd *mountCommand) RequiresRunc() bool { return false }
+
+func (cmd *mountCommand) Register(fs *flag.FlagSet) {
+	fs.StringVar(&cmd.target, "target", "", "Directory to mount the rootfs at")
+	fs.StringVar(&cmd.platform, "platform", platforms.DefaultString(), "Platform of the image to mount")
+}
+
+type mountCommand struct {
+	target   string
+	platform string
+}
+
+func (cmd *mountCommand) Run(ctx context.Context, args []string) (err error) {
+	if len(args) < 1 {
+		return usageErrorf("must pass an image to mount")
+	}
+
+	mode, err := planMount(system.GetParentNSeuid() != 0, cmd.target)
+	if err != nil {
+		return err
+	}
+
+	p, err := parsePlatform(cmd.platform)
+	if err != nil {
+		return fmt.Errorf("parsing platform %s failed: %v", cmd.platform, err)
+	}
+
+	reexec()
+
+	// Create the context.
+	id := identity.NewID()
+	ctx = session.NewContext(ctx, id)
+	ctx = namespaces.WithNamespace(ctx, "buildkit")
+
+	// Create the client.
+	c, err := client.New(stateDir, backend, nil)
+	if err != nil {
+		return systemError{err}
+	}
+	defer c.Close()
+
+	info, err := c.Mount(ctx, args[0], cmd.target, mode, platforms.Only(p))
+	if err != nil {
+		return err
+	}
+
+	if info.Mode == client.MountModeUnpack {
+		fmt.Printf("Successfully unpacked rootfs for %s to: %s\n", info.Image, info.Target)
+		return nil
+	}
+	fmt.Printf("Successfully mounted rootfs for %s at: %s\n", info.Image, info.Target)
+
+	return nil
+}
+
+// planMount returns how the rootfs is mounted at the target. Mounts made
+// inside the user namespace of an unprivileged img are gone when it exits, so
+// the rootfs is unpacked instead. The target must not exist for unpacking so
+// it is safe to remove on umount, otherwise it may be an empty directory.
+func planMount(rootless bool, target string) (string, error) {
+	if target == "" {
+		return "", usageErrorf("please specify a directory to mount the rootfs at with `--target`")
+	}
+
+	fi, err := os.Stat(target)
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	exists := err == nil
+
+	if rootless {
+		if exists {
+			return "", fmt.Errorf("target directory already exists: %s", target)
+		}
+		return client.MountModeUnpack, nil
+	}
+
+	if exists {
+		if !fi.IsDir() {
+			return "", fmt.Errorf("target %s is not a directory", target)
+		}
+		f, err := os.Open(target)
+		if err != nil {
+			return "", err
+		}
+		defer f.Close()
+		if names, _ := f.Readdirnames(1); len(names) > 0 {
+			return "", fmt.Errorf("target directory is not empty: %s", target)
+		}
+	}
+	return client.MountModeSnapshot, nil
+}
+
+const umountShortHelp = `Unmount an image's rootfs mounted with img mount.`
+
+func (cmd *umountCommand) Name() string       { return "umount" }
+func (cmd *umountCommand) Args() string       { return "DIR" }
+func (cmd *umountCommand) ShortHelp() string  { return umountShortHelp }
+func (cmd *umountCommand) LongHelp() string   { return umountShortHelp }
+func (cmd *umountCommand) Hidden() bool       { return false }
+func (cmd *umountCommand) DoReexec() bool     { return true }
+func (cmd *umountCommand) RequiresRunc() bool { return false }
+
+func (cmd *umountCommand) Register(fs *flag.FlagSet) {}
+
+type umountCommand struct{}
+
+func (cmd *umountCommand) Run(ctx context.Context, args []string) (err error) {
+	if len(args) < 1 {
+		return usageErrorf("must pass a directory to unmount")
+	}
+
+	reexec()
+
+	// Create the context.
+	id := identity.NewID()
+	ctx = session.NewContext(ctx, id)
+	ctx = namespaces.WithNamespace(ctx, "buildkit")
+
+	// Create the client.
+	c, err := client.New(stateDir, backend, nil)
+	if err != nil {
+		return systemError{err}
+	}
+	defer c.Close()
+
+	info, err := c.Umount(ctx, args[0])
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Successfully unmounted rootfs for %s from: %s\n", info.Image, info.Target)
+
+	return nil
+}
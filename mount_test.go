@@ -0,0 +1,66 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mchirico/img/client"
+)
+
+func TestPlanMount(t *testing.T) {
+	tmpd, err := ioutil.TempDir("", "img-mount")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpd)
+
+	missing := filepath.Join(tmpd, "missing")
+	empty := filepath.Join(tmpd, "empty")
+	full := filepath.Join(tmpd, "full")
+	file := filepath.Join(tmpd, "file")
+	if err := os.Mkdir(empty, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(full, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(full, "hello"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		rootless bool
+		target   string
+		expected string
+		err      bool
+	}{
+		{target: "", err: true},
+		{target: missing, expected: client.MountModeSnapshot},
+		{target: empty, expected: client.MountModeSnapshot},
+		{target: full, err: true},
+		{target: file, err: true},
+		{rootless: true, target: missing, expected: client.MountModeUnpack},
+		{rootless: true, target: empty, err: true},
+	}
+
+	for _, tc := range testCases {
+		mode, err := planMount(tc.rootless, tc.target)
+		if tc.err {
+			if err == nil {
+				t.Fatalf("expected planning mount (rootless: %t) at %q to fail, got mode %s", tc.rootless, tc.target, mode)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("planning mount (rootless: %t) at %q failed: %v", tc.rootless, tc.target, err)
+		}
+		if mode != tc.expected {
+			t.Fatalf("expected mount mode %s for (rootless: %t) %q, got: %s", tc.expected, tc.rootless, tc.target, mode)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"net/url"
+	"time"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// ociLabels returns the standard OCI annotations set as labels by
+// --oci-labels: the created time and, for a git context, the revision built
+// and the repository it was cloned from.
+func ociLabels(created time.Time, remote, revision string) map[string]string {
+	labels := map[string]string{
+		ocispec.AnnotationCreated: created.UTC().Format(time.RFC3339),
+	}
+	if remote != "" {
+		labels[ocispec.AnnotationSource] = sourceURL(remote)
+	}
+	if revision != "" {
+		labels[ocispec.AnnotationRevision] = revision
+	}
+	return labels
+}
+
+// sourceURL returns the remote of a git context without the credentials in
+// it, so they do not end up in the image.
+func sourceURL(remote string) string {
+	u, err := url.Parse(remote)
+	if err != nil || u.User == nil || u.Scheme == "" {
+		return remote
+	}
+	u.User = nil
+	return u.String()
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/containerd/containerd/platforms"
+	"github.com/mchirico/img/client"
+	specs "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// hostPlatform is the --platform value for the platform of the machine img
+// is running on.
+const hostPlatform = "host"
+
+// allPlatformsValue is the --platform value for every platform of the base
+// image.
+const allPlatformsValue = "all"
+
+// defaultPlatform returns the platform to build for when none is given. This
+// is the host platform unless it is overridden by the GOOS and GOARCH
+// environment variables.
+func defaultPlatform() specs.Platform {
+	p := client.HostPlatform()
+	if goos := os.Getenv("GOOS"); goos != "" {
+		p.OS = goos
+	}
+	if goarch := os.Getenv("GOARCH"); goarch != "" && goarch != p.Architecture {
+		// The variant of the host does not apply to another architecture.
+		p.Architecture = goarch
+		p.Variant = ""
+	}
+	return platforms.Normalize(p)
+}
+
+// describeHostPlatform describes the detected host platform for
+// --host-platform, with the platform of img itself when it runs emulated.
+func describeHostPlatform(host, binary specs.Platform) string {
+	s := fmt.Sprintf("host platform: %s", platforms.Format(host))
+	if binary = platforms.Normalize(binary); platforms.Format(binary) != platforms.Format(host) {
+		s += fmt.Sprintf(" (img is built for %s and runs emulated)", platforms.Format(binary))
+	}
+	return s
+}
+
+// resolvePlatforms splits the comma separated --platform values, replaces
+// every `host` by the platform of the machine img is running on and
+// normalizes the others like the frontend does, so linux/arm, linux/arm/7 and
+// linux/arm/v7 are all linux/arm/v7 and linux/arm64/v8 is linux/arm64. The
+// platforms are returned sorted without duplicates, so each is only built
+// once. `all` is kept for the caller to expand.
+func resolvePlatforms(values []string) ([]string, error) {
+	seen := map[string]bool{}
+	var resolved []string
+	for _, value := range values {
+		for _, s := range strings.Split(value, ",") {
+			if s = strings.TrimSpace(s); s == "" {
+				continue
+			}
+			if s != allPlatformsValue {
+				p, err := parsePlatform(s)
+				if err != nil {
+					return nil, usageErrorf("invalid platform: %v", err)
+				}
+				s = platforms.Format(p)
+			}
+			if !seen[s] {
+				seen[s] = true
+				resolved = append(resolved, s)
+			}
+		}
+	}
+	sort.Strings(resolved)
+	return resolved, nil
+}
+
+// parsePlatform parses and normalizes a platform specifier, accepting `host`
+// for the platform of the machine img is running on.
+func parsePlatform(s string) (specs.Platform, error) {
+	if s == hostPlatform {
+		return client.HostPlatform(), nil
+	}
+	p, err := platforms.Parse(s)
+	if err != nil {
+		return specs.Platform{}, err
+	}
+	return platforms.Normalize(p), nil
+}
+
+// checkSinglePlatformBase returns an error unless the manifest list of the
+// base image has exactly one manifest for each of the target platforms, for
+// --single-platform-base. The frontend would otherwise pick the closest
+// manifest, such as linux/arm/v6 for linux/arm/v7, or the first of several.
+func checkSinglePlatformBase(base string, index, targets []string) error {
+	count := map[string]int{}
+	for _, p := range index {
+		count[p]++
+	}
+	for _, target := range targets {
+		switch count[target] {
+		case 0:
+			return fmt.Errorf("base image %s has no manifest for %s, it has: %s", base, target, strings.Join(index, ", "))
+		case 1:
+		default:
+			return fmt.Errorf("base image %s has %d manifests for %s, which one to use is ambiguous", base, count[target], target)
+		}
+	}
+	return nil
+}
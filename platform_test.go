@@ -0,0 +1,153 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/containerd/containerd/platforms"
+	"github.com/mchirico/img/client"
+	specs "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func setEnv(key, value string) func() {
+	old, ok := os.LookupEnv(key)
+	if value == "" {
+		os.Unsetenv(key)
+	} else {
+		os.Setenv(key, value)
+	}
+	return func() {
+		if ok {
+			os.Setenv(key, old)
+		} else {
+			os.Unsetenv(key)
+		}
+	}
+}
+
+func TestDefaultPlatform(t *testing.T) {
+	defer setEnv("GOOS", "")()
+	defer setEnv("GOARCH", "")()
+
+	if p := defaultPlatform(); !reflect.DeepEqual(p, client.HostPlatform()) {
+		t.Fatalf("expected the host platform %v, got: %v", client.HostPlatform(), p)
+	}
+
+	os.Setenv("GOOS", "linux")
+	os.Setenv("GOARCH", "arm")
+	expected := specs.Platform{OS: "linux", Architecture: "arm", Variant: "v7"}
+	if p := defaultPlatform(); !reflect.DeepEqual(p, expected) {
+		t.Fatalf("expected GOARCH to override the platform with %v, got: %v", expected, p)
+	}
+
+	os.Setenv("GOARCH", "aarch64")
+	expected = specs.Platform{OS: "linux", Architecture: "arm64"}
+	if p := defaultPlatform(); !reflect.DeepEqual(p, expected) {
+		t.Fatalf("expected GOARCH to be normalized to %v, got: %v", expected, p)
+	}
+}
+
+func TestResolvePlatforms(t *testing.T) {
+	host := platforms.Format(client.HostPlatform())
+
+	ps, err := resolvePlatforms([]string{"linux/s390x", "host"})
+	if err != nil {
+		t.Fatalf("resolving platforms failed: %v", err)
+	}
+	expected := []string{host, "linux/s390x"}
+	if !reflect.DeepEqual(ps, expected) {
+		t.Fatalf("expected platforms %v, got: %v", expected, ps)
+	}
+
+	// Duplicates in any format and comma separated values are merged.
+	ps, err = resolvePlatforms([]string{"linux/arm64,linux/amd64", "linux/amd64", "linux/arm64/v8", " linux/aarch64 ,,linux/arm/7", "linux/arm"})
+	if err != nil {
+		t.Fatalf("resolving platforms failed: %v", err)
+	}
+	expected = []string{"linux/amd64", "linux/arm/v7", "linux/arm64"}
+	if !reflect.DeepEqual(ps, expected) {
+		t.Fatalf("expected platforms %v, got: %v", expected, ps)
+	}
+
+	if _, err := resolvePlatforms([]string{"linux/amd64,linux/notanarch/v9/x"}); err == nil || !strings.Contains(err.Error(), `invalid platform: "linux/notanarch/v9/x"`) {
+		t.Fatalf("expected an invalid platform to fail, got: %v", err)
+	}
+
+	p, err := parsePlatform("host")
+	if err != nil {
+		t.Fatalf("parsing platform host failed: %v", err)
+	}
+	if expected := client.HostPlatform(); !reflect.DeepEqual(p, expected) {
+		t.Fatalf("expected the host platform %v, got: %v", expected, p)
+	}
+}
+
+func TestPlatformVariants(t *testing.T) {
+	for s, expected := range map[string]specs.Platform{
+		"linux/arm/v6":    {OS: "linux", Architecture: "arm", Variant: "v6"},
+		"linux/arm/6":     {OS: "linux", Architecture: "arm", Variant: "v6"},
+		"linux/arm/v7":    {OS: "linux", Architecture: "arm", Variant: "v7"},
+		"linux/arm/7":     {OS: "linux", Architecture: "arm", Variant: "v7"},
+		"linux/arm":       {OS: "linux", Architecture: "arm", Variant: "v7"},
+		"linux/armhf":     {OS: "linux", Architecture: "arm", Variant: "v7"},
+		"linux/arm64/v8":  {OS: "linux", Architecture: "arm64"},
+		"linux/arm64":     {OS: "linux", Architecture: "arm64"},
+		"linux/aarch64/8": {OS: "linux", Architecture: "arm64"},
+	} {
+		p, err := parsePlatform(s)
+		if err != nil {
+			t.Fatalf("parsing platform %s failed: %v", s, err)
+		}
+		if !reflect.DeepEqual(p, expected) {
+			t.Fatalf("expected platform %s to be normalized to %v, got: %v", s, expected, p)
+		}
+		// The normalized platform parses back to itself.
+		if rp, err := parsePlatform(platforms.Format(p)); err != nil || !reflect.DeepEqual(rp, p) {
+			t.Fatalf("expected platform %s to round-trip through %s, got: %v (%v)", s, platforms.Format(p), rp, err)
+		}
+	}
+
+	ps, err := resolvePlatforms([]string{"linux/arm/6", "linux/arm/7", "linux/arm64/v8", "all"})
+	expected := []string{"all", "linux/arm/v6", "linux/arm/v7", "linux/arm64"}
+	if err != nil || !reflect.DeepEqual(ps, expected) {
+		t.Fatalf("expected platforms %v, got: %v (%v)", expected, ps, err)
+	}
+}
+
+func TestCheckSinglePlatformBase(t *testing.T) {
+	index := []string{"linux/amd64", "linux/arm/v6", "linux/arm64", "linux/s390x", "linux/s390x"}
+
+	for _, targets := range [][]string{
+		{"linux/amd64"},
+		{"linux/arm64"},
+		{"linux/arm/v6"},
+		{"linux/amd64", "linux/arm64"},
+	} {
+		if err := checkSinglePlatformBase("alpine", index, targets); err != nil {
+			t.Fatalf("expected building %v from the multi-platform base to pass, got: %v", targets, err)
+		}
+	}
+
+	// The frontend would fall back to linux/arm/v6 for linux/arm/v7.
+	err := checkSinglePlatformBase("alpine", index, []string{"linux/arm/v7"})
+	if err == nil || !strings.Contains(err.Error(), "has no manifest for linux/arm/v7") {
+		t.Fatalf("expected a platform missing from the base to fail, got: %v", err)
+	}
+	err = checkSinglePlatformBase("alpine", index, []string{"linux/amd64", "linux/s390x"})
+	if err == nil || !strings.Contains(err.Error(), "is ambiguous") {
+		t.Fatalf("expected several manifests for a platform to fail, got: %v", err)
+	}
+}
+
+func TestDescribeHostPlatform(t *testing.T) {
+	arm64 := specs.Platform{OS: "linux", Architecture: "arm64"}
+	if s := describeHostPlatform(arm64, arm64); s != "host platform: linux/arm64" {
+		t.Fatalf("expected the host platform without emulation, got: %q", s)
+	}
+	expected := "host platform: linux/arm64 (img is built for linux/amd64 and runs emulated)"
+	if s := describeHostPlatform(arm64, specs.Platform{OS: "linux", Architecture: "amd64"}); s != expected {
+		t.Fatalf("expected %q, got: %q", expected, s)
+	}
+}
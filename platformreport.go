@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/containerd/containerd/platforms"
+	controlapi "github.com/moby/buildkit/api/services/control"
+	digest "github.com/opencontainers/go-digest"
+)
+
+const (
+	platformSucceeded = "succeeded"
+	platformFailed    = "failed"
+	platformCanceled  = "canceled"
+)
+
+// platformReport aggregates the results of a multi-platform build per
+// platform from the vertices of the status responses. The dockerfile frontend
+// prefixes the names of the vertices for each platform with it, e.g.
+// "[linux/arm64 2/3] RUN make", and vertices without a prefix take the
+// platform of their inputs.
+type platformReport struct {
+	mu sync.Mutex
+
+	platforms []string
+	vertices  map[digest.Digest]string
+	completed map[digest.Digest]bool
+	failures  map[string]platformFailure
+}
+
+// platformFailure is the first step that failed for a platform.
+type platformFailure struct {
+	step string
+	err  string
+}
+
+// platformResult is the result of the build for a platform.
+type platformResult struct {
+	Platform string
+	Result   string
+	Step     string
+	Error    string
+}
+
+// newPlatformReport returns a report for the platforms, normalized the same
+// way as by the frontend when prefixing the vertices.
+func newPlatformReport(ps []string) *platformReport {
+	normalized := make([]string, 0, len(ps))
+	for _, s := range ps {
+		if p, err := parsePlatform(s); err == nil {
+			s = platforms.Format(p)
+		}
+		normalized = append(normalized, s)
+	}
+	return &platformReport{
+		platforms: normalized,
+		vertices:  map[digest.Digest]string{},
+		completed: map[digest.Digest]bool{},
+		failures:  map[string]platformFailure{},
+	}
+}
+
+// update correlates the vertices of the status response to their platforms
+// and records the failed ones. It is a no-op on a nil report.
+func (r *platformReport) update(resp *controlapi.StatusResponse) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, v := range resp.Vertexes {
+		platform := r.vertexPlatform(v)
+		if platform == "" {
+			continue
+		}
+		r.vertices[v.Digest] = platform
+		r.completed[v.Digest] = v.Completed != nil
+
+		if v.Error == "" {
+			continue
+		}
+		// Keep the step that failed first, not the ones canceled because of it.
+		if f, ok := r.failures[platform]; ok && !isCanceled(f.err) {
+			continue
+		}
+		r.failures[platform] = platformFailure{step: v.Name, err: v.Error}
+	}
+}
+
+// vertexPlatform returns the platform of the vertex from the prefix of its
+// name or otherwise from its inputs.
+func (r *platformReport) vertexPlatform(v *controlapi.Vertex) string {
+	if p, ok := r.vertices[v.Digest]; ok {
+		return p
+	}
+	for _, p := range r.platforms {
+		if strings.HasPrefix(v.Name, "["+p+" ") {
+			return p
+		}
+	}
+	for _, input := range v.Inputs {
+		if p, ok := r.vertices[input]; ok {
+			return p
+		}
+	}
+	return ""
+}
+
+// results returns the result for each platform of the build in order. A
+// platform succeeded if all of its vertices completed, the platforms whose
+// vertices did not all run are canceled.
+func (r *platformReport) results() []platformResult {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	done := map[string]bool{}
+	for _, p := range r.platforms {
+		done[p] = true
+	}
+	seen := map[string]bool{}
+	for dgst, p := range r.vertices {
+		seen[p] = true
+		if !r.completed[dgst] {
+			done[p] = false
+		}
+	}
+
+	results := make([]platformResult, 0, len(r.platforms))
+	for _, p := range r.platforms {
+		result := platformResult{Platform: p, Result: platformSucceeded}
+		if !seen[p] || !done[p] {
+			result.Result = platformCanceled
+		}
+		if f, ok := r.failures[p]; ok {
+			result.Result = platformFailed
+			if isCanceled(f.err) {
+				result.Result = platformCanceled
+			}
+			result.Step = f.step
+			result.Error = f.err
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// print writes the results as a table.
+func (r *platformReport) print(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 1, 8, 1, '\t', 0)
+	fmt.Fprintln(tw, "PLATFORM\tRESULT\tSTEP\tERROR")
+	for _, result := range r.results() {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", result.Platform, result.Result, result.Step, result.Error)
+	}
+	tw.Flush()
+}
+
+func isCanceled(err string) bool {
+	return strings.HasSuffix(err, "context canceled")
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	controlapi "github.com/moby/buildkit/api/services/control"
+	digest "github.com/opencontainers/go-digest"
+)
+
+func TestPlatformReport(t *testing.T) {
+	now := time.Now()
+	vertex := func(name string, completed bool, errMsg string, inputs ...digest.Digest) *controlapi.Vertex {
+		v := &controlapi.Vertex{Digest: digest.FromString(name), Name: name, Inputs: inputs, Started: &now, Error: errMsg}
+		if completed {
+			v.Completed = &now
+		}
+		return v
+	}
+
+	amd64From := vertex("[linux/amd64 1/2] FROM docker.io/library/alpine:latest", true, "")
+	arm64From := vertex("[linux/arm64 1/2] FROM docker.io/library/alpine:latest", true, "")
+	resp := &controlapi.StatusResponse{
+		Vertexes: []*controlapi.Vertex{
+			vertex("[internal] load build definition from Dockerfile", true, ""),
+			amd64From,
+			arm64From,
+			// The unpacked rootfs has no prefix but the platform of its input.
+			vertex("unpack", true, "", arm64From.Digest),
+			vertex("[linux/amd64 2/2] RUN make", true, ""),
+			vertex("[linux/arm64 2/2] RUN make", true, "executor failed running [/bin/sh -c make]: exit code: 2"),
+		},
+	}
+	canceled := &controlapi.StatusResponse{
+		Vertexes: []*controlapi.Vertex{
+			vertex("[linux/arm/v7 1/2] FROM docker.io/library/alpine:latest", false, "context canceled"),
+		},
+	}
+
+	report := newPlatformReport([]string{"linux/amd64", "linux/arm64", "linux/arm/v7"})
+	report.update(resp)
+	report.update(canceled)
+
+	expected := []platformResult{
+		{Platform: "linux/amd64", Result: platformSucceeded},
+		{Platform: "linux/arm64", Result: platformFailed, Step: "[linux/arm64 2/2] RUN make", Error: "executor failed running [/bin/sh -c make]: exit code: 2"},
+		{Platform: "linux/arm/v7", Result: platformCanceled, Step: "[linux/arm/v7 1/2] FROM docker.io/library/alpine:latest", Error: "context canceled"},
+	}
+	if results := report.results(); !reflect.DeepEqual(results, expected) {
+		t.Fatalf("expected results %#v, got: %#v", expected, results)
+	}
+
+	var buf bytes.Buffer
+	report.print(&buf)
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 4 || !strings.HasPrefix(lines[2], "linux/arm64") || !strings.Contains(lines[2], "failed") || !strings.Contains(lines[2], "RUN make") {
+		t.Fatalf("expected a line per platform with the failing step, got:\n%s", buf.String())
+	}
+
+	// A nil report ignores the statuses.
+	var nilReport *platformReport
+	nilReport.update(resp)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	controlapi "github.com/moby/buildkit/api/services/control"
+)
+
+// progressFile writes the status of the build to a file for --progress-file,
+// a JSON object per status update. All of the vertices and logs are written,
+// including the ones --quiet-pull and --max-log-size leave out of the
+// progress UI.
+type progressFile struct {
+	path string
+	f    *os.File
+	enc  *json.Encoder
+	err  error
+}
+
+// createProgressFile creates or truncates the progress file at path.
+func createProgressFile(path string) (*progressFile, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("creating progress file failed: %v", err)
+	}
+	return &progressFile{path: path, f: f, enc: json.NewEncoder(f)}, nil
+}
+
+// write appends the status response to the file. The file is not buffered so
+// every update is on disk as soon as it is received. It is a no-op on a nil
+// progress file and after the first error, which close returns.
+func (p *progressFile) write(resp *controlapi.StatusResponse) {
+	if p == nil || p.err != nil {
+		return
+	}
+	if err := p.enc.Encode(solveStatus(resp, false, nil)); err != nil {
+		p.err = fmt.Errorf("writing progress file %s failed: %v", p.path, err)
+	}
+}
+
+// close closes the file and returns the first error writing to it, closing it
+// again is a no-op.
+func (p *progressFile) close() error {
+	if p == nil || p.f == nil {
+		return nil
+	}
+	if err := p.f.Close(); err != nil && p.err == nil {
+		p.err = fmt.Errorf("closing progress file %s failed: %v", p.path, err)
+	}
+	p.f = nil
+	return p.err
+}
+
+const (
+	// progressJSON prints the status of the build as the JSON of the
+	// SolveStatus the progress UI displays, like the progress file.
+	progressJSON = "json"
+	// progressRawJSON prints the JSON of the StatusResponse the controller
+	// sends, before it is translated for the progress UI.
+	progressRawJSON = "rawjson"
+)
+
+// showJSONProgress prints a JSON object per status update to w instead of the
+// progress UI, for --progress json and rawjson. The raw objects have the
+// field names of the controller API, while the json ones are translated like
+// for the progress file.
+func showJSONProgress(ch chan *controlapi.StatusResponse, w io.Writer, raw bool, observers ...func(*controlapi.StatusResponse)) error {
+	enc := json.NewEncoder(w)
+	var err error
+	for resp := range ch {
+		for _, observe := range observers {
+			observe(resp)
+		}
+		if err != nil {
+			// Keep receiving so the solve is not blocked.
+			continue
+		}
+		if raw {
+			err = enc.Encode(resp)
+		} else {
+			err = enc.Encode(solveStatus(resp, false, nil))
+		}
+		if err != nil {
+			err = fmt.Errorf("writing progress failed: %v", err)
+		}
+	}
+	return err
+}
@@ -8,8 +8,8 @@ import (
 	"text/tabwriter"
 
 	"github.com/containerd/containerd/namespaces"
-	units "github.com/docker/go-units"
 	"github.com/mchirico/img/client"
+	bkclient "github.com/moby/buildkit/client"
 	"github.com/moby/buildkit/identity"
 	"github.com/moby/buildkit/session"
 )
@@ -22,9 +22,13 @@ func (cmd *pruneCommand) ShortHelp() string { return pruneHelp }
 func (cmd *pruneCommand) LongHelp() string  { return pruneHelp }
 func (cmd *pruneCommand) Hidden() bool      { return false }
 
-func (cmd *pruneCommand) Register(fs *flag.FlagSet) {}
+func (cmd *pruneCommand) Register(fs *flag.FlagSet) {
+	fs.BoolVar(&cmd.cacheMounts, "cache-mounts", false, "Only prune the RUN --mount=type=cache mounts")
+}
 
-type pruneCommand struct{}
+type pruneCommand struct {
+	cacheMounts bool
+}
 
 func (cmd *pruneCommand) Run(ctx context.Context, args []string) (err error) {
 	reexec()
@@ -37,11 +41,18 @@ func (cmd *pruneCommand) Run(ctx context.Context, args []string) (err error) {
 	// Create the client.
 	c, err := client.New(stateDir, backend, nil)
 	if err != nil {
-		return err
+		return systemError{err}
 	}
 	defer c.Close()
 
-	usage, err := c.Prune(ctx)
+	var opts []bkclient.PruneInfo
+	if cmd.cacheMounts {
+		opts = append(opts, bkclient.PruneInfo{
+			Filter: []string{"type==" + string(bkclient.UsageRecordTypeCacheMount)},
+		})
+	}
+
+	usage, err := c.Prune(ctx, opts...)
 	if err != nil {
 		return err
 	}
@@ -62,7 +73,7 @@ func (cmd *pruneCommand) Run(ctx context.Context, args []string) (err error) {
 			if len(desc) > 50 {
 				desc = desc[0:50] + "..."
 			}
-			fmt.Fprintf(tw, "%s\t%t\t%s\t%s\n", id, !di.InUse, units.BytesSize(float64(di.Size_)), desc)
+			fmt.Fprintf(tw, "%s\t%t\t%s\t%s\n", id, !di.InUse, formatBytes(di.Size_), desc)
 		}
 
 		tw.Flush()
@@ -81,8 +92,8 @@ func (cmd *pruneCommand) Run(ctx context.Context, args []string) (err error) {
 	}
 
 	tw = tabwriter.NewWriter(os.Stdout, 1, 8, 1, '\t', 0)
-	fmt.Fprintf(tw, "Reclaimed:\t%s\n", units.BytesSize(float64(reclaimable)))
-	fmt.Fprintf(tw, "Total:\t%s\n", units.BytesSize(float64(total)))
+	fmt.Fprintf(tw, "Reclaimed:\t%s\n", formatBytes(reclaimable))
+	fmt.Fprintf(tw, "Total:\t%s\n", formatBytes(total))
 	tw.Flush()
 
 	return nil
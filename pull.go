@@ -6,7 +6,6 @@ import (
 	"fmt"
 
 	"github.com/containerd/containerd/namespaces"
-	units "github.com/docker/go-units"
 	"github.com/mchirico/img/client"
 	"github.com/moby/buildkit/session"
 	"github.com/moby/buildkit/util/appcontext"
@@ -21,16 +20,25 @@ func (cmd *pullCommand) ShortHelp() string { return pullHelp }
 func (cmd *pullCommand) LongHelp() string  { return pullHelp }
 func (cmd *pullCommand) Hidden() bool      { return false }
 
-func (cmd *pullCommand) Register(fs *flag.FlagSet) {}
+func (cmd *pullCommand) Register(fs *flag.FlagSet) {
+	fs.StringVar(&cmd.platform, "platform", "", "Set to 'all' to pull the manifest list with every platform of the image, for saving or pushing it offline (defaults to the host platform)")
+	fs.BoolVar(&cmd.allPlatforms, "all-platforms", false, "Pull the manifest list with every platform of the image, the same as --platform all")
+}
 
 type pullCommand struct {
-	image string
+	image        string
+	platform     string
+	allPlatforms bool
 }
 
 func (cmd *pullCommand) Run(ctx context.Context, args []string) (err error) {
 	if len(args) < 1 {
-		return fmt.Errorf("must pass an image or repository to pull")
+		return usageErrorf("must pass an image or repository to pull")
+	}
+	if cmd.platform != "" && cmd.platform != allPlatformsValue {
+		return usageErrorf("pull only supports --platform all, the image of another platform is pulled by the builds for it")
 	}
+	allPlatforms := cmd.allPlatforms || cmd.platform == allPlatformsValue
 
 	reexec()
 
@@ -40,7 +48,7 @@ func (cmd *pullCommand) Run(ctx context.Context, args []string) (err error) {
 	// Create the client.
 	c, err := client.New(stateDir, backend, nil)
 	if err != nil {
-		return err
+		return systemError{err}
 	}
 	defer c.Close()
 
@@ -63,7 +71,11 @@ func (cmd *pullCommand) Run(ctx context.Context, args []string) (err error) {
 	eg.Go(func() error {
 		defer sess.Close()
 		var err error
-		listedImage, err = c.Pull(ctx, cmd.image)
+		if allPlatforms {
+			listedImage, err = c.PullAllPlatforms(ctx, cmd.image)
+		} else {
+			listedImage, err = c.Pull(ctx, cmd.image)
+		}
 		return err
 	})
 	if err := eg.Wait(); err != nil {
@@ -71,7 +83,7 @@ func (cmd *pullCommand) Run(ctx context.Context, args []string) (err error) {
 	}
 
 	fmt.Printf("Pulled: %s\n", listedImage.Target.Digest)
-	fmt.Printf("Size: %s\n", units.BytesSize(float64(listedImage.ContentSize)))
+	fmt.Printf("Size: %s\n", formatBytes(listedImage.ContentSize))
 
 	return nil
 }
@@ -4,6 +4,10 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
 
 	"github.com/containerd/containerd/namespaces"
 	"github.com/mchirico/img/client"
@@ -22,16 +26,20 @@ func (cmd *pushCommand) Hidden() bool      { return false }
 
 func (cmd *pushCommand) Register(fs *flag.FlagSet) {
 	fs.BoolVar(&cmd.insecure, "insecure-registry", false, "Push to insecure registry")
+	fs.Var(&cmd.registryAuth, "registry-auth", "Set registry credentials in the 'host=base64(user:pass)' format")
+	fs.Var(&cmd.registryTokens, "registry-token", "Set a registry bearer token in the 'host=token' format, 'host=-' reads it from STDIN")
 }
 
 type pushCommand struct {
-	image    string
-	insecure bool
+	image          string
+	insecure       bool
+	registryAuth   stringSlice
+	registryTokens stringSlice
 }
 
 func (cmd *pushCommand) Run(ctx context.Context, args []string) (err error) {
 	if len(args) < 1 {
-		return fmt.Errorf("must pass an image or repository to push")
+		return usageErrorf("must pass an image or repository to push")
 	}
 
 	reexec()
@@ -39,13 +47,25 @@ func (cmd *pushCommand) Run(ctx context.Context, args []string) (err error) {
 	// Get the specified image.
 	cmd.image = args[0]
 
+	registryTokens, err := readRegistryTokens(cmd.registryTokens, os.Stdin)
+	if err != nil {
+		return err
+	}
+
 	// Create the client.
 	c, err := client.New(stateDir, backend, nil)
 	if err != nil {
-		return err
+		return systemError{err}
 	}
 	defer c.Close()
 
+	if err := c.AddRegistryAuth(cmd.registryAuth...); err != nil {
+		return err
+	}
+	if err := c.AddRegistryToken(registryTokens...); err != nil {
+		return err
+	}
+
 	fmt.Printf("Pushing %s...\n", cmd.image)
 
 	// Create the context.
@@ -73,3 +93,29 @@ func (cmd *pushCommand) Run(ctx context.Context, args []string) (err error) {
 
 	return nil
 }
+
+// readRegistryTokens returns the HOST=TOKEN registry token values with the
+// token of a HOST=- value read from stdin. Only one token can be read from
+// stdin.
+func readRegistryTokens(values []string, stdin io.Reader) ([]string, error) {
+	tokens := make([]string, 0, len(values))
+	read := false
+	for _, value := range values {
+		if !strings.HasSuffix(value, "=-") {
+			tokens = append(tokens, value)
+			continue
+		}
+		if read {
+			return nil, usageErrorf("only one registry token can be read from stdin")
+		}
+		read = true
+
+		contents, err := ioutil.ReadAll(stdin)
+		if err != nil {
+			return nil, fmt.Errorf("reading registry token from stdin failed: %v", err)
+		}
+		token := strings.TrimSpace(string(contents))
+		tokens = append(tokens, strings.TrimSuffix(value, "-")+token)
+	}
+	return tokens, nil
+}
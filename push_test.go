@@ -0,0 +1,22 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadRegistryTokens(t *testing.T) {
+	tokens, err := readRegistryTokens([]string{"r.j3ss.co=flagtoken", "ghcr.io=-"}, strings.NewReader("stdintoken\n"))
+	if err != nil {
+		t.Fatalf("reading registry tokens failed: %v", err)
+	}
+	expected := []string{"r.j3ss.co=flagtoken", "ghcr.io=stdintoken"}
+	if !reflect.DeepEqual(tokens, expected) {
+		t.Fatalf("expected registry tokens %v, got: %v", expected, tokens)
+	}
+
+	if _, err := readRegistryTokens([]string{"r.j3ss.co=-", "ghcr.io=-"}, strings.NewReader("stdintoken\n")); err == nil {
+		t.Fatal("expected reading two registry tokens from stdin to fail")
+	}
+}
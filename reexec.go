@@ -4,16 +4,57 @@ import (
 	"os"
 	"os/exec"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 
 	"github.com/opencontainers/runc/libcontainer/system"
 	"github.com/sirupsen/logrus"
 )
 
+// noReexec is set by the hidden --no-reexec flag or the IMG_NO_REEXEC
+// environment variable to skip the reexec, e.g. under a debugger. It is only
+// meant for when img already runs in the right namespaces, builds fail if it
+// does not, for example when running unprivileged outside of a user namespace.
+var noReexec bool
+
+// noReexecFlag is the name of the hidden flag setting noReexec. The flags of
+// the cli are all shown in the help, so it is taken out of the arguments
+// before they are parsed instead of being registered.
+const noReexecFlag = "no-reexec"
+
+// parseNoReexec removes the --no-reexec flag from args and returns if it was
+// set, by the flag or the IMG_NO_REEXEC environment variable.
+func parseNoReexec(args []string) ([]string, bool) {
+	set, _ := strconv.ParseBool(os.Getenv("IMG_NO_REEXEC"))
+
+	rest := make([]string, 0, len(args))
+	for _, arg := range args {
+		name := strings.TrimLeft(arg, "-")
+		value := "true"
+		if i := strings.Index(name, "="); i >= 0 {
+			name, value = name[:i], name[i+1:]
+		}
+		if !strings.HasPrefix(arg, "-") || name != noReexecFlag {
+			rest = append(rest, arg)
+			continue
+		}
+		if b, err := strconv.ParseBool(value); err == nil {
+			set = b
+		}
+	}
+	return rest, set
+}
+
+// shouldReexec returns if img has to re-exec itself in a user namespace.
+func shouldReexec() bool {
+	return !noReexec && len(os.Getenv("IMG_RUNNING_TESTS")) <= 0 && len(os.Getenv("IMG_DO_UNSHARE")) <= 0 && system.GetParentNSeuid() != 0
+}
+
 func reexec() {
 	// TODO(jessfraz): This is a hack to re-exec our selves and wait for the
 	// process since it was not exiting correctly with the constructor.
-	if len(os.Getenv("IMG_RUNNING_TESTS")) <= 0 && len(os.Getenv("IMG_DO_UNSHARE")) <= 0 && system.GetParentNSeuid() != 0 {
+	if shouldReexec() {
 		var (
 			pgid int
 			err  error
@@ -26,16 +67,16 @@ func reexec() {
 			for sig := range c {
 				logrus.Infof("Received %s, exiting.", sig.String())
 				if err := syscall.Kill(-pgid, syscall.SIGKILL); err != nil {
-					logrus.Fatalf("syscall.Kill %d error: %v", pgid, err)
+					fatalSystemf("syscall.Kill %d error: %v", pgid, err)
 					continue
 				}
-				os.Exit(0)
+				os.Exit(exitInterrupted)
 			}
 		}()
 
 		// If newuidmap is not present re-exec will fail
 		if _, err := exec.LookPath("newuidmap"); err != nil {
-			logrus.Fatalf("newuidmap not found (install uidmap package?): %v", err)
+			fatalSystemf("newuidmap not found (install uidmap package?): %v", err)
 		}
 
 		// Initialize and re-exec with our unshare.
@@ -48,12 +89,12 @@ func reexec() {
 			Setpgid: true,
 		}
 		if err := cmd.Start(); err != nil {
-			logrus.Fatalf("cmd.Start error: %v", err)
+			fatalSystemf("cmd.Start error: %v", err)
 		}
 
 		pgid, err = syscall.Getpgid(cmd.Process.Pid)
 		if err != nil {
-			logrus.Fatalf("getpgid error: %v", err)
+			fatalSystemf("getpgid error: %v", err)
 		}
 
 		var (
@@ -71,7 +112,7 @@ func reexec() {
 					os.Exit(exitCode)
 				}
 
-				logrus.Fatalf("wait4 error: %v", err)
+				fatalSystemf("wait4 error: %v", err)
 			}
 		}
 	}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseNoReexec(t *testing.T) {
+	defer setEnv("IMG_NO_REEXEC", "")()
+
+	testcases := []struct {
+		args     []string
+		env      string
+		rest     []string
+		noReexec bool
+	}{
+		{args: []string{"img", "build", "-t", "img", "."}, rest: []string{"img", "build", "-t", "img", "."}},
+		{args: []string{"img", "build", "--no-reexec", "-t", "img", "."}, rest: []string{"img", "build", "-t", "img", "."}, noReexec: true},
+		{args: []string{"img", "-no-reexec=true", "ls"}, rest: []string{"img", "ls"}, noReexec: true},
+		{args: []string{"img", "ls", "--no-reexec=false"}, env: "1", rest: []string{"img", "ls"}},
+		{args: []string{"img", "ls"}, env: "1", rest: []string{"img", "ls"}, noReexec: true},
+		{args: []string{"img", "build", "no-reexec"}, rest: []string{"img", "build", "no-reexec"}},
+	}
+
+	for _, tc := range testcases {
+		setEnv("IMG_NO_REEXEC", tc.env)
+		rest, noReexec := parseNoReexec(tc.args)
+		if !reflect.DeepEqual(rest, tc.rest) || noReexec != tc.noReexec {
+			t.Fatalf("expected %v with IMG_NO_REEXEC=%q to parse as (%v, %t), got: (%v, %t)", tc.args, tc.env, tc.rest, tc.noReexec, rest, noReexec)
+		}
+	}
+}
+
+func TestNoReexecShortCircuits(t *testing.T) {
+	defer setEnv("IMG_RUNNING_TESTS", "")()
+	defer setEnv("IMG_DO_UNSHARE", "")()
+	defer func(v bool) { noReexec = v }(noReexec)
+
+	noReexec = true
+	if shouldReexec() {
+		t.Fatal("expected --no-reexec to skip the reexec")
+	}
+	// Returns right away instead of re-executing the test binary.
+	reexec()
+}
@@ -25,7 +25,7 @@ type removeCommand struct{}
 
 func (cmd *removeCommand) Run(ctx context.Context, args []string) (err error) {
 	if len(args) < 1 {
-		return fmt.Errorf("must pass an image to remove")
+		return usageErrorf("must pass an image to remove")
 	}
 
 	reexec()
@@ -38,7 +38,7 @@ func (cmd *removeCommand) Run(ctx context.Context, args []string) (err error) {
 	// Create the client.
 	c, err := client.New(stateDir, backend, nil)
 	if err != nil {
-		return err
+		return systemError{err}
 	}
 	defer c.Close()
 
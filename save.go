@@ -36,7 +36,7 @@ type saveCommand struct {
 
 func (cmd *saveCommand) Run(ctx context.Context, args []string) (err error) {
 	if len(args) < 1 {
-		return fmt.Errorf("must pass an image to save")
+		return usageErrorf("must pass an image to save")
 	}
 
 	reexec()
@@ -49,7 +49,7 @@ func (cmd *saveCommand) Run(ctx context.Context, args []string) (err error) {
 	// Create the client.
 	c, err := client.New(stateDir, backend, nil)
 	if err != nil {
-		return err
+		return systemError{err}
 	}
 	defer c.Close()
 
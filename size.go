@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// rawBytes is set by the global --bytes flag to print sizes as raw byte
+// counts for scripting.
+var rawBytes bool
+
+var binaryUnits = []string{"KiB", "MiB", "GiB", "TiB", "PiB", "EiB"}
+
+// formatBytes returns a human friendly representation of a size in bytes
+// using binary units with one decimal, or the raw byte count if --bytes is
+// set. It is used by all the commands that list sizes so they are formatted
+// consistently.
+func formatBytes(size int64) string {
+	if rawBytes {
+		return strconv.FormatInt(size, 10)
+	}
+
+	sign := ""
+	value := float64(size)
+	if size < 0 {
+		sign = "-"
+		value = -value
+	}
+	if value < 1024 {
+		return fmt.Sprintf("%s%.0f B", sign, value)
+	}
+
+	// Move up a unit once the value would round to 1024.0.
+	value /= 1024
+	unit := 0
+	for value >= 1023.95 && unit < len(binaryUnits)-1 {
+		value /= 1024
+		unit++
+	}
+	return fmt.Sprintf("%s%.1f %s", sign, value, binaryUnits[unit])
+}
@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+func TestFormatBytes(t *testing.T) {
+	testCases := []struct {
+		size     int64
+		expected string
+	}{
+		{size: 0, expected: "0 B"},
+		{size: 1023, expected: "1023 B"},
+		{size: 1024, expected: "1.0 KiB"},
+		{size: 1536, expected: "1.5 KiB"},
+		{size: 1048575, expected: "1.0 MiB"},
+		{size: 1048576, expected: "1.0 MiB"},
+		{size: 1073741824, expected: "1.0 GiB"},
+		{size: -1, expected: "-1 B"},
+		{size: -1048576, expected: "-1.0 MiB"},
+	}
+
+	for _, tc := range testCases {
+		if got := formatBytes(tc.size); got != tc.expected {
+			t.Errorf("formatBytes(%d): expected %q, got %q", tc.size, tc.expected, got)
+		}
+	}
+
+	rawBytes = true
+	defer func() { rawBytes = false }()
+	if got := formatBytes(1048576); got != "1048576" {
+		t.Errorf("formatBytes(1048576) with --bytes: expected %q, got %q", "1048576", got)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/containerd/containerd/namespaces"
+	"github.com/mchirico/img/client"
+	"github.com/moby/buildkit/identity"
+	"github.com/moby/buildkit/session"
+)
+
+const snapshotShortHelp = `Manage the snapshots of the snapshotter.`
+
+const snapshotLongHelp = `Manage the snapshots of the snapshotter.
+
+Commands:
+
+  ls              List the snapshots with their parent, kind, size and whether they are in use.
+  rm KEY...       Remove orphaned snapshots, refusing snapshots in use by the build cache or another snapshot.`
+
+func (cmd *snapshotCommand) Name() string      { return "snapshot" }
+func (cmd *snapshotCommand) Args() string      { return "[OPTIONS] COMMAND [KEY...]" }
+func (cmd *snapshotCommand) ShortHelp() string { return snapshotShortHelp }
+func (cmd *snapshotCommand) LongHelp() string  { return snapshotLongHelp }
+func (cmd *snapshotCommand) Hidden() bool      { return false }
+
+func (cmd *snapshotCommand) Register(fs *flag.FlagSet) {
+	fs.StringVar(&cmd.format, "format", formatTable, formatHelp)
+}
+
+type snapshotCommand struct {
+	format string
+}
+
+func (cmd *snapshotCommand) Run(ctx context.Context, args []string) (err error) {
+	if len(args) < 1 {
+		return usageErrorf("must pass a snapshot command (ls, rm)")
+	}
+	format, err := parseListFormat(cmd.format)
+	if err != nil {
+		return err
+	}
+
+	reexec()
+
+	// Create the context.
+	id := identity.NewID()
+	ctx = session.NewContext(ctx, id)
+	ctx = namespaces.WithNamespace(ctx, "buildkit")
+
+	// Create the client.
+	c, err := client.New(stateDir, backend, nil)
+	if err != nil {
+		return systemError{err}
+	}
+	defer c.Close()
+
+	switch args[0] {
+	case "ls":
+		snapshots, err := c.ListSnapshots(ctx)
+		if err != nil {
+			return err
+		}
+		return printSnapshots(os.Stdout, snapshots, format)
+	case "rm":
+		if len(args) < 2 {
+			return usageErrorf("must pass a snapshot key to remove")
+		}
+		for _, key := range args[1:] {
+			if err := c.RemoveSnapshot(ctx, key); err != nil {
+				return err
+			}
+
+			fmt.Printf("Successfully removed %s\n", key)
+		}
+		return nil
+	default:
+		return usageErrorf("%q is not a valid snapshot command (ls, rm)", args[0])
+	}
+}
+
+// printSnapshots writes the snapshots as a table or with the format.
+func printSnapshots(w io.Writer, snapshots []client.SnapshotInfo, format listFormat) error {
+	if !format.isTable() {
+		records := make([]interface{}, 0, len(snapshots))
+		for _, snapshot := range snapshots {
+			records = append(records, snapshot)
+		}
+		return format.write(w, records)
+	}
+
+	tw := tabwriter.NewWriter(w, 1, 8, 1, '\t', 0)
+
+	fmt.Fprintln(tw, "KEY\tPARENT\tKIND\tSIZE\tIN USE")
+
+	for _, snapshot := range snapshots {
+		parent := snapshot.Parent
+		if parent == "" {
+			parent = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%t\n",
+			snapshot.Key,
+			parent,
+			snapshot.Kind,
+			formatBytes(snapshot.Size),
+			snapshot.InUse,
+		)
+	}
+
+	return tw.Flush()
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/containerd/containerd/namespaces"
+	"github.com/mchirico/img/client"
+	"github.com/moby/buildkit/identity"
+	"github.com/moby/buildkit/session"
+)
+
+const systemShortHelp = `Show information about the builder.`
+
+const systemLongHelp = `Show information about the builder.
+
+Commands:
+
+  info            Show the workers of the builder with the platforms they can build for, and its frontends and exporters.`
+
+func (cmd *systemCommand) Name() string       { return "system" }
+func (cmd *systemCommand) Args() string       { return "[OPTIONS] COMMAND" }
+func (cmd *systemCommand) ShortHelp() string  { return systemShortHelp }
+func (cmd *systemCommand) LongHelp() string   { return systemLongHelp }
+func (cmd *systemCommand) Hidden() bool       { return false }
+func (cmd *systemCommand) RequiresRunc() bool { return true }
+
+func (cmd *systemCommand) Register(fs *flag.FlagSet) {
+	fs.StringVar(&cmd.format, "format", formatTable, "Format of the info output (table|json)")
+}
+
+type systemCommand struct {
+	format string
+}
+
+func (cmd *systemCommand) Run(ctx context.Context, args []string) (err error) {
+	if len(args) < 1 {
+		return usageErrorf("must pass a system command (info)")
+	}
+	if args[0] != "info" {
+		return usageErrorf("%q is not a valid system command (info)", args[0])
+	}
+	if cmd.format != formatTable && cmd.format != formatJSON {
+		return usageErrorf("%q is not a valid format (table, json)", cmd.format)
+	}
+
+	reexec()
+
+	// Create the context.
+	id := identity.NewID()
+	ctx = session.NewContext(ctx, id)
+	ctx = namespaces.WithNamespace(ctx, "buildkit")
+
+	// Create the client.
+	c, err := client.New(stateDir, backend, nil)
+	if err != nil {
+		return systemError{err}
+	}
+	defer c.Close()
+
+	info, err := c.Info(ctx)
+	if err != nil {
+		return err
+	}
+	return printSystemInfo(os.Stdout, info, cmd.format)
+}
+
+// printSystemInfo writes the info as a summary or as a JSON object.
+func printSystemInfo(w io.Writer, info *client.BuilderInfo, format string) error {
+	if format == formatJSON {
+		b, err := json.MarshalIndent(info, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(b))
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 1, 8, 1, '\t', 0)
+
+	fmt.Fprintln(tw, "Workers:")
+	for _, worker := range info.Workers {
+		fmt.Fprintf(tw, "  ID:\t%s\n", worker.ID)
+		fmt.Fprintf(tw, "  Executor:\t%s\n", worker.Executor)
+		fmt.Fprintf(tw, "  Snapshotter:\t%s\n", worker.Snapshotter)
+		fmt.Fprintf(tw, "  Platforms:\t%s\n", strings.Join(worker.Platforms, ", "))
+	}
+	fmt.Fprintf(tw, "Frontends:\t%s\n", strings.Join(info.Frontends, ", "))
+	fmt.Fprintf(tw, "Exporters:\t%s\n", strings.Join(info.Exporters, ", "))
+	fmt.Fprintf(tw, "Cache exporters:\t%s\n", strings.Join(info.CacheExporters, ", "))
+	fmt.Fprintf(tw, "Cache importers:\t%s\n", strings.Join(info.CacheImporters, ", "))
+
+	return tw.Flush()
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mchirico/img/client"
+)
+
+func TestPrintSystemInfo(t *testing.T) {
+	info := &client.BuilderInfo{
+		Workers: []client.WorkerInfo{{
+			ID:          "w0",
+			Executor:    "oci",
+			Snapshotter: "overlayfs",
+			Platforms:   []string{"linux/amd64", "linux/arm64"},
+		}},
+		Frontends:      []string{"dockerfile.v0"},
+		Exporters:      []string{"image", "oci"},
+		CacheExporters: []string{"inline"},
+		CacheImporters: []string{},
+	}
+
+	var b bytes.Buffer
+	if err := printSystemInfo(&b, info, formatTable); err != nil {
+		t.Fatal(err)
+	}
+	out := strings.Join(strings.Fields(b.String()), " ")
+	expected := "Workers: ID: w0 Executor: oci Snapshotter: overlayfs Platforms: linux/amd64, linux/arm64 " +
+		"Frontends: dockerfile.v0 Exporters: image, oci Cache exporters: inline Cache importers:"
+	if out != expected {
+		t.Fatalf("expected summary %q, got: %q", expected, out)
+	}
+
+	b.Reset()
+	if err := printSystemInfo(&b, info, formatJSON); err != nil {
+		t.Fatal(err)
+	}
+	var got client.BuilderInfo
+	if err := json.Unmarshal(b.Bytes(), &got); err != nil {
+		t.Fatalf("parsing system info json output failed: %v: %s", err, b.String())
+	}
+	if !reflect.DeepEqual(&got, info) {
+		t.Fatalf("expected json output %+v, got: %+v", info, got)
+	}
+}
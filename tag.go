@@ -2,8 +2,11 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
+	"os"
 
 	"github.com/containerd/containerd/namespaces"
 	"github.com/mchirico/img/client"
@@ -11,33 +14,63 @@ import (
 	"github.com/moby/buildkit/session"
 )
 
-const tagHelp = `Create a tag TARGET_IMAGE that refers to SOURCE_IMAGE.`
+const tagShortHelp = `Create a tag TARGET_IMAGE that refers to SOURCE_IMAGE.`
+
+const tagLongHelp = `Create a tag TARGET_IMAGE that refers to SOURCE_IMAGE.
+
+With --list IMAGE[:TAG] or --list DIGEST, list the tags referencing the same
+manifest as the image, given by name or by the digest of its manifest, instead.`
+
+const (
+	tagFormatPlain = "plain"
+	tagFormatJSON  = "json"
+)
 
 func (cmd *tagCommand) Name() string       { return "tag" }
 func (cmd *tagCommand) Args() string       { return "SOURCE_IMAGE[:TAG] TARGET_IMAGE[:TAG]" }
-func (cmd *tagCommand) ShortHelp() string  { return tagHelp }
-func (cmd *tagCommand) LongHelp() string   { return tagHelp }
+func (cmd *tagCommand) ShortHelp() string  { return tagShortHelp }
+func (cmd *tagCommand) LongHelp() string   { return tagLongHelp }
 func (cmd *tagCommand) Hidden() bool       { return false }
 func (cmd *tagCommand) DoReexec() bool     { return true }
 func (cmd *tagCommand) RequiresRunc() bool { return false }
 
-func (cmd *tagCommand) Register(fs *flag.FlagSet) {}
+func (cmd *tagCommand) Register(fs *flag.FlagSet) {
+	fs.BoolVar(&cmd.move, "move", false, "remove the SOURCE_IMAGE tag after tagging TARGET_IMAGE, both must be in the same registry")
+	fs.BoolVar(&cmd.list, "list", false, "list the tags referencing the same manifest as the image instead of tagging it")
+	fs.StringVar(&cmd.format, "format", tagFormatPlain, "Format of the --list output (plain|json)")
+}
 
 type tagCommand struct {
 	image  string
 	target string
+
+	move   bool
+	list   bool
+	format string
 }
 
 func (cmd *tagCommand) Run(ctx context.Context, args []string) (err error) {
-	if len(args) < 2 {
-		return fmt.Errorf("must pass an image or repository and target to tag")
+	if cmd.list {
+		if len(args) != 1 {
+			return usageErrorf("must pass an image or manifest digest to list the tags of")
+		}
+		if cmd.move {
+			return usageErrorf("--list and --move cannot be combined")
+		}
+		if cmd.format != tagFormatPlain && cmd.format != tagFormatJSON {
+			return usageErrorf("%q is not a valid format (plain, json)", cmd.format)
+		}
+	} else if len(args) < 2 {
+		return usageErrorf("must pass an image or repository and target to tag")
 	}
 
 	reexec()
 
 	// Get the specified image and target.
 	cmd.image = args[0]
-	cmd.target = args[1]
+	if !cmd.list {
+		cmd.target = args[1]
+	}
 
 	// Create the context.
 	id := identity.NewID()
@@ -47,10 +80,27 @@ func (cmd *tagCommand) Run(ctx context.Context, args []string) (err error) {
 	// Create the client.
 	c, err := client.New(stateDir, backend, nil)
 	if err != nil {
-		return err
+		return systemError{err}
 	}
 	defer c.Close()
 
+	if cmd.list {
+		tags, err := c.ImageTags(ctx, cmd.image)
+		if err != nil {
+			return err
+		}
+		return printTags(os.Stdout, tags, cmd.format)
+	}
+
+	if cmd.move {
+		if err := c.MoveImage(ctx, cmd.image, cmd.target); err != nil {
+			return err
+		}
+
+		fmt.Printf("Successfully moved %s to %s\n", cmd.image, cmd.target)
+		return nil
+	}
+
 	if err := c.TagImage(ctx, cmd.image, cmd.target); err != nil {
 		return err
 	}
@@ -59,3 +109,20 @@ func (cmd *tagCommand) Run(ctx context.Context, args []string) (err error) {
 
 	return nil
 }
+
+// printTags writes the tags one per line or as a JSON array.
+func printTags(w io.Writer, tags []string, format string) error {
+	if format == tagFormatJSON {
+		b, err := json.MarshalIndent(tags, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(b))
+		return err
+	}
+
+	for _, tag := range tags {
+		fmt.Fprintln(w, tag)
+	}
+	return nil
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"strings"
 	"testing"
 )
@@ -57,3 +58,49 @@ func TestTagPullAndPush(t *testing.T) {
 		t.Fatalf("expected push to fail with 'insufficient_scope: authorization failed' got: %s %v", out, err)
 	}
 }
+
+func TestTagImageMove(t *testing.T) {
+	runBuild(t, "tagthingmove", withDockerfile(`
+    FROM busybox
+    RUN echo tagtestmove
+    `))
+
+	out := run(t, "tag", "--move", "tagthingmove", "jess/tagtestmove")
+	if !strings.Contains(out, "Successfully moved tagthingmove to jess/tagtestmove") {
+		t.Fatalf("expected tag --move to report the move but got: %s", out)
+	}
+
+	out = run(t, "ls")
+
+	if strings.Contains(out, "tagthingmove:latest") || !strings.Contains(out, "jess/tagtestmove:latest") {
+		t.Fatalf("expected ls output to have jess/tagtestmove:latest but not tagthingmove:latest, got: %s", out)
+	}
+
+	out, err := doRun([]string{"tag", "--move", "jess/tagtestmove", "r.j3ss.co/tagtestmove"}, nil)
+	if err == nil || !strings.Contains(err.Error(), "registries docker.io and r.j3ss.co differ") {
+		t.Fatalf("expected moving to another registry to fail, got: %s %v", out, err)
+	}
+}
+
+func TestTagImageList(t *testing.T) {
+	runBuild(t, "tagthinglist", withDockerfile(`
+    FROM scratch
+    COPY Dockerfile /
+    `))
+
+	run(t, "tag", "tagthinglist", "jess/tagtestlist")
+
+	out := run(t, "tag", "--list", "tagthinglist")
+	if out != "docker.io/jess/tagtestlist:latest\ndocker.io/library/tagthinglist:latest\n" {
+		t.Fatalf("expected tag --list to list both tags, got: %q", out)
+	}
+
+	out = run(t, "tag", "--list", "--format", "json", "jess/tagtestlist")
+	var tags []string
+	if err := json.Unmarshal([]byte(out), &tags); err != nil {
+		t.Fatalf("parsing tag --list json output failed: %v: %s", err, out)
+	}
+	if len(tags) != 2 || tags[0] != "docker.io/jess/tagtestlist:latest" || tags[1] != "docker.io/library/tagthinglist:latest" {
+		t.Fatalf("expected tag --list json output to list both tags, got: %v", tags)
+	}
+}
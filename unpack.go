@@ -35,7 +35,7 @@ type unpackCommand struct {
 
 func (cmd *unpackCommand) Run(ctx context.Context, args []string) (err error) {
 	if len(args) < 1 {
-		return fmt.Errorf("must pass an image to unpack as a rootfs")
+		return usageErrorf("must pass an image to unpack as a rootfs")
 	}
 
 	reexec()
@@ -58,7 +58,7 @@ func (cmd *unpackCommand) Run(ctx context.Context, args []string) (err error) {
 	// Create the client.
 	c, err := client.New(stateDir, backend, nil)
 	if err != nil {
-		return err
+		return systemError{err}
 	}
 	defer c.Close()
 
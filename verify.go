@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/containerd/containerd/namespaces"
+	"github.com/containerd/containerd/platforms"
+	"github.com/mchirico/img/client"
+	"github.com/moby/buildkit/identity"
+	"github.com/moby/buildkit/session"
+)
+
+const verifyShortHelp = `Verify the integrity of an image in the local store.`
+
+var verifyLongHelp = verifyShortHelp + `
+
+The index, manifests, configs and layers of the image are read back from the
+content store and their digests recomputed. The first blob that is missing or
+does not match its digest fails the verification.`
+
+func (cmd *verifyCommand) Name() string       { return "verify" }
+func (cmd *verifyCommand) Args() string       { return "[OPTIONS] IMAGE" }
+func (cmd *verifyCommand) ShortHelp() string  { return verifyShortHelp }
+func (cmd *verifyCommand) LongHelp() string   { return verifyLongHelp }
+func (cmd *verifyCommand) Hidden() bool       { return false }
+func (cmd *verifyCommand) DoReexec() bool     { return true }
+func (cmd *verifyCommand) RequiresRunc() bool { return false }
+
+func (cmd *verifyCommand) Register(fs *flag.FlagSet) {
+	fs.StringVar(&cmd.platform, "platform", platforms.DefaultString(), "Platform of the image to verify, 'all' verifies every manifest of an index")
+}
+
+type verifyCommand struct {
+	platform string
+}
+
+func (cmd *verifyCommand) Run(ctx context.Context, args []string) (err error) {
+	if len(args) < 1 {
+		return usageErrorf("must pass an image to verify")
+	}
+
+	var matcher platforms.MatchComparer
+	if cmd.platform != "all" {
+		p, err := parsePlatform(cmd.platform)
+		if err != nil {
+			return fmt.Errorf("parsing platform %s failed: %v", cmd.platform, err)
+		}
+		matcher = platforms.Only(p)
+	}
+
+	reexec()
+
+	// Create the context.
+	id := identity.NewID()
+	ctx = session.NewContext(ctx, id)
+	ctx = namespaces.WithNamespace(ctx, "buildkit")
+
+	// Create the client.
+	c, err := client.New(stateDir, backend, nil)
+	if err != nil {
+		return systemError{err}
+	}
+	defer c.Close()
+
+	verified, err := c.Verify(ctx, args[0], matcher)
+	if err != nil {
+		return fmt.Errorf("verifying %s failed: %v", args[0], err)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 1, 8, 1, '\t', 0)
+	fmt.Fprintln(tw, "DIGEST\tMEDIA TYPE\tSIZE")
+	for _, desc := range verified {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", desc.Digest, desc.MediaType, formatBytes(desc.Size))
+	}
+	tw.Flush()
+
+	fmt.Printf("Successfully verified %d blobs of %s\n", len(verified), args[0])
+
+	return nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestVerifyImage(t *testing.T) {
+	name := "testverifyimage"
+
+	runBuild(t, name, withDockerfile(`
+    FROM busybox
+    RUN echo verify
+    `))
+
+	out := run(t, "verify", name)
+	if !strings.Contains(out, "Successfully verified") {
+		t.Fatalf("expected verify to succeed, got: %s", out)
+	}
+
+	// Find a layer of the image and remove it from the content store.
+	var layer string
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 1 && strings.Contains(fields[1], "layer") {
+			layer = fields[0]
+			break
+		}
+	}
+	if layer == "" {
+		t.Fatalf("expected verify output to list a layer, got: %s", out)
+	}
+	run(t, "content", "rm", "--force", layer)
+
+	out, err := doRun([]string{"verify", name}, nil)
+	if err == nil || !strings.Contains(out, layer) {
+		t.Fatalf("expected verify to fail for the removed layer %s, got: %s", layer, out)
+	}
+}